@@ -1,21 +1,24 @@
 package main
 
 import (
+	"context"
 	"couponcutter/authetication"
 	"couponcutter/listing"
+	"couponcutter/mailer"
 	"couponcutter/rest"
 	"couponcutter/storage/database"
 	"couponcutter/storemanagement"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/go-chi/httplog"
 	"github.com/rs/zerolog"
 )
 
-const SECRET_KEY = "57574F2B696AE7C7A29ggggggggggggggggggggggdddddlmbd5B5CBF289324"
-
 func main() {
 	storage, err := database.NewStorage()
 	if err != nil {
@@ -23,12 +26,41 @@ func main() {
 		os.Exit(1)
 	}
 	listing := listing.NewService(storage)
-	auth := authetication.NewService(storage, SECRET_KEY)
-	sManager := storemanagement.NewService(storage)
+	mail, err := mailer.NewFileMailer("mail")
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+	keys, err := authetication.LoadKeySet("keys")
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+	policy := authetication.DefaultPasswordPolicy()
+	err = policy.LoadBreachedPasswords("breached-passwords.txt")
+	if err != nil && !os.IsNotExist(err) {
+		log.Print(err)
+		os.Exit(1)
+	}
+	addr := "127.0.0.1:5500"
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://" + addr
+	}
+	auth := authetication.NewService(storage, mail, keys, storage, policy, publicURL, oidcProviders()...)
+	go cleanupSignups(auth)
+	go purgeDeletedAccounts(auth)
+	geocoder, err := loadGeocoder("geocoder.json")
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+	sManager := storemanagement.NewService(storage, mail, geocoder)
+	go purgeIdempotencyKeys(sManager)
 	apiLogger := httplog.NewLogger("web-server", httplog.Options{
 		Concise: true,
 	})
-	run(listing, sManager, auth, apiLogger, "127.0.0.1:5500")
+	run(listing, sManager, auth, apiLogger, addr)
 
 }
 
@@ -43,3 +75,83 @@ func run(listing listing.Service,
 	log.Fatal(server.ListenAndServe())
 
 }
+
+//oidcProviders configures the identity providers named in OIDC_PROVIDERS, a provider
+//named google is read from OIDC_GOOGLE_ISSUER, OIDC_GOOGLE_CLIENT_ID,
+//OIDC_GOOGLE_CLIENT_SECRET and OIDC_GOOGLE_REDIRECT_URL
+func oidcProviders() []*authetication.OIDCProvider {
+	var providers []*authetication.OIDCProvider
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, authetication.NewOIDCProvider(name,
+			os.Getenv(prefix+"ISSUER"),
+			os.Getenv(prefix+"CLIENT_ID"),
+			os.Getenv(prefix+"CLIENT_SECRET"),
+			os.Getenv(prefix+"REDIRECT_URL")))
+	}
+	return providers
+}
+
+//loadGeocoder reads the table of the static geocoder, a json object from addresses to
+//their location. Without the file there is no geocoder and addresses are saved unlocated
+func loadGeocoder(path string) (storemanagement.Geocoder, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	table := make(map[string]storemanagement.Location)
+	err = json.Unmarshal(b, &table)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return storemanagement.NewStaticGeocoder(table), nil
+}
+
+//periodically removes the signups that were never verified
+func cleanupSignups(auth authetication.Service) {
+	for range time.Tick(time.Hour) {
+		n, err := auth.CleanupExpiredSignups(context.Background())
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("removed %d expired signups", n)
+		}
+	}
+}
+
+//periodically erases the accounts whose deletion grace period has ended
+func purgeDeletedAccounts(auth authetication.Service) {
+	for range time.Tick(time.Hour) {
+		n, err := auth.PurgeDeletedAccounts(context.Background())
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("erased %d deleted accounts", n)
+		}
+	}
+}
+
+//periodically removes the idempotency keys of redemptions whose retention has ended
+func purgeIdempotencyKeys(sManager storemanagement.Service) {
+	for range time.Tick(time.Hour) {
+		n, err := sManager.PurgeIdempotencyKeys(context.Background())
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("removed %d expired idempotency keys", n)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package authetication
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+//ErrNoDeletionScheduled is returned if a deletion is cancelled for an account that is not being deleted
+var ErrNoDeletionScheduled = errors.New("account is not scheduled for deletion")
+
+//accountDeletionGrace is how long a deleted account can still be restored before its data is erased
+const accountDeletionGrace = time.Hour * 24 * 30
+
+//AccountExport is a copy of the personal data held about a user
+type AccountExport struct {
+	Profile        ExportProfile      `json:"profile"`
+	Identities     []ExportIdentity   `json:"identities"`
+	SavedCoupons   []ExportCoupon     `json:"saved_coupons"`
+	FollowedStores []ExportStore      `json:"followed_stores"`
+	OwnedStore     *ExportStore       `json:"owned_store,omitempty"`
+	Employments    []ExportEmployment `json:"employments"`
+	//Redemptions are made by the user as an employee, ShopperRedemptions for the user as a shopper
+	Redemptions        []ExportRedemption `json:"redemptions"`
+	ShopperRedemptions []ExportRedemption `json:"shopper_redemptions"`
+}
+
+//ExportProfile is the account of the user
+type ExportProfile struct {
+	UserID              string     `json:"user_id"`
+	Email               string     `json:"email"`
+	CreatedAt           time.Time  `json:"created_at"`
+	TwoFactorEnabled    bool       `json:"two_factor_enabled"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+//ExportIdentity is an identity provider account linked to the user
+type ExportIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+//ExportCoupon is a coupon saved by the user
+type ExportCoupon struct {
+	CouponID  string    `json:"coupon_id"`
+	StoreID   string    `json:"store_id"`
+	Desc      string    `json:"desc"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+//ExportStore is a store followed or owned by the user
+type ExportStore struct {
+	StoreID   string `json:"store_id"`
+	StoreName string `json:"store_name"`
+	State     string `json:"state,omitempty"`
+}
+
+//ExportEmployment is the employment of the user by a store
+type ExportEmployment struct {
+	EmpID     string    `json:"emp_id"`
+	StoreID   string    `json:"store_id"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+//ExportRedemption is a coupon redemption the user took part in
+type ExportRedemption struct {
+	CouponID   string    `json:"coupon_id"`
+	StoreID    string    `json:"store_id"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+}
+
+//WriteZip writes the export as a zip archive holding a json file for each part of the data
+func (e *AccountExport) WriteZip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", e.Profile},
+		{"identities.json", e.Identities},
+		{"saved_coupons.json", e.SavedCoupons},
+		{"followed_stores.json", e.FollowedStores},
+		{"owned_store.json", e.OwnedStore},
+		{"employments.json", e.Employments},
+		{"redemptions.json", e.Redemptions},
+		{"shopper_redemptions.json", e.ShopperRedemptions},
+	}
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(file.data)
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+//DeleteAccount schedules the erasure of the account once the grace period ends and logs
+//the user out everywhere, the current password must be confirmed like for any other change
+//of the credentials. The account can be restored by logging in and cancelling the deletion
+//before then
+func (s *service) DeleteAccount(ctx context.Context, userID string, password string) (time.Time, error) {
+	email, err := s.repo.UserEmail(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrIdentityDoesNotExists) {
+			return time.Time{}, ErrIdentityDoesNotExists
+		}
+		return time.Time{}, ErrUnableToProcessRequest
+	}
+	err = s.confirmPassword(ctx, email, password)
+	if err != nil {
+		return time.Time{}, err
+	}
+	at := time.Now().Add(accountDeletionGrace)
+	err = s.repo.ScheduleAccountDeletion(ctx, userID, at)
+	if err != nil {
+		if errors.Is(err, ErrIdentityDoesNotExists) {
+			return time.Time{}, ErrIdentityDoesNotExists
+		}
+		return time.Time{}, ErrUnableToProcessRequest
+	}
+	return at, nil
+}
+
+//CancelAccountDeletion restores an account that is scheduled for deletion
+func (s *service) CancelAccountDeletion(ctx context.Context, userID string) error {
+	err := s.repo.CancelAccountDeletion(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrNoDeletionScheduled) {
+			return ErrNoDeletionScheduled
+		}
+		return ErrUnableToProcessRequest
+	}
+	return nil
+}
+
+//PurgeDeletedAccounts erases the accounts whose grace period has ended and returns how many were erased
+func (s *service) PurgeDeletedAccounts(ctx context.Context) (int64, error) {
+	return s.repo.PurgeDeletedAccounts(ctx)
+}
+
+//ExportAccount returns a copy of the personal data held about the user
+func (s *service) ExportAccount(ctx context.Context, userID string) (*AccountExport, error) {
+	export, err := s.repo.AccountExport(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrIdentityDoesNotExists) {
+			return nil, ErrIdentityDoesNotExists
+		}
+		return nil, ErrUnableToProcessRequest
+	}
+	return export, nil
+}
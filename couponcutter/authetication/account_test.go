@@ -0,0 +1,144 @@
+package authetication
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func (m *mockRepo) ScheduleAccountDeletion(ctx context.Context, userID string, at time.Time) error {
+	if userID == "unknown" {
+		return ErrIdentityDoesNotExists
+	}
+	if m.deletions == nil {
+		m.deletions = make(map[string]time.Time)
+	}
+	m.deletions[userID] = at
+	return nil
+}
+func (m *mockRepo) CancelAccountDeletion(ctx context.Context, userID string) error {
+	if _, ok := m.deletions[userID]; !ok {
+		return ErrNoDeletionScheduled
+	}
+	delete(m.deletions, userID)
+	return nil
+}
+func (m *mockRepo) PurgeDeletedAccounts(ctx context.Context) (int64, error) {
+	var n int64
+	for userID, at := range m.deletions {
+		if !time.Now().Before(at) {
+			delete(m.deletions, userID)
+			n++
+		}
+	}
+	return n, nil
+}
+func (m *mockRepo) AccountExport(ctx context.Context, userID string) (*AccountExport, error) {
+	if userID != "12ddf" {
+		return nil, ErrIdentityDoesNotExists
+	}
+	return &AccountExport{
+		Profile:            ExportProfile{UserID: userID, Email: "user@gmail.com"},
+		Identities:         []ExportIdentity{},
+		SavedCoupons:       []ExportCoupon{{CouponID: "c1", StoreID: "s1", Desc: "10% off"}},
+		FollowedStores:     []ExportStore{{StoreID: "s1", StoreName: "corner shop"}},
+		OwnedStore:         &ExportStore{StoreID: userID, StoreName: "my shop", State: "active"},
+		Employments:        []ExportEmployment{},
+		Redemptions:        []ExportRedemption{},
+		ShopperRedemptions: []ExportRedemption{},
+	}, nil
+}
+
+func Test_service_DeleteAccount(t *testing.T) {
+	repo := &mockRepo{}
+	s := &service{repo: repo, attempts: NewMemoryAttemptStore(), policy: DefaultPasswordPolicy()}
+	ctx := context.Background()
+
+	_, err := s.DeleteAccount(ctx, "12ddf", "wrong password")
+	if !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("DeleteAccount() error = %v, want %v", err, ErrIncorrectPassword)
+	}
+	if _, ok := repo.deletions["12ddf"]; ok {
+		t.Errorf("DeleteAccount() scheduled the deletion without the password")
+	}
+	at, err := s.DeleteAccount(ctx, "12ddf", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grace := time.Until(at); grace < accountDeletionGrace-time.Minute || grace > accountDeletionGrace {
+		t.Errorf("DeleteAccount() scheduled in %v, want %v", grace, accountDeletionGrace)
+	}
+	if n, _ := s.PurgeDeletedAccounts(ctx); n != 0 {
+		t.Errorf("PurgeDeletedAccounts() erased %d accounts within the grace period", n)
+	}
+
+	err = s.CancelAccountDeletion(ctx, "12ddf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.CancelAccountDeletion(ctx, "12ddf")
+	if !errors.Is(err, ErrNoDeletionScheduled) {
+		t.Errorf("CancelAccountDeletion() error = %v, want %v", err, ErrNoDeletionScheduled)
+	}
+
+	_, err = s.DeleteAccount(ctx, "unknown", "password")
+	if !errors.Is(err, ErrIdentityDoesNotExists) {
+		t.Errorf("DeleteAccount() error = %v, want %v", err, ErrIdentityDoesNotExists)
+	}
+
+	repo.deletions["j332v"] = time.Now().Add(-time.Second)
+	if n, _ := s.PurgeDeletedAccounts(ctx); n != 1 {
+		t.Errorf("PurgeDeletedAccounts() erased %d accounts, want 1", n)
+	}
+}
+
+func Test_service_ExportAccount(t *testing.T) {
+	s := &service{repo: &mockRepo{}}
+	ctx := context.Background()
+
+	_, err := s.ExportAccount(ctx, "unknown")
+	if !errors.Is(err, ErrIdentityDoesNotExists) {
+		t.Fatalf("ExportAccount() error = %v, want %v", err, ErrIdentityDoesNotExists)
+	}
+	export, err := s.ExportAccount(ctx, "12ddf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = export.WriteZip(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	for _, name := range []string{"profile.json", "identities.json", "saved_coupons.json", "followed_stores.json",
+		"owned_store.json", "employments.json", "redemptions.json"} {
+		if files[name] == nil {
+			t.Errorf("export archive is missing %s", name)
+		}
+	}
+	f, err := files["saved_coupons.json"].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var saved []ExportCoupon
+	err = json.NewDecoder(f).Decode(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].CouponID != "c1" {
+		t.Errorf("saved_coupons.json = %+v", saved)
+	}
+}
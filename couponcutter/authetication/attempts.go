@@ -0,0 +1,216 @@
+package authetication
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+//ErrTooManyAttempts is returned if logins of an account or from an address are blocked after repeated failures,
+//the returned error is an *AttemptsError telling when to retry
+var ErrTooManyAttempts = errors.New("too many failed login attempts")
+
+//AttemptsError carries how long a blocked login has to wait before it is tried again
+type AttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *AttemptsError) Error() string {
+	return ErrTooManyAttempts.Error()
+}
+
+func (e *AttemptsError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+//LoginAttempt is the record of failed logins for an account or an address
+type LoginAttempt struct {
+	Failures     int
+	BlockedUntil time.Time
+}
+
+//AttemptStore records failed logins. Keys are prefixed with what they track,
+//"account:" followed by an email or "address:" followed by an IP address
+type AttemptStore interface {
+	//LoginAttempt returns the failures of the key, failures older than the window are forgotten
+	LoginAttempt(ctx context.Context, key string, window time.Duration) (*LoginAttempt, error)
+	//FailLogin counts a failure for the key and returns the failures within the window
+	FailLogin(ctx context.Context, key string, window time.Duration) (int, error)
+	BlockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLogin(ctx context.Context, key string) error
+}
+
+//LoginPolicy decides how long logins are blocked after a number of failures
+type LoginPolicy struct {
+	//FreeAttempts is the number of failures before any delay is imposed
+	FreeAttempts int
+	//BaseDelay is the first delay, it doubles with every further failure
+	BaseDelay time.Duration
+	//MaxFailures is the number of failures after which logins are locked out
+	MaxFailures int
+	Lockout     time.Duration
+	//Window is how long a failure is remembered
+	Window time.Duration
+}
+
+var (
+	//accountPolicy protects a single account against password guessing
+	accountPolicy = LoginPolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxFailures: 10, Lockout: time.Minute * 15, Window: time.Hour}
+	//addressPolicy is looser as many users can share an address, it stops credential stuffing across accounts
+	addressPolicy = LoginPolicy{FreeAttempts: 20, BaseDelay: time.Second, MaxFailures: 100, Lockout: time.Hour, Window: time.Hour}
+)
+
+//blockFor returns how long logins are blocked after the failures
+func (p LoginPolicy) blockFor(failures int) time.Duration {
+	if failures >= p.MaxFailures {
+		return p.Lockout
+	}
+	if failures < p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay << uint(failures-p.FreeAttempts)
+	if delay <= 0 || delay > p.Lockout {
+		return p.Lockout
+	}
+	return delay
+}
+
+type attemptKey struct {
+	key    string
+	policy LoginPolicy
+}
+
+//the keys a login is tracked under, logins without a known address are only tracked per account
+func loginKeys(email string, address string) []attemptKey {
+	keys := []attemptKey{{key: "account:" + email, policy: accountPolicy}}
+	if address != "" {
+		keys = append(keys, attemptKey{key: "address:" + address, policy: addressPolicy})
+	}
+	return keys
+}
+
+//checkAttempts returns an *AttemptsError if the account or the address is blocked,
+//it runs before the password is checked so blocked attempts cost no hashing
+func (s *service) checkAttempts(ctx context.Context, email string, address string) error {
+	var wait time.Duration
+	for _, k := range loginKeys(email, address) {
+		attempt, err := s.attempts.LoginAttempt(ctx, k.key, k.policy.Window)
+		if err != nil {
+			return ErrUnableToProcessRequest
+		}
+		if d := time.Until(attempt.BlockedUntil); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return &AttemptsError{RetryAfter: wait}
+	}
+	return nil
+}
+
+//failLogin counts the failure against the account and the address and blocks them when needed
+func (s *service) failLogin(ctx context.Context, email string, address string) {
+	for _, k := range loginKeys(email, address) {
+		failures, err := s.attempts.FailLogin(ctx, k.key, k.policy.Window)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if d := k.policy.blockFor(failures); d > 0 {
+			err = s.attempts.BlockLogin(ctx, k.key, time.Now().Add(d))
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+//a successful login clears the failures of the account, the address keeps its
+//record so that one known password cannot be used to keep guessing others
+func (s *service) succeedLogin(ctx context.Context, email string) {
+	err := s.attempts.ResetLogin(ctx, "account:"+email)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+//MemoryAttemptStore keeps failed logins in memory, it suits tests and single instance deployments
+type MemoryAttemptStore struct {
+	mu        sync.Mutex
+	attempts  map[string]*memoryAttempt
+	lastSweep time.Time
+}
+
+type memoryAttempt struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+//NewMemoryAttemptStore returns an empty in-memory attempt store
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{attempts: map[string]*memoryAttempt{}, lastSweep: time.Now()}
+}
+
+//LoginAttempt returns the failures of the key within the window
+func (m *MemoryAttemptStore) LoginAttempt(ctx context.Context, key string, window time.Duration) (*LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.attempts[key]
+	if !ok || (time.Since(a.lastFailure) > window && time.Now().After(a.blockedUntil)) {
+		return &LoginAttempt{}, nil
+	}
+	return &LoginAttempt{Failures: a.failures, BlockedUntil: a.blockedUntil}, nil
+}
+
+//FailLogin counts a failure for the key
+func (m *MemoryAttemptStore) FailLogin(ctx context.Context, key string, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	//forget the keys that have not failed for a while so the map does not grow without bound
+	if now.Sub(m.lastSweep) > window {
+		for k, a := range m.attempts {
+			if now.Sub(a.lastFailure) > window && now.After(a.blockedUntil) {
+				delete(m.attempts, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	a, ok := m.attempts[key]
+	if !ok || now.Sub(a.lastFailure) > window {
+		a = &memoryAttempt{}
+		m.attempts[key] = a
+	}
+	a.failures++
+	a.lastFailure = now
+	return a.failures, nil
+}
+
+//BlockLogin blocks logins of the key until the given time
+func (m *MemoryAttemptStore) BlockLogin(ctx context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.attempts[key]
+	if !ok {
+		a = &memoryAttempt{lastFailure: time.Now()}
+		m.attempts[key] = a
+	}
+	a.blockedUntil = until
+	return nil
+}
+
+//ResetLogin forgets the failures of the key
+func (m *MemoryAttemptStore) ResetLogin(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
@@ -0,0 +1,118 @@
+package authetication
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoginPolicy_blockFor(t *testing.T) {
+	policy := LoginPolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxFailures: 10, Lockout: time.Minute * 15}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: 0},
+		{failures: 2, want: 0},
+		{failures: 3, want: time.Second},
+		{failures: 4, want: time.Second * 2},
+		{failures: 6, want: time.Second * 8},
+		{failures: 9, want: time.Second * 64},
+		{failures: 10, want: time.Minute * 15},
+		{failures: 70, want: time.Minute * 15},
+	}
+	for _, tt := range tests {
+		if got := policy.blockFor(tt.failures); got != tt.want {
+			t.Errorf("blockFor(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func Test_service_Login_Attempts(t *testing.T) {
+	attempts := NewMemoryAttemptStore()
+	s := &service{repo: &mockRepo{}, keys: testKeys(t), attempts: attempts, policy: DefaultPasswordPolicy()}
+	ctx := context.Background()
+
+	for i := 0; i < accountPolicy.FreeAttempts; i++ {
+		_, err := s.Login(ctx, "user@gmail.com", "wrong password", "10.0.0.1")
+		if !errors.Is(err, ErrIdentityDoesNotExists) {
+			t.Fatalf("Login() attempt %d error = %v", i, err)
+		}
+	}
+
+	//the account is blocked even with the right password and from another address
+	_, err := s.Login(ctx, "user@gmail.com", "password", "10.0.0.2")
+	var attemptsErr *AttemptsError
+	if !errors.As(err, &attemptsErr) || !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("Login() error = %v, want %v", err, ErrTooManyAttempts)
+	}
+	if attemptsErr.RetryAfter <= 0 || attemptsErr.RetryAfter > accountPolicy.BaseDelay {
+		t.Errorf("RetryAfter = %v", attemptsErr.RetryAfter)
+	}
+
+	//other accounts are not affected
+	_, err = s.Login(ctx, "user1@gmail.com", "password", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Login() of another account error = %v", err)
+	}
+
+	//a successful login clears the failures of the account
+	attempts.ResetLogin(ctx, "account:user@gmail.com")
+	_, err = s.Login(ctx, "user@gmail.com", "password", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempt, _ := attempts.LoginAttempt(ctx, "account:user@gmail.com", accountPolicy.Window)
+	if attempt.Failures != 0 {
+		t.Errorf("failures after a successful login = %d, want 0", attempt.Failures)
+	}
+	attempt, _ = attempts.LoginAttempt(ctx, "address:10.0.0.1", addressPolicy.Window)
+	if attempt.Failures != accountPolicy.FreeAttempts {
+		t.Errorf("failures of the address = %d, want %d", attempt.Failures, accountPolicy.FreeAttempts)
+	}
+}
+
+func Test_service_Login_AddressLockout(t *testing.T) {
+	attempts := NewMemoryAttemptStore()
+	s := &service{repo: &mockRepo{}, keys: testKeys(t), attempts: attempts, policy: DefaultPasswordPolicy()}
+	ctx := context.Background()
+
+	//credential stuffing spreads failures across accounts
+	for i := 0; i < addressPolicy.MaxFailures; i++ {
+		attempts.FailLogin(ctx, "address:10.0.0.9", addressPolicy.Window)
+	}
+	attempts.BlockLogin(ctx, "address:10.0.0.9", time.Now().Add(addressPolicy.blockFor(addressPolicy.MaxFailures)))
+
+	_, err := s.Login(ctx, "user@gmail.com", "password", "10.0.0.9")
+	var attemptsErr *AttemptsError
+	if !errors.As(err, &attemptsErr) || attemptsErr.RetryAfter <= time.Minute*59 {
+		t.Fatalf("Login() error = %v, want a lockout", err)
+	}
+	_, err = s.Login(ctx, "user@gmail.com", "password", "10.0.0.10")
+	if err != nil {
+		t.Errorf("Login() from another address error = %v", err)
+	}
+}
+
+func TestMemoryAttemptStore_Window(t *testing.T) {
+	store := NewMemoryAttemptStore()
+	ctx := context.Background()
+
+	store.FailLogin(ctx, "account:a", time.Hour)
+	store.FailLogin(ctx, "account:a", time.Hour)
+	n, _ := store.FailLogin(ctx, "account:a", time.Hour)
+	if n != 3 {
+		t.Fatalf("failures = %d, want 3", n)
+	}
+	//failures outside the window are forgotten
+	store.attempts["account:a"].lastFailure = time.Now().Add(-time.Hour * 2)
+	attempt, _ := store.LoginAttempt(ctx, "account:a", time.Hour)
+	if attempt.Failures != 0 {
+		t.Errorf("failures outside the window = %d, want 0", attempt.Failures)
+	}
+	n, _ = store.FailLogin(ctx, "account:a", time.Hour)
+	if n != 1 {
+		t.Errorf("failures after the window = %d, want 1", n)
+	}
+}
@@ -2,18 +2,25 @@ package authetication
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"log"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/form3tech-oss/jwt-go"
+	"github.com/google/uuid"
 )
 
 var (
 	//ErrInvalidEmail is returned if the user provided email address is invalid
 	ErrInvalidEmail = errors.New("email is invalid")
-	//ErrPasswordLengthUnAcceptable is returned if the user supplied a password length that < 6 || > 64
+	//ErrPasswordLengthUnAcceptable is returned if the length of the password is outside the limits of the password policy
 	ErrPasswordLengthUnAcceptable = errors.New("the lenght of the password is unacceptable")
 	//ErrIdentityDoesNotExists is returned if an user cannot ber verified to exists
 	ErrIdentityDoesNotExists = errors.New("identity does not exists")
@@ -21,7 +28,33 @@ var (
 	ErrIdentityAlreadyExists = errors.New("identity already exists")
 	//ErrUnableToProcessRequest is returned if an authetication request fails
 	ErrUnableToProcessRequest = errors.New("unable to process request")
+	//ErrInvalidResetToken is returned if a password reset token is unknown, expired or already used
+	ErrInvalidResetToken = errors.New("reset token is invalid or expired")
+	//ErrInvalidVerificationToken is returned if an email verification token is unknown or expired
+	ErrInvalidVerificationToken = errors.New("verification token is invalid or expired")
+	//ErrIdentityNotVerified is returned if a user tries to login before verifying their email address
+	ErrIdentityNotVerified = errors.New("identity is not verified")
+	//ErrInvalidRefreshToken is returned if a refresh token is unknown, expired, revoked or already used
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid")
+	//ErrIncorrectPassword is returned if the current password given to change credentials is wrong
+	ErrIncorrectPassword = errors.New("password is incorrect")
+	//ErrInvalidEmailChangeToken is returned if an email change token is unknown, expired or already used
+	ErrInvalidEmailChangeToken = errors.New("email change token is invalid or expired")
 )
+
+const (
+	//resetTokenTTL is how long a password reset token remains usable
+	resetTokenTTL = time.Hour
+	//signupTokenTTL is how long a pending signup waits for its email to be verified
+	signupTokenTTL = time.Hour * 24 * 7
+	//accessTokenTTL is how long an access token is valid, it is kept short as it cannot be recalled once leaked
+	accessTokenTTL = time.Minute * 15
+	//refreshTokenTTL is how long a refresh token can be used to get a new access token
+	refreshTokenTTL = time.Hour * 24 * 30
+	//emailChangeTokenTTL is how long a new email address has to be verified
+	emailChangeTokenTTL = time.Hour * 24
+)
+
 var emailRegex = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
 //User represent an entity to be authenticated
@@ -32,49 +65,228 @@ type User struct {
 
 type service struct {
 	repo      Repository
-	secretKey string
+	mailer    Mailer
+	keys      *KeySet
+	attempts  AttemptStore
+	policy    *PasswordPolicy
+	publicURL string
+	providers map[string]*OIDCProvider
 }
 
 //TokenResponse is returned carrying the token
 type TokenResponse struct {
-	Token string `json:"token,omitempty"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	//Challenge is returned instead of the tokens when the login has to be completed with a one-time password
+	Challenge string `json:"challenge,omitempty"`
 }
 
-// NewService returns an Authentication Service Provider
-func NewService(repo Repository, secretKey string) Service {
-	return &service{repo: repo, secretKey: secretKey}
+//claims carried by the access token
+type tokenClaims struct {
+	SessionID string   `json:"sid,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	StoreID   string   `json:"store,omitempty"`
+	//IssuedAtNano is when the token was issued to the nanosecond, iat only holds whole seconds
+	IssuedAtNano int64 `json:"iat_ns,omitempty"`
+	jwt.StandardClaims
+}
+
+// NewService returns an Authentication Service Provider signing tokens with the active key of the key set
+// and recording failed logins in the attempt store, new passwords have to satisfy the policy.
+// Links sent by email point to the publicURL of the api. Users can also log in with any of the identity providers
+func NewService(repo Repository, mailer Mailer, keys *KeySet, attempts AttemptStore, policy *PasswordPolicy, publicURL string, providers ...*OIDCProvider) Service {
+	byName := make(map[string]*OIDCProvider, len(providers))
+	for _, p := range providers {
+		byName[p.Name] = p
+	}
+	return &service{repo: repo, mailer: mailer, keys: keys, attempts: attempts, policy: policy,
+		publicURL: strings.TrimSuffix(publicURL, "/"), providers: byName}
+}
+
+//Mailer delivers messages such as password reset tokens to users
+type Mailer interface {
+	Send(ctx context.Context, to string, subject string, body string) error
 }
 
 //Repository provides access to storage facilities for authentication
 type Repository interface {
 	CreateUser(ctx context.Context, email string, password string) (string, error)
+	//UserWithEmail returns ErrIdentityDoesNotExists if no user has the email
 	UserWithEmail(ctx context.Context, email string) (string, error)
 	UserWithIdentity(ctx context.Context, email string, password string) (string, error)
-	ResetPassword(ctx context.Context, email string) error
+	UserEmail(ctx context.Context, userID string) (string, error)
+
+	//CreateSignup stores a pending signup, replacing any earlier pending signup of the email
+	CreateSignup(ctx context.Context, email string, password string, tokenHash string, expiredAt time.Time) error
+	RenewSignupToken(ctx context.Context, email string, tokenHash string, expiredAt time.Time) error
+	//VerifySignup turns the pending signup of the token into a user and returns the userid
+	VerifySignup(ctx context.Context, tokenHash string) (string, error)
+	//HasPendingSignup reports whether the email has a pending signup that has not expired
+	HasPendingSignup(ctx context.Context, email string) (bool, error)
+	SignupWithIdentity(ctx context.Context, email string, password string) error
+	DeleteExpiredSignups(ctx context.Context) (int64, error)
+
+	CreateResetToken(ctx context.Context, userID string, tokenHash string, expiredAt time.Time) error
+	//ResetTokenEmail returns the email of the user a usable reset token was issued to
+	ResetTokenEmail(ctx context.Context, tokenHash string) (string, error)
+	//ResetPassword consumes the reset token, sets the new password and revokes the user's issued tokens
+	ResetPassword(ctx context.Context, tokenHash string, password string) (string, error)
+	TokensValidAfter(ctx context.Context, userID string) (time.Time, error)
+
+	//ChangePassword sets the password and revokes every session of the user except the one to keep
+	ChangePassword(ctx context.Context, userID string, password string, keepSessionID string) error
+	//CreateEmailChange stores a pending change to the email, replacing earlier pending changes of the user
+	CreateEmailChange(ctx context.Context, userID string, email string, tokenHash string, expiredAt time.Time) error
+	//ConfirmEmailChange applies the pending change of the token and returns the userid
+	ConfirmEmailChange(ctx context.Context, tokenHash string) (string, error)
+
+	CreateRefreshToken(ctx context.Context, token RefreshToken) error
+	RefreshTokenWithHash(ctx context.Context, tokenHash string) (*RefreshToken, error)
+	//UseRefreshToken marks the token as used and returns false if it was already used
+	UseRefreshToken(ctx context.Context, tokenHash string) (bool, error)
+	//RevokeSession revokes every refresh token of the session and the access tokens issued with it
+	RevokeSession(ctx context.Context, sessionID string) error
+	SessionRevoked(ctx context.Context, sessionID string) (bool, error)
+
+	//UserAccess resolves the roles of the user and the store they act for
+	UserAccess(ctx context.Context, userID string) (*Principal, error)
+
+	//CreateTOTP stores a new secret for the user, replacing a secret that was never enabled
+	CreateTOTP(ctx context.Context, userID string, secret string) error
+	UserTOTP(ctx context.Context, userID string) (*TOTP, error)
+	//EnableTOTP enables the secret of the user and replaces their recovery codes
+	EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error
+	//UseTOTPStep records the step of an accepted code and returns false if that step was already used
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error)
+	DisableTOTP(ctx context.Context, userID string) error
+	CreateLoginChallenge(ctx context.Context, userID string, tokenHash string, expiredAt time.Time) error
+	//AttemptLoginChallenge counts an attempt at the challenge and returns its user while attempts remain
+	AttemptLoginChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error)
+	UseLoginChallenge(ctx context.Context, tokenHash string) (bool, error)
+	CreateOIDCState(ctx context.Context, stateHash string, state OIDCState) error
+	//UseOIDCState returns the state with the hash, a state can only be used once
+	UseOIDCState(ctx context.Context, stateHash string) (*OIDCState, error)
+	UserWithOIDCIdentity(ctx context.Context, provider string, subject string) (string, error)
+	LinkOIDCIdentity(ctx context.Context, userID string, provider string, subject string) error
+	CreateMagicLink(ctx context.Context, userID string, tokenHash string, expiredAt time.Time) error
+	//UseMagicLink consumes the unexpired magic link and returns its user, a link can only be used once
+	UseMagicLink(ctx context.Context, tokenHash string) (string, error)
+
+	//ScheduleAccountDeletion marks the user for erasure at the time and revokes all their sessions
+	ScheduleAccountDeletion(ctx context.Context, userID string, at time.Time) error
+	CancelAccountDeletion(ctx context.Context, userID string) error
+	//PurgeDeletedAccounts anonymises the users due for erasure and removes their personal data
+	PurgeDeletedAccounts(ctx context.Context) (int64, error)
+	AccountExport(ctx context.Context, userID string) (*AccountExport, error)
 }
 
 //Service defines the constract for accessing authentication services
 type Service interface {
 	VerifyToken(authToken string) (string, bool)
+	VerifyPrincipal(authToken string) (*Principal, bool)
 	CreateUser(ctx context.Context, email string, password string) (bool, error)
-	Login(ctx context.Context, email string, password string) (*TokenResponse, error)
+	VerifyUser(ctx context.Context, token string) error
+	ResendVerification(ctx context.Context, email string) error
+	CleanupExpiredSignups(ctx context.Context) (int64, error)
+	//CheckPassword returns why the password is not acceptable for an account with the email
+	CheckPassword(email string, password string) error
+	//Login checks the identity of the user, address is the client address failed attempts are tracked by
+	Login(ctx context.Context, email string, password string, address string) (*TokenResponse, error)
+	CompleteLogin(ctx context.Context, challenge string, code string) (*TokenResponse, error)
+	//OIDCAuthURL returns the url a user logs in at with the identity provider
+	OIDCAuthURL(ctx context.Context, provider string) (string, error)
+	OIDCLogin(ctx context.Context, provider string, state string, code string) (*TokenResponse, error)
+	//RequestMagicLink emails a one-time login link to the user with the email
+	RequestMagicLink(ctx context.Context, email string) error
+	MagicLogin(ctx context.Context, token string) (*TokenResponse, error)
+	//DeleteAccount schedules the erasure of the account once the password is confirmed and returns when it happens
+	DeleteAccount(ctx context.Context, userID string, password string) (time.Time, error)
+	CancelAccountDeletion(ctx context.Context, userID string) error
+	PurgeDeletedAccounts(ctx context.Context) (int64, error)
+	ExportAccount(ctx context.Context, userID string) (*AccountExport, error)
+	EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID string, code string) ([]string, error)
+	DisableTOTP(ctx context.Context, userID string, code string) error
 	ResetPassword(ctx context.Context, email string) error
+	ConfirmPasswordReset(ctx context.Context, token string, password string) error
+	ChangePassword(ctx context.Context, principal Principal, currentPassword string, password string) error
+	ChangeEmail(ctx context.Context, userID string, password string, email string) error
+	ConfirmEmailChange(ctx context.Context, token string) error
+	RefreshToken(ctx context.Context, refreshToken string) (*TokenResponse, error)
+	Logout(ctx context.Context, refreshToken string) error
+	//JWKS returns the public keys access tokens can be verified with
+	JWKS() JSONWebKeySet
 }
 
 //helper function for emails
 func trimAndLower(value string) string {
 	return strings.ToLower(strings.TrimSpace(value))
 }
+
+//ResetPassword sends a single-use reset token to the email if an account is associated with it.
+//No error is returned for unknown emails so that accounts cannot be enumerated
 func (s *service) ResetPassword(ctx context.Context, email string) error {
+	email = trimAndLower(email)
 
 	valid := isEmailValid(email)
 	if !valid {
-		return errors.New("password length is not accepted")
+		return ErrInvalidEmail
+	}
+	userid, err := s.repo.UserWithEmail(ctx, email)
+	if err != nil {
+		return nil
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+	err = s.repo.CreateResetToken(ctx, userid, hashToken(token), time.Now().Add(resetTokenTTL))
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+
+	body := fmt.Sprintf("A password reset was requested for your couponcutter account.\n\n"+
+		"Use the following code to choose a new password, it expires in %v:\n\n%s\n\n"+
+		"If you did not request this you can ignore this email.", resetTokenTTL, token)
+	err = s.mailer.Send(ctx, email, "Reset your password", body)
+	if err != nil {
+		log.Println(err)
+		return ErrUnableToProcessRequest
+	}
+	return nil
+}
+
+//ConfirmPasswordReset sets a new password for the owner of the reset token
+func (s *service) ConfirmPasswordReset(ctx context.Context, token string, password string) error {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ErrInvalidResetToken
+	}
+	email, err := s.repo.ResetTokenEmail(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, ErrInvalidResetToken) {
+			return ErrInvalidResetToken
+		}
+		return ErrUnableToProcessRequest
+	}
+	err = s.policy.Check(email, password)
+	if err != nil {
+		return err
+	}
+	_, err = s.repo.ResetPassword(ctx, hashToken(token), password)
+	if err != nil {
+		if errors.Is(err, ErrInvalidResetToken) {
+			return ErrInvalidResetToken
+		}
+		return ErrUnableToProcessRequest
 	}
-	return s.repo.ResetPassword(ctx, email)
+	return nil
 }
 
-func (s *service) Login(ctx context.Context, email string, password string) (*TokenResponse, error) {
+func (s *service) Login(ctx context.Context, email string, password string, address string) (*TokenResponse, error) {
 	email = trimAndLower(email)
 
 	//check if email is of valid type
@@ -83,25 +295,45 @@ func (s *service) Login(ctx context.Context, email string, password string) (*To
 		return nil, ErrInvalidEmail
 	}
 	//check if password length if acceptable
-	valid = isPasswordLengthValid(password)
+	valid = s.policy.acceptableForLogin(password)
 	if !valid {
 		return nil, ErrPasswordLengthUnAcceptable
 	}
+	//refuse blocked accounts and addresses before spending time on the password hash
+	err := s.checkAttempts(ctx, email, address)
+	if err != nil {
+		return nil, err
+	}
 	//fetch user with the given email if exists else return error
 	userid, err := s.repo.UserWithIdentity(ctx, email, password)
 	if err != nil {
+		s.failLogin(ctx, email, address)
+		//tell the user to verify their email if they only have a pending signup, the
+		//password is only hashed again when there is one
+		pending, err := s.repo.HasPendingSignup(ctx, email)
+		if err == nil && pending && s.repo.SignupWithIdentity(ctx, email, password) == nil {
+			return nil, ErrIdentityNotVerified
+		}
 		return nil, ErrIdentityDoesNotExists
 	}
 
-	token, err := s.createToken(userid)
-	if err != nil {
+	//users with two-factor authentication get a challenge instead of their tokens,
+	//their failures are only cleared once the challenge is completed
+	totp, err := s.repo.UserTOTP(ctx, userid)
+	if err != nil && !errors.Is(err, ErrTOTPNotEnabled) {
 		return nil, ErrUnableToProcessRequest
 	}
-	return &TokenResponse{Token: token}, nil
+	if err == nil && totp.Enabled {
+		return s.loginChallenge(ctx, userid)
+	}
+
+	s.succeedLogin(ctx, email)
+	return s.startSession(ctx, userid)
 
 }
 
-//TODO  fix bug
+//CreateUser registers a pending user and sends a verification token to the email,
+//the user is only created once the email is verified
 func (s *service) CreateUser(ctx context.Context, email string, password string) (bool, error) {
 	email = trimAndLower(email)
 
@@ -110,27 +342,92 @@ func (s *service) CreateUser(ctx context.Context, email string, password string)
 	if !valid {
 		return false, ErrInvalidEmail
 	}
-	//check if password length if acceptable
-	valid = isPasswordLengthValid(password)
-	if !valid {
-		return false, ErrPasswordLengthUnAcceptable
+	//check if the password satisfies the password policy
+	err := s.policy.Check(email, password)
+	if err != nil {
+		return false, err
 	}
 	//fetch user with the given email if exists else return error
-	_, err := s.repo.UserWithEmail(ctx, email)
+	_, err = s.repo.UserWithEmail(ctx, email)
 	if err == nil {
 		return false, ErrIdentityAlreadyExists
 	}
-	s.repo.CreateUser(ctx, email, password)
+
+	token, err := generateToken()
+	if err != nil {
+		return false, ErrUnableToProcessRequest
+	}
+	err = s.repo.CreateSignup(ctx, email, password, hashToken(token), time.Now().Add(signupTokenTTL))
+	if err != nil {
+		if errors.Is(err, ErrIdentityAlreadyExists) {
+			return false, ErrIdentityAlreadyExists
+		}
+		return false, ErrUnableToProcessRequest
+	}
+	err = s.sendVerification(ctx, email, token)
+	if err != nil {
+		return false, ErrUnableToProcessRequest
+	}
 	return true, nil
 
 }
 
-// check if the password if of proper length
-func isPasswordLengthValid(password string) bool {
-	if len(password) < 6 || len(password) > 64 {
-		return false
+//VerifyUser creates the user of the pending signup associated with the token
+func (s *service) VerifyUser(ctx context.Context, token string) error {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ErrInvalidVerificationToken
+	}
+	_, err := s.repo.VerifySignup(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, ErrInvalidVerificationToken) || errors.Is(err, ErrIdentityAlreadyExists) {
+			return err
+		}
+		return ErrUnableToProcessRequest
+	}
+	return nil
+}
+
+//ResendVerification issues a new verification token for a pending signup.
+//Like ResetPassword no error is returned for unknown emails
+func (s *service) ResendVerification(ctx context.Context, email string) error {
+	email = trimAndLower(email)
+
+	valid := isEmailValid(email)
+	if !valid {
+		return ErrInvalidEmail
+	}
+	token, err := generateToken()
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+	err = s.repo.RenewSignupToken(ctx, email, hashToken(token), time.Now().Add(signupTokenTTL))
+	if err != nil {
+		if errors.Is(err, ErrIdentityDoesNotExists) {
+			return nil
+		}
+		return ErrUnableToProcessRequest
+	}
+	err = s.sendVerification(ctx, email, token)
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+	return nil
+}
+
+//CleanupExpiredSignups removes the pending signups that were never verified
+func (s *service) CleanupExpiredSignups(ctx context.Context) (int64, error) {
+	return s.repo.DeleteExpiredSignups(ctx)
+}
+
+func (s *service) sendVerification(ctx context.Context, email string, token string) error {
+	body := fmt.Sprintf("Welcome to couponcutter.\n\n"+
+		"Verify your email address with the following code, it expires in %v:\n\n%s", signupTokenTTL, token)
+	err := s.mailer.Send(ctx, email, "Verify your email address", body)
+	if err != nil {
+		log.Println(err)
 	}
-	return true
+	return err
 }
 
 //check if the email address is a valid one
@@ -141,29 +438,53 @@ func isEmailValid(email string) bool {
 	return emailRegex.MatchString(email)
 }
 
-//create a token from the userid
-func (s *service) createToken(userID string) (string, error) {
-	claim := jwt.StandardClaims{
-		Subject:   userID,
-		ExpiresAt: time.Now().Add(time.Hour * 24 * 25).Unix(),
-	}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
-	token, err := t.SignedString([]byte(s.secretKey))
+//generate a random url safe token
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
 	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-		return "", errors.New("unable to create token")
+//tokens are stored hashed so a leaked table cannot be used to take over accounts
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//issuedAfter reports whether the token was issued after the time, a token without iat_ns
+//issued within the same second is not
+func (c *tokenClaims) issuedAfter(t time.Time) bool {
+	if c.IssuedAtNano != 0 {
+		return time.Unix(0, c.IssuedAtNano).After(t)
 	}
-	return token, err
+	return c.IssuedAt > t.Unix()
 }
 
-//create a token from the userid
-func (s *service) CreateSignupToken(email string) (string, error) {
-	claim := jwt.StandardClaims{
-		Subject:   email,
-		ExpiresAt: time.Now().Add(time.Hour * 24 * 7).Unix(),
+//create an access token for the principal in the given session
+func (s *service) createToken(principal Principal, sessionID string) (string, error) {
+	now := time.Now()
+	claim := tokenClaims{
+		SessionID:    sessionID,
+		Roles:        principal.Roles,
+		StoreID:      principal.StoreID,
+		IssuedAtNano: now.UnixNano(),
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Subject:   principal.UserID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(accessTokenTTL).Unix(),
+		},
+	}
+	key, err := s.keys.signingKey()
+	if err != nil {
+		return "", err
 	}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
-	token, err := t.SignedString([]byte(s.secretKey))
+	t := jwt.NewWithClaims(key.method(), claim)
+	t.Header["kid"] = key.ID
+	token, err := t.SignedString(key.PrivateKey)
 	if err != nil {
 
 		return "", errors.New("unable to create token")
@@ -172,32 +493,73 @@ func (s *service) CreateSignupToken(email string) (string, error) {
 }
 
 // verify a token
-func (s *service) verifytoken(authtoken string) (*jwt.Token, error) {
+func (s *service) verifytoken(authtoken string) (*tokenClaims, error) {
 
-	token, err := jwt.Parse(authtoken, func(token *jwt.Token) (interface{}, error) {
-		_, ok := token.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			return "", errors.New("token not properly signed")
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(authtoken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := s.keys.verificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		//the algorithm is taken from the key, never from the token
+		if token.Method.Alg() != key.Algorithm {
+			return nil, errors.New("token not properly signed")
 		}
-		return []byte(s.secretKey), nil
+		return key.PrivateKey.Public(), nil
 	})
 	if err != nil {
 		return nil, errors.New("invalid token, signature is false")
 	}
-	if token.Claims.Valid() != nil {
+	if claims.Valid() != nil || claims.Subject == "" {
 		return nil, errors.New("invalid token")
 	}
 
-	return token, nil
+	return claims, nil
+}
+
+//JWKS returns the public keys of the keys that are not retired
+func (s *service) JWKS() JSONWebKeySet {
+	return s.keys.JWKS()
 }
 
 func (s *service) VerifyToken(authToken string) (string, bool) {
+	principal, ok := s.VerifyPrincipal(authToken)
+	if !ok {
+		return "", false
+	}
+	return principal.UserID, true
+}
 
-	token, err := s.verifytoken(authToken)
+//VerifyPrincipal verifies the token and returns the user and access it carries
+func (s *service) VerifyPrincipal(authToken string) (*Principal, bool) {
+
+	claims, err := s.verifytoken(authToken)
 	if err != nil {
-		return "", false
+		return nil, false
+	}
+
+	//tokens issued before a password reset are no longer accepted
+	validAfter, err := s.repo.TokensValidAfter(context.Background(), claims.Subject)
+	if err != nil {
+		return nil, false
+	}
+	if !claims.issuedAfter(validAfter) {
+		return nil, false
+	}
+
+	//tokens of a session that was logged out or found to be compromised are revoked
+	if claims.SessionID != "" {
+		revoked, err := s.repo.SessionRevoked(context.Background(), claims.SessionID)
+		if err != nil || revoked {
+			return nil, false
+		}
 	}
-	c := token.Claims.(jwt.MapClaims)
-	return c["sub"].(string), true
+	return &Principal{
+		UserID:    claims.Subject,
+		Roles:     claims.Roles,
+		StoreID:   claims.StoreID,
+		SessionID: claims.SessionID,
+	}, true
 
 }
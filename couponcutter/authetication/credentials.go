@@ -0,0 +1,123 @@
+package authetication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+//ChangePassword sets a new password for the user once the current one is confirmed.
+//Every other session of the user is revoked, the session the change was made from stays logged in
+func (s *service) ChangePassword(ctx context.Context, principal Principal, currentPassword string, password string) error {
+	email, err := s.repo.UserEmail(ctx, principal.UserID)
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+	err = s.confirmPassword(ctx, email, currentPassword)
+	if err != nil {
+		return err
+	}
+	err = s.policy.Check(email, password)
+	if err != nil {
+		return err
+	}
+	err = s.repo.ChangePassword(ctx, principal.UserID, password, principal.SessionID)
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+
+	body := "The password of your couponcutter account was changed and your other devices were logged out.\n\n" +
+		"If you did not change it, reset your password immediately."
+	err = s.mailer.Send(ctx, email, "Your password was changed", body)
+	if err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+//ChangeEmail sends a verification token to the new address, the email of the user
+//only changes once the token is confirmed with ConfirmEmailChange
+func (s *service) ChangeEmail(ctx context.Context, userID string, password string, email string) error {
+	email = trimAndLower(email)
+
+	valid := isEmailValid(email)
+	if !valid {
+		return ErrInvalidEmail
+	}
+	current, err := s.repo.UserEmail(ctx, userID)
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+	err = s.confirmPassword(ctx, current, password)
+	if err != nil {
+		return err
+	}
+	if email == current {
+		return ErrIdentityAlreadyExists
+	}
+	_, err = s.repo.UserWithEmail(ctx, email)
+	if err == nil {
+		return ErrIdentityAlreadyExists
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+	err = s.repo.CreateEmailChange(ctx, userID, email, hashToken(token), time.Now().Add(emailChangeTokenTTL))
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+
+	body := fmt.Sprintf("A change of the email address of your couponcutter account to this address was requested.\n\n"+
+		"Confirm the change with the following code, it expires in %v:\n\n%s", emailChangeTokenTTL, token)
+	err = s.mailer.Send(ctx, email, "Confirm your new email address", body)
+	if err != nil {
+		log.Println(err)
+		return ErrUnableToProcessRequest
+	}
+	return nil
+}
+
+//ConfirmEmailChange changes the email of the user the token was sent for
+func (s *service) ConfirmEmailChange(ctx context.Context, token string) error {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ErrInvalidEmailChangeToken
+	}
+	_, err := s.repo.ConfirmEmailChange(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, ErrInvalidEmailChangeToken) || errors.Is(err, ErrIdentityAlreadyExists) {
+			return err
+		}
+		return ErrUnableToProcessRequest
+	}
+	return nil
+}
+
+//confirmPassword checks the current password of a logged in user, failures count
+//against the account like failed logins so a stolen token cannot be used to guess it
+func (s *service) confirmPassword(ctx context.Context, email string, password string) error {
+	if !s.policy.acceptableForLogin(password) {
+		return ErrIncorrectPassword
+	}
+	err := s.checkAttempts(ctx, email, "")
+	if err != nil {
+		return err
+	}
+	_, err = s.repo.UserWithIdentity(ctx, email, password)
+	if err != nil {
+		s.failLogin(ctx, email, "")
+		return ErrIncorrectPassword
+	}
+	return nil
+}
+
+//CheckPassword applies the password policy to a password chosen for an account that is
+//created outside the service, such as by accepting an employee invite
+func (s *service) CheckPassword(email string, password string) error {
+	return s.policy.Check(trimAndLower(email), password)
+}
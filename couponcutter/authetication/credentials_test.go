@@ -0,0 +1,118 @@
+package authetication
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func (m *mockRepo) ChangePassword(ctx context.Context, userID string, password string, keepSessionID string) error {
+	if m.passwords == nil {
+		m.passwords = make(map[string]string)
+	}
+	m.passwords[userID] = password
+	m.kept = keepSessionID
+	return nil
+}
+func (m *mockRepo) CreateEmailChange(ctx context.Context, userID string, email string, tokenHash string, expiredAt time.Time) error {
+	if m.changes == nil {
+		m.changes = make(map[string][2]string)
+	}
+	m.changes[tokenHash] = [2]string{userID, email}
+	return nil
+}
+func (m *mockRepo) ConfirmEmailChange(ctx context.Context, tokenHash string) (string, error) {
+	change, ok := m.changes[tokenHash]
+	if !ok {
+		return "", ErrInvalidEmailChangeToken
+	}
+	delete(m.changes, tokenHash)
+	return change[0], nil
+}
+
+func Test_service_ChangePassword(t *testing.T) {
+	repo := &mockRepo{}
+	mailer := &mockMailer{}
+	s := &service{repo: repo, mailer: mailer, keys: testKeys(t), attempts: NewMemoryAttemptStore(), policy: DefaultPasswordPolicy()}
+	ctx := context.Background()
+	principal := Principal{UserID: "12ddf", SessionID: "session-1"}
+
+	err := s.ChangePassword(ctx, principal, "wrong password", "new password")
+	if !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("ChangePassword() with a wrong password error = %v, want %v", err, ErrIncorrectPassword)
+	}
+	err = s.ChangePassword(ctx, principal, "password", "user@gmail.com")
+	if !errors.Is(err, ErrPasswordIsEmail) {
+		t.Errorf("ChangePassword() to the email error = %v, want %v", err, ErrPasswordIsEmail)
+	}
+	err = s.ChangePassword(ctx, principal, "password", "new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.kept != "session-1" {
+		t.Errorf("kept session = %q, want the session of the change", repo.kept)
+	}
+	if mailer.to != "user@gmail.com" {
+		t.Errorf("notification sent to %q", mailer.to)
+	}
+	if _, err := s.Login(ctx, "user@gmail.com", "new password", ""); err != nil {
+		t.Errorf("Login() with the new password error = %v", err)
+	}
+
+	//guessing the current password is throttled like logins
+	for i := 0; i < accountPolicy.FreeAttempts; i++ {
+		s.ChangePassword(ctx, principal, "wrong password", "other password")
+	}
+	err = s.ChangePassword(ctx, principal, "new password", "other password")
+	if !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("ChangePassword() after wrong passwords error = %v, want %v", err, ErrTooManyAttempts)
+	}
+}
+
+func Test_service_ChangeEmail(t *testing.T) {
+	repo := &mockRepo{}
+	mailer := &mockMailer{}
+	s := &service{repo: repo, mailer: mailer, keys: testKeys(t), attempts: NewMemoryAttemptStore(), policy: DefaultPasswordPolicy()}
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		want     error
+	}{
+		{name: "invalid email", password: "password", email: "new", want: ErrInvalidEmail},
+		{name: "wrong password", password: "wrong password", email: "new@gmail.com", want: ErrIncorrectPassword},
+		{name: "email of another user", password: "password", email: "user1@gmail.com", want: ErrIdentityAlreadyExists},
+		{name: "same email", password: "password", email: "USER@gmail.com", want: ErrIdentityAlreadyExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.ChangeEmail(ctx, "12ddf", tt.password, tt.email); !errors.Is(err, tt.want) {
+				t.Errorf("ChangeEmail() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	err := s.ChangeEmail(ctx, "12ddf", "password", " New@gmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mailer.to != "new@gmail.com" {
+		t.Fatalf("verification sent to %q, want the new address", mailer.to)
+	}
+	token := tokenFromMail(mailer.body)
+	if _, ok := repo.changes[token]; ok {
+		t.Errorf("email change token must not be stored in plain text")
+	}
+	if err := s.ConfirmEmailChange(ctx, "unknown"); !errors.Is(err, ErrInvalidEmailChangeToken) {
+		t.Errorf("ConfirmEmailChange() with an unknown token error = %v", err)
+	}
+	if err := s.ConfirmEmailChange(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ConfirmEmailChange(ctx, token); !errors.Is(err, ErrInvalidEmailChangeToken) {
+		t.Errorf("ConfirmEmailChange() reusing the token error = %v", err)
+	}
+}
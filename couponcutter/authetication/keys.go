@@ -0,0 +1,292 @@
+package authetication
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/form3tech-oss/jwt-go"
+)
+
+var (
+	//ErrUnknownKey is returned if a key id is not part of the key set
+	ErrUnknownKey = errors.New("signing key is unknown")
+	//ErrUnsupportedKey is returned if a key is neither an Ed25519 nor an RSA key
+	ErrUnsupportedKey = errors.New("signing key type is not supported")
+	//ErrNoSigningKey is returned if the key set has no active key to sign tokens with
+	ErrNoSigningKey = errors.New("no active signing key")
+)
+
+const (
+	//AlgEdDSA signs tokens with an Ed25519 key
+	AlgEdDSA = "EdDSA"
+	//AlgRS256 signs tokens with an RSA key using PKCS1 v1.5 and SHA-256
+	AlgRS256 = "RS256"
+)
+
+//SigningMethodEdDSA implements the EdDSA signing method for Ed25519 keys,
+//it is registered with jwt-go which only ships the HMAC, RSA and ECDSA methods
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(AlgEdDSA, func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type signingMethodEdDSA struct{}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return AlgEdDSA
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+//SigningKey is a private key used to sign access tokens, identified in the token by its kid
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	Retired    bool
+}
+
+//NewSigningKey wraps an Ed25519 or RSA private key and picks the matching algorithm
+func NewSigningKey(id string, privateKey crypto.Signer) (*SigningKey, error) {
+	switch privateKey.(type) {
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: id, Algorithm: AlgEdDSA, PrivateKey: privateKey}, nil
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: id, Algorithm: AlgRS256, PrivateKey: privateKey}, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+//GenerateSigningKey creates a new Ed25519 signing key
+func GenerateSigningKey(id string) (*SigningKey, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKey(id, privateKey)
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return SigningMethodEdDSA
+}
+
+//KeySet holds the keys of the service. New tokens are signed with the active key
+//while tokens signed by any key that is not retired are accepted, so keys can be
+//rotated by adding and activating a new key and retiring the old one once the
+//tokens it signed have expired
+type KeySet struct {
+	mu     sync.RWMutex
+	keys   map[string]*SigningKey
+	order  []string
+	active string
+}
+
+//NewKeySet returns a key set, the last key given becomes the active key
+func NewKeySet(keys ...*SigningKey) *KeySet {
+	set := &KeySet{keys: map[string]*SigningKey{}}
+	for _, key := range keys {
+		set.Add(key)
+		set.active = key.ID
+	}
+	return set
+}
+
+//Add adds a key that is used to verify tokens, it does not sign tokens until activated
+func (ks *KeySet) Add(key *SigningKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if _, ok := ks.keys[key.ID]; !ok {
+		ks.order = append(ks.order, key.ID)
+	}
+	ks.keys[key.ID] = key
+}
+
+//Activate makes the key the one new tokens are signed with
+func (ks *KeySet) Activate(id string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key, ok := ks.keys[id]
+	if !ok || key.Retired {
+		return ErrUnknownKey
+	}
+	ks.active = id
+	return nil
+}
+
+//Retire stops accepting tokens signed by the key, the active key cannot be retired
+func (ks *KeySet) Retire(id string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key, ok := ks.keys[id]
+	if !ok || id == ks.active {
+		return ErrUnknownKey
+	}
+	key.Retired = true
+	return nil
+}
+
+//signingKey returns the active key
+func (ks *KeySet) signingKey() (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[ks.active]
+	if !ok {
+		return nil, ErrNoSigningKey
+	}
+	return key, nil
+}
+
+//verificationKey returns the key with the id if it is still accepted
+func (ks *KeySet) verificationKey(id string) (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[id]
+	if !ok || key.Retired {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+//JSONWebKey is the public part of a signing key as described in RFC 7517
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+//JSONWebKeySet is the document served to clients verifying access tokens on their own
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+//JWKS returns the public keys of every key that is not retired
+func (ks *KeySet) JWKS() JSONWebKeySet {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, id := range ks.order {
+		key := ks.keys[id]
+		if key.Retired {
+			continue
+		}
+		jwk := JSONWebKey{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch publicKey := key.PrivateKey.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+//LoadKeySet reads the PKCS8 PEM encoded private keys stored in the directory,
+//each file is named after the id of its key. Keys are ordered by name and the last
+//one is active, a new key is generated if the directory holds none.
+//Retire a key by removing its file once the tokens it signed have expired
+func LoadKeySet(dir string) (*KeySet, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var keys []*SigningKey
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no pem data found in " + file)
+		}
+		privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := privateKey.(crypto.Signer)
+		if !ok {
+			return nil, ErrUnsupportedKey
+		}
+		key, err := NewSigningKey(strings.TrimSuffix(filepath.Base(file), ".pem"), signer)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		key, err := GenerateSigningKey(time.Now().UTC().Format("20060102150405"))
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		err = ioutil.WriteFile(filepath.Join(dir, key.ID+".pem"), data, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewKeySet(keys...), nil
+}
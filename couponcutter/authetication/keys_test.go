@@ -0,0 +1,149 @@
+package authetication
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/form3tech-oss/jwt-go"
+)
+
+func testKeys(t *testing.T) *KeySet {
+	key, err := GenerateSigningKey("test-ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewKeySet(key)
+}
+
+func testRSAKeys(t *testing.T) *KeySet {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewSigningKey("test-rsa", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewKeySet(key)
+}
+
+//decode the header of a token
+func tokenHeader(t *testing.T, token string) map[string]interface{} {
+	data, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := map[string]interface{}{}
+	err = json.Unmarshal(data, &header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+func Test_KeySet_Rotation(t *testing.T) {
+	oldKey, _ := GenerateSigningKey("old")
+	newKey, _ := GenerateSigningKey("new")
+	keys := NewKeySet(oldKey)
+	s := &service{repo: &mockRepo{}, keys: keys}
+
+	oldToken, err := s.createToken(Principal{UserID: "12ddf"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenHeader(t, oldToken)["kid"]; kid != "old" {
+		t.Errorf("kid = %v, want old", kid)
+	}
+
+	keys.Add(newKey)
+	if err := keys.Activate("new"); err != nil {
+		t.Fatal(err)
+	}
+	newToken, _ := s.createToken(Principal{UserID: "12ddf"}, "")
+	if kid := tokenHeader(t, newToken)["kid"]; kid != "new" {
+		t.Errorf("kid = %v, want new", kid)
+	}
+
+	//tokens of the previous key are accepted until it is retired
+	if _, ok := s.VerifyToken(oldToken); !ok {
+		t.Errorf("token of the previous key should be accepted")
+	}
+	if len(s.JWKS().Keys) != 2 {
+		t.Errorf("jwks has %d keys, want 2", len(s.JWKS().Keys))
+	}
+
+	if err := keys.Retire("new"); err == nil {
+		t.Errorf("the active key should not be retired")
+	}
+	if err := keys.Retire("old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.VerifyToken(oldToken); ok {
+		t.Errorf("token of a retired key should be rejected")
+	}
+	if _, ok := s.VerifyToken(newToken); !ok {
+		t.Errorf("token of the active key should be accepted")
+	}
+	jwks := s.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].KeyID != "new" || jwks.Keys[0].Curve != "Ed25519" {
+		t.Errorf("unexpected jwks %+v", jwks)
+	}
+}
+
+func Test_verifytoken_RejectsForeignTokens(t *testing.T) {
+	keys := testRSAKeys(t)
+	s := &service{repo: &mockRepo{}, keys: keys}
+	key, _ := keys.signingKey()
+
+	claims := jwt.StandardClaims{Subject: "12ddf"}
+
+	//a token signed with the public key as an HMAC secret must not be accepted
+	publicKey := key.PrivateKey.Public().(*rsa.PublicKey)
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmacToken.Header["kid"] = key.ID
+	signed, _ := hmacToken.SignedString(publicKey.N.Bytes())
+	if _, ok := s.VerifyToken(signed); ok {
+		t.Errorf("hmac token should be rejected")
+	}
+
+	//a token signed by a key that is not part of the set
+	other := testKeys(t)
+	foreign, _ := (&service{keys: other}).createToken(Principal{UserID: "12ddf"}, "")
+	if _, ok := s.VerifyToken(foreign); ok {
+		t.Errorf("token of an unknown key should be rejected")
+	}
+}
+
+func Test_LoadKeySet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &service{repo: &mockRepo{}, keys: keys}
+	token, err := s.createToken(Principal{UserID: "12ddf"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the generated key is written so tokens survive a restart
+	reloaded, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = &service{repo: &mockRepo{}, keys: reloaded}
+	if _, ok := s.VerifyToken(token); !ok {
+		t.Errorf("token should be valid with the reloaded keys")
+	}
+}
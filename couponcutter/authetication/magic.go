@@ -0,0 +1,77 @@
+package authetication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//ErrInvalidMagicLink is returned if a magic link is unknown, expired or already used
+var ErrInvalidMagicLink = errors.New("magic link is invalid or expired")
+
+//magicLinkTTL is how long a magic link can be used to log in
+const magicLinkTTL = time.Minute * 15
+
+//RequestMagicLink emails a one-time login link to the user with the email.
+//No error is returned for unknown emails so that accounts cannot be enumerated
+func (s *service) RequestMagicLink(ctx context.Context, email string) error {
+	email = trimAndLower(email)
+
+	valid := isEmailValid(email)
+	if !valid {
+		return ErrInvalidEmail
+	}
+	userid, err := s.repo.UserWithEmail(ctx, email)
+	if err != nil {
+		return nil
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+	err = s.repo.CreateMagicLink(ctx, userid, hashToken(token), time.Now().Add(magicLinkTTL))
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+
+	link := s.publicURL + "/user/login/magic?" + url.Values{"token": {token}}.Encode()
+	body := fmt.Sprintf("A login link was requested for your couponcutter account.\n\n"+
+		"Open the following link to log in, it can be used once and expires in %v:\n\n%s\n\n"+
+		"If you did not request this you can ignore this email.", magicLinkTTL, link)
+	err = s.mailer.Send(ctx, email, "Your login link", body)
+	if err != nil {
+		log.Println(err)
+		return ErrUnableToProcessRequest
+	}
+	return nil
+}
+
+//MagicLogin logs in the user the magic link was sent to, users with two-factor
+//authentication are answered with a challenge like a login with a password
+func (s *service) MagicLogin(ctx context.Context, token string) (*TokenResponse, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, ErrInvalidMagicLink
+	}
+	userid, err := s.repo.UseMagicLink(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, ErrInvalidMagicLink) {
+			return nil, ErrInvalidMagicLink
+		}
+		return nil, ErrUnableToProcessRequest
+	}
+
+	totp, err := s.repo.UserTOTP(ctx, userid)
+	if err != nil && !errors.Is(err, ErrTOTPNotEnabled) {
+		return nil, ErrUnableToProcessRequest
+	}
+	if err == nil && totp.Enabled {
+		return s.loginChallenge(ctx, userid)
+	}
+	return s.startSession(ctx, userid)
+}
@@ -0,0 +1,112 @@
+package authetication
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockMagicLink struct {
+	userID    string
+	expiredAt time.Time
+	used      bool
+}
+
+func (m *mockRepo) CreateMagicLink(ctx context.Context, userID string, tokenHash string, expiredAt time.Time) error {
+	if m.magicLinks == nil {
+		m.magicLinks = make(map[string]*mockMagicLink)
+	}
+	m.magicLinks[tokenHash] = &mockMagicLink{userID: userID, expiredAt: expiredAt}
+	return nil
+}
+func (m *mockRepo) UseMagicLink(ctx context.Context, tokenHash string) (string, error) {
+	link, ok := m.magicLinks[tokenHash]
+	if !ok || link.used || time.Now().After(link.expiredAt) {
+		return "", ErrInvalidMagicLink
+	}
+	link.used = true
+	return link.userID, nil
+}
+
+//returns the token of the magic link in a mail sent by the service
+func magicTokenFromMail(t *testing.T, body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "https://api.couponcutter.test/user/login/magic?") {
+			u, err := url.Parse(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return u.Query().Get("token")
+		}
+	}
+	t.Fatalf("no magic link in %q", body)
+	return ""
+}
+
+func Test_service_MagicLogin(t *testing.T) {
+	repo := &mockRepo{}
+	mailer := &mockMailer{}
+	s := &service{repo: repo, mailer: mailer, keys: testKeys(t), attempts: NewMemoryAttemptStore(),
+		policy: DefaultPasswordPolicy(), publicURL: "https://api.couponcutter.test"}
+	ctx := context.Background()
+
+	err := s.RequestMagicLink(ctx, "not an email")
+	if !errors.Is(err, ErrInvalidEmail) {
+		t.Fatalf("RequestMagicLink() error = %v, want %v", err, ErrInvalidEmail)
+	}
+	//unknown emails are not told apart from registered ones
+	err = s.RequestMagicLink(ctx, "nobody@gmail.com")
+	if err != nil || mailer.to != "" {
+		t.Fatalf("RequestMagicLink() of an unknown email error = %v, mailed to %q", err, mailer.to)
+	}
+
+	err = s.RequestMagicLink(ctx, " User1@gmail.com ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mailer.to != "user1@gmail.com" {
+		t.Fatalf("magic link mailed to %q", mailer.to)
+	}
+	token := magicTokenFromMail(t, mailer.body)
+	if _, ok := repo.magicLinks[token]; ok {
+		t.Error("magic link token is stored in plain text")
+	}
+
+	response, err := s.MagicLogin(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal, ok := s.VerifyPrincipal(response.Token)
+	if !ok || principal.UserID != "j332v" || response.RefreshToken == "" {
+		t.Fatalf("MagicLogin() = %+v", response)
+	}
+
+	//a link can only be used once
+	_, err = s.MagicLogin(ctx, token)
+	if !errors.Is(err, ErrInvalidMagicLink) {
+		t.Errorf("MagicLogin() with a used link error = %v", err)
+	}
+
+	//an expired link is refused
+	s.RequestMagicLink(ctx, "user1@gmail.com")
+	token = magicTokenFromMail(t, mailer.body)
+	repo.magicLinks[hashToken(token)].expiredAt = time.Now().Add(-time.Second)
+	_, err = s.MagicLogin(ctx, token)
+	if !errors.Is(err, ErrInvalidMagicLink) {
+		t.Errorf("MagicLogin() with an expired link error = %v", err)
+	}
+
+	//users with two-factor authentication still have to complete the challenge
+	repo.totp = map[string]*TOTP{"j332v": {Secret: "secret", Enabled: true}}
+	s.RequestMagicLink(ctx, "user1@gmail.com")
+	response, err = s.MagicLogin(ctx, magicTokenFromMail(t, mailer.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Token != "" || response.Challenge == "" {
+		t.Errorf("MagicLogin() of a user with 2fa = %+v, want a challenge", response)
+	}
+}
@@ -0,0 +1,396 @@
+package authetication
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/form3tech-oss/jwt-go"
+)
+
+var (
+	//ErrUnknownProvider is returned if no identity provider is configured with the name
+	ErrUnknownProvider = errors.New("identity provider is unknown")
+	//ErrInvalidOIDCState is returned if a login callback does not belong to a login started here
+	ErrInvalidOIDCState = errors.New("login state is invalid or expired")
+	//ErrOIDCLoginRejected is returned if the identity provider refuses the authorization code
+	ErrOIDCLoginRejected = errors.New("identity provider rejected the login")
+	//ErrInvalidIDToken is returned if the id token of the identity provider cannot be trusted
+	ErrInvalidIDToken = errors.New("id token is invalid")
+	//ErrOIDCEmailNotVerified is returned if the identity provider has not verified the email of the user
+	ErrOIDCEmailNotVerified = errors.New("identity provider has not verified the email")
+)
+
+//oidcStateTTL is how long a user has to complete the login at the identity provider
+const oidcStateTTL = time.Minute * 10
+
+//OIDCState is what is remembered of a login between the redirect to the identity provider and its callback
+type OIDCState struct {
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	ExpiredAt    time.Time
+}
+
+//OIDCProvider is an OpenID Connect identity provider users can log in with using
+//the authorization code flow with PKCE. Its endpoints are discovered from the issuer.
+//Users are linked to existing accounts by their email, so only providers trusted to
+//verify email addresses should be configured
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Client       *http.Client
+
+	mu     sync.Mutex
+	config *oidcConfiguration
+	keys   map[string]*rsa.PublicKey
+}
+
+//NewOIDCProvider returns a provider, its configuration is discovered on first use
+func NewOIDCProvider(name, issuer, clientID, clientSecret, redirectURL string) *OIDCProvider {
+	return &OIDCProvider{
+		Name:         name,
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Client:       &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+type oidcConfiguration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+//oidcClaims are the claims of an id token the login relies on
+type oidcClaims struct {
+	jwt.StandardClaims
+	Audience      oidcAudience `json:"aud"`
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified oidcBool     `json:"email_verified"`
+}
+
+//oidcAudience accepts an audience sent as a single string or as a list
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = oidcAudience{single}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(data, &list)
+	*a = list
+	return err
+}
+
+func (a oidcAudience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+//oidcBool accepts booleans some providers send as strings
+type oidcBool bool
+
+func (b *oidcBool) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	*b = oidcBool(value == "true")
+	return nil
+}
+
+//OIDCAuthURL starts a login with the provider and returns the url to send the user to
+func (s *service) OIDCAuthURL(ctx context.Context, provider string) (string, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+	config, err := p.discover(ctx)
+	if err != nil {
+		return "", ErrUnableToProcessRequest
+	}
+	state, err := generateToken()
+	if err != nil {
+		return "", ErrUnableToProcessRequest
+	}
+	verifier, err := generateToken()
+	if err != nil {
+		return "", ErrUnableToProcessRequest
+	}
+	nonce, err := generateToken()
+	if err != nil {
+		return "", ErrUnableToProcessRequest
+	}
+	err = s.repo.CreateOIDCState(ctx, hashToken(state), OIDCState{
+		Provider:     provider,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiredAt:    time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		return "", ErrUnableToProcessRequest
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {"openid email"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(config.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return config.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+//OIDCLogin completes a login with the code the provider redirected the user back with.
+//The identity is linked to the account with the same verified email, an account is
+//created if there is none
+func (s *service) OIDCLogin(ctx context.Context, provider string, state string, code string) (*TokenResponse, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	state = strings.TrimSpace(state)
+	if state == "" || code == "" {
+		return nil, ErrInvalidOIDCState
+	}
+	saved, err := s.repo.UseOIDCState(ctx, hashToken(state))
+	if err != nil {
+		if errors.Is(err, ErrInvalidOIDCState) {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, ErrUnableToProcessRequest
+	}
+	if saved.Provider != provider || time.Now().After(saved.ExpiredAt) {
+		return nil, ErrInvalidOIDCState
+	}
+
+	idToken, err := p.exchange(ctx, code, saved.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := p.verifyIDToken(ctx, idToken, saved.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := s.repo.UserWithOIDCIdentity(ctx, provider, claims.Subject)
+	if err != nil {
+		if !errors.Is(err, ErrIdentityDoesNotExists) {
+			return nil, ErrUnableToProcessRequest
+		}
+		userID, err = s.linkOIDCIdentity(ctx, provider, claims)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	totp, err := s.repo.UserTOTP(ctx, userID)
+	if err != nil && !errors.Is(err, ErrTOTPNotEnabled) {
+		return nil, ErrUnableToProcessRequest
+	}
+	if err == nil && totp.Enabled {
+		return s.loginChallenge(ctx, userID)
+	}
+	return s.startSession(ctx, userID)
+}
+
+//links the identity to the user with its verified email, creating the user if there is none
+func (s *service) linkOIDCIdentity(ctx context.Context, provider string, claims *oidcClaims) (string, error) {
+	if !claims.EmailVerified {
+		return "", ErrOIDCEmailNotVerified
+	}
+	email := trimAndLower(claims.Email)
+	if !isEmailValid(email) {
+		return "", ErrOIDCEmailNotVerified
+	}
+	userID, err := s.repo.UserWithEmail(ctx, email)
+	if err != nil {
+		//only a missing user is created, a failed lookup must not create a second account
+		if !errors.Is(err, ErrIdentityDoesNotExists) {
+			return "", ErrUnableToProcessRequest
+		}
+		//the user never learns this password, they can set one with a password reset
+		password, err := generateToken()
+		if err != nil {
+			return "", ErrUnableToProcessRequest
+		}
+		userID, err = s.repo.CreateUser(ctx, email, password)
+		if err != nil {
+			return "", ErrUnableToProcessRequest
+		}
+	}
+	err = s.repo.LinkOIDCIdentity(ctx, userID, provider, claims.Subject)
+	if err != nil {
+		return "", ErrUnableToProcessRequest
+	}
+	return userID, nil
+}
+
+//discover fetches the configuration of the provider from its issuer once
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcConfiguration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.config != nil {
+		return p.config, nil
+	}
+	config := &oidcConfiguration{}
+	err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", config)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(config.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc: issuer %q does not match %q", config.Issuer, p.Issuer)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: incomplete configuration of %q", p.Issuer)
+	}
+	p.config = config
+	return config, nil
+}
+
+//exchange redeems the authorization code for the id token of the user
+func (p *OIDCProvider) exchange(ctx context.Context, code string, verifier string) (string, error) {
+	config, err := p.discover(ctx)
+	if err != nil {
+		return "", ErrUnableToProcessRequest
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", ErrUnableToProcessRequest
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return "", ErrUnableToProcessRequest
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", ErrOIDCLoginRejected
+	}
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil || body.IDToken == "" {
+		return "", ErrOIDCLoginRejected
+	}
+	return body.IDToken, nil
+}
+
+//verifyIDToken checks the signature, issuer, audience, expiry and nonce of the id token
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, raw string, nonce string) (*oidcClaims, error) {
+	claims := &oidcClaims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != jwt.SigningMethodRS256.Alg() {
+			return nil, ErrUnsupportedKey
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidIDToken
+	}
+	if !claims.VerifyIssuer(p.Issuer, true) || !claims.Audience.contains(p.ClientID) ||
+		claims.ExpiresAt == 0 || claims.Subject == "" || claims.Nonce != nonce {
+		return nil, ErrInvalidIDToken
+	}
+	return claims, nil
+}
+
+//publicKey returns the signing key of the provider with the id, the keys are
+//fetched again once if the provider has rotated to an unknown key
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	config, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var set JSONWebKeySet
+	err = p.getJSON(ctx, config.JWKSURI, &set)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.keys = keys
+	key, ok := keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s returned %s", endpoint, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
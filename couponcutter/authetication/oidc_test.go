@@ -0,0 +1,344 @@
+package authetication
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/form3tech-oss/jwt-go"
+)
+
+func (m *mockRepo) CreateOIDCState(ctx context.Context, stateHash string, state OIDCState) error {
+	if m.oidcStates == nil {
+		m.oidcStates = make(map[string]*OIDCState)
+	}
+	m.oidcStates[stateHash] = &state
+	return nil
+}
+func (m *mockRepo) UseOIDCState(ctx context.Context, stateHash string) (*OIDCState, error) {
+	state, ok := m.oidcStates[stateHash]
+	if !ok {
+		return nil, ErrInvalidOIDCState
+	}
+	delete(m.oidcStates, stateHash)
+	return state, nil
+}
+func (m *mockRepo) UserWithOIDCIdentity(ctx context.Context, provider string, subject string) (string, error) {
+	userID, ok := m.identities[provider+"|"+subject]
+	if !ok {
+		return "", ErrIdentityDoesNotExists
+	}
+	return userID, nil
+}
+func (m *mockRepo) LinkOIDCIdentity(ctx context.Context, userID string, provider string, subject string) error {
+	if m.identities == nil {
+		m.identities = make(map[string]string)
+	}
+	m.identities[provider+"|"+subject] = userID
+	return nil
+}
+
+//oidcRepo creates the users the mock repository does not know about
+type oidcRepo struct {
+	*mockRepo
+	created  map[string]string
+	emailErr error
+}
+
+func (m *oidcRepo) UserWithEmail(ctx context.Context, email string) (string, error) {
+	if m.emailErr != nil {
+		return "", m.emailErr
+	}
+	if userID, ok := m.created[email]; ok {
+		return userID, nil
+	}
+	return m.mockRepo.UserWithEmail(ctx, email)
+}
+func (m *oidcRepo) CreateUser(ctx context.Context, email string, password string) (string, error) {
+	userID := "oidc-" + email
+	m.created[email] = userID
+	return userID, nil
+}
+
+//fakeIssuer is an OpenID Connect provider issuing id tokens for the codes it was told about
+type fakeIssuer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	secret   string
+
+	mu    sync.Mutex
+	codes map[string]fakeGrant
+}
+
+type fakeGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{key: key, clientID: "couponcutter", secret: "client-secret", codes: map[string]fakeGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(rw).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(rw).Encode(JSONWebKeySet{Keys: []JSONWebKey{{
+			KeyType:   "RSA",
+			KeyID:     "fake",
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(rw http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != f.clientID || secret != f.secret || r.PostFormValue("grant_type") != "authorization_code" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		grant, ok := f.codes[r.PostFormValue("code")]
+		delete(f.codes, r.PostFormValue("code"))
+		f.mu.Unlock()
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+			rw.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(rw).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(rw).Encode(map[string]string{"id_token": f.sign(t, grant.claims, f.key)})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeIssuer) sign(t *testing.T, claims jwt.MapClaims, key *rsa.PrivateKey) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "fake"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+//authorize plays the user logging in at the provider, it returns the state and code
+//the provider redirects back with. The claims are added to the defaults of the id token
+func (f *fakeIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) (string, string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if u.Path != "/authorize" || query.Get("client_id") != f.clientID || query.Get("code_challenge_method") != "S256" ||
+		query.Get("response_type") != "code" || query.Get("state") == "" || query.Get("code_challenge") == "" {
+		t.Fatalf("unexpected authorization url %v", authURL)
+	}
+	idClaims := jwt.MapClaims{
+		"iss":   f.URL,
+		"aud":   f.clientID,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		idClaims[name] = value
+	}
+	code, _ := generateToken()
+	f.mu.Lock()
+	f.codes[code] = fakeGrant{challenge: query.Get("code_challenge"), claims: idClaims}
+	f.mu.Unlock()
+	return query.Get("state"), code
+}
+
+func newOIDCService(t *testing.T, f *fakeIssuer) (*service, *oidcRepo) {
+	repo := &oidcRepo{mockRepo: &mockRepo{}, created: map[string]string{}}
+	provider := NewOIDCProvider("fake", f.URL, f.clientID, f.secret, "http://localhost/user/login/oidc/fake/callback")
+	s := NewService(repo, &mockMailer{}, testKeys(t), NewMemoryAttemptStore(), DefaultPasswordPolicy(), "http://localhost", provider).(*service)
+	return s, repo
+}
+
+func Test_service_OIDCLogin(t *testing.T) {
+	f := newFakeIssuer(t)
+	s, repo := newOIDCService(t, f)
+	ctx := context.Background()
+
+	login := func(claims jwt.MapClaims) (*TokenResponse, error) {
+		authURL, err := s.OIDCAuthURL(ctx, "fake")
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, code := f.authorize(t, authURL, claims)
+		return s.OIDCLogin(ctx, "fake", state, code)
+	}
+	userOf := func(token *TokenResponse) string {
+		principal, ok := s.VerifyPrincipal(token.Token)
+		if !ok {
+			t.Fatal("token of the oidc login is invalid")
+		}
+		return principal.UserID
+	}
+
+	//a new user is created for a verified email
+	token, err := login(jwt.MapClaims{"sub": "new", "email": "New@gmail.com", "email_verified": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := userOf(token); got != "oidc-new@gmail.com" {
+		t.Errorf("user of a new identity = %v", got)
+	}
+
+	//an existing user is linked by their verified email
+	token, err = login(jwt.MapClaims{"sub": "existing", "email": "user1@gmail.com", "email_verified": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := userOf(token); got != "j332v" {
+		t.Errorf("user of an identity with an existing email = %v, want j332v", got)
+	}
+
+	//a linked identity keeps its user even if the provider reports another email
+	token, err = login(jwt.MapClaims{"sub": "existing", "email": "user2@gmail.com", "email_verified": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := userOf(token); got != "j332v" {
+		t.Errorf("user of a linked identity = %v, want j332v", got)
+	}
+
+	//unverified emails are never linked or created
+	_, err = login(jwt.MapClaims{"sub": "unverified", "email": "user@gmail.com", "email_verified": false})
+	if !errors.Is(err, ErrOIDCEmailNotVerified) {
+		t.Errorf("OIDCLogin() of an unverified email error = %v", err)
+	}
+	_, err = login(jwt.MapClaims{"sub": "unverified", "email": "user@gmail.com"})
+	if !errors.Is(err, ErrOIDCEmailNotVerified) {
+		t.Errorf("OIDCLogin() without email_verified error = %v", err)
+	}
+
+	//users with two-factor authentication still have to complete the challenge
+	repo.totp = map[string]*TOTP{"j332v": {Secret: "secret", Enabled: true}}
+	token, err = login(jwt.MapClaims{"sub": "existing", "email": "user1@gmail.com", "email_verified": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "" || token.Challenge == "" {
+		t.Errorf("OIDCLogin() of a user with 2fa = %+v, want a challenge", token)
+	}
+}
+
+func Test_service_OIDCLogin_Rejected(t *testing.T) {
+	f := newFakeIssuer(t)
+	s, repo := newOIDCService(t, f)
+	ctx := context.Background()
+	verified := jwt.MapClaims{"sub": "new", "email": "new@gmail.com", "email_verified": true}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.OIDCAuthURL(ctx, "unknown")
+	if !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("OIDCAuthURL() of an unknown provider error = %v", err)
+	}
+
+	//a state can only be used once
+	authURL, _ := s.OIDCAuthURL(ctx, "fake")
+	state, code := f.authorize(t, authURL, verified)
+	_, err = s.OIDCLogin(ctx, "fake", state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.OIDCLogin(ctx, "fake", state, code)
+	if !errors.Is(err, ErrInvalidOIDCState) {
+		t.Errorf("OIDCLogin() with a used state error = %v", err)
+	}
+
+	//a code issued for another login fails the PKCE check
+	authURL, _ = s.OIDCAuthURL(ctx, "fake")
+	_, code = f.authorize(t, authURL, verified)
+	authURL, _ = s.OIDCAuthURL(ctx, "fake")
+	state, _ = f.authorize(t, authURL, verified)
+	_, err = s.OIDCLogin(ctx, "fake", state, code)
+	if !errors.Is(err, ErrOIDCLoginRejected) {
+		t.Errorf("OIDCLogin() with another login's code error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{name: "wrong nonce", claims: jwt.MapClaims{"nonce": "replayed"}},
+		{name: "wrong audience", claims: jwt.MapClaims{"aud": "another-client"}},
+		{name: "wrong issuer", claims: jwt.MapClaims{"iss": "https://evil.example"}},
+		{name: "expired", claims: jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}},
+		{name: "no subject", claims: jwt.MapClaims{"sub": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := jwt.MapClaims{}
+			for name, value := range verified {
+				claims[name] = value
+			}
+			for name, value := range tt.claims {
+				claims[name] = value
+			}
+			authURL, _ := s.OIDCAuthURL(ctx, "fake")
+			state, code := f.authorize(t, authURL, claims)
+			_, err := s.OIDCLogin(ctx, "fake", state, code)
+			if !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("OIDCLogin() error = %v, want %v", err, ErrInvalidIDToken)
+			}
+		})
+	}
+
+	//no account is created when the user with the email cannot be looked up
+	repo.emailErr = errors.New("connection reset")
+	authURL, _ = s.OIDCAuthURL(ctx, "fake")
+	state, code = f.authorize(t, authURL, jwt.MapClaims{"sub": "lookup", "email": "lookup@gmail.com", "email_verified": true})
+	_, err = s.OIDCLogin(ctx, "fake", state, code)
+	if !errors.Is(err, ErrUnableToProcessRequest) {
+		t.Errorf("OIDCLogin() with a failed lookup error = %v, want %v", err, ErrUnableToProcessRequest)
+	}
+	if _, ok := repo.created["lookup@gmail.com"]; ok {
+		t.Errorf("OIDCLogin() with a failed lookup created a user")
+	}
+	repo.emailErr = nil
+
+	//an id token that is not signed by the provider is refused
+	provider := s.providers["fake"]
+	authURL, _ = s.OIDCAuthURL(ctx, "fake")
+	f.authorize(t, authURL, verified)
+	u, _ := url.Parse(authURL)
+	forged := f.sign(t, jwt.MapClaims{
+		"iss": f.URL, "aud": f.clientID, "sub": "new", "exp": time.Now().Add(time.Minute).Unix(),
+		"nonce": u.Query().Get("nonce"), "email": "new@gmail.com", "email_verified": true,
+	}, otherKey)
+	_, err = provider.verifyIDToken(ctx, forged, u.Query().Get("nonce"))
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("verifyIDToken() of a forged token error = %v", err)
+	}
+}
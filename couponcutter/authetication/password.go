@@ -0,0 +1,87 @@
+package authetication
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	//ErrPasswordBreached is returned if the password appears in the list of breached passwords
+	ErrPasswordBreached = errors.New("the password is known from a data breach")
+	//ErrPasswordIsEmail is returned if the password is the email address of the user
+	ErrPasswordIsEmail = errors.New("the password must not be the email address")
+)
+
+//PasswordPolicy decides which passwords users may choose. It is checked when a
+//password is set, logins only enforce the maximum length so that passwords chosen
+//under an older policy keep working
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	//DisallowEmail rejects passwords that are the email address or its local part
+	DisallowEmail bool
+	breached      map[string]struct{}
+}
+
+//DefaultPasswordPolicy returns the policy used when none is configured
+func DefaultPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{MinLength: 8, MaxLength: 64, DisallowEmail: true}
+}
+
+//LoadBreachedPasswords reads a list of breached passwords, one per line,
+//passwords in the list are rejected regardless of case
+func (p *PasswordPolicy) LoadBreachedPasswords(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		breached[strings.ToLower(line)] = struct{}{}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+	p.breached = breached
+	return nil
+}
+
+//Check returns an error if the password cannot be chosen by the owner of the email
+func (p *PasswordPolicy) Check(email string, password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength || length > p.MaxLength {
+		return ErrPasswordLengthUnAcceptable
+	}
+	lower := strings.ToLower(password)
+	if p.DisallowEmail && email != "" {
+		email = trimAndLower(email)
+		local := email
+		if at := strings.LastIndex(email, "@"); at > 0 {
+			local = email[:at]
+		}
+		if lower == email || lower == local {
+			return ErrPasswordIsEmail
+		}
+	}
+	if _, ok := p.breached[lower]; ok {
+		return ErrPasswordBreached
+	}
+	return nil
+}
+
+//acceptableForLogin only rejects passwords that could never have been set,
+//it spares hashing huge inputs without locking out users of an older policy
+func (p *PasswordPolicy) acceptableForLogin(password string) bool {
+	return password != "" && utf8.RuneCountInString(password) <= p.MaxLength
+}
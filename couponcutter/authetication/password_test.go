@@ -0,0 +1,54 @@
+package authetication
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	list := filepath.Join(dir, "breached.txt")
+	err = ioutil.WriteFile(list, []byte("123456\nPassword1\n\nqwertyuiop\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := DefaultPasswordPolicy()
+	err = policy.LoadBreachedPasswords(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		want     error
+	}{
+		{name: "short password length", email: "user@gmail.com", password: "1234567", want: ErrPasswordLengthUnAcceptable},
+		{name: "length of 8", email: "user@gmail.com", password: "12345678", want: nil},
+		{name: "multibyte characters count once", email: "user@gmail.com", password: "ééééééé", want: ErrPasswordLengthUnAcceptable},
+		{name: "long password length", email: "user@gmail.com", password: "12345123451234512345123451234512345123451234512345123451234512345", want: ErrPasswordLengthUnAcceptable},
+		{name: "email as password", email: "longname@gmail.com", password: "LongName@gmail.com", want: ErrPasswordIsEmail},
+		{name: "local part as password", email: "longname@gmail.com", password: "longname", want: ErrPasswordIsEmail},
+		{name: "breached password", email: "user@gmail.com", password: "password1", want: ErrPasswordBreached},
+		{name: "breached password exact", email: "user@gmail.com", password: "qwertyuiop", want: ErrPasswordBreached},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Check(tt.email, tt.password); got != tt.want {
+				t.Errorf("Check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	lenient := &PasswordPolicy{MinLength: 6, MaxLength: 64}
+	if err := lenient.Check("longname@gmail.com", "longname"); err != nil {
+		t.Errorf("Check() without DisallowEmail = %v", err)
+	}
+}
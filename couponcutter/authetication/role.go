@@ -0,0 +1,36 @@
+package authetication
+
+const (
+	//RoleShopper is held by every user
+	RoleShopper = "shopper"
+	//RoleOwner is held by a user who owns a store
+	RoleOwner = "owner"
+	//RoleEmployee is held by an active employee of a store
+	RoleEmployee = "employee"
+	//RolePlatformAdmin is held by the operators of the platform
+	RolePlatformAdmin = "platform-admin"
+	//RoleAPIKey is held by a store API key used by point of sale terminals
+	RoleAPIKey = "api-key"
+)
+
+//Principal is the identity of an authenticated user together with their roles
+//and the store they act for if they own or work for one
+type Principal struct {
+	UserID  string   `json:"user_id"`
+	Roles   []string `json:"roles,omitempty"`
+	StoreID string   `json:"store_id,omitempty"`
+	//SessionID is the session the access token was issued for
+	SessionID string `json:"session_id,omitempty"`
+	//Scopes restricts what an API key principal may do, it is nil for users
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+//HasRole reports whether the principal holds the role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package authetication
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+//RefreshToken is a long lived token exchanged for new access tokens.
+//Every refresh token belongs to a session, each exchange rotates the token within that session
+type RefreshToken struct {
+	TokenHash string
+	UserID    string
+	SessionID string
+	ExpiredAt time.Time
+	Used      bool
+	Revoked   bool
+}
+
+//starts a new session for the user returning its first access and refresh tokens
+func (s *service) startSession(ctx context.Context, userID string) (*TokenResponse, error) {
+	return s.issueTokens(ctx, userID, uuid.NewString())
+}
+
+//issue an access token and a new refresh token within the session.
+//Roles are resolved on every issue so a change in employment is picked up on the next refresh
+func (s *service) issueTokens(ctx context.Context, userID string, sessionID string) (*TokenResponse, error) {
+	principal, err := s.repo.UserAccess(ctx, userID)
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	token, err := s.createToken(*principal, sessionID)
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	refresh, err := generateToken()
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	err = s.repo.CreateRefreshToken(ctx, RefreshToken{
+		TokenHash: hashToken(refresh),
+		UserID:    userID,
+		SessionID: sessionID,
+		ExpiredAt: time.Now().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	return &TokenResponse{
+		Token:        token,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+//RefreshToken exchanges a refresh token for a new access token and a new refresh token.
+//A refresh token can only be used once, presenting it again means it was stolen
+//so the whole session is revoked
+func (s *service) RefreshToken(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	refreshToken = strings.TrimSpace(refreshToken)
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+	hash := hashToken(refreshToken)
+	current, err := s.repo.RefreshTokenWithHash(ctx, hash)
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, ErrUnableToProcessRequest
+	}
+	if current.Revoked || time.Now().After(current.ExpiredAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	fresh, err := s.repo.UseRefreshToken(ctx, hash)
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	if current.Used || !fresh {
+		log.Printf("refresh token reused, revoking session %s", current.SessionID)
+		err = s.repo.RevokeSession(ctx, current.SessionID)
+		if err != nil {
+			return nil, ErrUnableToProcessRequest
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	return s.issueTokens(ctx, current.UserID, current.SessionID)
+}
+
+//Logout revokes the session of the refresh token together with every access token issued in it
+func (s *service) Logout(ctx context.Context, refreshToken string) error {
+	refreshToken = strings.TrimSpace(refreshToken)
+	if refreshToken == "" {
+		return ErrInvalidRefreshToken
+	}
+	current, err := s.repo.RefreshTokenWithHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			return ErrInvalidRefreshToken
+		}
+		return ErrUnableToProcessRequest
+	}
+	err = s.repo.RevokeSession(ctx, current.SessionID)
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+	return nil
+}
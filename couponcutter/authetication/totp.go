@@ -0,0 +1,316 @@
+package authetication
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+var (
+	//ErrTOTPNotAllowed is returned if a user that does not own a store tries to enroll an authenticator
+	ErrTOTPNotAllowed = errors.New("two-factor authentication is only available to store owners")
+	//ErrTOTPAlreadyEnabled is returned if the user already completed enrollment
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	//ErrTOTPNotEnabled is returned if the user has not enrolled an authenticator
+	ErrTOTPNotEnabled = errors.New("two-factor authentication is not enabled")
+	//ErrInvalidTOTPCode is returned if a one-time password or recovery code is wrong or was already used
+	ErrInvalidTOTPCode = errors.New("authentication code is invalid")
+	//ErrInvalidLoginChallenge is returned if a login challenge is unknown, expired, used or attempted too often
+	ErrInvalidLoginChallenge = errors.New("login challenge is invalid or expired")
+)
+
+const (
+	//totpPeriod is the time step of the one-time passwords as recommended by RFC 6238
+	totpPeriod = 30
+	//totpDigits is the length of the one-time passwords
+	totpDigits = 6
+	//totpSkew is the number of steps before and after the current one that are accepted to allow for clock drift
+	totpSkew = 1
+	//totpIssuer is the name shown next to the account in authenticator apps
+	totpIssuer = "couponcutter"
+	//recoveryCodeCount is the number of recovery codes handed out when two-factor authentication is enabled
+	recoveryCodeCount = 10
+	//loginChallengeTTL is how long a user has to enter their code after a password login
+	loginChallengeTTL = time.Minute * 5
+	//loginChallengeAttempts is how many codes can be tried against a login challenge
+	loginChallengeAttempts = 5
+)
+
+//TOTP is the one-time password configuration of a user
+type TOTP struct {
+	Secret  string
+	Enabled bool
+	//LastUsedStep is the time step of the last accepted code, codes cannot be replayed
+	LastUsedStep int64
+}
+
+//TOTPEnrollment carries what the user needs to add the account to an authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	//QRCode is a PNG image of the URI
+	QRCode []byte `json:"qr_code"`
+}
+
+//EnrollTOTP generates a new secret for the store owner, it is not required at login until confirmed
+func (s *service) EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error) {
+	principal, err := s.repo.UserAccess(ctx, userID)
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	if !principal.HasRole(RoleOwner) && !principal.HasRole(RolePlatformAdmin) {
+		return nil, ErrTOTPNotAllowed
+	}
+	email, err := s.repo.UserEmail(ctx, userID)
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	err = s.repo.CreateTOTP(ctx, userID, secret)
+	if err != nil {
+		if errors.Is(err, ErrTOTPAlreadyEnabled) {
+			return nil, ErrTOTPAlreadyEnabled
+		}
+		return nil, ErrUnableToProcessRequest
+	}
+
+	uri := totpURI(email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	return &TOTPEnrollment{Secret: secret, URI: uri, QRCode: png}, nil
+}
+
+//ConfirmTOTP enables two-factor authentication once the user proves their authenticator
+//produces valid codes and returns the recovery codes, they are shown only this once
+func (s *service) ConfirmTOTP(ctx context.Context, userID string, code string) ([]string, error) {
+	totp, err := s.repo.UserTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrTOTPNotEnabled) {
+			return nil, ErrTOTPNotEnabled
+		}
+		return nil, ErrUnableToProcessRequest
+	}
+	if totp.Enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	step, ok := validateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = generateRecoveryCode()
+		if err != nil {
+			return nil, ErrUnableToProcessRequest
+		}
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+	err = s.repo.EnableTOTP(ctx, userID, step, hashes)
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	return codes, nil
+}
+
+//DisableTOTP turns off two-factor authentication, a valid code or recovery code is required
+func (s *service) DisableTOTP(ctx context.Context, userID string, code string) error {
+	totp, err := s.repo.UserTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrTOTPNotEnabled) {
+			return ErrTOTPNotEnabled
+		}
+		return ErrUnableToProcessRequest
+	}
+	if !totp.Enabled {
+		return ErrTOTPNotEnabled
+	}
+	err = s.checkSecondFactor(ctx, userID, totp, code)
+	if err != nil {
+		return err
+	}
+	err = s.repo.DisableTOTP(ctx, userID)
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+	return nil
+}
+
+//CompleteLogin finishes a login that was answered with a challenge by checking the code
+//of the user's authenticator or one of their recovery codes
+func (s *service) CompleteLogin(ctx context.Context, challenge string, code string) (*TokenResponse, error) {
+	challenge = strings.TrimSpace(challenge)
+	if challenge == "" {
+		return nil, ErrInvalidLoginChallenge
+	}
+	challengeHash := hashToken(challenge)
+	userid, err := s.repo.AttemptLoginChallenge(ctx, challengeHash, loginChallengeAttempts)
+	if err != nil {
+		if errors.Is(err, ErrInvalidLoginChallenge) {
+			return nil, ErrInvalidLoginChallenge
+		}
+		return nil, ErrUnableToProcessRequest
+	}
+	totp, err := s.repo.UserTOTP(ctx, userid)
+	if err != nil || !totp.Enabled {
+		return nil, ErrInvalidLoginChallenge
+	}
+	//wrong codes count against the account like wrong passwords so that
+	//requesting new challenges does not allow guessing codes without limit
+	email, err := s.repo.UserEmail(ctx, userid)
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	err = s.checkSecondFactor(ctx, userid, totp, code)
+	if err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			s.failLogin(ctx, email, "")
+		}
+		return nil, err
+	}
+	used, err := s.repo.UseLoginChallenge(ctx, challengeHash)
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	if !used {
+		return nil, ErrInvalidLoginChallenge
+	}
+	s.succeedLogin(ctx, email)
+	return s.startSession(ctx, userid)
+}
+
+//issue a challenge that has to be completed with a code before the user gets their tokens
+func (s *service) loginChallenge(ctx context.Context, userID string) (*TokenResponse, error) {
+	challenge, err := generateToken()
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	err = s.repo.CreateLoginChallenge(ctx, userID, hashToken(challenge), time.Now().Add(loginChallengeTTL))
+	if err != nil {
+		return nil, ErrUnableToProcessRequest
+	}
+	return &TokenResponse{Challenge: challenge, ExpiresIn: int64(loginChallengeTTL.Seconds())}, nil
+}
+
+//accept either a fresh code of the authenticator or an unused recovery code
+func (s *service) checkSecondFactor(ctx context.Context, userID string, totp *TOTP, code string) error {
+	code = strings.TrimSpace(code)
+	if step, ok := validateTOTP(totp.Secret, code, time.Now()); ok {
+		if step <= totp.LastUsedStep {
+			return ErrInvalidTOTPCode
+		}
+		fresh, err := s.repo.UseTOTPStep(ctx, userID, step)
+		if err != nil {
+			return ErrUnableToProcessRequest
+		}
+		if !fresh {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return ErrInvalidTOTPCode
+	}
+	used, err := s.repo.UseRecoveryCode(ctx, userID, hashToken(code))
+	if err != nil {
+		return ErrUnableToProcessRequest
+	}
+	if !used {
+		return ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+//generate a random 160 bit secret, the size of the HMAC-SHA1 output
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+//build the key uri understood by authenticator apps
+func totpURI(email string, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", totpIssuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(totpIssuer) + ":" + url.PathEscape(email)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+//compute the code of the time step as described in RFC 4226 and RFC 6238
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+//validate the code against the steps around the given time and return the matching step
+func validateTOTP(secret string, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+//generate a recovery code formatted as two groups of five characters
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+//recovery codes are accepted regardless of case and grouping
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
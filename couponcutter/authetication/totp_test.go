@@ -0,0 +1,227 @@
+package authetication
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockChallenge struct {
+	userID   string
+	attempts int
+	used     bool
+}
+
+func (m *mockRepo) UserEmail(ctx context.Context, userID string) (string, error) {
+	for _, email := range []string{"user@gmail.com", "user1@gmail.com", "user2@gmail.com"} {
+		id, _ := m.UserWithEmail(ctx, email)
+		if id == userID {
+			return email, nil
+		}
+	}
+	return "", ErrIdentityDoesNotExists
+}
+func (m *mockRepo) CreateTOTP(ctx context.Context, userID string, secret string) error {
+	if m.totp == nil {
+		m.totp = make(map[string]*TOTP)
+	}
+	if t, ok := m.totp[userID]; ok && t.Enabled {
+		return ErrTOTPAlreadyEnabled
+	}
+	m.totp[userID] = &TOTP{Secret: secret}
+	return nil
+}
+func (m *mockRepo) UserTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	t, ok := m.totp[userID]
+	if !ok {
+		return nil, ErrTOTPNotEnabled
+	}
+	copy := *t
+	return &copy, nil
+}
+func (m *mockRepo) EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	m.totp[userID].Enabled = true
+	m.totp[userID].LastUsedStep = step
+	m.recovery = make(map[string]bool)
+	for _, hash := range recoveryCodeHashes {
+		m.recovery[userID+hash] = true
+	}
+	return nil
+}
+func (m *mockRepo) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	if step <= m.totp[userID].LastUsedStep {
+		return false, nil
+	}
+	m.totp[userID].LastUsedStep = step
+	return true, nil
+}
+func (m *mockRepo) UseRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	if !m.recovery[userID+codeHash] {
+		return false, nil
+	}
+	delete(m.recovery, userID+codeHash)
+	return true, nil
+}
+func (m *mockRepo) DisableTOTP(ctx context.Context, userID string) error {
+	delete(m.totp, userID)
+	return nil
+}
+func (m *mockRepo) CreateLoginChallenge(ctx context.Context, userID string, tokenHash string, expiredAt time.Time) error {
+	if m.challenges == nil {
+		m.challenges = make(map[string]*mockChallenge)
+	}
+	m.challenges[tokenHash] = &mockChallenge{userID: userID}
+	return nil
+}
+func (m *mockRepo) AttemptLoginChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error) {
+	c, ok := m.challenges[tokenHash]
+	if !ok || c.used || c.attempts >= maxAttempts {
+		return "", ErrInvalidLoginChallenge
+	}
+	c.attempts++
+	return c.userID, nil
+}
+func (m *mockRepo) UseLoginChallenge(ctx context.Context, tokenHash string) (bool, error) {
+	c, ok := m.challenges[tokenHash]
+	if !ok || c.used {
+		return false, nil
+	}
+	c.used = true
+	return true, nil
+}
+
+func Test_totpCode(t *testing.T) {
+	//test vectors of RFC 6238 appendix B truncated to six digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode(%d) = %v, want %v", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func Test_validateTOTP(t *testing.T) {
+	secret, _ := generateTOTPSecret()
+	now := time.Now()
+	step := now.Unix() / totpPeriod
+
+	previous, _ := totpCode(secret, step-1)
+	if got, ok := validateTOTP(secret, previous, now); !ok || got != step-1 {
+		t.Errorf("code of the previous step should be accepted")
+	}
+	stale, _ := totpCode(secret, step-3)
+	if _, ok := validateTOTP(secret, stale, now); ok {
+		t.Errorf("stale code should be rejected")
+	}
+	if _, ok := validateTOTP(secret, "12345", now); ok {
+		t.Errorf("short code should be rejected")
+	}
+}
+
+func Test_service_TOTP(t *testing.T) {
+	repo := &mockRepo{}
+	s := &service{repo: repo, keys: testKeys(t), attempts: NewMemoryAttemptStore(), policy: DefaultPasswordPolicy()}
+	ctx := context.Background()
+
+	_, err := s.EnrollTOTP(ctx, "j332v")
+	if !errors.Is(err, ErrTOTPNotAllowed) {
+		t.Fatalf("EnrollTOTP() for a shopper error = %v, want %v", err, ErrTOTPNotAllowed)
+	}
+
+	enrollment, err := s.EnrollTOTP(ctx, "12ddf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/couponcutter:user@gmail.com?") || len(enrollment.QRCode) == 0 {
+		t.Errorf("unexpected enrollment %v", enrollment.URI)
+	}
+
+	//enrollment is not required at login until it is confirmed
+	login, err := s.Login(ctx, "user@gmail.com", "password", "")
+	if err != nil || login.Token == "" {
+		t.Fatalf("Login() before confirming = %v, %v", login, err)
+	}
+
+	_, err = s.ConfirmTOTP(ctx, "12ddf", "000000")
+	if !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("ConfirmTOTP() with a wrong code error = %v", err)
+	}
+	code, _ := totpCode(enrollment.Secret, time.Now().Unix()/totpPeriod)
+	recovery, err := s.ConfirmTOTP(ctx, "12ddf", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovery) != recoveryCodeCount {
+		t.Errorf("got %d recovery codes, want %d", len(recovery), recoveryCodeCount)
+	}
+
+	login, err = s.Login(ctx, "user@gmail.com", "password", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.Token != "" || login.Challenge == "" {
+		t.Fatalf("Login() should return a challenge, got %+v", login)
+	}
+
+	//the code used to confirm the enrollment cannot be replayed
+	_, err = s.CompleteLogin(ctx, login.Challenge, code)
+	if !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("CompleteLogin() with a replayed code error = %v", err)
+	}
+
+	tokens, err := s.CompleteLogin(ctx, login.Challenge, strings.ToUpper(recovery[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userid, ok := s.VerifyToken(tokens.Token); !ok || userid != "12ddf" {
+		t.Errorf("CompleteLogin() returned an invalid token")
+	}
+	if _, err := s.CompleteLogin(ctx, login.Challenge, recovery[1]); !errors.Is(err, ErrInvalidLoginChallenge) {
+		t.Errorf("a completed challenge should not be reusable, error = %v", err)
+	}
+
+	//a recovery code works only once
+	login, _ = s.Login(ctx, "user@gmail.com", "password", "")
+	if _, err := s.CompleteLogin(ctx, login.Challenge, recovery[0]); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("a used recovery code should be rejected, error = %v", err)
+	}
+
+	//the challenge is invalidated after too many wrong codes
+	for i := 1; i < loginChallengeAttempts; i++ {
+		s.CompleteLogin(ctx, login.Challenge, "000000")
+	}
+	if _, err := s.CompleteLogin(ctx, login.Challenge, recovery[2]); !errors.Is(err, ErrInvalidLoginChallenge) {
+		t.Errorf("challenge should be locked after %d attempts, error = %v", loginChallengeAttempts, err)
+	}
+
+	//the wrong codes count against the account
+	if _, err := s.Login(ctx, "user@gmail.com", "password", ""); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("Login() after wrong codes error = %v, want %v", err, ErrTooManyAttempts)
+	}
+	s.attempts.ResetLogin(ctx, "account:user@gmail.com")
+
+	err = s.DisableTOTP(ctx, "12ddf", recovery[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, err = s.Login(ctx, "user@gmail.com", "password", "")
+	if err != nil || login.Token == "" {
+		t.Errorf("Login() after disabling = %v, %v", login, err)
+	}
+}
@@ -3,12 +3,12 @@ module couponcutter
 go 1.16
 
 require (
-	github.com/Masterminds/squirrel v1.5.0 // indirect
+	github.com/Masterminds/squirrel v1.5.0
 	github.com/form3tech-oss/jwt-go v3.2.2+incompatible
 	github.com/go-chi/chi v1.5.3
 	github.com/go-chi/httplog v0.2.0
 	github.com/google/uuid v1.2.0
-	github.com/hashicorp/go-hclog v0.16.0 // indirect
+	github.com/hashicorp/go-hclog v0.16.0
 	github.com/jackc/pgconn v1.8.0
 	github.com/jackc/pgproto3/v2 v2.0.7 // indirect
 	github.com/jackc/pgx/v4 v4.10.1
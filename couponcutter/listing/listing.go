@@ -68,14 +68,16 @@ type Coupon struct {
 
 // Store represent an indentity that owns coupons
 type Store struct {
-	StoreID     string `json:"store_id,omitempty"`
-	StoreName   string `json:"store_name,omitempty"`
-	ThemeColor  int    `json:"theme_color,omitempty"`
-	StoreImage  string `json:"store_image,omitempty"`
-	Tagline     string `json:"tagline,omitempty"`
-	Address     string `json:"address,omitempty"`
-	Following   bool   `json:"following,omitempty"`
-	CouponCount uint   `json:"coupon_count,omitempty"`
+	StoreID     string  `json:"store_id,omitempty"`
+	StoreName   string  `json:"store_name,omitempty"`
+	ThemeColor  int     `json:"theme_color,omitempty"`
+	StoreImage  string  `json:"store_image,omitempty"`
+	Tagline     string  `json:"tagline,omitempty"`
+	Address     string  `json:"address,omitempty"`
+	Latitude    float64 `json:"latitude,omitempty"`
+	Longitude   float64 `json:"longitude,omitempty"`
+	Following   bool    `json:"following,omitempty"`
+	CouponCount uint    `json:"coupon_count,omitempty"`
 }
 
 // User is an identifiable entity
@@ -105,6 +107,15 @@ type Repository interface {
 	StoreCoupons(ctx context.Context, storeid string) (*CouponListResponse, error)
 	StoreCouponsBeforeIDAndTime(ctx context.Context, storeid, couponid, lastTime string) (*CouponListResponse, error)
 	QrImage(ctx context.Context, image string) ([]byte, error)
+	//SearchCoupons returns up to limit active coupons matching the query, ordered by rank
+	//then coupon id, that come after the cursor when it is not nil
+	SearchCoupons(ctx context.Context, query SearchQuery, after *SearchCursor, limit int) ([]SearchResult, error)
+	//Suggestions returns up to limit suggestions matching the lower case term by prefix or by
+	//spelling, prefix matches first then the most redeemed
+	Suggestions(ctx context.Context, term string, limit int) ([]Suggestion, error)
+	//NearbyCoupons returns up to limit active coupons of the stores within radius meters
+	//of the location, the closest first
+	NearbyCoupons(ctx context.Context, lat, lng, radius float64, limit int) ([]NearbyCoupon, error)
 }
 
 // Service provides store and coupon listing operation
@@ -129,6 +140,9 @@ type Service interface {
 	CategoryCoupons(ctx context.Context, catName string) (*CouponListResponse, error)
 	CategoryCouponsBeforeIDAndTime(ctx context.Context, catName, couponid, lastTime string) (*CouponListResponse, error)
 	QrImage(ctx context.Context, image string) ([]byte, error)
+	Search(ctx context.Context, query SearchQuery) (*SearchResponse, error)
+	Suggest(ctx context.Context, term string) (*SuggestionsResponse, error)
+	NearbyCoupons(ctx context.Context, lat, lng, radius float64) (*NearbyCouponsResponse, error)
 }
 type service struct {
 	r Repository
@@ -0,0 +1,87 @@
+package listing
+
+import (
+	"context"
+	"errors"
+	"math"
+)
+
+var (
+	//ErrInvalidLocation is returned if the coordinates of a nearby search are out of range
+	ErrInvalidLocation = errors.New("invalid location")
+	//ErrInvalidRadius is returned if the radius of a nearby search is not positive
+	ErrInvalidRadius = errors.New("invalid radius")
+)
+
+const (
+	//DefaultNearbyRadius is the radius in meters of a nearby search made without one
+	DefaultNearbyRadius = 5000
+	//MaxNearbyRadius is the largest radius in meters of a nearby search
+	MaxNearbyRadius = 50000
+
+	nearbyLimit = 50
+	earthRadius = 6371000
+)
+
+//NearbyCoupon is a coupon of a store around the shopper
+type NearbyCoupon struct {
+	Coupon Coupon `json:"coupon"`
+	//Distance is the distance in meters to the store
+	Distance float64 `json:"distance"`
+}
+
+//NearbyCouponsResponse is sent to the client containing the coupons around the shopper, the closest first
+type NearbyCouponsResponse struct {
+	Coupons []NearbyCoupon `json:"coupons"`
+}
+
+//NearbyCoupons returns the active coupons of the stores within radius meters of the location,
+//the closest first. A radius of zero is the default radius, larger ones are capped
+func (s *service) NearbyCoupons(ctx context.Context, lat, lng, radius float64) (*NearbyCouponsResponse, error) {
+	if math.IsNaN(lat) || math.IsNaN(lng) || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return nil, ErrInvalidLocation
+	}
+	if radius < 0 || math.IsNaN(radius) {
+		return nil, ErrInvalidRadius
+	}
+	if radius == 0 {
+		radius = DefaultNearbyRadius
+	}
+	if radius > MaxNearbyRadius {
+		radius = MaxNearbyRadius
+	}
+	coupons, err := s.r.NearbyCoupons(ctx, lat, lng, radius, nearbyLimit)
+	if err != nil {
+		return nil, err
+	}
+	if coupons == nil {
+		coupons = []NearbyCoupon{}
+	}
+	return &NearbyCouponsResponse{Coupons: coupons}, nil
+}
+
+//Distance returns the great circle distance in meters between two locations
+func Distance(lat1, lng1, lat2, lng2 float64) float64 {
+	rad1 := lat1 * math.Pi / 180
+	rad2 := lat2 * math.Pi / 180
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLng := (lng2 - lng1) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad1)*math.Cos(rad2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+//BoundingBox returns the latitude and longitude ranges holding every location within radius
+//meters of the given one, the longitude range is the whole earth near the poles or across
+//the antimeridian
+func BoundingBox(lat, lng, radius float64) (minLat, maxLat, minLng, maxLng float64) {
+	delta := radius / earthRadius * 180 / math.Pi
+	minLat, maxLat = math.Max(-90, lat-delta), math.Min(90, lat+delta)
+	minLng, maxLng = -180, 180
+	if c := math.Cos(lat * math.Pi / 180); maxLat < 90 && minLat > -90 && c > 0 {
+		lngDelta := delta / c
+		if lng-lngDelta >= -180 && lng+lngDelta <= 180 {
+			minLng, maxLng = lng-lngDelta, lng+lngDelta
+		}
+	}
+	return minLat, maxLat, minLng, maxLng
+}
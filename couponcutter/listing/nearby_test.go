@@ -0,0 +1,79 @@
+package listing
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+type mockNearbyRepo struct {
+	Repository
+	radius float64
+}
+
+func (m *mockNearbyRepo) NearbyCoupons(ctx context.Context, lat, lng, radius float64, limit int) ([]NearbyCoupon, error) {
+	m.radius = radius
+	return nil, nil
+}
+
+func TestDistance(t *testing.T) {
+	//Paris to London
+	got := Distance(48.8566, 2.3522, 51.5074, -0.1278)
+	if math.Abs(got-343500) > 1000 {
+		t.Errorf("Distance() = %v, want about 343.5km", got)
+	}
+	if got := Distance(6.5244, 3.3792, 6.5244, 3.3792); got != 0 {
+		t.Errorf("Distance() to itself = %v", got)
+	}
+}
+
+func TestBoundingBox(t *testing.T) {
+	lat, lng, radius := 6.5244, 3.3792, 5000.0
+	minLat, maxLat, minLng, maxLng := BoundingBox(lat, lng, radius)
+	for _, corner := range [][2]float64{{minLat, lng}, {maxLat, lng}, {lat, minLng}, {lat, maxLng}} {
+		if d := Distance(lat, lng, corner[0], corner[1]); math.Abs(d-radius) > 50 {
+			t.Errorf("BoundingBox() edge %v is %vm away, want %vm", corner, d, radius)
+		}
+	}
+	//across the antimeridian every longitude is kept
+	_, _, minLng, maxLng = BoundingBox(0, 179.99, radius)
+	if minLng != -180 || maxLng != 180 {
+		t.Errorf("BoundingBox() across the antimeridian = %v..%v", minLng, maxLng)
+	}
+}
+
+func Test_service_NearbyCoupons(t *testing.T) {
+	repo := &mockNearbyRepo{}
+	s := NewService(repo)
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		lat, lng   float64
+		radius     float64
+		wantRadius float64
+		wantErr    error
+	}{
+		{name: "default radius", lat: 6.5, lng: 3.3, wantRadius: DefaultNearbyRadius},
+		{name: "capped radius", lat: 6.5, lng: 3.3, radius: 1e9, wantRadius: MaxNearbyRadius},
+		{name: "given radius", lat: 6.5, lng: 3.3, radius: 800, wantRadius: 800},
+		{name: "latitude out of range", lat: 91, lng: 3.3, wantErr: ErrInvalidLocation},
+		{name: "longitude out of range", lat: 6.5, lng: -181, wantErr: ErrInvalidLocation},
+		{name: "negative radius", lat: 6.5, lng: 3.3, radius: -1, wantErr: ErrInvalidRadius},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := s.NearbyCoupons(ctx, tt.lat, tt.lng, tt.radius)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NearbyCoupons() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if repo.radius != tt.wantRadius || response.Coupons == nil {
+				t.Errorf("NearbyCoupons() searched %vm, coupons %v", repo.radius, response.Coupons)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package listing
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var (
+	//ErrEmptySearch is returned if a search is made without any term
+	ErrEmptySearch = errors.New("search term is empty")
+	//ErrInvalidSearchCursor is returned if the cursor of a search was not returned by a previous search
+	ErrInvalidSearchCursor = errors.New("invalid search cursor")
+	//ErrInvalidDiscountType is returned if a search is filtered by an unknown discount type
+	ErrInvalidDiscountType = errors.New("invalid discount type")
+)
+
+const (
+	//SnippetStart and SnippetStop surround the matched words in a search snippet, the rest of
+	//the snippet is HTML escaped
+	SnippetStart = "<b>"
+	SnippetStop  = "</b>"
+
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+//SearchQuery describes a coupon search
+type SearchQuery struct {
+	Term         string
+	Categories   []string
+	DiscountType string
+	//Cursor is the next cursor of the previous page, empty for the first page
+	Cursor string
+	Limit  int
+}
+
+//SearchCursor is the position of the last result of a page of search results
+type SearchCursor struct {
+	Rank     float64 `json:"r"`
+	CouponID string  `json:"id"`
+}
+
+//SearchResult is a coupon matching a search
+type SearchResult struct {
+	Coupon  Coupon  `json:"coupon"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet,omitempty"`
+}
+
+//SearchResponse is sent to the client containing a page of search results
+type SearchResponse struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+//Search returns the active coupons matching the term in their description or store name, the
+//best matches first. Results are paged with the next cursor of the response
+func (s *service) Search(ctx context.Context, query SearchQuery) (*SearchResponse, error) {
+	query.Term = strings.TrimSpace(query.Term)
+	if query.Term == "" {
+		return nil, ErrEmptySearch
+	}
+	switch query.DiscountType {
+	case "", "amount_off", "percentage_off":
+	default:
+		return nil, ErrInvalidDiscountType
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultSearchLimit
+	}
+	if query.Limit > maxSearchLimit {
+		query.Limit = maxSearchLimit
+	}
+	var after *SearchCursor
+	if query.Cursor != "" {
+		cursor, err := decodeSearchCursor(query.Cursor)
+		if err != nil {
+			return nil, ErrInvalidSearchCursor
+		}
+		after = cursor
+	}
+
+	//one more result than asked for tells whether there is a next page
+	results, err := s.r.SearchCoupons(ctx, query, after, query.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	response := &SearchResponse{Results: results}
+	if len(results) > query.Limit {
+		response.Results = results[:query.Limit]
+		last := response.Results[query.Limit-1]
+		response.NextCursor = encodeSearchCursor(SearchCursor{Rank: last.Rank, CouponID: last.Coupon.CouponID})
+	}
+	if response.Results == nil {
+		response.Results = []SearchResult{}
+	}
+	return response, nil
+}
+
+func encodeSearchCursor(cursor SearchCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSearchCursor(s string) (*SearchCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cursor SearchCursor
+	err = json.Unmarshal(b, &cursor)
+	if err != nil {
+		return nil, err
+	}
+	if cursor.CouponID == "" {
+		return nil, ErrInvalidSearchCursor
+	}
+	return &cursor, nil
+}
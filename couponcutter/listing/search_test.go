@@ -0,0 +1,103 @@
+package listing
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+)
+
+//mockSearchRepo answers searches from a fixed list of ranked results
+type mockSearchRepo struct {
+	Repository
+	results []SearchResult
+	query   SearchQuery
+}
+
+func (m *mockSearchRepo) SearchCoupons(ctx context.Context, query SearchQuery, after *SearchCursor, limit int) ([]SearchResult, error) {
+	m.query = query
+	results := append([]SearchResult(nil), m.results...)
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Coupon.CouponID < results[j].Coupon.CouponID
+	})
+	var page []SearchResult
+	for _, r := range results {
+		if after != nil && !(r.Rank < after.Rank || (r.Rank == after.Rank && r.Coupon.CouponID > after.CouponID)) {
+			continue
+		}
+		if len(page) == limit {
+			break
+		}
+		page = append(page, r)
+	}
+	return page, nil
+}
+
+func Test_service_Search(t *testing.T) {
+	repo := &mockSearchRepo{results: []SearchResult{
+		{Coupon: Coupon{CouponID: "a"}, Rank: 0.5},
+		{Coupon: Coupon{CouponID: "b"}, Rank: 0.9},
+		{Coupon: Coupon{CouponID: "c"}, Rank: 0.5},
+		{Coupon: Coupon{CouponID: "d"}, Rank: 0.1},
+		{Coupon: Coupon{CouponID: "e"}, Rank: 0.5},
+	}}
+	s := NewService(repo)
+	ctx := context.Background()
+
+	var got []string
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		response, err := s.Search(ctx, SearchQuery{Term: " pizza ", Cursor: cursor, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range response.Results {
+			got = append(got, r.Coupon.CouponID)
+		}
+		cursor = response.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	want := []string{"b", "a", "c", "e", "d"}
+	if len(got) != len(want) {
+		t.Fatalf("Search() pages returned %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Search() pages returned %v, want %v", got, want)
+		}
+	}
+	if repo.query.Term != "pizza" {
+		t.Errorf("Search() passed term %q", repo.query.Term)
+	}
+
+	response, err := s.Search(ctx, SearchQuery{Term: "pizza", Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.query.Limit != maxSearchLimit || response.NextCursor != "" {
+		t.Errorf("Search() limit = %d, next cursor = %q", repo.query.Limit, response.NextCursor)
+	}
+
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  error
+	}{
+		{name: "empty term", query: SearchQuery{Term: "  "}, want: ErrEmptySearch},
+		{name: "bad cursor", query: SearchQuery{Term: "pizza", Cursor: "not a cursor"}, want: ErrInvalidSearchCursor},
+		{name: "unknown discount type", query: SearchQuery{Term: "pizza", DiscountType: "free"}, want: ErrInvalidDiscountType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Search(ctx, tt.query)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Search() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
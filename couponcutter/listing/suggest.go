@@ -0,0 +1,47 @@
+package listing
+
+import (
+	"context"
+	"strings"
+)
+
+//kinds of the things a suggestion can point to
+const (
+	SuggestStore    = "store"
+	SuggestCategory = "category"
+	SuggestCoupon   = "coupon"
+)
+
+//suggestLimit is the number of suggestions returned for a search box entry
+const suggestLimit = 10
+
+//Suggestion is a store name, category or coupon description matching what was typed in the search box
+type Suggestion struct {
+	Text string `json:"text"`
+	Kind string `json:"kind"`
+	//ID is the id of the store, category or coupon
+	ID         string `json:"id,omitempty"`
+	Popularity int    `json:"popularity"`
+}
+
+//SuggestionsResponse is sent to the client containing the suggestions for a search box entry
+type SuggestionsResponse struct {
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+//Suggest returns the store names, categories and coupon descriptions starting with or close to
+//the term, prefix matches first and each group by the number of redemptions
+func (s *service) Suggest(ctx context.Context, term string) (*SuggestionsResponse, error) {
+	term = strings.ToLower(strings.TrimSpace(term))
+	if term == "" {
+		return nil, ErrEmptySearch
+	}
+	suggestions, err := s.r.Suggestions(ctx, term, suggestLimit)
+	if err != nil {
+		return nil, err
+	}
+	if suggestions == nil {
+		suggestions = []Suggestion{}
+	}
+	return &SuggestionsResponse{Suggestions: suggestions}, nil
+}
@@ -0,0 +1,38 @@
+package listing
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type mockSuggestRepo struct {
+	Repository
+	term  string
+	limit int
+}
+
+func (m *mockSuggestRepo) Suggestions(ctx context.Context, term string, limit int) ([]Suggestion, error) {
+	m.term, m.limit = term, limit
+	return nil, nil
+}
+
+func Test_service_Suggest(t *testing.T) {
+	repo := &mockSuggestRepo{}
+	s := NewService(repo)
+
+	_, err := s.Suggest(context.Background(), "   ")
+	if !errors.Is(err, ErrEmptySearch) {
+		t.Fatalf("Suggest() error = %v, want %v", err, ErrEmptySearch)
+	}
+	response, err := s.Suggest(context.Background(), " PizZ ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.term != "pizz" || repo.limit != suggestLimit {
+		t.Errorf("Suggest() looked up %q with limit %d", repo.term, repo.limit)
+	}
+	if response.Suggestions == nil {
+		t.Error("Suggest() returned nil suggestions, want an empty list")
+	}
+}
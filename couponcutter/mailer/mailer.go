@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//FileMailer is a stand-in mailer that drops every message as a file in a directory
+//and logs where it was written, used when no mail provider is configured
+type FileMailer struct {
+	dir string
+	mu  sync.Mutex
+}
+
+//NewFileMailer returns a mailer that writes messages into the given directory
+func NewFileMailer(dir string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+//Send writes the message to a file named after the recipient and the time it was sent
+func (m *FileMailer) Send(ctx context.Context, to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name := fmt.Sprintf("%d_%s.txt", time.Now().UnixNano(), sanitize(to))
+	path := filepath.Join(m.dir, name)
+
+	msg := fmt.Sprintf("To: %s\nSubject: %s\nDate: %s\n\n%s\n", to, subject, time.Now().Format(time.RFC1123Z), body)
+	err := ioutil.WriteFile(path, []byte(msg), 0o600)
+	if err != nil {
+		return err
+	}
+	log.Printf("mail to %s written to %s", to, path)
+	return nil
+}
+
+//replace characters that should not appear in a file name
+func sanitize(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@':
+			return r
+		}
+		return '_'
+	}, value)
+}
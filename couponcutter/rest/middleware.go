@@ -0,0 +1,133 @@
+package rest
+
+import (
+	"context"
+	"couponcutter/authetication"
+	"couponcutter/storemanagement"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+)
+
+//principalKey is the context key of the authenticated principal
+type principalKey struct{}
+
+//authenticate verifies the bearer token of the request and stores its principal in the request context,
+//a principal already stored by acceptAPIKeys is kept
+func authenticate(auth authetication.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if principalFrom(r.Context()) != nil {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			token, ok := bearerToken(r)
+			if !ok {
+				unauthorized(rw)
+				return
+			}
+			principal, valid := auth.VerifyPrincipal(token)
+			if !valid {
+				unauthorized(rw)
+				return
+			}
+			ctx := context.WithValue(r.Context(), principalKey{}, principal)
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	}
+}
+
+//acceptAPIKeys lets store API keys authenticate in place of access tokens. A bearer
+//token carrying the API key prefix is verified and its principal stored in the request
+//context, other requests are left to authenticate
+func acceptAPIKeys(sManager storemanagement.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok || !strings.HasPrefix(token, storemanagement.APIKeyPrefix) {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			key, err := sManager.AuthenticateAPIKey(r.Context(), token)
+			if err != nil {
+				unauthorized(rw)
+				return
+			}
+			scopes := make([]string, len(key.Scopes))
+			for i, scope := range key.Scopes {
+				scopes[i] = string(scope)
+			}
+			principal := &authetication.Principal{
+				UserID:  key.ID,
+				Roles:   []string{authetication.RoleAPIKey},
+				StoreID: key.StoreID,
+				Scopes:  scopes,
+			}
+			ctx := context.WithValue(r.Context(), principalKey{}, principal)
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	}
+}
+
+//requireRole only lets through principals holding at least one of the roles,
+//it must be used after authenticate
+func requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			principal := principalFrom(r.Context())
+			if principal == nil {
+				unauthorized(rw)
+				return
+			}
+			for _, role := range roles {
+				if principal.HasRole(role) {
+					next.ServeHTTP(rw, r)
+					return
+				}
+			}
+			forbidden(rw)
+		})
+	}
+}
+
+//principalFrom returns the principal stored in the context by authenticate
+func principalFrom(ctx context.Context) *authetication.Principal {
+	principal, _ := ctx.Value(principalKey{}).(*authetication.Principal)
+	return principal
+}
+
+//extract the token of an "authorization: Bearer <token>" header, the scheme is case insensitive
+func bearerToken(r *http.Request) (string, bool) {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	parts := strings.Fields(header)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func unauthorized(rw http.ResponseWriter) {
+	e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("WWW-Authenticate", "Bearer")
+	rw.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(rw).Encode(e)
+}
+
+func forbidden(rw http.ResponseWriter) {
+	e := constructError(http.StatusForbidden, "permission denied", "you are not allowed to perform this action")
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(rw).Encode(e)
+}
+
+//clientAddress returns the address of the client connection. Forwarding headers
+//are ignored as any client can set them to escape per address limits
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
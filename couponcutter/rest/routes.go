@@ -8,11 +8,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/httplog"
@@ -56,34 +61,83 @@ func NewServer(logger zerolog.Logger, l listing.Service, s storemanagement.Servi
 func (s *Server) Routes() {
 
 	s.router.Post("/user/login", login(s.auth))
+	s.router.Post("/user/login/2fa", completeLogin(s.auth))
+	s.router.Post("/user/login/magic", requestMagicLink(s.auth))
+	s.router.Get("/user/login/magic", magicLogin(s.auth))
+	s.router.Get("/user/login/oidc/{provider}", startOIDCLogin(s.auth))
+	s.router.Get("/user/login/oidc/{provider}/callback", completeOIDCLogin(s.auth))
 	s.router.Post("/user/signup", signUp(s.auth))
+	s.router.Get("/user/verify", verifyUser(s.auth))
+	s.router.Post("/user/verify/resend", resendVerification(s.auth))
+	s.router.Post("/user/token/refresh", refreshToken(s.auth))
+	s.router.Post("/user/logout", logout(s.auth))
+	s.router.Post("/user/password/reset", requestPasswordReset(s.auth))
+	s.router.Post("/user/password/reset/confirm", confirmPasswordReset(s.auth))
+	s.router.Get("/.well-known/jwks.json", getJWKS(s.auth))
+	s.router.Get("/user/email/verify", confirmEmailChange(s.auth))
 	s.router.Get("/coupon/categories", getCategoriesList(s.listing))
+	s.router.Get("/search", searchCoupons(s.listing))
+	s.router.Get("/search/suggest", suggestSearches(s.listing))
 	s.router.Get("/search/categories", getSearchCategories(s.listing))
 
 	s.router.Get("/category/{name}/coupons", getCategoryCoupons(s.listing))
 	s.router.Get("/coupon/latest", getLatestCoupons(s.listing))
 	s.router.Get("/coupon/popular", getPopularCoupons(s.listing))
+	s.router.Get("/coupon/nearby", getNearbyCoupons(s.listing))
 	s.router.Get("/coupon/{id}", getSingleCoupon(s.listing))
 
 	s.router.Get("/coupon/qrcode/{id}", getQrCode(s.listing))
 	s.router.Get("/store/{id}", getStoreDetails(s.listing))
 	s.router.Get("/store/{id}/coupons", getStoreCoupons(s.listing))
 
-	s.router.Post("/store/coupon/{id}/verify", verifyCoupon(s.sManager, s.auth))
 	s.router.Post("/store/coupon/{id}/checkstate", checkCouponState(s.sManager))
-
-	s.router.Get("/store", getUserStoreDetails(s.sManager, s.auth))
-	s.router.Get("/store/dashboard/coupons", getUserStoreCoupons(s.sManager, s.auth))
-	s.router.Get("/store/dashboard/coupons/redeemedcount", getUserStoreCouponsRedeemedCount(s.sManager, s.auth))
-	s.router.Get("/store/dashboard/followed", getStoresFollowedByUser(s.listing, s.auth))
-	s.router.Get("/store/dashboard/coupons/saved", getCouponsSavedByUser(s.listing, s.auth))
-
-	s.router.Post("/store/dashboard/coupon", couponAction(s.sManager, s.auth))
-	s.router.Get("/store/dashboard/employee", getEmployees(s.sManager, s.auth))
-	s.router.Post("/store/dashboard/employee", employeeAction(s.sManager, s.auth))
-	//s.router.Get("/store/dashboard/substore", getSubStores(s.sManager, s.auth))
-	//s.router.Post("/store/dashboard/substore", subStoreAction(s.sManager, s.auth))
-	s.router.Post("/store/dashboard/store", storeAction(s.sManager, s.auth))
+	s.router.Post("/employee/invite/accept", acceptEmployeeInvite(s.sManager, s.auth))
+
+	// routes for any logged in user
+	s.router.Group(func(r chi.Router) {
+		r.Use(authenticate(s.auth))
+		r.Get("/store/dashboard/followed", getStoresFollowedByUser(s.listing))
+		r.Get("/store/dashboard/coupons/saved", getCouponsSavedByUser(s.listing))
+		r.Post("/user/2fa/enroll", enrollTOTP(s.auth))
+		r.Post("/user/2fa/confirm", confirmTOTP(s.auth))
+		r.Post("/user/2fa/disable", disableTOTP(s.auth))
+		r.Post("/user/password", changePassword(s.auth))
+		r.Post("/user/email", changeEmail(s.auth))
+		r.Delete("/user", deleteAccount(s.auth))
+		r.Post("/user/delete/cancel", cancelAccountDeletion(s.auth))
+		r.Get("/user/export", exportAccount(s.auth))
+	})
+
+	// routes for users and API keys acting for a store, the store management
+	// service checks what each of these roles is allowed to do
+	s.router.Group(func(r chi.Router) {
+		r.Use(acceptAPIKeys(s.sManager))
+		r.Use(authenticate(s.auth))
+		r.Use(requireRole(authetication.RoleOwner, authetication.RoleEmployee, authetication.RolePlatformAdmin, authetication.RoleAPIKey))
+		r.Post("/store/coupon/{id}/verify", verifyCoupon(s.sManager))
+		r.Post("/store/coupon/redemption/{id}/void", voidRedemption(s.sManager))
+
+		r.Get("/store", getUserStoreDetails(s.sManager))
+		r.Get("/store/dashboard/coupons", getUserStoreCoupons(s.sManager))
+		r.Get("/store/dashboard/coupons/redeemedcount", getUserStoreCouponsRedeemedCount(s.sManager))
+
+		r.Post("/store/dashboard/coupon", couponAction(s.sManager))
+		r.Patch("/store/dashboard/coupon/{id}", updateCoupon(s.sManager))
+		r.Get("/store/dashboard/coupon/{id}/history", getCouponHistory(s.sManager))
+		r.Get("/store/dashboard/coupon/{id}/redemptions", getCouponRedemptions(s.sManager))
+		r.Get("/store/dashboard/redemption/voids", getRedemptionVoids(s.sManager))
+		r.Get("/store/dashboard/employee", getEmployees(s.sManager))
+		r.Post("/store/dashboard/employee", employeeAction(s.sManager))
+		r.Get("/store/dashboard/employee/invites", getEmployeeInvites(s.sManager))
+		r.Delete("/store/dashboard/employee/invites/{id}", revokeEmployeeInvite(s.sManager))
+		r.Get("/store/dashboard/apikeys", getAPIKeys(s.sManager))
+		r.Post("/store/dashboard/apikeys", createAPIKey(s.sManager))
+		r.Delete("/store/dashboard/apikeys/{id}", revokeAPIKey(s.sManager))
+		r.Get("/store/dashboard/branch", getBranches(s.sManager))
+		r.Post("/store/dashboard/branch", branchAction(s.sManager))
+		r.Get("/store/dashboard/branch/redeemedcount", getBranchesRedeemedCount(s.sManager))
+		r.Post("/store/dashboard/store", storeAction(s.sManager))
+	})
 
 	h := http.FileServer(http.Dir(""))
 	s.router.Handle("/static/images/*", h)
@@ -95,9 +149,7 @@ func getSingleCoupon(lister listing.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 		rw.Header().Set("Content-Type", "application/json")
-		fmt.Println("reust \n\n")
 		coupon, err := lister.SingleCoupon(r.Context(), id)
-		fmt.Println("curreent here\n\n")
 		if err != nil {
 			if errors.Is(err, listing.ErrCouponNotFound) {
 				// if id is not associated with any coupon
@@ -219,12 +271,7 @@ func signUp(auth authetication.Service) http.HandlerFunc {
 				json.NewEncoder(rw).Encode(r)
 				return
 			}
-			if errors.Is(err, authetication.ErrPasswordLengthUnAcceptable) {
-				r := constructError(http.StatusUnprocessableEntity,
-					"unacceptable password length",
-					"Password lenght should be between 6 and 64")
-				rw.WriteHeader(http.StatusUnprocessableEntity)
-				json.NewEncoder(rw).Encode(r)
+			if writePasswordPolicyError(rw, err) {
 				return
 			}
 
@@ -241,12 +288,80 @@ func signUp(auth authetication.Service) http.HandlerFunc {
 	}
 }
 
+//verifies the email address of a pending signup and creates the user
+func verifyUser(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		token := r.FormValue("token")
+		err := auth.VerifyUser(r.Context(), token)
+		if err != nil {
+			if errors.Is(err, authetication.ErrInvalidVerificationToken) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"token",
+					"invalid verification token",
+					"The verification token is invalid or has expired")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if errors.Is(err, authetication.ErrIdentityAlreadyExists) {
+				e := constructError(http.StatusConflict,
+					"identity already exists",
+					"An account is already associated with this email address")
+				rw.WriteHeader(http.StatusConflict)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		type Response struct {
+			Verified bool `json:"verified"`
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(Response{Verified: true})
+	}
+}
+
+//sends a new verification token to a pending signup
+func resendVerification(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		email := r.PostFormValue("email")
+		err := auth.ResendVerification(r.Context(), email)
+		if err != nil {
+			if errors.Is(err, authetication.ErrInvalidEmail) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"email",
+					"invalid email address",
+					"Enter a proper email address")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		rw.WriteHeader(http.StatusAccepted)
+	}
+}
+
 //logins in a user with an authorization token
 func login(auth authetication.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		email := r.PostFormValue("email")
 		password := r.PostFormValue("password")
-		token, err := auth.Login(r.Context(), email, password)
+		token, err := auth.Login(r.Context(), email, password, clientAddress(r))
 
 		rw.Header().Set("Content-Type", "application/json")
 
@@ -270,6 +385,18 @@ func login(auth authetication.Service) http.HandlerFunc {
 				jsonErr = json.NewEncoder(rw).Encode(errorRes)
 				return
 
+			} else if writeTooManyAttempts(rw, err) {
+				return
+
+			} else if errors.Is(err, authetication.ErrIdentityNotVerified) {
+				errorRes := constructError(http.StatusForbidden,
+					"identity is not verified",
+					"Verify your email address with the code sent to it before logging in",
+				)
+				rw.WriteHeader(http.StatusForbidden)
+				jsonErr = json.NewEncoder(rw).Encode(errorRes)
+				return
+
 			} else if errors.Is(err, authetication.ErrIdentityDoesNotExists) {
 				errorRes := constructError(http.StatusConflict,
 					"identity does not exists",
@@ -297,185 +424,926 @@ func login(auth authetication.Service) http.HandlerFunc {
 
 	}
 }
-func getLatestCouponOnly(ctx context.Context, lister listing.Service) (*listing.CouponListResponse, *ResponseError) {
-	latest, err := lister.LatestCoupons(ctx)
 
-	if err != nil {
-		e := constructError(http.StatusInternalServerError,
-			"unable to process request",
-			"an error occured while processing your request")
+//completes a login that was answered with a challenge using a one-time password or recovery code
+func completeLogin(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
 
-		return nil, &e
+		challenge := r.PostFormValue("challenge")
+		code := r.PostFormValue("code")
+		token, err := auth.CompleteLogin(r.Context(), challenge, code)
+		if err != nil {
+			writeTOTPError(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(token)
 	}
-	return latest, nil
-
 }
-func getFilteredCouponOnly(ctx context.Context, lister listing.Service, filter string) (*listing.CouponListResponse, *ResponseError) {
-	// filter the coupons
-	filtered, err := lister.LatestCouponsWithFiltering(ctx, filter)
-	if err != nil {
-		log.Println(err)
-		e := constructError(http.StatusInternalServerError,
-			"unable to process request",
-			"an error occured while processing your request")
-
-		return nil, &e
-
-	}
-
-	return filtered, nil
 
-}
-func getLatestCoupons(lister listing.Service) http.HandlerFunc {
+//emails a one-time login link to the user
+func requestMagicLink(auth authetication.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("Content-Type", "application/json")
-		filter := r.FormValue("filter")
-		lastID := r.FormValue("couponid")
-		lastTime := r.FormValue("lasttime")
 
-		// check if last coupon id pass into the query to load more coupons
-		if lastID == "" || lastTime == "" {
-			// check if filter is empty and return the latest coupons
-			if filter == "" {
-				coupons, resErr := getLatestCouponOnly(r.Context(), lister)
-				if resErr != nil {
-					rw.WriteHeader(http.StatusInternalServerError)
-					json.NewEncoder(rw).Encode(resErr)
-					return
-				}
-				json.NewEncoder(rw).Encode(coupons)
+		email := r.PostFormValue("email")
+		err := auth.RequestMagicLink(r.Context(), email)
+		if err != nil {
+			if errors.Is(err, authetication.ErrInvalidEmail) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"email",
+					"invalid email address",
+					"Enter a proper email address")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
 				return
 			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		// the same response is sent whether or not the email is registered
+		rw.WriteHeader(http.StatusAccepted)
+	}
+}
 
-			filtered, resErr := getFilteredCouponOnly(r.Context(), lister, filter)
-			if resErr != nil {
-				json.NewEncoder(rw).Encode(resErr)
+//logs in the user the magic link of the token was sent to
+func magicLogin(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		token, err := auth.MagicLogin(r.Context(), r.URL.Query().Get("token"))
+		if err != nil {
+			if errors.Is(err, authetication.ErrInvalidMagicLink) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"token",
+					"invalid login link",
+					"The login link is invalid, expired or has already been used")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
 				return
 			}
-
-			json.NewEncoder(rw).Encode(filtered)
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
 			return
-
 		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(token)
+	}
+}
 
-		before, err := lister.CouponBeforeIDAndTimeFiltered(r.Context(), lastID, lastTime, filter)
+//redirects the user to log in with the identity provider
+func startOIDCLogin(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		authURL, err := auth.OIDCAuthURL(r.Context(), chi.URLParam(r, "provider"))
 		if err != nil {
+			writeOIDCError(rw, err)
+			return
+		}
+		http.Redirect(rw, r, authURL, http.StatusFound)
+	}
+}
 
-			resErr := constructError(http.StatusInternalServerError,
-				"unable to process request",
-				"an error occured while processing your request")
-
-			rw.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(rw).Encode(resErr)
+//completes the login the identity provider redirected the user back from,
+//the response is the same as the one of a login with a password
+func completeOIDCLogin(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("error") != "" {
+			rw.Header().Set("Content-Type", "application/json")
+			e := constructError(http.StatusUnauthorized,
+				"login rejected",
+				"The identity provider did not complete the login: "+query.Get("error"))
+			rw.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(rw).Encode(e)
 			return
 		}
-		json.NewEncoder(rw).Encode(before)
+		token, err := auth.OIDCLogin(r.Context(), chi.URLParam(r, "provider"), query.Get("state"), query.Get("code"))
 		if err != nil {
-			fmt.Println(err)
+			writeOIDCError(rw, err)
+			return
 		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(token)
 	}
 }
 
-func getCategoryCoupons(lister listing.Service) http.HandlerFunc {
+//writes the response of a failed login with an identity provider
+func writeOIDCError(rw http.ResponseWriter, err error) {
+	rw.Header().Set("Content-Type", "application/json")
+	var e ResponseError
+	switch {
+	case errors.Is(err, authetication.ErrUnknownProvider):
+		e = constructError(http.StatusNotFound,
+			"unknown identity provider",
+			"No identity provider is configured with this name")
+	case errors.Is(err, authetication.ErrInvalidOIDCState):
+		e = constructError(http.StatusUnprocessableEntity,
+			"invalid login state",
+			"The login is invalid or expired, start the login again")
+	case errors.Is(err, authetication.ErrOIDCEmailNotVerified):
+		e = constructError(http.StatusForbidden,
+			"email not verified",
+			"The identity provider has not verified your email address")
+	case errors.Is(err, authetication.ErrOIDCLoginRejected), errors.Is(err, authetication.ErrInvalidIDToken):
+		e = constructError(http.StatusUnauthorized,
+			"login rejected",
+			"The login with the identity provider could not be verified")
+	default:
+		e = constructError(http.StatusInternalServerError,
+			"unable to process request",
+			"A problem occurs while processing the request")
+	}
+	rw.WriteHeader(e.Code)
+	json.NewEncoder(rw).Encode(e)
+}
+
+//starts the enrollment of an authenticator app for the logged in store owner
+func enrollTOTP(auth authetication.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("Content-Type", "application/json")
-		catName := chi.URLParam(r, "name")
 
-		lastID := r.FormValue("couponid")
-		lastTime := r.FormValue("lasttime")
+		enrollment, err := auth.EnrollTOTP(r.Context(), principalFrom(r.Context()).UserID)
+		if err != nil {
+			writeTOTPError(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(enrollment)
+	}
+}
 
-		var coupons *listing.CouponListResponse
-		var err error
-		if lastID == "" || lastTime == "" {
-			coupons, err = lister.CategoryCoupons(r.Context(), catName)
-		} else {
-			coupons, err = lister.CategoryCouponsBeforeIDAndTime(r.Context(), catName, lastID, lastTime)
+//enables two-factor authentication and returns the recovery codes
+func confirmTOTP(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
 
-		}
+		code := r.PostFormValue("code")
+		codes, err := auth.ConfirmTOTP(r.Context(), principalFrom(r.Context()).UserID, code)
 		if err != nil {
-			resErr := constructError(http.StatusInternalServerError,
-				"unable to process request",
-				"an error occured while processing your request")
-
-			rw.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(rw).Encode(resErr)
+			writeTOTPError(rw, err)
 			return
 		}
-		json.NewEncoder(rw).Encode(coupons)
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}{codes})
+	}
+}
+
+//turns off two-factor authentication for the logged in user
+func disableTOTP(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		code := r.PostFormValue("code")
+		err := auth.DisableTOTP(r.Context(), principalFrom(r.Context()).UserID, code)
 		if err != nil {
-			fmt.Println(err)
+			writeTOTPError(rw, err)
+			return
 		}
+		rw.WriteHeader(http.StatusNoContent)
 	}
 }
 
-func getPopularCoupons(lister listing.Service) http.HandlerFunc {
+//maps the errors of the two-factor authentication endpoints
+func writeTOTPError(rw http.ResponseWriter, err error) {
+	var e interface{}
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, authetication.ErrInvalidTOTPCode):
+		status = http.StatusUnauthorized
+		e = constructErrorWithField(status, "code", "invalid code", "The authentication code is invalid or was already used")
+	case errors.Is(err, authetication.ErrInvalidLoginChallenge):
+		status = http.StatusUnauthorized
+		e = constructErrorWithField(status, "challenge", "invalid challenge", "The login has expired, log in again")
+	case errors.Is(err, authetication.ErrTOTPNotAllowed):
+		status = http.StatusForbidden
+		e = constructError(status, "two-factor authentication not allowed", "Two-factor authentication is only available to store owners")
+	case errors.Is(err, authetication.ErrTOTPAlreadyEnabled):
+		status = http.StatusConflict
+		e = constructError(status, "two-factor authentication already enabled", "Disable two-factor authentication before enrolling a new authenticator")
+	case errors.Is(err, authetication.ErrTOTPNotEnabled):
+		status = http.StatusConflict
+		e = constructError(status, "two-factor authentication not enabled", "Enroll an authenticator first")
+	default:
+		e = constructError(status, "unable to process request", "A problem occurs while processing the request")
+	}
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(e)
+}
 
+//exchanges a refresh token for a new access token and refresh token
+func refreshToken(auth authetication.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("Content-Type", "application/json")
-		filter := r.FormValue("filter")
-		var popular *listing.CouponListResponse
-		var err error
-		if filter != "" {
-			popular, err = lister.PopularCouponsWithFiltering(r.Context(), filter)
-		} else {
-			popular, err = lister.PopularCoupons(r.Context())
-		}
 
+		refresh := r.PostFormValue("refresh_token")
+		token, err := auth.RefreshToken(r.Context(), refresh)
 		if err != nil {
-			log.Println(err)
-
+			if errors.Is(err, authetication.ErrInvalidRefreshToken) {
+				e := constructErrorWithField(http.StatusUnauthorized,
+					"refresh_token",
+					"invalid refresh token",
+					"The refresh token is invalid, expired or revoked, login again")
+				rw.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
 			e := constructError(http.StatusInternalServerError,
 				"unable to process request",
-				"an error occured while processing your request")
+				"A problem occurs while processing the request")
 			rw.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(rw).Encode(e)
 			return
-
 		}
-		json.NewEncoder(rw).Encode(popular)
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(token)
 	}
 }
 
-//returns the store details of the store asscoiated with the store id
-func getStoreDetails(lister listing.Service) http.HandlerFunc {
+//revokes the session of the refresh token
+func logout(auth authetication.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("Content-Type", "application/json")
-		storeID := chi.URLParam(r, "id")
-		store, err := lister.StoreDetails(r.Context(), storeID)
 
+		refresh := r.PostFormValue("refresh_token")
+		err := auth.Logout(r.Context(), refresh)
 		if err != nil {
-			fmt.Println(err)
-			if errors.Is(err, listing.ErrStoreNotFound) {
-				e := constructError(http.StatusNotFound, "content not found", "store with id is not found")
-				rw.WriteHeader(http.StatusNotFound)
+			if errors.Is(err, authetication.ErrInvalidRefreshToken) {
+				e := constructErrorWithField(http.StatusUnauthorized,
+					"refresh_token",
+					"invalid refresh token",
+					"The refresh token is invalid")
+				rw.WriteHeader(http.StatusUnauthorized)
 				json.NewEncoder(rw).Encode(e)
 				return
 			}
-
 			e := constructError(http.StatusInternalServerError,
 				"unable to process request",
-				"an error occured while processing your request")
+				"A problem occurs while processing the request")
 			rw.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(rw).Encode(e)
 			return
 		}
-		err = json.NewEncoder(rw).Encode(store)
-		if err != nil {
-			fmt.Println(err)
-		}
+		rw.WriteHeader(http.StatusNoContent)
 	}
 }
 
-// returns the  coupons associated with a particular store
-func getStoreCoupons(lister listing.Service) http.HandlerFunc {
+//publishes the public keys access tokens are signed with
+func getJWKS(auth authetication.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "public, max-age=300")
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(auth.JWKS())
+	}
+}
 
+//sends a password reset token to the email of the user
+func requestPasswordReset(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("Content-Type", "application/json")
-		storeID := chi.URLParam(r, "id")
-		lastID := r.FormValue("last")
-		lastTime := r.FormValue("time")
 
-		var coupons *listing.CouponListResponse
+		email := r.PostFormValue("email")
+		err := auth.ResetPassword(r.Context(), email)
+		if err != nil {
+			if errors.Is(err, authetication.ErrInvalidEmail) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"email",
+					"invalid email address",
+					"Enter a proper email address")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		// the same response is sent whether or not the email is registered
+		rw.WriteHeader(http.StatusAccepted)
+	}
+}
+
+//sets a new password using the token sent to the user
+func confirmPasswordReset(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		token := r.PostFormValue("token")
+		password := r.PostFormValue("password")
+		err := auth.ConfirmPasswordReset(r.Context(), token, password)
+		if err != nil {
+			if errors.Is(err, authetication.ErrInvalidResetToken) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"token",
+					"invalid reset token",
+					"The reset token is invalid, expired or has already been used")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if writePasswordPolicyError(rw, err) {
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+}
+
+//writes the response of a login blocked after too many failures and reports whether it did
+func writeTooManyAttempts(rw http.ResponseWriter, err error) bool {
+	var attemptsErr *authetication.AttemptsError
+	if !errors.As(err, &attemptsErr) {
+		return false
+	}
+	retryAfter := int64(math.Ceil(attemptsErr.RetryAfter.Seconds()))
+	e := constructError(http.StatusTooManyRequests,
+		"too many login attempts",
+		fmt.Sprintf("Too many failed attempts, try again in %d seconds", retryAfter))
+	rw.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	rw.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(rw).Encode(e)
+	return true
+}
+
+//changes the password of the logged in user, their other sessions are logged out
+func changePassword(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		current := r.PostFormValue("current_password")
+		password := r.PostFormValue("password")
+		err := auth.ChangePassword(r.Context(), *principalFrom(r.Context()), current, password)
+		if err != nil {
+			if writeCredentialsError(rw, err) || writePasswordPolicyError(rw, err) {
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+//sends a verification token to the new email address of the logged in user
+func changeEmail(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		password := r.PostFormValue("password")
+		email := r.PostFormValue("email")
+		err := auth.ChangeEmail(r.Context(), principalFrom(r.Context()).UserID, password, email)
+		if err != nil {
+			if writeCredentialsError(rw, err) {
+				return
+			}
+			if errors.Is(err, authetication.ErrInvalidEmail) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"email",
+					"bad email format",
+					"Email address is not of good format")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if errors.Is(err, authetication.ErrIdentityAlreadyExists) {
+				e := constructErrorWithField(http.StatusConflict,
+					"email",
+					"identity already exists",
+					"An account is already associated with this email address")
+				rw.WriteHeader(http.StatusConflict)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		rw.WriteHeader(http.StatusAccepted)
+	}
+}
+
+//changes the email of the user the token was sent for
+func confirmEmailChange(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		token := r.FormValue("token")
+		err := auth.ConfirmEmailChange(r.Context(), token)
+		if err != nil {
+			if errors.Is(err, authetication.ErrInvalidEmailChangeToken) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"token",
+					"invalid email change token",
+					"The token is invalid, expired or has already been used")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if errors.Is(err, authetication.ErrIdentityAlreadyExists) {
+				e := constructError(http.StatusConflict,
+					"identity already exists",
+					"An account is already associated with this email address")
+				rw.WriteHeader(http.StatusConflict)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		type Response struct {
+			Verified bool `json:"verified"`
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(Response{Verified: true})
+	}
+}
+
+//schedules the deletion of the account of the logged in user
+func deleteAccount(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		password := deleteFormValue(r, "password")
+		at, err := auth.DeleteAccount(r.Context(), principalFrom(r.Context()).UserID, password)
+		if err != nil {
+			if writeCredentialsError(rw, err) {
+				return
+			}
+			if errors.Is(err, authetication.ErrIdentityDoesNotExists) {
+				unauthorized(rw)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		type Response struct {
+			DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+		}
+		rw.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(rw).Encode(Response{DeletionScheduledAt: at})
+	}
+}
+
+//maxDeleteFormSize limits the form read from the body of a DELETE request
+const maxDeleteFormSize = 1 << 16
+
+//deleteFormValue returns a field of the url encoded body of a DELETE request,
+//net/http only parses the body of POST, PUT and PATCH requests
+func deleteFormValue(r *http.Request, key string) string {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxDeleteFormSize))
+	if err != nil {
+		return ""
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+	return values.Get(key)
+}
+
+//restores the account of the logged in user before its deletion
+func cancelAccountDeletion(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		err := auth.CancelAccountDeletion(r.Context(), principalFrom(r.Context()).UserID)
+		if err != nil {
+			if errors.Is(err, authetication.ErrNoDeletionScheduled) {
+				e := constructError(http.StatusConflict,
+					"no deletion scheduled",
+					"The account is not scheduled for deletion")
+				rw.WriteHeader(http.StatusConflict)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+//returns the personal data held about the logged in user as json, or as a zip archive
+//when asked for with the format query or the Accept header
+func exportAccount(auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		export, err := auth.ExportAccount(r.Context(), principalFrom(r.Context()).UserID)
+		if err != nil {
+			rw.Header().Set("Content-Type", "application/json")
+			if errors.Is(err, authetication.ErrIdentityDoesNotExists) {
+				unauthorized(rw)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		if r.URL.Query().Get("format") == "zip" || strings.Contains(r.Header.Get("Accept"), "application/zip") {
+			rw.Header().Set("Content-Type", "application/zip")
+			rw.Header().Set("Content-Disposition", `attachment; filename="couponcutter-export.zip"`)
+			rw.WriteHeader(http.StatusOK)
+			export.WriteZip(rw)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Content-Disposition", `attachment; filename="couponcutter-export.json"`)
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(export)
+	}
+}
+
+//writes the response of a wrong or throttled current password and reports whether it did
+func writeCredentialsError(rw http.ResponseWriter, err error) bool {
+	if writeTooManyAttempts(rw, err) {
+		return true
+	}
+	if !errors.Is(err, authetication.ErrIncorrectPassword) {
+		return false
+	}
+	e := constructErrorWithField(http.StatusForbidden,
+		"current_password",
+		"incorrect password",
+		"The current password is not correct")
+	rw.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(rw).Encode(e)
+	return true
+}
+
+//writes the response of a password rejected by the password policy and reports whether it did
+func writePasswordPolicyError(rw http.ResponseWriter, err error) bool {
+	var desc string
+	switch {
+	case errors.Is(err, authetication.ErrPasswordLengthUnAcceptable):
+		desc = "Password length is either too short or too long"
+	case errors.Is(err, authetication.ErrPasswordIsEmail):
+		desc = "Password must not be your email address"
+	case errors.Is(err, authetication.ErrPasswordBreached):
+		desc = "Password has appeared in a data breach, choose another one"
+	default:
+		return false
+	}
+	e := constructErrorWithField(http.StatusUnprocessableEntity, "password", "unacceptable password", desc)
+	rw.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(rw).Encode(e)
+	return true
+}
+
+func getLatestCouponOnly(ctx context.Context, lister listing.Service) (*listing.CouponListResponse, *ResponseError) {
+	latest, err := lister.LatestCoupons(ctx)
+
+	if err != nil {
+		e := constructError(http.StatusInternalServerError,
+			"unable to process request",
+			"an error occured while processing your request")
+
+		return nil, &e
+	}
+	return latest, nil
+
+}
+func getFilteredCouponOnly(ctx context.Context, lister listing.Service, filter string) (*listing.CouponListResponse, *ResponseError) {
+	// filter the coupons
+	filtered, err := lister.LatestCouponsWithFiltering(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		e := constructError(http.StatusInternalServerError,
+			"unable to process request",
+			"an error occured while processing your request")
+
+		return nil, &e
+
+	}
+
+	return filtered, nil
+
+}
+
+//returns the coupons matching the q term, filtered by the category and discount_type
+//queries and paged with the cursor query
+func searchCoupons(lister listing.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		query := listing.SearchQuery{
+			Term:         r.FormValue("q"),
+			DiscountType: r.FormValue("discount_type"),
+			Cursor:       r.FormValue("cursor"),
+		}
+		for _, category := range r.Form["category"] {
+			for _, name := range strings.Split(category, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					query.Categories = append(query.Categories, name)
+				}
+			}
+		}
+		if limit := r.FormValue("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"limit",
+					"invalid limit",
+					"The limit must be a number")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			query.Limit = n
+		}
+
+		response, err := lister.Search(r.Context(), query)
+		if err != nil {
+			var field, message, desc string
+			switch {
+			case errors.Is(err, listing.ErrEmptySearch):
+				field, message, desc = "q", "empty search", "A search term is needed"
+			case errors.Is(err, listing.ErrInvalidSearchCursor):
+				field, message, desc = "cursor", "invalid cursor", "The cursor was not returned by a previous search"
+			case errors.Is(err, listing.ErrInvalidDiscountType):
+				field, message, desc = "discount_type", "invalid discount type", "The discount type must be amount_off or percentage_off"
+			default:
+				e := constructError(http.StatusInternalServerError,
+					"unable to process request",
+					"A problem occurs while processing the request")
+				rw.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			e := constructErrorWithField(http.StatusUnprocessableEntity, field, message, desc)
+			rw.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(response)
+	}
+}
+
+//returns the store names, categories and coupon descriptions completing the q term
+func suggestSearches(lister listing.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		response, err := lister.Suggest(r.Context(), r.FormValue("q"))
+		if err != nil {
+			if errors.Is(err, listing.ErrEmptySearch) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"q",
+					"empty search",
+					"A search term is needed")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(response)
+	}
+}
+
+//returns the coupons of the stores within radius meters of the lat and lng queries
+func getNearbyCoupons(lister listing.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		var coords [3]float64
+		for i, field := range []string{"lat", "lng", "radius"} {
+			value := r.FormValue(field)
+			if value == "" && field == "radius" {
+				continue
+			}
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					field,
+					"invalid "+field,
+					"The "+field+" must be a number")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			coords[i] = n
+		}
+
+		response, err := lister.NearbyCoupons(r.Context(), coords[0], coords[1], coords[2])
+		if err != nil {
+			if errors.Is(err, listing.ErrInvalidLocation) || errors.Is(err, listing.ErrInvalidRadius) {
+				e := constructError(http.StatusUnprocessableEntity,
+					err.Error(),
+					"The lat must be within -90 and 90, the lng within -180 and 180 and the radius positive")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"A problem occurs while processing the request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(response)
+	}
+}
+
+func getLatestCoupons(lister listing.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		filter := r.FormValue("filter")
+		lastID := r.FormValue("couponid")
+		lastTime := r.FormValue("lasttime")
+
+		// check if last coupon id pass into the query to load more coupons
+		if lastID == "" || lastTime == "" {
+			// check if filter is empty and return the latest coupons
+			if filter == "" {
+				coupons, resErr := getLatestCouponOnly(r.Context(), lister)
+				if resErr != nil {
+					rw.WriteHeader(http.StatusInternalServerError)
+					json.NewEncoder(rw).Encode(resErr)
+					return
+				}
+				json.NewEncoder(rw).Encode(coupons)
+				return
+			}
+
+			filtered, resErr := getFilteredCouponOnly(r.Context(), lister, filter)
+			if resErr != nil {
+				json.NewEncoder(rw).Encode(resErr)
+				return
+			}
+
+			json.NewEncoder(rw).Encode(filtered)
+			return
+
+		}
+
+		before, err := lister.CouponBeforeIDAndTimeFiltered(r.Context(), lastID, lastTime, filter)
+		if err != nil {
+
+			resErr := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"an error occured while processing your request")
+
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(resErr)
+			return
+		}
+		json.NewEncoder(rw).Encode(before)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func getCategoryCoupons(lister listing.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		catName := chi.URLParam(r, "name")
+
+		lastID := r.FormValue("couponid")
+		lastTime := r.FormValue("lasttime")
+
+		var coupons *listing.CouponListResponse
+		var err error
+		if lastID == "" || lastTime == "" {
+			coupons, err = lister.CategoryCoupons(r.Context(), catName)
+		} else {
+			coupons, err = lister.CategoryCouponsBeforeIDAndTime(r.Context(), catName, lastID, lastTime)
+
+		}
+		if err != nil {
+			resErr := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"an error occured while processing your request")
+
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(resErr)
+			return
+		}
+		json.NewEncoder(rw).Encode(coupons)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func getPopularCoupons(lister listing.Service) http.HandlerFunc {
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		filter := r.FormValue("filter")
+		var popular *listing.CouponListResponse
+		var err error
+		if filter != "" {
+			popular, err = lister.PopularCouponsWithFiltering(r.Context(), filter)
+		} else {
+			popular, err = lister.PopularCoupons(r.Context())
+		}
+
+		if err != nil {
+			log.Println(err)
+
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"an error occured while processing your request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+
+		}
+		json.NewEncoder(rw).Encode(popular)
+	}
+}
+
+//returns the store details of the store asscoiated with the store id
+func getStoreDetails(lister listing.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		storeID := chi.URLParam(r, "id")
+		store, err := lister.StoreDetails(r.Context(), storeID)
+
+		if err != nil {
+			fmt.Println(err)
+			if errors.Is(err, listing.ErrStoreNotFound) {
+				e := constructError(http.StatusNotFound, "content not found", "store with id is not found")
+				rw.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"an error occured while processing your request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		err = json.NewEncoder(rw).Encode(store)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// returns the  coupons associated with a particular store
+func getStoreCoupons(lister listing.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+
+		rw.Header().Set("Content-Type", "application/json")
+		storeID := chi.URLParam(r, "id")
+		lastID := r.FormValue("last")
+		lastTime := r.FormValue("time")
+
+		var coupons *listing.CouponListResponse
 		var err error
 		if lastID != "" && lastTime != "" {
 			coupons, err = lister.StoreCouponsBeforeIDAndTime(r.Context(), storeID, lastID, lastTime)
@@ -507,34 +1375,19 @@ func getStoreCoupons(lister listing.Service) http.HandlerFunc {
 	}
 }
 
-func getUserStoreDetails(sManager storemanagement.Service, auth authetication.Service) http.HandlerFunc {
+func getUserStoreDetails(sManager storemanagement.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("Content-Type", "application/json")
 
-		authBearer := r.Header.Get("authorization")
-		if authBearer == "" {
-			e := constructError(http.StatusUnauthorized, "no token found", "user authetication is required for this route")
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		token := strings.Split(authBearer, " ")
-		if len(token) != 2 {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		userid, valid := auth.VerifyToken(token[1])
-		if !valid {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
+		actor := storeActor(principalFrom(r.Context()), r)
 
-		userStore, err := sManager.UserStoreData(r.Context(), userid)
+		userStore, err := sManager.UserStoreData(r.Context(), actor)
 
 		if err != nil {
+			if errors.Is(err, storemanagement.ErrPermissionDenied) {
+				forbidden(rw)
+				return
+			}
 			e := constructError(http.StatusInternalServerError,
 				"unable to process request",
 				"an error occured while processing your request")
@@ -551,41 +1404,21 @@ func getUserStoreDetails(sManager storemanagement.Service, auth authetication.Se
 }
 
 // returns a list of coupons of the user store
-func getUserStoreCoupons(sManager storemanagement.Service, auth authetication.Service) http.HandlerFunc {
+func getUserStoreCoupons(sManager storemanagement.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 
 		rw.Header().Set("Content-Type", "application/json")
 
-		authBearer := r.Header.Get("authorization")
-		if authBearer == "" {
-			e := constructError(http.StatusUnauthorized, "no token found", "user authetication is required for this route")
-			fmt.Println(e)
-			rw.WriteHeader(http.StatusUnauthorized)
-
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		token := strings.Split(authBearer, " ")
-		if len(token) != 2 {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			fmt.Println("here no w")
-
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		userid, valid := auth.VerifyToken(token[1])
-		if !valid {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
+		actor := storeActor(principalFrom(r.Context()), r)
 
-		coupons, err := sManager.UserStoreCoupons(r.Context(), userid)
+		coupons, err := sManager.UserStoreCoupons(r.Context(), actor)
 		fmt.Println("here")
 
 		if err != nil {
+			if errors.Is(err, storemanagement.ErrPermissionDenied) {
+				forbidden(rw)
+				return
+			}
 			e := constructError(http.StatusInternalServerError,
 				"unable to process request",
 				"an error occured while processing your request")
@@ -602,42 +1435,21 @@ func getUserStoreCoupons(sManager storemanagement.Service, auth authetication.Se
 }
 
 // returns a list of coupons of the user store
-func getUserStoreCouponsRedeemedCount(sManager storemanagement.Service, auth authetication.Service) http.HandlerFunc {
+func getUserStoreCouponsRedeemedCount(sManager storemanagement.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 
 		rw.Header().Set("Content-Type", "application/json")
 		filter := r.FormValue("filter")
 
-		authBearer := r.Header.Get("authorization")
-
-		if authBearer == "" {
-			e := constructError(http.StatusUnauthorized, "no token found", "user authetication is required for this route")
-			fmt.Println(e)
-			rw.WriteHeader(http.StatusUnauthorized)
-
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		token := strings.Split(authBearer, " ")
-		if len(token) != 2 {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			fmt.Println("here no w")
-
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		userid, valid := auth.VerifyToken(token[1])
-		if !valid {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
+		actor := storeActor(principalFrom(r.Context()), r)
 
-		count, err := sManager.GetUserStoreCouponsRedeemedCount(r.Context(), userid, filter)
+		count, err := sManager.GetUserStoreCouponsRedeemedCount(r.Context(), actor, filter)
 
 		if err != nil {
+			if errors.Is(err, storemanagement.ErrPermissionDenied) {
+				forbidden(rw)
+				return
+			}
 			e := constructError(http.StatusInternalServerError,
 				"unable to process request",
 				"an error occured while processing your request")
@@ -656,34 +1468,14 @@ func getUserStoreCouponsRedeemedCount(sManager storemanagement.Service, auth aut
 }
 
 // returns the list of coupons saved by a particular user
-func getCouponsSavedByUser(lister listing.Service, auth authetication.Service) http.HandlerFunc {
+func getCouponsSavedByUser(lister listing.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 
 		rw.Header().Set("Content-Type", "application/json")
 		lastID := r.FormValue("last")
 		lastTime := r.FormValue("time")
 
-		authBearer := r.Header.Get("authorization")
-		if authBearer == "" {
-			e := constructError(http.StatusUnauthorized, "no token found", "user authetication is required for this route")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		token := strings.Split(authBearer, " ")
-		if len(token) != 2 {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		userid, valid := auth.VerifyToken(token[1])
-		if !valid {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
+		userid := principalFrom(r.Context()).UserID
 		var coupons *listing.CouponListResponse
 		var err error
 
@@ -711,31 +1503,12 @@ func getCouponsSavedByUser(lister listing.Service, auth authetication.Service) h
 }
 
 // returns the list of stores followed by a particular user
-func getStoresFollowedByUser(lister listing.Service, auth authetication.Service) http.HandlerFunc {
+func getStoresFollowedByUser(lister listing.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 
 		rw.Header().Set("Content-Type", "application/json")
 
-		authBearer := r.Header.Get("authorization")
-		if authBearer == "" {
-			e := constructError(http.StatusUnauthorized, "no token found", "user authetication is required for this route")
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		token := strings.Split(authBearer, " ")
-		if len(token) != 2 {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		userid, valid := auth.VerifyToken(token[1])
-		if !valid {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
+		userid := principalFrom(r.Context()).UserID
 
 		stores, err := lister.StoresFollowedByUser(r.Context(), userid)
 
@@ -756,36 +1529,17 @@ func getStoresFollowedByUser(lister listing.Service, auth authetication.Service)
 }
 
 // perform certain actions on employees management
-func employeeAction(sManager storemanagement.Service, auth authetication.Service) http.HandlerFunc {
+func employeeAction(sManager storemanagement.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 
 		rw.Header().Set("Content-Type", "application/json")
-		authBearer := r.Header.Get("authorization")
-		if authBearer == "" {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		token := strings.Split(authBearer, " ")
-		if len(token) != 2 {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		userid, valid := auth.VerifyToken(token[1])
-		if !valid {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
+		actor := storeActor(principalFrom(r.Context()), r)
 
 		type EmployeePayload struct {
-			EmpID  string `json:"emp_id,omitempty"`
-			Email  string `json:"email,omitempty"`
-			Action string `json:"action,omitempty"`
+			EmpID    string   `json:"emp_id,omitempty"`
+			Email    string   `json:"email,omitempty"`
+			Branches []string `json:"branches,omitempty"`
+			Action   string   `json:"action,omitempty"`
 		}
 
 		if r.Body == nil {
@@ -804,7 +1558,7 @@ func employeeAction(sManager storemanagement.Service, auth authetication.Service
 			return
 		}
 
-		if payload.Action == "suspend" || payload.Action == "remove" || payload.Action == "resume" {
+		if payload.Action == "suspend" || payload.Action == "remove" || payload.Action == "resume" || payload.Action == "assign" {
 			if payload.EmpID == "" {
 				e := constructError(http.StatusUnprocessableEntity, "no valid body found", "retry the request by sending a body")
 				rw.WriteHeader(http.StatusUnprocessableEntity)
@@ -813,18 +1567,28 @@ func employeeAction(sManager storemanagement.Service, auth authetication.Service
 			}
 		}
 
-		if payload.Email == "" {
-			if payload.Action == "create" {
-
+		if payload.Action == "create" {
+			if payload.Email == "" {
 				e := constructError(http.StatusUnprocessableEntity, "no valid body found", "retry the request by sending a body")
 				rw.WriteHeader(http.StatusUnprocessableEntity)
 				json.NewEncoder(rw).Encode(e)
 				return
 			}
-			err := sManager.AddEmployee(r.Context(), userid, storemanagement.Employee{
-				Email: payload.Email,
-			})
+			invite, err := sManager.InviteEmployee(r.Context(), actor, payload.Email)
 			if err != nil {
+				if errors.Is(err, storemanagement.ErrPermissionDenied) {
+					forbidden(rw)
+					return
+				}
+				if errors.Is(err, storemanagement.ErrInvalidEmail) {
+					e := constructErrorWithField(http.StatusUnprocessableEntity,
+						"email",
+						"bad email format",
+						"Email address is not of good format")
+					rw.WriteHeader(http.StatusUnprocessableEntity)
+					json.NewEncoder(rw).Encode(e)
+					return
+				}
 				e := constructError(http.StatusInternalServerError,
 					"unable to process request",
 					"an error occured while processing your request")
@@ -832,23 +1596,39 @@ func employeeAction(sManager storemanagement.Service, auth authetication.Service
 				json.NewEncoder(rw).Encode(e)
 				return
 			}
+			rw.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(rw).Encode(invite)
+			return
+		}
+
+		if payload.Action == "assign" {
+			err := sManager.AssignEmployeeBranches(r.Context(), actor, payload.EmpID, payload.Branches)
+			if err != nil {
+				writeBranchError(rw, err)
+				return
+			}
 			type ActionResponse struct {
-				Type  string `json:"type"`
-				Email string `json:"email"`
-				State bool   `json:"create"`
+				Type     string   `json:"type,omitempty"`
+				EmpID    string   `json:"emp_id,omitempty"`
+				Branches []string `json:"branches"`
 			}
-			rw.WriteHeader(http.StatusAccepted)
-			json.NewEncoder(rw).Encode(ActionResponse{
-				Type:  "employee",
-				Email: payload.Email,
-				State: true})
+			rw.WriteHeader(http.StatusOK)
+			json.NewEncoder(rw).Encode(ActionResponse{
+				Type:     "employee",
+				EmpID:    payload.EmpID,
+				Branches: payload.Branches,
+			})
 			return
 		}
 
 		if payload.Action == "suspend" {
 			fmt.Printf("suspend")
-			err := sManager.SuspendEmployee(r.Context(), userid, payload.EmpID)
+			err := sManager.SuspendEmployee(r.Context(), actor, payload.EmpID)
 			if err != nil {
+				if errors.Is(err, storemanagement.ErrPermissionDenied) {
+					forbidden(rw)
+					return
+				}
 				if errors.Is(err, storemanagement.ErrEmployeeNotFound) {
 					e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
 					rw.WriteHeader(http.StatusUnprocessableEntity)
@@ -876,8 +1656,12 @@ func employeeAction(sManager storemanagement.Service, auth authetication.Service
 		}
 		if payload.Action == "remove" {
 			fmt.Printf("removing")
-			err := sManager.RemoveEmployee(r.Context(), userid, payload.EmpID)
+			err := sManager.RemoveEmployee(r.Context(), actor, payload.EmpID)
 			if err != nil {
+				if errors.Is(err, storemanagement.ErrPermissionDenied) {
+					forbidden(rw)
+					return
+				}
 				if errors.Is(err, storemanagement.ErrEmployeeNotFound) {
 					e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
 					rw.WriteHeader(http.StatusUnprocessableEntity)
@@ -906,8 +1690,12 @@ func employeeAction(sManager storemanagement.Service, auth authetication.Service
 		}
 		if payload.Action == "resume" {
 			fmt.Printf("resuming")
-			err := sManager.ResumeEmployee(r.Context(), userid, payload.EmpID)
+			err := sManager.ResumeEmployee(r.Context(), actor, payload.EmpID)
 			if err != nil {
+				if errors.Is(err, storemanagement.ErrPermissionDenied) {
+					forbidden(rw)
+					return
+				}
 				if errors.Is(err, storemanagement.ErrEmployeeNotFound) {
 					e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
 					rw.WriteHeader(http.StatusUnprocessableEntity)
@@ -946,49 +1734,36 @@ func employeeAction(sManager storemanagement.Service, auth authetication.Service
 }
 
 // perform certain actions on coupons management
-func couponAction(sManager storemanagement.Service, auth authetication.Service) http.HandlerFunc {
+func couponAction(sManager storemanagement.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 
 		rw.Header().Set("Content-Type", "application/json")
-		authBearer := r.Header.Get("authorization")
-		if authBearer == "" {
-			e := constructError(http.StatusUnauthorized, "token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-
-		}
-		token := strings.Split(authBearer, " ")
-		if len(token) != 2 {
-			e := constructError(http.StatusUnauthorized, "token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		userid, valid := auth.VerifyToken(token[1])
-		if !valid {
-			e := constructError(http.StatusUnauthorized, "token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
+		actor := storeActor(principalFrom(r.Context()), r)
 
 		type CouponPayload struct {
-			CouponID      string   `json:"coupon_id,omitempty"`
-			AmountOff     float64  `json:"amount_off,omitempty"`
-			PercentageOff float64  `json:"percentage_off,omitempty"`
-			Desc          string   `json:"desc,omitempty"`
-			Categories    []string `json:"Categories"`
-			CurrencyCode  string   `json:"currency_code,omitempty"`
-			DiscountType  string   `json:"discount_type,omitempty"`
-			ExpiringDate  uint     `json:"expiring_date"`
-			//	SingleUserUse       bool     `json:"single_user_use,omitempty"`
-			UnlimitedRedemption bool   `json:"unlimited_redemption,omitempty"`
-			MaxRedemption       uint   `json:"max_redemption,omitempty"`
-			Action              string `json:"action,omitempty"`
-			IsTextCoupon        bool   `json:"is_text_coupon,omitempty"`
-			TextCouponWebURL    string `json:"text_coupon_web_url,omitempty"`
-			TextCouponCode      string `json:"text_coupon_code,omitempty"`
+			CouponID            string   `json:"coupon_id,omitempty"`
+			AmountOff           float64  `json:"amount_off,omitempty"`
+			PercentageOff       float64  `json:"percentage_off,omitempty"`
+			Desc                string   `json:"desc,omitempty"`
+			Categories          []string `json:"Categories"`
+			CurrencyCode        string   `json:"currency_code,omitempty"`
+			DiscountType        string   `json:"discount_type,omitempty"`
+			ExpiringDate        uint     `json:"expiring_date"`
+			SingleUserUse       bool     `json:"single_user_use,omitempty"`
+			UnlimitedRedemption bool     `json:"unlimited_redemption,omitempty"`
+			MaxRedemption       uint     `json:"max_redemption,omitempty"`
+			Action              string   `json:"action,omitempty"`
+			IsTextCoupon        bool     `json:"is_text_coupon,omitempty"`
+			TextCouponWebURL    string   `json:"text_coupon_web_url,omitempty"`
+			TextCouponCode      string   `json:"text_coupon_code,omitempty"`
+			State               string   `json:"state,omitempty"`
+			//Branches limits the coupon to some branches of the store, empty for every branch
+			Branches []string `json:"branches,omitempty"`
+			//StartDate and Windows limit when the coupon can be redeemed, in the timezone of the store
+			StartDate uint                             `json:"start_date,omitempty"`
+			Windows   []storemanagement.ValidityWindow `json:"windows,omitempty"`
+			//UserLimit is how many times a shopper can redeem the coupon, ever or per day, week or month
+			UserLimit storemanagement.UserLimit `json:"user_limit"`
 		}
 
 		payload := &CouponPayload{}
@@ -1002,23 +1777,41 @@ func couponAction(sManager storemanagement.Service, auth authetication.Service)
 		}
 		if payload.Action == "create" {
 			coupon := storemanagement.CreateCoupon{
-				StoreID: userid,
+				StoreID: actor.StoreID,
 
-				Desc:         payload.Desc,
-				Categories:   payload.Categories,
-				CurrencyCode: payload.CurrencyCode,
-				ExpiringDate: payload.ExpiringDate,
-				//	SingleUserUse:       payload.SingleUserUse,
+				Desc:                payload.Desc,
+				Categories:          payload.Categories,
+				CurrencyCode:        payload.CurrencyCode,
+				ExpiringDate:        payload.ExpiringDate,
+				SingleUserUse:       payload.SingleUserUse,
 				UnlimitedRedemption: payload.UnlimitedRedemption,
 				MaxRedemption:       payload.MaxRedemption,
 				IsTextCoupon:        payload.IsTextCoupon,
 				TextCouponCode:      payload.TextCouponCode,
 				TextCouponWebURL:    payload.TextCouponWebURL,
 				DiscountType:        payload.DiscountType,
+				State:               payload.State,
+				Branches:            payload.Branches,
+				StartDate:           payload.StartDate,
+				Windows:             payload.Windows,
+				UserLimit:           payload.UserLimit,
 			}
-			couponid, err := sManager.CreateCoupon(r.Context(), userid, coupon)
+			couponid, err := sManager.CreateCoupon(r.Context(), actor, coupon)
 
 			if err != nil {
+				if errors.Is(err, storemanagement.ErrPermissionDenied) {
+					forbidden(rw)
+					return
+				}
+				if errors.Is(err, storemanagement.ErrBranchNotFound) {
+					writeBranchError(rw, err)
+					return
+				}
+				if errors.Is(err, storemanagement.ErrInvalidCouponState) || errors.Is(err, storemanagement.ErrInvalidSchedule) ||
+					errors.Is(err, storemanagement.ErrInvalidUserLimit) {
+					writeCouponEditError(rw, err)
+					return
+				}
 				fmt.Println(err)
 				e := constructError(http.StatusInternalServerError,
 					"unable to process request",
@@ -1040,6 +1833,58 @@ func couponAction(sManager storemanagement.Service, auth authetication.Service)
 			})
 			return
 		}
+		if payload.Action == "state" {
+			if payload.CouponID == "" || payload.State == "" {
+				e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+
+			err := sManager.TransitionCoupon(r.Context(), actor, payload.CouponID, payload.State)
+			if err != nil {
+				writeCouponEditError(rw, err)
+				return
+			}
+			type ActionResponse struct {
+				Type     string `json:"type"`
+				CouponID string `json:"coupon_id"`
+				State    string `json:"state"`
+			}
+			rw.WriteHeader(http.StatusOK)
+			json.NewEncoder(rw).Encode(ActionResponse{
+				Type:     "coupon",
+				CouponID: payload.CouponID,
+				State:    payload.State,
+			})
+			return
+		}
+		if payload.Action == "branches" {
+			if payload.CouponID == "" {
+				e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+
+			err := sManager.SetCouponBranches(r.Context(), actor, payload.CouponID, payload.Branches)
+			if err != nil {
+				writeBranchError(rw, err)
+				return
+			}
+			type ActionResponse struct {
+				Type     string   `json:"type"`
+				CouponID string   `json:"coupon_id"`
+				Branches []string `json:"branches"`
+			}
+			rw.WriteHeader(http.StatusOK)
+			json.NewEncoder(rw).Encode(ActionResponse{
+				Type:     "coupon",
+				CouponID: payload.CouponID,
+				Branches: payload.Branches,
+			})
+			return
+		}
 		if payload.Action == "delete" {
 			fmt.Println("here now")
 			if payload.CouponID == "" {
@@ -1049,7 +1894,11 @@ func couponAction(sManager storemanagement.Service, auth authetication.Service)
 				return
 			}
 
-			sManager.DeleteCoupon(r.Context(), userid, payload.CouponID)
+			err := sManager.DeleteCoupon(r.Context(), actor, payload.CouponID)
+			if err != nil {
+				writeCouponEditError(rw, err)
+				return
+			}
 			type ActionResponse struct {
 				Type     string `json:"type"`
 				CouponID string `json:"coupon_id"`
@@ -1074,38 +1923,121 @@ func couponAction(sManager storemanagement.Service, auth authetication.Service)
 	}
 }
 
-// perform certain actions on sstore
-func storeAction(sManager storemanagement.Service, auth authetication.Service) http.HandlerFunc {
+// edit the fields of a coupon that are sent, the others are left unchanged
+func updateCoupon(sManager storemanagement.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 
 		rw.Header().Set("Content-Type", "application/json")
-		authBearer := r.Header.Get("authorization")
-		if authBearer == "" {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		update := storemanagement.CouponUpdate{}
+		err := json.NewDecoder(r.Body).Decode(&update)
+		if err != nil {
+			e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
+			rw.WriteHeader(http.StatusUnprocessableEntity)
 			json.NewEncoder(rw).Encode(e)
 			return
 		}
-		token := strings.Split(authBearer, " ")
-		if len(token) != 2 {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
+
+		coupon, err := sManager.UpdateCoupon(r.Context(), actor, chi.URLParam(r, "id"), update)
+		if err != nil {
+			writeCouponEditError(rw, err)
 			return
 		}
-		userid, valid := auth.VerifyToken(token[1])
-		if !valid {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(coupon)
+	}
+}
+
+// fetch the edit history of a coupon
+func getCouponHistory(sManager storemanagement.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+
+		rw.Header().Set("Content-Type", "application/json")
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		edits, err := sManager.CouponHistory(r.Context(), actor, chi.URLParam(r, "id"))
+		if err != nil {
+			writeCouponEditError(rw, err)
 			return
 		}
+		type Response struct {
+			Edits []storemanagement.CouponEdit `json:"edits"`
+		}
+		json.NewEncoder(rw).Encode(Response{Edits: edits})
+	}
+}
+
+//writes the error of a coupon edit or state change
+func writeCouponEditError(rw http.ResponseWriter, err error) {
+	if errors.Is(err, storemanagement.ErrPermissionDenied) {
+		forbidden(rw)
+		return
+	}
+	var e ResponseError
+	switch {
+	case errors.Is(err, storemanagement.ErrCouponNotValid):
+		e = constructError(http.StatusNotFound,
+			"couponid is not valid",
+			"the couponid is not associated with any coupon of the store")
+	case errors.Is(err, storemanagement.ErrInvalidCouponState):
+		e = constructError(http.StatusUnprocessableEntity,
+			"invalid state",
+			"the state must be one of draft, scheduled, active, paused, expired or deleted")
+	case errors.Is(err, storemanagement.ErrInvalidSchedule):
+		e = constructError(http.StatusUnprocessableEntity,
+			"invalid schedule",
+			"the start date must come before the expiring date and every window needs days and a start before its end, as HH:MM")
+	case errors.Is(err, storemanagement.ErrInvalidUserLimit):
+		e = constructError(http.StatusUnprocessableEntity,
+			"invalid user limit",
+			"the period of the limit per shopper must be day, week, month or left out for ever")
+	case errors.Is(err, storemanagement.ErrInvalidCouponEdit):
+		e = constructError(http.StatusUnprocessableEntity,
+			"invalid coupon edit",
+			"the description can not be empty, the expiring date must be ahead and the redemption limit above the redemptions made")
+	case errors.Is(err, storemanagement.ErrInvalidTransition):
+		e = constructError(http.StatusConflict,
+			"invalid state change",
+			"the coupon can not move from its current state to this one")
+	case errors.Is(err, storemanagement.ErrCouponNotEditable):
+		e = constructError(http.StatusConflict,
+			"coupon can no longer be edited",
+			"expired, exhausted and deleted coupons can not be edited")
+	case errors.Is(err, storemanagement.ErrCouponTermsLocked):
+		e = constructError(http.StatusConflict,
+			"coupon terms are locked",
+			"the discount of a coupon can not change once it has been redeemed")
+	case errors.Is(err, storemanagement.ErrCouponEditConflict):
+		e = constructError(http.StatusConflict,
+			"coupon changed",
+			"the coupon changed while being edited, fetch it and retry")
+	default:
+		e = constructError(http.StatusInternalServerError,
+			"unable to process request",
+			"an error occured while processing your request")
+	}
+	rw.WriteHeader(e.Code)
+	json.NewEncoder(rw).Encode(e)
+}
+
+// perform certain actions on sstore
+func storeAction(sManager storemanagement.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+
+		rw.Header().Set("Content-Type", "application/json")
+		actor := storeActor(principalFrom(r.Context()), r)
 
 		type StorePayload struct {
-			Name    string `json:"name,omitempty"`
-			Tagline string `json:"tagline,omitempty"`
-			Address string `json:"address,omitempty"`
-			Action  string `json:"action,omitempty"`
+			Name      string   `json:"name,omitempty"`
+			Tagline   string   `json:"tagline,omitempty"`
+			Address   string   `json:"address,omitempty"`
+			Latitude  *float64 `json:"latitude,omitempty"`
+			Longitude *float64 `json:"longitude,omitempty"`
+			Timezone  string   `json:"timezone,omitempty"`
+			//VoidWindow is in minutes
+			VoidWindow *uint  `json:"void_window,omitempty"`
+			Action     string `json:"action,omitempty"`
 		}
 
 		if r.Body == nil {
@@ -1126,13 +2058,71 @@ func storeAction(sManager storemanagement.Service, auth authetication.Service) h
 		}
 
 		if payload.Action == "edit" {
-			err := sManager.EditStore(r.Context(), userid, storemanagement.StoreEdit{
-				Name:    payload.Name,
-				Tagline: payload.Tagline,
-				Address: payload.Address,
-			})
+			edit := storemanagement.StoreEdit{
+				Name:     payload.Name,
+				Tagline:  payload.Tagline,
+				Address:  payload.Address,
+				Timezone: payload.Timezone,
+			}
+			if (payload.Latitude == nil) != (payload.Longitude == nil) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"location",
+					"incomplete location",
+					"Both the latitude and the longitude are needed")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if payload.Latitude != nil {
+				edit.Location = &storemanagement.Location{Latitude: *payload.Latitude, Longitude: *payload.Longitude}
+			}
+			if payload.VoidWindow != nil {
+				window := time.Duration(*payload.VoidWindow) * time.Minute
+				edit.VoidWindow = &window
+			}
+			err := sManager.EditStore(r.Context(), actor, edit)
 
 			if err != nil {
+				if errors.Is(err, storemanagement.ErrPermissionDenied) {
+					forbidden(rw)
+					return
+				}
+				if errors.Is(err, storemanagement.ErrInvalidTimezone) {
+					e := constructErrorWithField(http.StatusUnprocessableEntity,
+						"timezone",
+						"invalid timezone",
+						"The timezone must be a name of the tz database such as Africa/Lagos")
+					rw.WriteHeader(http.StatusUnprocessableEntity)
+					json.NewEncoder(rw).Encode(e)
+					return
+				}
+				if errors.Is(err, storemanagement.ErrInvalidLocation) {
+					e := constructErrorWithField(http.StatusUnprocessableEntity,
+						"location",
+						"invalid location",
+						"The latitude must be within -90 and 90 and the longitude within -180 and 180")
+					rw.WriteHeader(http.StatusUnprocessableEntity)
+					json.NewEncoder(rw).Encode(e)
+					return
+				}
+				if errors.Is(err, storemanagement.ErrInvalidVoidWindow) {
+					e := constructErrorWithField(http.StatusUnprocessableEntity,
+						"void_window",
+						"invalid void window",
+						"The void window is in minutes and can not be longer than 7 days")
+					rw.WriteHeader(http.StatusUnprocessableEntity)
+					json.NewEncoder(rw).Encode(e)
+					return
+				}
+				if errors.Is(err, storemanagement.ErrAddressNotFound) {
+					e := constructErrorWithField(http.StatusUnprocessableEntity,
+						"address",
+						"address not found",
+						"The address could not be located, send the latitude and longitude of the store")
+					rw.WriteHeader(http.StatusUnprocessableEntity)
+					json.NewEncoder(rw).Encode(e)
+					return
+				}
 				e := constructError(http.StatusInternalServerError,
 					"unable to process request",
 					"an error occured while processing your request")
@@ -1145,162 +2135,397 @@ func storeAction(sManager storemanagement.Service, auth authetication.Service) h
 	}
 }
 
-/*
-// perform certain actions on substore management
-func subStoreAction(sManager storemanagement.Service, auth authetication.Service) http.HandlerFunc {
+// perform certain actions on the branches of the store
+func branchAction(sManager storemanagement.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 
 		rw.Header().Set("Content-Type", "application/json")
-		authBearer := r.Header.Get("authorization")
-		if authBearer == "" {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		type BranchPayload struct {
+			BranchID  string   `json:"branch_id,omitempty"`
+			Name      string   `json:"name,omitempty"`
+			Address   string   `json:"address,omitempty"`
+			Latitude  *float64 `json:"latitude,omitempty"`
+			Longitude *float64 `json:"longitude,omitempty"`
+			Action    string   `json:"action,omitempty"`
+		}
+
+		if r.Body == nil {
+			e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
+			rw.WriteHeader(http.StatusUnprocessableEntity)
 			json.NewEncoder(rw).Encode(e)
 			return
 		}
-		token := strings.Split(authBearer, " ")
-		if len(token) != 2 {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
+
+		payload := &BranchPayload{}
+
+		err := json.NewDecoder(r.Body).Decode(payload)
+		if err != nil {
+			e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
+			rw.WriteHeader(http.StatusUnprocessableEntity)
 			json.NewEncoder(rw).Encode(e)
 			return
 		}
-		userid, valid := auth.VerifyToken(token[1])
-		if !valid {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
+
+		if (payload.Action == "edit" || payload.Action == "remove") && payload.BranchID == "" {
+			e := constructErrorWithField(http.StatusUnprocessableEntity,
+				"branch_id",
+				"no valid body found",
+				"retry the request by sending the id of the branch")
+			rw.WriteHeader(http.StatusUnprocessableEntity)
 			json.NewEncoder(rw).Encode(e)
 			return
 		}
 
-		type SubStorePayload struct {
-			SubStoreID    string `json:"sub_store_id,omitempty"`
-			SubStoreEmail string `json:"sub_store_email,omitempty"`
-			Action        string `json:"action,omitempty"`
+		edit := storemanagement.BranchEdit{
+			Name:    payload.Name,
+			Address: payload.Address,
 		}
-
-		if r.Body == nil {
-			e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
+		if (payload.Latitude == nil) != (payload.Longitude == nil) {
+			e := constructErrorWithField(http.StatusUnprocessableEntity,
+				"location",
+				"incomplete location",
+				"Both the latitude and the longitude are needed")
 			rw.WriteHeader(http.StatusUnprocessableEntity)
 			json.NewEncoder(rw).Encode(e)
 			return
 		}
+		if payload.Latitude != nil {
+			edit.Location = &storemanagement.Location{Latitude: *payload.Latitude, Longitude: *payload.Longitude}
+		}
+
+		if payload.Action == "add" {
+			branch, err := sManager.AddBranch(r.Context(), actor, edit)
+			if err != nil {
+				writeBranchError(rw, err)
+				return
+			}
+			rw.WriteHeader(http.StatusCreated)
+			json.NewEncoder(rw).Encode(branch)
+			return
+		}
+
+		if payload.Action == "edit" {
+			err := sManager.EditBranch(r.Context(), actor, payload.BranchID, edit)
+			if err != nil {
+				writeBranchError(rw, err)
+				return
+			}
+			type ActionResponse struct {
+				Type     string `json:"type"`
+				BranchID string `json:"branch_id"`
+				State    bool   `json:"edited"`
+			}
+			rw.WriteHeader(http.StatusOK)
+			json.NewEncoder(rw).Encode(ActionResponse{
+				Type:     "branch",
+				BranchID: payload.BranchID, State: true,
+			})
+			return
+		}
+
+		if payload.Action == "remove" {
+			err := sManager.RemoveBranch(r.Context(), actor, payload.BranchID)
+			if err != nil {
+				writeBranchError(rw, err)
+				return
+			}
+			type ActionResponse struct {
+				Type     string `json:"type"`
+				BranchID string `json:"branch_id"`
+				State    bool   `json:"removed"`
+			}
+			rw.WriteHeader(http.StatusOK)
+			json.NewEncoder(rw).Encode(ActionResponse{
+				Type:     "branch",
+				BranchID: payload.BranchID, State: true,
+			})
+			return
+
+		}
+
+		// if action is not found
+		e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(rw).Encode(e)
+		return
+
+	}
+}
+
+// fetch the branches of the store
+func getBranches(sManager storemanagement.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+
+		rw.Header().Set("Content-Type", "application/json")
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		branches, err := sManager.Branches(r.Context(), actor)
+		if err != nil {
+			writeBranchError(rw, err)
+			return
+		}
+		type Response struct {
+			Branches []storemanagement.Branch `json:"branches"`
+		}
+		json.NewEncoder(rw).Encode(Response{Branches: branches})
+
+	}
+}
+
+// fetch the number of coupons redeemed at each branch of the store, over the last days when given
+func getBranchesRedeemedCount(sManager storemanagement.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+
+		rw.Header().Set("Content-Type", "application/json")
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		var since time.Time
+		if v := r.FormValue("days"); v != "" {
+			days, err := strconv.Atoi(v)
+			if err != nil || days <= 0 {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"days",
+					"invalid days",
+					"days must be a positive number")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			since = time.Now().AddDate(0, 0, -days).UTC()
+		}
+
+		counts, err := sManager.BranchRedemptionCounts(r.Context(), actor, since)
+		if err != nil {
+			writeBranchError(rw, err)
+			return
+		}
+		type Response struct {
+			Counts []storemanagement.BranchRedemptionCount `json:"counts"`
+		}
+		json.NewEncoder(rw).Encode(Response{Counts: counts})
+
+	}
+}
+
+//writes the error of a branch operation
+func writeBranchError(rw http.ResponseWriter, err error) {
+	if errors.Is(err, storemanagement.ErrPermissionDenied) {
+		forbidden(rw)
+		return
+	}
+	field, message, desc := "", "", ""
+	switch {
+	case errors.Is(err, storemanagement.ErrInvalidBranch):
+		field, message, desc = "name", "invalid branch", "The name of the branch is needed"
+	case errors.Is(err, storemanagement.ErrBranchNotFound):
+		field, message, desc = "branch_id", "no branch found", "no open branch of the store is associated with this id"
+	case errors.Is(err, storemanagement.ErrInvalidLocation):
+		field, message, desc = "location", "invalid location", "The latitude must be within -90 and 90 and the longitude within -180 and 180"
+	case errors.Is(err, storemanagement.ErrAddressNotFound):
+		field, message, desc = "address", "address not found", "The address could not be located, send the latitude and longitude of the branch"
+	case errors.Is(err, storemanagement.ErrEmployeeNotFound):
+		field, message, desc = "emp_id", "no employee found", "no employee of the store is associated with this id"
+	case errors.Is(err, storemanagement.ErrCouponNotValid):
+		field, message, desc = "coupon_id", "couponid is not valid", "the couponid is not associated with any coupon of the store"
+	default:
+		e := constructError(http.StatusInternalServerError,
+			"unable to process request",
+			"an error occured while processing your request")
+		rw.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(rw).Encode(e)
+		return
+	}
+	e := constructErrorWithField(http.StatusUnprocessableEntity, field, message, desc)
+	rw.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(rw).Encode(e)
+}
+
+// fetch employees associated with store
+func getEmployees(sManager storemanagement.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+
+		rw.Header().Set("Content-Type", "application/json")
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		employees, err := sManager.Employees(r.Context(), actor)
+		fmt.Printf(" \n\n\n")
+		fmt.Println(employees)
+		if err != nil {
+			if errors.Is(err, storemanagement.ErrPermissionDenied) {
+				forbidden(rw)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"an error occured while processing your request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(employees)
 
-		payload := &SubStorePayload{}
+	}
+}
 
-		err := json.NewDecoder(r.Body).Decode(payload)
+//lists the invites of the store that have not been accepted yet
+func getEmployeeInvites(sManager storemanagement.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		invites, err := sManager.Invites(r.Context(), actor)
 		if err != nil {
-			e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
-			rw.WriteHeader(http.StatusUnprocessableEntity)
+			if errors.Is(err, storemanagement.ErrPermissionDenied) {
+				forbidden(rw)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"an error occured while processing your request")
+			rw.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(rw).Encode(e)
 			return
 		}
+		type InvitesResponse struct {
+			Invites []storemanagement.Invite `json:"invites"`
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(InvitesResponse{Invites: invites})
+	}
+}
 
-		if payload.Action == "add" {
-			if payload.SubStoreEmail == "" {
-				e := constructError(http.StatusUnprocessableEntity, "no valid body found", "retry the request by sending a valid body")
-				rw.WriteHeader(http.StatusUnprocessableEntity)
-				json.NewEncoder(rw).Encode(e)
+//revokes a pending invite of the store
+func revokeEmployeeInvite(sManager storemanagement.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		err := sManager.RevokeInvite(r.Context(), actor, chi.URLParam(r, "id"))
+		if err != nil {
+			if errors.Is(err, storemanagement.ErrPermissionDenied) {
+				forbidden(rw)
 				return
 			}
-			err := sManager.AddSubStore(userid, payload.SubStoreEmail)
-			if err != nil {
-				e := constructError(http.StatusInternalServerError,
-					"unable to process request",
-					"an error occured while processing your request")
-				rw.WriteHeader(http.StatusInternalServerError)
+			if errors.Is(err, storemanagement.ErrInvalidInvite) {
+				e := constructError(http.StatusNotFound,
+					"invite not found",
+					"No pending invite of the store is associated with the id")
+				rw.WriteHeader(http.StatusNotFound)
 				json.NewEncoder(rw).Encode(e)
 				return
 			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"an error occured while processing your request")
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
 
-			type ActionResponse struct {
-				Type  string `json:"sub_store"`
-				Email string `json:"email"`
-				State bool   `json:"adding"`
-			}
-			rw.WriteHeader(http.StatusAccepted)
-			json.NewEncoder(rw).Encode(ActionResponse{
-				Type:  "sub_store",
-				Email: payload.SubStoreEmail,
-				State: true})
+//accepts an employee invite. A logged in user is linked to the store, otherwise an
+//account is created for the invited email with the password sent
+func acceptEmployeeInvite(sManager storemanagement.Service, auth authetication.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		token := r.PostFormValue("token")
+		invite, err := sManager.InviteWithToken(r.Context(), token)
+		if err != nil {
+			writeInviteError(rw, err)
 			return
 		}
 
-		if payload.Action == "remove" {
-			if payload.SubStoreID == "" {
-				e := constructError(http.StatusUnprocessableEntity, "no valid body found", "retry the request by sending a valid body")
-				rw.WriteHeader(http.StatusUnprocessableEntity)
-				json.NewEncoder(rw).Encode(e)
+		var userID string
+		if bearer, ok := bearerToken(r); ok {
+			principal, valid := auth.VerifyPrincipal(bearer)
+			if !valid {
+				unauthorized(rw)
 				return
 			}
-			err := sManager.RemoveSubStore(userid, payload.SubStoreID)
+			userID = principal.UserID
+			err = sManager.AcceptInvite(r.Context(), token, userID)
+		} else {
+			password := r.PostFormValue("password")
+			err = auth.CheckPassword(invite.Email, password)
 			if err != nil {
-				if errors.Is(err, storemanagement.ErrStoreNotFound) {
-					e := constructError(http.StatusUnprocessableEntity, "no store found", "no sub store is associated with this id")
-					rw.WriteHeader(http.StatusUnprocessableEntity)
-					json.NewEncoder(rw).Encode(e)
+				if writePasswordPolicyError(rw, err) {
 					return
 				}
 				e := constructError(http.StatusInternalServerError,
 					"unable to process request",
-					"an error occured while processing your request")
+					"A problem occurs while processing the request")
 				rw.WriteHeader(http.StatusInternalServerError)
 				json.NewEncoder(rw).Encode(e)
 				return
 			}
-			type ActionResponse struct {
-				Type       string `json:"type"`
-				SubStoreID string `json:"sub_store_id"`
-				State      bool   `json:"removed"`
-			}
-			rw.WriteHeader(http.StatusOK)
-			json.NewEncoder(rw).Encode(ActionResponse{
-				Type:       "substore",
-				SubStoreID: payload.SubStoreID, State: true,
-			})
+			//the account is created together with the acceptance so a failed acceptance leaves none behind
+			userID, err = sManager.AcceptInviteAsNewUser(r.Context(), token, password)
+		}
+		if err != nil {
+			writeInviteError(rw, err)
 			return
-
 		}
+		type AcceptResponse struct {
+			StoreID string `json:"store_id"`
+			UserID  string `json:"user_id"`
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(AcceptResponse{StoreID: invite.StoreID, UserID: userID})
+	}
+}
 
-		// if action is not found
-		e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
+//writes the response of a failed invite acceptance
+func writeInviteError(rw http.ResponseWriter, err error) {
+	if errors.Is(err, storemanagement.ErrInvalidInvite) {
+		e := constructErrorWithField(http.StatusUnprocessableEntity,
+			"token",
+			"invalid invite",
+			"The invite is invalid, expired, revoked or has already been accepted")
 		rw.WriteHeader(http.StatusUnprocessableEntity)
 		json.NewEncoder(rw).Encode(e)
 		return
-
 	}
-} */
+	if errors.Is(err, storemanagement.ErrInviteEmailMismatch) {
+		e := constructError(http.StatusForbidden,
+			"invite email mismatch",
+			"The invite was sent to another email address than the one of the logged in user")
+		rw.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(rw).Encode(e)
+		return
+	}
+	if errors.Is(err, storemanagement.ErrInviteeRegistered) {
+		e := constructError(http.StatusConflict,
+			"identity already exists",
+			"An account is already associated with the invited email, log in to accept the invite")
+		rw.WriteHeader(http.StatusConflict)
+		json.NewEncoder(rw).Encode(e)
+		return
+	}
+	e := constructError(http.StatusInternalServerError,
+		"unable to process request",
+		"A problem occurs while processing the request")
+	rw.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(rw).Encode(e)
+}
 
-//v2
-/* // fetch substores associated with store
-func getSubStores(sManager storemanagement.Service, auth authetication.Service) http.HandlerFunc {
+//lists the API keys of the store
+func getAPIKeys(sManager storemanagement.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-
 		rw.Header().Set("Content-Type", "application/json")
-		authBearer := r.Header.Get("authorization")
-		if authBearer == "" {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		token := strings.Split(authBearer, " ")
-		if len(token) != 2 {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		storeid, valid := auth.VerifyToken(token[1])
-		if !valid {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
+		actor := storeActor(principalFrom(r.Context()), r)
 
-		stores, err := sManager.SubStores(storeid)
+		keys, err := sManager.APIKeys(r.Context(), actor)
 		if err != nil {
+			if errors.Is(err, storemanagement.ErrPermissionDenied) {
+				forbidden(rw)
+				return
+			}
 			e := constructError(http.StatusInternalServerError,
 				"unable to process request",
 				"an error occured while processing your request")
@@ -1308,42 +2533,84 @@ func getSubStores(sManager storemanagement.Service, auth authetication.Service)
 			json.NewEncoder(rw).Encode(e)
 			return
 		}
-		json.NewEncoder(rw).Encode(stores)
-
+		type APIKeysResponse struct {
+			Keys []storemanagement.APIKey `json:"keys"`
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(APIKeysResponse{Keys: keys})
 	}
 }
-*/
-// fetch employees associated with store
-func getEmployees(sManager storemanagement.Service, auth authetication.Service) http.HandlerFunc {
-	return func(rw http.ResponseWriter, r *http.Request) {
 
+//creates an API key for the store, the key is only shown in this response
+func createAPIKey(sManager storemanagement.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("Content-Type", "application/json")
-		authBearer := r.Header.Get("authorization")
-		if authBearer == "" {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		type APIKeyPayload struct {
+			Name   string                       `json:"name"`
+			Scopes []storemanagement.Permission `json:"scopes"`
 		}
-		token := strings.Split(authBearer, " ")
-		if len(token) != 2 {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
+		payload := &APIKeyPayload{}
+		err := json.NewDecoder(r.Body).Decode(payload)
+		if err != nil {
+			e := constructError(http.StatusUnprocessableEntity, "no valid body found", "retry the request by sending a body")
+			rw.WriteHeader(http.StatusUnprocessableEntity)
 			json.NewEncoder(rw).Encode(e)
 			return
 		}
-		storeid, valid := auth.VerifyToken(token[1])
-		if !valid {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
+
+		apiKey, key, err := sManager.CreateAPIKey(r.Context(), actor, payload.Name, payload.Scopes)
+		if err != nil {
+			if errors.Is(err, storemanagement.ErrPermissionDenied) {
+				forbidden(rw)
+				return
+			}
+			if errors.Is(err, storemanagement.ErrInvalidScope) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"scopes",
+					"invalid scopes",
+					"API keys can only be granted the coupon:read and coupon:verify scopes")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			e := constructError(http.StatusInternalServerError,
+				"unable to process request",
+				"an error occured while processing your request")
+			rw.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(rw).Encode(e)
 			return
 		}
+		type CreateAPIKeyResponse struct {
+			*storemanagement.APIKey
+			Key string `json:"key"`
+		}
+		rw.WriteHeader(http.StatusCreated)
+		json.NewEncoder(rw).Encode(CreateAPIKeyResponse{APIKey: apiKey, Key: key})
+	}
+}
 
-		employees, err := sManager.Employees(r.Context(), storeid)
-		fmt.Printf(" \n\n\n")
-		fmt.Println(employees)
+//revokes an API key of the store
+func revokeAPIKey(sManager storemanagement.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		err := sManager.RevokeAPIKey(r.Context(), actor, chi.URLParam(r, "id"))
 		if err != nil {
+			if errors.Is(err, storemanagement.ErrPermissionDenied) {
+				forbidden(rw)
+				return
+			}
+			if errors.Is(err, storemanagement.ErrAPIKeyNotFound) {
+				e := constructError(http.StatusNotFound,
+					"api key not found",
+					"No active API key of the store is associated with the id")
+				rw.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
 			e := constructError(http.StatusInternalServerError,
 				"unable to process request",
 				"an error occured while processing your request")
@@ -1351,10 +2618,44 @@ func getEmployees(sManager storemanagement.Service, auth authetication.Service)
 			json.NewEncoder(rw).Encode(e)
 			return
 		}
-		rw.WriteHeader(http.StatusOK)
-		json.NewEncoder(rw).Encode(employees)
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+//storeRoles maps the roles of a principal to the roles of a store management actor
+var storeRoles = map[string]string{
+	authetication.RoleShopper:       storemanagement.RoleShopper,
+	authetication.RoleOwner:         storemanagement.RoleOwner,
+	authetication.RoleEmployee:      storemanagement.RoleEmployee,
+	authetication.RolePlatformAdmin: storemanagement.RolePlatformAdmin,
+	authetication.RoleAPIKey:        storemanagement.RoleAPIKey,
+}
 
+//storeActor returns the actor for store management operations of the principal.
+//Platform admins may act for any store by naming it with the store_id parameter
+func storeActor(principal *authetication.Principal, r *http.Request) storemanagement.Actor {
+	actor := storemanagement.Actor{
+		UserID:  principal.UserID,
+		StoreID: principal.StoreID,
+	}
+	for _, role := range principal.Roles {
+		if storeRole, ok := storeRoles[role]; ok {
+			actor.Roles = append(actor.Roles, storeRole)
+		}
+	}
+	if principal.Scopes != nil {
+		actor.Scopes = make([]storemanagement.Permission, len(principal.Scopes))
+		for i, scope := range principal.Scopes {
+			actor.Scopes[i] = storemanagement.Permission(scope)
+		}
+	}
+	if principal.HasRole(authetication.RolePlatformAdmin) {
+		storeID := r.URL.Query().Get("store_id")
+		if storeID != "" {
+			actor.StoreID = storeID
+		}
 	}
+	return actor
 }
 
 func getQrCode(lister listing.Service) http.HandlerFunc {
@@ -1410,36 +2711,46 @@ func checkCouponState(sManager storemanagement.Service) http.HandlerFunc {
 }
 
 // verifyCoupon validate coupons
-func verifyCoupon(sManager storemanagement.Service, auth authetication.Service) http.HandlerFunc {
+func verifyCoupon(sManager storemanagement.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("Content-Type", "application/json")
 
 		couponid := chi.URLParam(r, "id")
 
-		authBearer := r.Header.Get("authorization")
-		if authBearer == "" {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		token := strings.Split(authBearer, " ")
-		if len(token) != 2 {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
-		userid, valid := auth.VerifyToken(token[1])
-		if !valid {
-			e := constructError(http.StatusUnauthorized, "valid token not found", "authetication is needed to make this action")
-			rw.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(rw).Encode(e)
-			return
-		}
+		actor := storeActor(principalFrom(r.Context()), r)
+		actor.BranchID = r.FormValue("branch_id")
 
-		err := sManager.VerifyCoupon(r.Context(), userid, couponid)
+		err := sManager.VerifyCoupon(r.Context(), actor, couponid, r.FormValue("shopper_id"), r.Header.Get("Idempotency-Key"))
 		if err != nil {
+			if errors.Is(err, storemanagement.ErrPermissionDenied) {
+				forbidden(rw)
+				return
+			}
+			if errors.Is(err, storemanagement.ErrInvalidIdempotencyKey) {
+				e := constructError(http.StatusUnprocessableEntity,
+					"invalid idempotency key",
+					"the Idempotency-Key header can not be longer than 255 characters")
+				rw.WriteHeader(e.Code)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if errors.Is(err, storemanagement.ErrIdempotencyKeyReused) {
+				e := constructError(http.StatusUnprocessableEntity,
+					"idempotency key already used",
+					"the Idempotency-Key was sent before for another redemption, send a new key")
+				rw.WriteHeader(e.Code)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if errors.Is(err, storemanagement.ErrRedemptionInProgress) {
+				e := constructError(http.StatusConflict,
+					"redemption in progress",
+					"the redemption with this Idempotency-Key is still being processed, retry shortly")
+				rw.Header().Set("Retry-After", "1")
+				rw.WriteHeader(e.Code)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
 			if errors.Is(err, storemanagement.ErrCouponIsUsed) {
 				e := constructError(http.StatusConflict,
 					"coupon is already used",
@@ -1464,6 +2775,65 @@ func verifyCoupon(sManager storemanagement.Service, auth authetication.Service)
 				json.NewEncoder(rw).Encode(e)
 				return
 			}
+			if errors.Is(err, storemanagement.ErrBranchRequired) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"branch_id",
+					"branch is required",
+					"the coupon is only valid at some branches, send the branch it is redeemed at")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if errors.Is(err, storemanagement.ErrBranchNotFound) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"branch_id",
+					"no branch found",
+					"no open branch of the store is associated with the branch id")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if errors.Is(err, storemanagement.ErrCouponNotAtBranch) {
+				e := constructError(http.StatusConflict,
+					"coupon is not valid at this branch",
+					"the coupon is only valid at other branches of the store")
+				rw.WriteHeader(e.Code)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if errors.Is(err, storemanagement.ErrShopperRequired) {
+				e := constructErrorWithField(http.StatusUnprocessableEntity,
+					"shopper_id",
+					"shopper is required",
+					"the coupon is limited per shopper, send the shopper it is redeemed for")
+				rw.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if errors.Is(err, storemanagement.ErrCouponUserLimitReached) {
+				e := constructError(http.StatusConflict,
+					"coupon limit reached for this shopper",
+					"the shopper already redeemed the coupon as many times as allowed")
+				rw.WriteHeader(e.Code)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if errors.Is(err, storemanagement.ErrCouponNotStarted) {
+				e := constructError(http.StatusConflict,
+					"coupon has not started",
+					"the coupon can only be redeemed from its start date")
+				rw.WriteHeader(e.Code)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
+			if errors.Is(err, storemanagement.ErrCouponOutsideWindow) {
+				e := constructError(http.StatusConflict,
+					"coupon is not valid at this time",
+					"the coupon can only be redeemed within its validity windows, in the timezone of the store")
+				rw.WriteHeader(e.Code)
+				json.NewEncoder(rw).Encode(e)
+				return
+			}
 
 			e := constructError(http.StatusInternalServerError,
 				"unable to process request",
@@ -1476,3 +2846,103 @@ func verifyCoupon(sManager storemanagement.Service, auth authetication.Service)
 
 	}
 }
+
+// voidRedemption undoes a redemption made by mistake, a reason is required
+func voidRedemption(sManager storemanagement.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		type VoidPayload struct {
+			Reason string `json:"reason,omitempty"`
+		}
+		payload := VoidPayload{}
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		if err != nil {
+			e := constructError(http.StatusUnprocessableEntity, "no body found", "retry the request by sending a body")
+			rw.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(rw).Encode(e)
+			return
+		}
+
+		void, err := sManager.VoidRedemption(r.Context(), actor, chi.URLParam(r, "id"), payload.Reason)
+		if err != nil {
+			writeRedemptionError(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(void)
+	}
+}
+
+// fetch the redemptions of a coupon that were not voided
+func getCouponRedemptions(sManager storemanagement.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+
+		rw.Header().Set("Content-Type", "application/json")
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		redemptions, err := sManager.CouponRedemptions(r.Context(), actor, chi.URLParam(r, "id"))
+		if err != nil {
+			writeRedemptionError(rw, err)
+			return
+		}
+		type Response struct {
+			Redemptions []storemanagement.Redemption `json:"redemptions"`
+		}
+		json.NewEncoder(rw).Encode(Response{Redemptions: redemptions})
+	}
+}
+
+// fetch the audit trail of the voided redemptions of the store
+func getRedemptionVoids(sManager storemanagement.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+
+		rw.Header().Set("Content-Type", "application/json")
+		actor := storeActor(principalFrom(r.Context()), r)
+
+		voids, err := sManager.RedemptionVoids(r.Context(), actor)
+		if err != nil {
+			writeRedemptionError(rw, err)
+			return
+		}
+		type Response struct {
+			Voids []storemanagement.RedemptionVoid `json:"voids"`
+		}
+		json.NewEncoder(rw).Encode(Response{Voids: voids})
+	}
+}
+
+//writes the error of a redemption lookup or void
+func writeRedemptionError(rw http.ResponseWriter, err error) {
+	if errors.Is(err, storemanagement.ErrPermissionDenied) {
+		forbidden(rw)
+		return
+	}
+	var e ResponseError
+	switch {
+	case errors.Is(err, storemanagement.ErrVoidReasonRequired):
+		e = constructError(http.StatusUnprocessableEntity,
+			"reason is required",
+			"send why the redemption is voided, in at most 500 characters")
+	case errors.Is(err, storemanagement.ErrRedemptionNotFound):
+		e = constructError(http.StatusNotFound,
+			"redemption not found",
+			"the id is not associated with any redemption of the store")
+	case errors.Is(err, storemanagement.ErrRedemptionAlreadyVoided):
+		e = constructError(http.StatusConflict,
+			"redemption already voided",
+			"the redemption was voided before")
+	case errors.Is(err, storemanagement.ErrVoidWindowPassed):
+		e = constructError(http.StatusForbidden,
+			"redemption can no longer be voided",
+			"the void window of the store has passed, only the store owner can void the redemption now")
+	default:
+		e = constructError(http.StatusInternalServerError,
+			"unable to process request",
+			"an error occured while processing your request")
+	}
+	rw.WriteHeader(e.Code)
+	json.NewEncoder(rw).Encode(e)
+}
@@ -0,0 +1,113 @@
+package rest
+
+import (
+	"context"
+	"couponcutter/authetication"
+	"couponcutter/storemanagement"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (s *stubAuth) Login(ctx context.Context, email string, password string, address string) (*authetication.TokenResponse, error) {
+	s.address = address
+	if s.loginErr != nil {
+		return nil, s.loginErr
+	}
+	return &authetication.TokenResponse{Token: "token"}, nil
+}
+
+func Test_login_TooManyAttempts(t *testing.T) {
+	auth := &stubAuth{loginErr: &authetication.AttemptsError{RetryAfter: time.Millisecond * 1500}}
+	form := url.Values{"email": {"user@gmail.com"}, "password": {"password"}}
+	r := httptest.NewRequest(http.MethodPost, "/user/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "10.0.0.1:53211"
+	rw := httptest.NewRecorder()
+
+	login(auth).ServeHTTP(rw, r)
+	if rw.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want %d", rw.Code, http.StatusTooManyRequests)
+	}
+	if got := rw.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want %q", got, "2")
+	}
+	if auth.address != "10.0.0.1" {
+		t.Errorf("address = %q, want %q", auth.address, "10.0.0.1")
+	}
+}
+
+func (s *stubAuth) DeleteAccount(ctx context.Context, userID string, password string) (time.Time, error) {
+	if password != "password" {
+		return time.Time{}, authetication.ErrIncorrectPassword
+	}
+	return time.Now(), nil
+}
+
+func Test_deleteAccount(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		want     int
+	}{
+		{name: "current password", password: "password", want: http.StatusAccepted},
+		{name: "wrong password", password: "wrong password", want: http.StatusForbidden},
+		{name: "no password", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"password": {tt.password}}
+			r := httptest.NewRequest(http.MethodDelete, "/user", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, &authetication.Principal{UserID: "u1"}))
+			rw := httptest.NewRecorder()
+
+			deleteAccount(&stubAuth{}).ServeHTTP(rw, r)
+			if rw.Code != tt.want {
+				t.Errorf("status = %d, want %d", rw.Code, tt.want)
+			}
+		})
+	}
+}
+
+func Test_storeActor(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal authetication.Principal
+		query     string
+		wantRoles []string
+		wantStore string
+	}{
+		{name: "owner", principal: authetication.Principal{UserID: "u1", StoreID: "s1",
+			Roles: []string{authetication.RoleShopper, authetication.RoleOwner}},
+			wantRoles: []string{storemanagement.RoleShopper, storemanagement.RoleOwner}, wantStore: "s1"},
+		{name: "employee", principal: authetication.Principal{UserID: "u2", StoreID: "s1",
+			Roles: []string{authetication.RoleShopper, authetication.RoleEmployee}},
+			wantRoles: []string{storemanagement.RoleShopper, storemanagement.RoleEmployee}, wantStore: "s1"},
+		{name: "api key", principal: authetication.Principal{UserID: "k1", StoreID: "s1", Roles: []string{authetication.RoleAPIKey}},
+			wantRoles: []string{storemanagement.RoleAPIKey}, wantStore: "s1"},
+		{name: "platform admin for a store", principal: authetication.Principal{UserID: "a1",
+			Roles: []string{authetication.RolePlatformAdmin}}, query: "?store_id=s2",
+			wantRoles: []string{storemanagement.RolePlatformAdmin}, wantStore: "s2"},
+		{name: "store of an owner is not overridden", principal: authetication.Principal{UserID: "u1", StoreID: "s1",
+			Roles: []string{authetication.RoleOwner}}, query: "?store_id=s2",
+			wantRoles: []string{storemanagement.RoleOwner}, wantStore: "s1"},
+		{name: "unknown role", principal: authetication.Principal{UserID: "u3", Roles: []string{"auditor"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/store"+tt.query, nil)
+			actor := storeActor(&tt.principal, r)
+			if !reflect.DeepEqual(actor.Roles, tt.wantRoles) {
+				t.Errorf("storeActor() roles = %v, want %v", actor.Roles, tt.wantRoles)
+			}
+			if actor.StoreID != tt.wantStore || actor.UserID != tt.principal.UserID {
+				t.Errorf("storeActor() = %+v, want store %q", actor, tt.wantStore)
+			}
+		})
+	}
+}
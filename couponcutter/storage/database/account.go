@@ -0,0 +1,287 @@
+package database
+
+import (
+	"context"
+	"couponcutter/authetication"
+	"couponcutter/storage"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//ScheduleAccountDeletion marks the user for erasure at the time and revokes all their sessions
+func (s *Database) ScheduleAccountDeletion(ctx context.Context, userID string, at time.Time) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `update users set deletion_scheduled_at = $1 where user_id = $2 and deleted_at is null`, at, userID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return authetication.ErrIdentityDoesNotExists
+	}
+	_, err = tx.Exec(ctx, `update user_sessions set revoked_at = now() where user_id = $1 and revoked_at is null`, userID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//CancelAccountDeletion restores a user scheduled for erasure
+func (s *Database) CancelAccountDeletion(ctx context.Context, userID string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `update users set deletion_scheduled_at = null
+	where user_id = $1 and deletion_scheduled_at is not null and deleted_at is null`, userID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return authetication.ErrNoDeletionScheduled
+	}
+	return nil
+}
+
+//PurgeDeletedAccounts erases the users whose deletion is due. The users row is kept
+//anonymised as stores, coupons and redemptions refer to it, every personal row is removed,
+//employments are detached from the user and a store owned by the user is closed
+func (s *Database) PurgeDeletedAccounts(ctx context.Context) (int64, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return 0, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `select user_id from users where deletion_scheduled_at <= now() and deleted_at is null`)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return 0, storage.ErrServerError
+	}
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		err = rows.Scan(&userID)
+		if err != nil {
+			rows.Close()
+			s.logger.Error(err.Error())
+			return 0, storage.ErrServerError
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		s.logger.Error(rows.Err().Error())
+		return 0, storage.ErrServerError
+	}
+
+	var purged int64
+	for _, userID := range userIDs {
+		err = s.purgeAccount(ctx, conn.Conn(), userID)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return purged, storage.ErrServerError
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+//purgeAccount erases a single user within a transaction
+func (s *Database) purgeAccount(ctx context.Context, conn *pgx.Conn, userID string) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	statements := []string{
+		`delete from saved_coupons where user_id = $1`,
+		`delete from stores_followed where user_id = $1`,
+		`delete from refresh_tokens where user_id = $1`,
+		`delete from user_sessions where user_id = $1`,
+		`delete from password_resets where user_id = $1`,
+		`delete from magic_links where user_id = $1`,
+		`delete from email_changes where user_id = $1`,
+		`delete from login_challenges where user_id = $1`,
+		`delete from totp_recovery_codes where user_id = $1`,
+		`delete from user_totp where user_id = $1`,
+		`delete from user_identities where user_id = $1`,
+		`update create_employees set accepted_by = null where accepted_by = $1`,
+		// redemptions stay with the store, they only lose the link to the person
+		`update stores_employees set user_id = null, emp_state = 'removed' where user_id = $1`,
+		`update redeemed_coupons set redeemed_for = null where redeemed_for = $1`,
+		`update redemption_voids set redeemed_for = null where redeemed_for = $1`,
+		`update stores set store_state = 'inactive' where store_id = $1`,
+		`update coupons set state = 'deleted' where store_id = $1 and state = 'active'`,
+		`update api_keys set revoked_at = now() where store_id = $1 and revoked_at is null`,
+		`update users set email = 'deleted-' || user_id || '@invalid', password_hash = '', platform_admin = false,
+		tokens_valid_after = now(), deleted_at = now() where user_id = $1`,
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(ctx, statement, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+//AccountExport collects the personal data held about the user
+func (s *Database) AccountExport(ctx context.Context, userID string) (*authetication.AccountExport, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	export := &authetication.AccountExport{
+		Identities:         []authetication.ExportIdentity{},
+		SavedCoupons:       []authetication.ExportCoupon{},
+		FollowedStores:     []authetication.ExportStore{},
+		Employments:        []authetication.ExportEmployment{},
+		Redemptions:        []authetication.ExportRedemption{},
+		ShopperRedemptions: []authetication.ExportRedemption{},
+	}
+	profile := &export.Profile
+	err = conn.QueryRow(ctx, `select users.user_id,users.email,users.created_at,users.deletion_scheduled_at,
+	coalesce(user_totp.enabled_at is not null, false) from users
+	left join user_totp on user_totp.user_id = users.user_id
+	where users.user_id = $1 and users.deleted_at is null`, userID).Scan(
+		&profile.UserID, &profile.Email, &profile.CreatedAt, &profile.DeletionScheduledAt, &profile.TwoFactorEnabled)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, authetication.ErrIdentityDoesNotExists
+		}
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+
+	err = s.collect(ctx, conn.Conn(), `select provider,subject,created_at from user_identities where user_id = $1`, userID,
+		func(row pgx.Rows) error {
+			var identity authetication.ExportIdentity
+			err := row.Scan(&identity.Provider, &identity.Subject, &identity.CreatedAt)
+			export.Identities = append(export.Identities, identity)
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+	err = s.collect(ctx, conn.Conn(), `select coupons.coupon_id::text,coupons.store_id,coupons."desc",coupons.expired_at from saved_coupons
+	inner join coupons on coupons.coupon_id::text = saved_coupons.coupon_id where saved_coupons.user_id = $1`, userID,
+		func(row pgx.Rows) error {
+			var coupon authetication.ExportCoupon
+			err := row.Scan(&coupon.CouponID, &coupon.StoreID, &coupon.Desc, &coupon.ExpiredAt)
+			export.SavedCoupons = append(export.SavedCoupons, coupon)
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+	err = s.collect(ctx, conn.Conn(), `select stores.store_id,stores.store_name from stores_followed
+	inner join stores on stores.store_id = stores_followed.store_id where stores_followed.user_id = $1`, userID,
+		func(row pgx.Rows) error {
+			var store authetication.ExportStore
+			err := row.Scan(&store.StoreID, &store.StoreName)
+			export.FollowedStores = append(export.FollowedStores, store)
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+	err = s.collect(ctx, conn.Conn(), `select store_id,store_name,store_state from stores where store_id = $1`, userID,
+		func(row pgx.Rows) error {
+			store := &authetication.ExportStore{}
+			err := row.Scan(&store.StoreID, &store.StoreName, &store.State)
+			export.OwnedStore = store
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+	err = s.collect(ctx, conn.Conn(), `select emp_id,store_id,emp_state,created_at from stores_employees where user_id = $1`, userID,
+		func(row pgx.Rows) error {
+			var employment authetication.ExportEmployment
+			err := row.Scan(&employment.EmpID, &employment.StoreID, &employment.State, &employment.CreatedAt)
+			export.Employments = append(export.Employments, employment)
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+	err = s.collect(ctx, conn.Conn(), `select redeemed_coupons.coupon_id,stores_employees.store_id,redeemed_coupons.redeemed_when
+	from redeemed_coupons inner join stores_employees on stores_employees.emp_id = redeemed_coupons.redeemed_by
+	where stores_employees.user_id = $1 order by redeemed_coupons.redeemed_when`, userID,
+		func(row pgx.Rows) error {
+			var redemption authetication.ExportRedemption
+			err := row.Scan(&redemption.CouponID, &redemption.StoreID, &redemption.RedeemedAt)
+			export.Redemptions = append(export.Redemptions, redemption)
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+	err = s.collect(ctx, conn.Conn(), `select redeemed_coupons.coupon_id,coupons.store_id,redeemed_coupons.redeemed_when
+	from redeemed_coupons inner join coupons on coupons.coupon_id::text = redeemed_coupons.coupon_id
+	where redeemed_coupons.redeemed_for = $1 order by redeemed_coupons.redeemed_when`, userID,
+		func(row pgx.Rows) error {
+			var redemption authetication.ExportRedemption
+			err := row.Scan(&redemption.CouponID, &redemption.StoreID, &redemption.RedeemedAt)
+			export.ShopperRedemptions = append(export.ShopperRedemptions, redemption)
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+//collect runs the query for the user and hands every row to scan
+func (s *Database) collect(ctx context.Context, conn *pgx.Conn, query string, userID string, scan func(pgx.Rows) error) error {
+	rows, err := conn.Query(ctx, query, userID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return storage.ErrServerError
+		}
+	}
+	if rows.Err() != nil {
+		s.logger.Error(rows.Err().Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
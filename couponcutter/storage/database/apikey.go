@@ -0,0 +1,126 @@
+package database
+
+import (
+	"context"
+	"couponcutter/storage"
+	"couponcutter/storemanagement"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//CreateAPIKey stores the API key with the hash of its key and returns the id of the key
+func (s *Database) CreateAPIKey(ctx context.Context, key storemanagement.APIKey, keyHash string) (string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer conn.Release()
+
+	id := storage.GenerateUUID()
+	_, err = conn.Exec(ctx, `insert into api_keys(key_id,store_id,name,prefix,key_hash,scopes,created_at) values($1,$2,$3,$4,$5,$6,$7)`,
+		id, key.StoreID, key.Name, key.Prefix, keyHash, scopeNames(key.Scopes), key.CreatedAt)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return id, nil
+}
+
+//APIKeys returns the API keys of the store that are not revoked
+func (s *Database) APIKeys(ctx context.Context, storeID string) ([]storemanagement.APIKey, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `select key_id,store_id,name,prefix,scopes,created_at,last_used_at from api_keys
+	where store_id = $1 and revoked_at is null order by created_at desc`, storeID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer rows.Close()
+
+	keys := []storemanagement.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return nil, storage.ErrServerError
+		}
+		keys = append(keys, *key)
+	}
+	if rows.Err() != nil {
+		s.logger.Error(rows.Err().Error())
+		return nil, storage.ErrServerError
+	}
+	return keys, nil
+}
+
+//RevokeAPIKey stops the API key of the store from being accepted
+func (s *Database) RevokeAPIKey(ctx context.Context, storeID string, keyID string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `update api_keys set revoked_at = now() where key_id = $1 and store_id = $2 and revoked_at is null`, keyID, storeID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return storemanagement.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+//UseAPIKey returns the active API key with the hash and records when it was used
+func (s *Database) UseAPIKey(ctx context.Context, keyHash string) (*storemanagement.APIKey, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(ctx, `update api_keys set last_used_at = now() where key_hash = $1 and revoked_at is null
+	returning key_id,store_id,name,prefix,scopes,created_at,last_used_at`, keyHash)
+	key, err := scanAPIKey(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storemanagement.ErrInvalidAPIKey
+		}
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	return key, nil
+}
+
+func scanAPIKey(row pgx.Row) (*storemanagement.APIKey, error) {
+	key := &storemanagement.APIKey{}
+	var scopes []string
+	err := row.Scan(&key.ID, &key.StoreID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &key.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = make([]storemanagement.Permission, len(scopes))
+	for i, scope := range scopes {
+		key.Scopes[i] = storemanagement.Permission(scope)
+	}
+	return key, nil
+}
+
+func scopeNames(scopes []storemanagement.Permission) []string {
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		names[i] = string(scope)
+	}
+	return names
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"couponcutter/authetication"
+	"couponcutter/storage"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//LoginAttempt returns the failed logins of the key, failures older than the window are forgotten
+func (s *Database) LoginAttempt(ctx context.Context, key string, window time.Duration) (*authetication.LoginAttempt, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var attempt authetication.LoginAttempt
+	row := conn.QueryRow(ctx, `select case when last_failure_at > now() - make_interval(secs => $2) then failures else 0 end,
+	coalesce(blocked_until, 'epoch') from login_attempts where attempt_key = $1`, key, window.Seconds())
+	err = row.Scan(&attempt.Failures, &attempt.BlockedUntil)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &authetication.LoginAttempt{}, nil
+		}
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	return &attempt, nil
+}
+
+//FailLogin counts a failed login for the key and returns the failures within the window
+func (s *Database) FailLogin(ctx context.Context, key string, window time.Duration) (int, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return 0, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var failures int
+	row := conn.QueryRow(ctx, `insert into login_attempts(attempt_key,failures,last_failure_at)values($1,1,now())
+	on conflict (attempt_key) do update set last_failure_at = now(),
+	failures = case when login_attempts.last_failure_at > now() - make_interval(secs => $2) then login_attempts.failures + 1 else 1 end
+	returning failures`, key, window.Seconds())
+	err = row.Scan(&failures)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return 0, storage.ErrServerError
+	}
+	return failures, nil
+}
+
+//BlockLogin blocks logins of the key until the given time
+func (s *Database) BlockLogin(ctx context.Context, key string, until time.Time) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `update login_attempts set blocked_until = $2 where attempt_key = $1`, key, until)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//ResetLogin forgets the failed logins of the key
+func (s *Database) ResetLogin(ctx context.Context, key string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `delete from login_attempts where attempt_key = $1`, key)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
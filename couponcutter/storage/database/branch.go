@@ -0,0 +1,369 @@
+package database
+
+import (
+	"context"
+	"couponcutter/storage"
+	"couponcutter/storemanagement"
+	"errors"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+const branchColumns = `branch_id,store_id,name,coalesce(address,''),latitude,longitude,branch_state,created_at`
+
+//scanBranch reads a row of branchColumns
+func scanBranch(row pgx.Row) (*storemanagement.Branch, error) {
+	var branch storemanagement.Branch
+	var latitude, longitude *float64
+	err := row.Scan(&branch.ID, &branch.StoreID, &branch.Name, &branch.Address, &latitude, &longitude, &branch.State, &branch.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if latitude != nil && longitude != nil {
+		branch.Location = &storemanagement.Location{Latitude: *latitude, Longitude: *longitude}
+	}
+	return &branch, nil
+}
+
+//Branches returns the branches of the store, oldest first
+func (s *Database) Branches(ctx context.Context, storeID string) ([]storemanagement.Branch, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `select `+branchColumns+` from store_branches where store_id = $1 order by created_at`, storeID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer rows.Close()
+	branches := []storemanagement.Branch{}
+	for rows.Next() {
+		branch, err := scanBranch(rows)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return nil, storage.ErrServerError
+		}
+		branches = append(branches, *branch)
+	}
+	if rows.Err() != nil {
+		s.logger.Error(rows.Err().Error())
+		return nil, storage.ErrServerError
+	}
+	return branches, nil
+}
+
+//Branch returns the branch of the store with the id
+func (s *Database) Branch(ctx context.Context, storeID string, branchID string) (*storemanagement.Branch, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	branch, err := scanBranch(conn.QueryRow(ctx, `select `+branchColumns+` from store_branches where store_id = $1 and branch_id = $2`, storeID, branchID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storemanagement.ErrBranchNotFound
+		}
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	return branch, nil
+}
+
+//CreateBranch stores the branch under a new id
+func (s *Database) CreateBranch(ctx context.Context, branch storemanagement.Branch) (string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var latitude, longitude *float64
+	if branch.Location != nil {
+		latitude, longitude = &branch.Location.Latitude, &branch.Location.Longitude
+	}
+	branchID := uuid.New().String()
+	_, err = conn.Exec(ctx, `insert into store_branches(branch_id,store_id,name,address,latitude,longitude,branch_state,created_at)
+	values($1,$2,$3,nullif($4,''),$5,$6,$7,$8)`,
+		branchID, branch.StoreID, branch.Name, branch.Address, latitude, longitude, branch.State, branch.CreatedAt)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return branchID, nil
+}
+
+//EditBranch changes the details of an open branch that are given in the edit
+func (s *Database) EditBranch(ctx context.Context, storeID string, branchID string, edit storemanagement.BranchEdit) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	//an edit without changes still tells whether the branch exists
+	c := s.psql.Update("store_branches").Set("name", sq.Expr("name")).
+		Where(sq.Eq{"store_id": storeID, "branch_id": branchID, "branch_state": storemanagement.BranchOpen})
+	if edit.Name != "" {
+		c = c.Set("name", edit.Name)
+	}
+	if edit.Address != "" {
+		c = c.Set("address", edit.Address)
+	}
+	if edit.Location != nil {
+		c = c.Set("latitude", edit.Location.Latitude).Set("longitude", edit.Location.Longitude)
+	}
+	cStr, args, err := c.ToSql()
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	tag, err := conn.Exec(ctx, cStr, args...)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return storemanagement.ErrBranchNotFound
+	}
+	return nil
+}
+
+//CloseBranch closes an open branch of the store
+func (s *Database) CloseBranch(ctx context.Context, storeID string, branchID string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `update store_branches set branch_state = 'closed'
+	where store_id = $1 and branch_id = $2 and branch_state = 'open'`, storeID, branchID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return storemanagement.ErrBranchNotFound
+	}
+	return nil
+}
+
+//openBranches reports whether every branch is an open branch of the store
+func openBranches(ctx context.Context, tx pgx.Tx, storeID string, branchIDs []string) (bool, error) {
+	if len(branchIDs) == 0 {
+		return true, nil
+	}
+	var n int
+	err := tx.QueryRow(ctx, `select count(*) from store_branches
+	where store_id = $1 and branch_id = any($2) and branch_state = 'open'`, storeID, branchIDs).Scan(&n)
+	return n == len(branchIDs), err
+}
+
+//SetEmployeeBranches replaces the branches the employee is limited to
+func (s *Database) SetEmployeeBranches(ctx context.Context, storeID string, employeeID string, branchIDs []string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	var empID string
+	err = tx.QueryRow(ctx, `select emp_id from stores_employees
+	where emp_id = $1 and store_id = $2 and emp_state <> 'removed' for update`, employeeID, storeID).Scan(&empID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storemanagement.ErrEmployeeNotFound
+		}
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	ok, err := openBranches(ctx, tx, storeID, branchIDs)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if !ok {
+		return storemanagement.ErrBranchNotFound
+	}
+	_, err = tx.Exec(ctx, `delete from employee_branches where emp_id = $1`, empID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	_, err = tx.Exec(ctx, `insert into employee_branches(emp_id,branch_id) select $1, unnest($2::text[])`, empID, branchIDs)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//EmployeeBranches returns the branches the user is limited to as an active employee of the store
+func (s *Database) EmployeeBranches(ctx context.Context, storeID string, userID string) ([]string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var branchIDs []string
+	err = conn.QueryRow(ctx, `select coalesce(array_agg(employee_branches.branch_id), '{}') from employee_branches
+	inner join stores_employees using(emp_id)
+	where stores_employees.store_id = $1 and stores_employees.user_id = $2 and stores_employees.emp_state = 'active'`,
+		storeID, userID).Scan(&branchIDs)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	return branchIDs, nil
+}
+
+//SetCouponBranches replaces the branches the coupon is limited to
+func (s *Database) SetCouponBranches(ctx context.Context, storeID string, couponID string, branchIDs []string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	err = setCouponBranches(ctx, tx, storeID, couponID, branchIDs)
+	if err != nil {
+		if errors.Is(err, storemanagement.ErrCouponNotValid) || errors.Is(err, storemanagement.ErrBranchNotFound) {
+			return err
+		}
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//setCouponBranches replaces the branches of the coupon within the transaction
+func setCouponBranches(ctx context.Context, tx pgx.Tx, storeID string, couponID string, branchIDs []string) error {
+	var id int
+	err := tx.QueryRow(ctx, `select coupon_id from coupons where coupon_id::text = $1 and store_id = $2 for update`, couponID, storeID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storemanagement.ErrCouponNotValid
+		}
+		return err
+	}
+	ok, err := openBranches(ctx, tx, storeID, branchIDs)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return storemanagement.ErrBranchNotFound
+	}
+	_, err = tx.Exec(ctx, `delete from coupon_branches where coupon_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `insert into coupon_branches(coupon_id,branch_id) select $1, unnest($2::text[])`, id, branchIDs)
+	return err
+}
+
+//CouponBranches returns the branches the coupon of the store is limited to
+func (s *Database) CouponBranches(ctx context.Context, storeID string, couponID string) ([]string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var branchIDs []string
+	err = conn.QueryRow(ctx, `select coalesce(array_agg(coupon_branches.branch_id), '{}') from coupon_branches
+	inner join coupons using(coupon_id)
+	where coupons.store_id = $1 and coupons.coupon_id::text = $2`, storeID, couponID).Scan(&branchIDs)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	return branchIDs, nil
+}
+
+//BranchRedemptionCounts counts the redemptions of the coupons of the store made since the time
+//at every branch, closed ones included, and the redemptions made without a branch
+func (s *Database) BranchRedemptionCounts(ctx context.Context, storeID string, since time.Time) ([]storemanagement.BranchRedemptionCount, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `select store_branches.branch_id,store_branches.name,count(redeemed_coupons.id)
+	from store_branches left join redeemed_coupons on redeemed_coupons.branch_id = store_branches.branch_id
+	and redeemed_coupons.redeemed_when >= $2
+	where store_branches.store_id = $1
+	group by store_branches.branch_id,store_branches.name
+	union all
+	select '','',count(*) from redeemed_coupons inner join coupons on coupons.coupon_id::text = redeemed_coupons.coupon_id
+	where coupons.store_id = $1 and redeemed_coupons.branch_id is null and redeemed_coupons.redeemed_when >= $2
+	order by 3 desc, 2`, storeID, since)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer rows.Close()
+	counts := []storemanagement.BranchRedemptionCount{}
+	for rows.Next() {
+		var count storemanagement.BranchRedemptionCount
+		err = rows.Scan(&count.BranchID, &count.BranchName, &count.Count)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return nil, storage.ErrServerError
+		}
+		if count.BranchID == "" && count.Count == 0 {
+			continue
+		}
+		counts = append(counts, count)
+	}
+	if rows.Err() != nil {
+		s.logger.Error(rows.Err().Error())
+		return nil, storage.ErrServerError
+	}
+	return counts, nil
+}
@@ -0,0 +1,269 @@
+package database
+
+import (
+	"context"
+	"couponcutter/storage"
+	"couponcutter/storemanagement"
+	"errors"
+	"strconv"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+//StoreCoupon returns the coupon of the store with the id
+func (s *Database) StoreCoupon(ctx context.Context, storeID string, couponID string) (*storemanagement.Coupon, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var coupon storemanagement.Coupon
+	err = conn.QueryRow(ctx, `select coupons.coupon_id::text,coupons.store_id,coupons."desc",coupons."state",coupons.discount_type,
+	coalesce(coupons.amount_off,0)::float8,coalesce(coupons.percentage_off,0)::float8,coalesce(coupons.currency_code,''),
+	coalesce(coupons.qr_code_url,''),extract(epoch from coupons.expired_at)::integer,extract(epoch from coupons.created_at)::bigint,
+	coupons.is_text_coupon,coalesce(coupons.text_coupon_code,''),coalesce(coupons.text_coupon_weburl,''),
+	coupons.unlimited_redemption,coalesce(coupons.max_redemptions,0),coupons.redemption_count,
+	coalesce((select array_agg(categories.cat_name order by categories.cat_name) from coupon_categories
+		inner join categories using(cat_id) where coupon_categories.coupon_id = coupons.coupon_id::text), '{}'),
+	coalesce(extract(epoch from coupons.start_at)::integer,0),stores.timezone,
+	coupons.user_limit,coalesce(coupons.user_limit_period,'')
+	from coupons inner join stores using(store_id)
+	where coupons.store_id = $1 and coupons.coupon_id::text = $2`, storeID, couponID).Scan(
+		&coupon.CouponID,
+		&coupon.Store.StoreID,
+		&coupon.Desc,
+		&coupon.State,
+		&coupon.DiscountType,
+		&coupon.AmountOff,
+		&coupon.PercentageOff,
+		&coupon.CurrencyCode,
+		&coupon.QrCodeURL,
+		&coupon.ExpiringDate,
+		&coupon.CreationTime,
+		&coupon.IsTextCoupon,
+		&coupon.TextCouponCode,
+		&coupon.TextCouponWebURL,
+		&coupon.UnlimitedRedemption,
+		&coupon.MaxRedemption,
+		&coupon.RedemptionCount,
+		&coupon.Categories,
+		&coupon.StartDate,
+		&coupon.Store.Timezone,
+		&coupon.UserLimit.Count,
+		&coupon.UserLimit.Period,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storemanagement.ErrCouponNotValid
+		}
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	coupon.SingleUserUse = coupon.UserLimit == storemanagement.UserLimit{Count: 1}
+	coupon.Windows, err = couponWindows(ctx, conn.Conn(), couponID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	return &coupon, nil
+}
+
+//UpdateCoupon applies the update to the coupon while it is still in the state and records the edit.
+//A coupon redeemed meanwhile keeps its terms, and the redemption limit check of the coupons
+//table rejects a limit below the redemptions made meanwhile
+func (s *Database) UpdateCoupon(ctx context.Context, storeID string, couponID string, state string, update storemanagement.CouponUpdate, edit storemanagement.CouponEdit) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	//an update of the categories only still locks and checks the coupon
+	c := s.psql.Update("coupons").Set(`"desc"`, sq.Expr(`"desc"`)).
+		Where(`store_id = ? and coupon_id::text = ? and "state" = ?`, storeID, couponID, state).
+		Suffix("returning coupon_id")
+	if update.ChangesTerms() {
+		c = c.Where("redemption_count = 0")
+	}
+	if update.Desc != nil {
+		c = c.Set(`"desc"`, *update.Desc)
+	}
+	if update.ExpiringDate != nil {
+		c = c.Set("expired_at", sq.Expr("to_timestamp(?)", *update.ExpiringDate))
+	}
+	if update.UnlimitedRedemption != nil {
+		c = c.Set("unlimited_redemption", *update.UnlimitedRedemption)
+	}
+	if update.MaxRedemption != nil {
+		c = c.Set("max_redemptions", *update.MaxRedemption)
+	}
+	if update.StartDate != nil {
+		c = c.Set("start_at", sq.Expr("to_timestamp(nullif(?::bigint,0))", *update.StartDate))
+	}
+	if update.UserLimit != nil {
+		c = c.Set("user_limit", update.UserLimit.Count).Set("user_limit_period", sq.Expr("nullif(?,'')", update.UserLimit.Period))
+	}
+	if update.TextCouponWebURL != nil {
+		c = c.Set("text_coupon_weburl", sq.Expr("nullif(?,'')", *update.TextCouponWebURL))
+	}
+	if update.AmountOff != nil {
+		c = c.Set("amount_off", sq.Expr("nullif(?::numeric,0)", *update.AmountOff))
+	}
+	if update.PercentageOff != nil {
+		c = c.Set("percentage_off", sq.Expr("nullif(?::numeric,0)", *update.PercentageOff))
+	}
+	if update.CurrencyCode != nil {
+		c = c.Set("currency_code", sq.Expr("nullif(?,'')", *update.CurrencyCode))
+	}
+	if update.DiscountType != nil {
+		c = c.Set("discount_type", *update.DiscountType)
+	}
+	if update.IsTextCoupon != nil {
+		c = c.Set("is_text_coupon", *update.IsTextCoupon)
+	}
+	if update.TextCouponCode != nil {
+		c = c.Set("text_coupon_code", sq.Expr("nullif(?,'')", *update.TextCouponCode))
+	}
+	cStr, args, err := c.ToSql()
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	var id int
+	err = tx.QueryRow(ctx, cStr, args...).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.Is(err, pgx.ErrNoRows) || (errors.As(err, &pgErr) && pgErr.Code == "23514") {
+			return storemanagement.ErrCouponEditConflict
+		}
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if update.Categories != nil {
+		_, err = tx.Exec(ctx, `delete from coupon_categories where coupon_id = $1`, strconv.Itoa(id))
+		if err != nil {
+			s.logger.Error(err.Error())
+			return storage.ErrServerError
+		}
+		_, err = tx.Exec(ctx, `insert into coupon_categories(coupon_id,cat_id)
+		select $1, cat_id from categories where cat_name = any($2)`, strconv.Itoa(id), *update.Categories)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return storage.ErrServerError
+		}
+	}
+	if update.Windows != nil {
+		err = setCouponWindows(ctx, tx, strconv.Itoa(id), *update.Windows)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return storage.ErrServerError
+		}
+	}
+	err = insertCouponEdit(ctx, tx, id, edit)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//SetCouponState moves the coupon from a state to another and records the edit
+func (s *Database) SetCouponState(ctx context.Context, storeID string, couponID string, from string, to string, edit storemanagement.CouponEdit) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	err = tx.QueryRow(ctx, `update coupons set "state" = $4
+	where store_id = $1 and coupon_id::text = $2 and "state" = $3 returning coupon_id`, storeID, couponID, from, to).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storemanagement.ErrCouponEditConflict
+		}
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	err = insertCouponEdit(ctx, tx, id, edit)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//insertCouponEdit records the edit of the coupon within the transaction, its time in UTC
+func insertCouponEdit(ctx context.Context, tx pgx.Tx, couponID int, edit storemanagement.CouponEdit) error {
+	_, err := tx.Exec(ctx, `insert into coupon_edits(coupon_id,edited_by,edited_at,changes) values($1,$2,$3,$4)`,
+		couponID, edit.EditedBy, edit.EditedAt, edit.Changes)
+	return err
+}
+
+//CouponEdits returns the edit history of the coupon of the store, oldest first
+func (s *Database) CouponEdits(ctx context.Context, storeID string, couponID string) ([]storemanagement.CouponEdit, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `select coupon_edits.edit_id::text,coupon_edits.coupon_id::text,coupon_edits.edited_by,
+	coupon_edits.edited_at,coupon_edits.changes
+	from coupon_edits inner join coupons using(coupon_id)
+	where coupons.store_id = $1 and coupons.coupon_id::text = $2
+	order by coupon_edits.edited_at, coupon_edits.edit_id`, storeID, couponID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer rows.Close()
+	edits := []storemanagement.CouponEdit{}
+	for rows.Next() {
+		var edit storemanagement.CouponEdit
+		err = rows.Scan(&edit.ID, &edit.CouponID, &edit.EditedBy, &edit.EditedAt, &edit.Changes)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return nil, storage.ErrServerError
+		}
+		edits = append(edits, edit)
+	}
+	if rows.Err() != nil {
+		s.logger.Error(rows.Err().Error())
+		return nil, storage.ErrServerError
+	}
+	return edits, nil
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"couponcutter/authetication"
+	"couponcutter/storage"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+//ChangePassword sets the password hash and revokes every session of the user except the one to keep
+func (s *Database) ChangePassword(ctx context.Context, userID string, password string, keepSessionID string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	hash, err := storage.HashPassword(password)
+	if err != nil {
+		return storage.ErrServerError
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `update users set password_hash = $1 where user_id = $2`, hash, userID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return authetication.ErrIdentityDoesNotExists
+	}
+	_, err = tx.Exec(ctx, `update user_sessions set revoked_at = now() where user_id = $1 and session_id <> $2 and revoked_at is null`, userID, keepSessionID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	// a reset token requested before the change must not undo it
+	_, err = tx.Exec(ctx, `update password_resets set used_at = now() where user_id = $1 and used_at is null`, userID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//CreateEmailChange stores the hash of the token confirming the new email, earlier pending changes are dropped
+func (s *Database) CreateEmailChange(ctx context.Context, userID string, email string, tokenHash string, expiredAt time.Time) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `delete from email_changes where user_id = $1 and used_at is null`, userID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	_, err = tx.Exec(ctx, `insert into email_changes(user_id,email,token_hash,expired_at,created_at)values($1,$2,$3,$4,now())`,
+		userID, email, tokenHash, expiredAt)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//ConfirmEmailChange consumes the token and sets the email of its user
+func (s *Database) ConfirmEmailChange(ctx context.Context, tokenHash string) (string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	var userid, email string
+	row := tx.QueryRow(ctx, `update email_changes set used_at = now() where token_hash = $1 and used_at is null and expired_at > now() returning user_id,email`, tokenHash)
+	err = row.Scan(&userid, &email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", authetication.ErrInvalidEmailChangeToken
+		}
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+
+	_, err = tx.Exec(ctx, `update users set email = $1 where user_id = $2`, email, userid)
+	if err != nil {
+		// the address was taken by another account after the change was requested
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return "", authetication.ErrIdentityAlreadyExists
+		}
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return userid, nil
+}
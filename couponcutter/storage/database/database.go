@@ -42,51 +42,285 @@ func NewStorage() (*Database, error) {
 	return st, nil
 }
 
-//TODO
-func (s *Database) ResetPassword(ctx context.Context, email string) error {
+//CreateResetToken stores the hash of a password reset token for the user
+func (s *Database) CreateResetToken(ctx context.Context, userID string, tokenHash string, expiredAt time.Time) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `insert into password_resets(user_id,token_hash,expired_at,created_at)values($1,$2,$3,now())`, userID, tokenHash, expiredAt)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
 	return nil
 }
-func (s *Database) SearchCoupon(ctx context.Context, term string) (interface{}, error) {
+
+//ResetTokenEmail returns the email of the user the reset token was issued to if it is still usable
+func (s *Database) ResetTokenEmail(ctx context.Context, tokenHash string) (string, error) {
 	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return "", storage.ErrServerError
+	}
 	defer conn.Release()
+
+	var email string
+	row := conn.QueryRow(ctx, `select users.email from password_resets inner join users using(user_id)
+	where token_hash = $1 and used_at is null and expired_at > now()`, tokenHash)
+	err = row.Scan(&email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", authetication.ErrInvalidResetToken
+		}
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return email, nil
+}
+
+//ResetPassword consumes the reset token, sets the new password hash and revokes every token issued to the user
+func (s *Database) ResetPassword(ctx context.Context, tokenHash string, password string) (string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
 	if err != nil {
 		s.logger.Debug(err.Error())
 		return "", storage.ErrServerError
 	}
-	c := `coupon_id,store_id,store_id,store_name,tagline,theme_color,"desc",amount_off,percentage_off,currency_code,qr_code_url,extract(epoch from expired_at),is_text_coupon,text_coupon_code,text_coupon_weburl from coupons where tokens @@ $1`
-	rows, err := conn.Query(ctx, c, term)
+	defer conn.Release()
+
+	hash, err := storage.HashPassword(password)
+	if err != nil {
+		return "", storage.ErrServerError
+	}
+
+	tx, err := conn.Begin(ctx)
 	if err != nil {
 		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	var userid string
+	row := tx.QueryRow(ctx, `update password_resets set used_at = now() where token_hash = $1 and used_at is null and expired_at > now() returning user_id`, tokenHash)
+	err = row.Scan(&userid)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", authetication.ErrInvalidResetToken
+		}
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+
+	_, err = tx.Exec(ctx, `update users set password_hash = $1, tokens_valid_after = now() where user_id = $2`, hash, userid)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	_, err = tx.Exec(ctx, `update user_sessions set revoked_at = now() where user_id = $1 and revoked_at is null`, userid)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	// any other outstanding reset token for the user is no longer needed
+	_, err = tx.Exec(ctx, `update password_resets set used_at = now() where user_id = $1 and used_at is null`, userid)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return userid, nil
+}
+
+//TokensValidAfter returns the time before which tokens issued to the user are no longer valid
+func (s *Database) TokensValidAfter(ctx context.Context, userID string) (time.Time, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return time.Time{}, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var validAfter time.Time
+	row := conn.QueryRow(ctx, `select coalesce(tokens_valid_after, 'epoch') from users where user_id = $1`, userID)
+	err = row.Scan(&validAfter)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, authetication.ErrIdentityDoesNotExists
+		}
+		s.logger.Error(err.Error())
+		return time.Time{}, storage.ErrServerError
+	}
+	return validAfter, nil
+}
+
+//CreateRefreshToken stores the hash of a refresh token, starting its session if it is new
+func (s *Database) CreateRefreshToken(ctx context.Context, token authetication.RefreshToken) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `insert into user_sessions(session_id,user_id,created_at)values($1,$2,now()) on conflict (session_id) do nothing`, token.SessionID, token.UserID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	_, err = tx.Exec(ctx, `insert into refresh_tokens(token_hash,session_id,user_id,expired_at,created_at)values($1,$2,$3,$4,now())`,
+		token.TokenHash, token.SessionID, token.UserID, token.ExpiredAt)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//RefreshTokenWithHash returns the refresh token with the given hash
+func (s *Database) RefreshTokenWithHash(ctx context.Context, tokenHash string) (*authetication.RefreshToken, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
 		return nil, storage.ErrServerError
 	}
-	var response listing.CouponListResponse
-	for rows.Next() {
-		var coupon listing.Coupon
-		err = rows.Scan(
-			&coupon.CouponID,
-			&coupon.Store.StoreID,
-			&coupon.Store.StoreName,
-			&coupon.Store.Tagline,
-			&coupon.Store.Address,
-			&coupon.Store.ThemeColor,
-			&coupon.Desc,
-			&coupon.AmountOff,
-			&coupon.PercentageOff,
-			&coupon.CurrencyCode,
-			&coupon.QrCodeURL,
-			&coupon.ExpiringDate,
-			&coupon.IsTextCoupon,
-			&coupon.TextCouponCode,
-			&coupon.TextCouponWebURL,
-		)
-		if err != nil {
-			continue
+	defer conn.Release()
+
+	var token authetication.RefreshToken
+	row := conn.QueryRow(ctx, `select token_hash,session_id,user_id,expired_at,used_at is not null,revoked_at is not null
+	from refresh_tokens inner join user_sessions using(session_id,user_id) where token_hash = $1`, tokenHash)
+	err = row.Scan(
+		&token.TokenHash,
+		&token.SessionID,
+		&token.UserID,
+		&token.ExpiredAt,
+		&token.Used,
+		&token.Revoked,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, authetication.ErrInvalidRefreshToken
 		}
-		response.Coupons = append(response.Coupons, coupon)
-		response.LastCouponID = response.Coupons[len(response.Coupons)-1].CouponID
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
 	}
-	return &response, nil
+	return &token, nil
+}
+
+//UseRefreshToken marks the refresh token as used, false is returned if it was already used
+func (s *Database) UseRefreshToken(ctx context.Context, tokenHash string) (bool, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return false, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `update refresh_tokens set used_at = now() where token_hash = $1 and used_at is null`, tokenHash)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return false, storage.ErrServerError
+	}
+	return tag.RowsAffected() == 1, nil
 }
+
+//RevokeSession revokes the session and with it all its refresh and access tokens
+func (s *Database) RevokeSession(ctx context.Context, sessionID string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `update user_sessions set revoked_at = now() where session_id = $1 and revoked_at is null`, sessionID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//SessionRevoked reports whether the session has been revoked
+func (s *Database) SessionRevoked(ctx context.Context, sessionID string) (bool, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return false, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var revoked bool
+	row := conn.QueryRow(ctx, `select revoked_at is not null from user_sessions where session_id = $1`, sessionID)
+	err = row.Scan(&revoked)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return true, nil
+		}
+		s.logger.Error(err.Error())
+		return false, storage.ErrServerError
+	}
+	return revoked, nil
+}
+
+//UserAccess resolves the roles of the user from the store they own or are employed at
+func (s *Database) UserAccess(ctx context.Context, userID string) (*authetication.Principal, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var admin bool
+	var ownedStore, employerStore *string
+	row := conn.QueryRow(ctx, `select users.platform_admin,stores.store_id,stores_employees.store_id from users
+	left join stores on stores.store_id = users.user_id
+	left join stores_employees on stores_employees.user_id = users.user_id and stores_employees.emp_state = 'active'
+	where users.user_id = $1 limit 1`, userID)
+	err = row.Scan(&admin, &ownedStore, &employerStore)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, authetication.ErrIdentityDoesNotExists
+		}
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+
+	principal := &authetication.Principal{UserID: userID, Roles: []string{authetication.RoleShopper}}
+	if admin {
+		principal.Roles = append(principal.Roles, authetication.RolePlatformAdmin)
+	}
+	// a user acts for the store they own before any store they are employed at
+	if ownedStore != nil {
+		principal.Roles = append(principal.Roles, authetication.RoleOwner)
+		principal.StoreID = *ownedStore
+	} else if employerStore != nil {
+		principal.Roles = append(principal.Roles, authetication.RoleEmployee)
+		principal.StoreID = *employerStore
+	}
+	return principal, nil
+}
+
 func (s *Database) UserWithEmail(ctx context.Context, email string) (string, error) {
 	conn, err := s.dbPool.Acquire(ctx)
 	defer conn.Release()
@@ -109,7 +343,7 @@ func (s *Database) UserWithEmail(ctx context.Context, email string) (string, err
 	if err != nil {
 		s.logger.Error(err.Error())
 		if errors.Is(err, pgx.ErrNoRows) {
-			return "", listing.ErrUserNotExists
+			return "", authetication.ErrIdentityDoesNotExists
 		}
 		return "", storage.ErrServerError
 	}
@@ -118,31 +352,22 @@ func (s *Database) UserWithEmail(ctx context.Context, email string) (string, err
 
 }
 
-//create a user with the given password
+//create a verified user with the given password and return the userid
 func (s *Database) CreateUser(ctx context.Context, email, password string) (string, error) {
 	conn, err := s.dbPool.Acquire(ctx)
-	defer conn.Release()
 	if err != nil {
 		s.logger.Debug(err.Error())
 		return "", storage.ErrServerError
 	}
+	defer conn.Release()
 	hash, err := storage.HashPassword(password)
 	if err != nil {
 		s.logger.Error(err.Error())
 		return "", storage.ErrServerError
 	}
 
-	token := storage.GenerateUUID()
-	expired_at := time.Now().Add(time.Hour * 24 * 7)
-
-	r := conn.QueryRow(ctx, "select * from users where email = $1", email)
-	// a returned value means a user already exists with the given email
-	e := r.Scan()
-	if e != nil {
-		return "", authetication.ErrIdentityAlreadyExists
-	}
-
-	_, err = conn.Exec(ctx, "insert into signup_users(email,password_hash,token,expired_at)values($1 ,$2)", email, password, expired_at, hash, token)
+	userid := storage.GenerateUUID()
+	tag, err := conn.Exec(ctx, "insert into users(user_id,email,password_hash,created_at)values($1,$2,$3,now()) on conflict (email) do nothing", userid, email, hash)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
@@ -152,26 +377,171 @@ func (s *Database) CreateUser(ctx context.Context, email, password string) (stri
 		s.logger.Error(err.Error())
 		return "", storage.ErrServerError
 	}
+	// no inserted row means a user already exists with the given email
+	if tag.RowsAffected() == 0 {
+		return "", authetication.ErrIdentityAlreadyExists
+	}
 
-	return token, nil
+	return userid, nil
 
 }
 
-//Fetch user id with the given email and password
-func (s *Database) UserWithIdentity(ctx context.Context, email, password string) (string, error) {
+//CreateSignup stores a pending signup waiting for its email to be verified
+func (s *Database) CreateSignup(ctx context.Context, email, password, tokenHash string, expiredAt time.Time) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+	hash, err := storage.HashPassword(password)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
 
+	_, err = conn.Exec(ctx, `insert into signup_users(email,password_hash,token,expired_at)values($1,$2,$3,$4)
+	on conflict (email) do update set password_hash = excluded.password_hash, token = excluded.token, expired_at = excluded.expired_at`,
+		email, hash, tokenHash, expiredAt)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//RenewSignupToken replaces the verification token of a pending signup
+func (s *Database) RenewSignupToken(ctx context.Context, email, tokenHash string, expiredAt time.Time) error {
 	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
 	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `update signup_users set token = $1, expired_at = $2 where email = $3`, tokenHash, expiredAt, email)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return authetication.ErrIdentityDoesNotExists
+	}
+	return nil
+}
+
+//VerifySignup moves the pending signup associated with the token into users
+func (s *Database) VerifySignup(ctx context.Context, tokenHash string) (string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
 	if err != nil {
 		s.logger.Debug(err.Error())
 		return "", storage.ErrServerError
 	}
-	hash, err := storage.HashPassword(password)
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
 	if err != nil {
-		s.logger.Debug(err.Error())
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	var email, hash string
+	row := tx.QueryRow(ctx, `delete from signup_users where token = $1 and expired_at > now() returning email,password_hash`, tokenHash)
+	err = row.Scan(&email, &hash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", authetication.ErrInvalidVerificationToken
+		}
+		s.logger.Error(err.Error())
 		return "", storage.ErrServerError
 	}
 
+	userid := storage.GenerateUUID()
+	tag, err := tx.Exec(ctx, `insert into users(user_id,email,password_hash,created_at)values($1,$2,$3,now()) on conflict (email) do nothing`, userid, email, hash)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return "", authetication.ErrIdentityAlreadyExists
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return userid, nil
+}
+
+//HasPendingSignup reports whether the email has a pending signup that has not expired
+func (s *Database) HasPendingSignup(ctx context.Context, email string) (bool, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return false, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var pending bool
+	row := conn.QueryRow(ctx, `select exists(select 1 from signup_users where email = $1 and expired_at > now())`, email)
+	err = row.Scan(&pending)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return false, storage.ErrServerError
+	}
+	return pending, nil
+}
+
+//SignupWithIdentity returns nil if a pending signup matches the email and password
+func (s *Database) SignupWithIdentity(ctx context.Context, email, password string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var hash string
+	row := conn.QueryRow(ctx, `select password_hash from signup_users where email = $1 and expired_at > now()`, email)
+	err = row.Scan(&hash)
+	if err != nil {
+		return authetication.ErrIdentityDoesNotExists
+	}
+	if !storage.CheckPasswordHash(hash, password) {
+		return authetication.ErrIdentityDoesNotExists
+	}
+	return nil
+}
+
+//DeleteExpiredSignups removes pending signups whose token has expired
+func (s *Database) DeleteExpiredSignups(ctx context.Context) (int64, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return 0, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `delete from signup_users where expired_at <= now()`)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return 0, storage.ErrServerError
+	}
+	return tag.RowsAffected(), nil
+}
+
+//Fetch user id with the given email and password, the stored hash is upgraded
+//to the current algorithm once the password is known to be correct
+func (s *Database) UserWithIdentity(ctx context.Context, email, password string) (string, error) {
+
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer conn.Release()
 	var userid string
 	var passwdHash string
 
@@ -181,14 +551,41 @@ func (s *Database) UserWithIdentity(ctx context.Context, email, password string)
 		s.logger.Debug(err.Error())
 		return "", authetication.ErrIdentityDoesNotExists
 	}
-	if passwdHash != hash {
-		return "", authetication.ErrIdentityAlreadyExists
+	if !storage.CheckPasswordHash(passwdHash, password) {
+		return "", authetication.ErrIdentityDoesNotExists
+	}
+
+	if storage.NeedsRehash(passwdHash) {
+		hash, err := storage.HashPassword(password)
+		if err == nil {
+			// only replace the hash that was checked in case the password changed in between
+			_, err = conn.Exec(ctx, `update users set password_hash = $1 where user_id = $2 and password_hash = $3`, hash, userid, passwdHash)
+		}
+		if err != nil {
+			s.logger.Error(err.Error())
+		}
 	}
 
 	return userid, nil
 
 }
 
+//listedCouponColumns are the columns of a coupon listed to shoppers in the order they are scanned,
+//the coupons must be joined with their stores
+const listedCouponColumns = `coupons.coupon_id,stores.store_id,stores.store_name,stores.tagline,coalesce(stores.address,''),
+coalesce(stores.theme_color,0),coupons."desc",coalesce(coupons.amount_off,0)::float8,coalesce(coupons.percentage_off,0)::float8,
+coalesce(coupons.currency_code,''),coalesce(coupons.qr_code_url,''),extract(epoch from coupons.expired_at)::integer,
+coupons.is_text_coupon,coalesce(coupons.text_coupon_code,''),coalesce(coupons.text_coupon_weburl,'')`
+
+//popularCoupons joins the popular coupons with their coupons and stores. The materialized view
+//is only refreshed now and then, so the coupons that are not live any more are left out by the caller
+const popularCoupons = `popular_coupons inner join coupons on coupons.coupon_id = popular_coupons.coupon_id
+inner join stores on stores.store_id = coupons.store_id`
+
+//couponCategories joins the coupons with their categories
+const couponCategories = `coupon_categories on coupon_categories.coupon_id = coupons.coupon_id::text
+inner join categories on categories.cat_id = coupon_categories.cat_id`
+
 //fetch the coupon details associated with the given id
 func (s *Database) SingleCoupon(ctx context.Context, couponId string) (*listing.SingleCouponResponse, error) {
 	conn, err := s.dbPool.Acquire(ctx)
@@ -197,13 +594,13 @@ func (s *Database) SingleCoupon(ctx context.Context, couponId string) (*listing.
 		return nil, storage.ErrServerError
 	}
 
-	c := s.psql.Select(`coupon_id,store_id,store_id,store_name,tagline,theme_color,"desc",amount_off,percentage_off,currency_code,qr_code_url,extract(epoch from expired_at),is_text_coupon,text_coupon_code,text_coupon_weburl`).From("coupons").InnerJoin("stores using(store_id)").Where(sq.Eq{"coupon_id": "", "coupon_state": ""})
+	c := s.psql.Select(listedCouponColumns).From("coupons").InnerJoin("stores using(store_id)").Where(liveCoupon).Where(sq.Eq{"coupons.coupon_id::text": ""})
 
 	cStr, _, err := c.ToSql()
 	if err != nil {
 		return nil, storage.ErrServerError
 	}
-	row := conn.QueryRow(ctx, cStr, couponId, "active")
+	row := conn.QueryRow(ctx, cStr, couponId)
 
 	var couponResponse listing.SingleCouponResponse
 	couponResponse.Coupon = &listing.Coupon{}
@@ -243,7 +640,7 @@ func (s *Database) PopularCoupons(ctx context.Context) (*listing.CouponListRespo
 		return nil, storage.ErrServerError
 	}
 
-	c := s.psql.Select(`coupon_id,store_id,store_id,store_name,tagline,theme_color,"desc",amount_off,percentage_off,currency_code,qr_code_url,extract(epoch from expired_at),is_text_coupon,text_coupon_code,text_coupon_weburl`).From("popular_coupons").OrderBy("coupon_id desc")
+	c := s.psql.Select(listedCouponColumns).From(popularCoupons).Where(liveCoupon).OrderBy("coupons.coupon_id desc")
 
 	cStr, _, err := c.ToSql()
 	if err != nil {
@@ -296,7 +693,7 @@ func (s *Database) LatestCoupons(ctx context.Context) (*listing.CouponListRespon
 		return nil, storage.ErrServerError
 	}
 
-	c := s.psql.Select(`coupon_id,store_id,store_id,store_name,tagline,theme_color,"desc",amount_off,percentage_off,currency_code,qr_code_url,extract(epoch from expired_at),is_text_coupon,text_coupon_code,text_coupon_weburl`).From("coupons").InnerJoin("stores using (store_id)").Where("coupon_state='active'").OrderBy("created_at desc")
+	c := s.psql.Select(`coupon_id,store_id,store_id,store_name,tagline,theme_color,"desc",amount_off,percentage_off,currency_code,qr_code_url,extract(epoch from expired_at),is_text_coupon,text_coupon_code,text_coupon_weburl`).From("coupons").InnerJoin("stores using (store_id)").Where(liveCoupon).OrderBy("created_at desc")
 
 	cStr, _, err := c.ToSql()
 	if err != nil {
@@ -350,9 +747,8 @@ func (s *Database) PopularCouponsWithFiltering(ctx context.Context, catName stri
 	if err != nil {
 		return nil, storage.ErrServerError
 	}
-	subQ := s.psql.Select("*").From("popular_coupons").InnerJoin("categories using (cat_id)").Where(sq.Eq{"cat_name": ""})
-
-	c := s.psql.Select(`coupon_id,store_id,store_id,store_name,tagline,theme_color,"desc",amount_off,percentage_off,currency_code,qr_code_url,extract(epoch from expired_at),is_text_coupon,text_coupon_code,text_coupon_weburl`).FromSelect(subQ, "coupons")
+	c := s.psql.Select(listedCouponColumns).From(popularCoupons).InnerJoin(couponCategories).
+		Where(liveCoupon).Where(sq.Eq{"categories.cat_name": ""}).OrderBy("coupons.coupon_id desc")
 
 	cStr, _, err := c.ToSql()
 	if err != nil {
@@ -401,9 +797,8 @@ func (s *Database) LatestCouponsWithFiltering(ctx context.Context, filter string
 	if err != nil {
 		return nil, storage.ErrServerError
 	}
-	cQ := s.psql.Select("select cat_id from categories").InnerJoin("coupon_categories using(cat_id)").Where(sq.Eq{"cat_name": ""})
-	cQ = s.psql.Select("*").FromSelect(cQ, "cats").InnerJoin("coupons using(coupon_id)")
-	stQ := s.psql.Select("*").FromSelect(cQ, "stores").InnerJoin("stores using(store_id)").OrderBy("coupon_id desc")
+	stQ := s.psql.Select(listedCouponColumns).From("coupons").InnerJoin("stores using(store_id)").InnerJoin(couponCategories).
+		Where(liveCoupon).Where(sq.Eq{"categories.cat_name": ""}).OrderBy("coupons.coupon_id desc")
 
 	qStr, _, err := stQ.ToSql()
 	if err != nil {
@@ -524,10 +919,10 @@ func (s *Database) CouponBeforeIDAndTimeFiltered(ctx context.Context, couponID s
 	if err != nil {
 		return nil, storage.ErrServerError
 	}
-	c := s.psql.Select(`*`).From("categories").Where(sq.Eq{"cat_name": ""}).InnerJoin("coupon_categories using(cat_id")
-	d := s.psql.Select("*").FromSelect(c, "cat").InnerJoin("coupons using(coupon_id)").Where(sq.Eq{"coupon_state": "active", "created_at": ""})
-
-	cQ := s.psql.Select(`coupon_id,store_id,store_name,tagline,address,theme_color,"desc",amount_off,percentage_off,currency_code,qr_code_url,extract(epoch from expired_at),is_text_coupon,text_coupon_code,text_coupon_weburl`).FromSelect(d, "coupons").InnerJoin("stores using(store_id")
+	cQ := s.psql.Select(listedCouponColumns).From("coupons").InnerJoin("stores using(store_id)").InnerJoin(couponCategories).
+		Where(liveCoupon).Where(sq.Eq{"categories.cat_name": ""}).
+		Where("coupons.created_at <= ?::timestamptz and coupons.coupon_id < ?::integer").
+		OrderBy("coupons.created_at desc", "coupons.coupon_id desc")
 
 	cStr, _, err := cQ.ToSql()
 	if err != nil {
@@ -678,7 +1073,7 @@ func (s *Database) UserStoreData(ctx context.Context, storeID string) (*storeman
 		return nil, storage.ErrServerError
 	}
 
-	c := s.psql.Select(`store_id,store_name,tagline,address,theme_color`).From("stores").Where(sq.Eq{"store_id": ""})
+	c := s.psql.Select(`store_id,store_name,tagline,coalesce(address,''),latitude,longitude`).From("stores").Where(sq.Eq{"store_id": ""})
 
 	cStr, _, err := c.ToSql()
 	if err != nil {
@@ -688,16 +1083,22 @@ func (s *Database) UserStoreData(ctx context.Context, storeID string) (*storeman
 	row := conn.QueryRow(ctx, cStr, storeID)
 
 	var response storemanagement.UserStoreResponse
+	var latitude, longitude *float64
 
 	err = row.Scan(
 		&response.Store.StoreID,
 		&response.Store.StoreName,
 		&response.Store.Tagline,
 		&response.Store.Address,
+		&latitude,
+		&longitude,
 	)
 	if err != nil {
 		return nil, storemanagement.ErrStoreNotFound
 	}
+	if latitude != nil && longitude != nil {
+		response.Store.Location = &storemanagement.Location{Latitude: *latitude, Longitude: *longitude}
+	}
 
 	return &response, nil
 }
@@ -796,7 +1197,7 @@ func (s *Database) StoreCoupons(ctx context.Context, storeID string) (*listing.C
 		return nil, storage.ErrServerError
 	}
 
-	c := s.psql.Select(`coupon_id,store_id,store_name,tagline,address,theme_color,"desc",amount_off,percentage_off,currency_code,qr_code_url,extract(epoch from expired_at),is_text_coupon,text_coupon_code,text_coupon_weburl`).From("coupons").Where("coupon_state=active and store_id =$1").OrderBy("created_at desc")
+	c := s.psql.Select(`coupon_id,store_id,store_name,tagline,address,theme_color,"desc",amount_off,percentage_off,currency_code,qr_code_url,extract(epoch from expired_at),is_text_coupon,text_coupon_code,text_coupon_weburl`).From("coupons").InnerJoin("stores using(store_id)").Where(liveCoupon + " and store_id =$1").OrderBy("created_at desc")
 
 	cStr, _, err := c.ToSql()
 	if err != nil {
@@ -848,9 +1249,8 @@ func (s *Database) CategoryCoupons(ctx context.Context, catName string) (*listin
 		return nil, storage.ErrServerError
 	}
 
-	sub := s.psql.Select(`*`).From("coupon_categories").InnerJoin("categories using(cat_id)").Where(sq.Eq{"cat_name": ""})
-
-	c := s.psql.Select(`coupon_id,store_id,store_name,tagline,address,"desc",amount_off,percentage_off,currency_code,qr_code_url,extract(epoch from expired_at),is_text_coupon,text_coupon_code,text_coupon_weburl`).FromSelect(sub, "inner").InnerJoin("coupons").Where("coupon_state=active").OrderBy("created_at desc")
+	c := s.psql.Select(listedCouponColumns).From("coupons").InnerJoin("stores using(store_id)").InnerJoin(couponCategories).
+		Where(liveCoupon).Where(sq.Eq{"categories.cat_name": ""}).OrderBy("coupons.created_at desc")
 
 	cStr, _, err := c.ToSql()
 	if err != nil {
@@ -899,9 +1299,8 @@ func (s *Database) CategoryCouponsBeforeIDAndTime(ctx context.Context, catName,
 		return nil, storage.ErrServerError
 	}
 
-	sub := s.psql.Select(`*`).From("coupon_categories").InnerJoin("categories using(cat_id)").Where(sq.Eq{"cat_name": ""})
-
-	c := s.psql.Select(`coupon_id,store_id,store_name,tagline,address,"desc",amount_off,percentage_off,currency_code,qr_code_url,extract(epoch from expired_at),is_text_coupon,text_coupon_code,text_coupon_weburl`).FromSelect(sub, "inner").InnerJoin("coupons").Where("coupon_state=active").OrderBy("created_at desc")
+	c := s.psql.Select(listedCouponColumns).From("coupons").InnerJoin("stores using(store_id)").InnerJoin(couponCategories).
+		Where(liveCoupon).Where(sq.Eq{"categories.cat_name": ""}).OrderBy("coupons.created_at desc")
 
 	cStr, _, err := c.ToSql()
 	if err != nil {
@@ -995,83 +1394,102 @@ func (s *Database) CouponState(ctx context.Context, couponid string) (string, er
 	return state, nil
 }
 
-//CreateCoupon create a coupon for the store
+//CreateCoupon create a coupon for the store together with its categories and branches
 func (s *Database) CreateCoupon(ctx context.Context, storeID string, coupon storemanagement.CreateCoupon) (string, error) {
 	conn, err := s.dbPool.Acquire(ctx)
-	defer conn.Release()
 	if err != nil {
+		s.logger.Debug(err.Error())
 		return "", storage.ErrServerError
 	}
-	created_at := time.Now().UnixNano()
-
-	c := s.psql.Insert("coupons").Columns(`"store_id,"desc",state,discount_typw,created_at,expired_at,percentage_off,amount_off,currency_code,is_text_coupon,text_coupon_weburl,max_redemption,unlimited_redemption`).Values(storeID, coupon.Desc, coupon.State, created_at)
+	defer conn.Release()
 
-	cStr, _, err := c.ToSql()
+	tx, err := conn.Begin(ctx)
 	if err != nil {
+		s.logger.Error(err.Error())
 		return "", storage.ErrServerError
 	}
+	defer tx.Rollback(ctx)
 
-	cStr = cStr + "returning id"
-	row := conn.QueryRow(ctx, cStr)
-	var couponid string
-	err = row.Scan(&couponid)
-
+	state := coupon.State
+	if state == "" {
+		state = storemanagement.CouponActive
+	}
+	var couponID string
+	err = tx.QueryRow(ctx, `insert into coupons(store_id,"desc","state",discount_type,created_at,expired_at,
+	percentage_off,amount_off,currency_code,is_text_coupon,text_coupon_code,text_coupon_weburl,
+	max_redemptions,unlimited_redemption,start_at,user_limit,user_limit_period)
+	values($1,$2,$3,$4,now(),to_timestamp($5),nullif($6,0),nullif($7,0),nullif($8,''),$9,nullif($10,''),nullif($11,''),$12,$13,
+	to_timestamp(nullif($14::bigint,0)),$15,nullif($16,''))
+	returning coupon_id::text`,
+		storeID, coupon.Desc, state, coupon.DiscountType, coupon.ExpiringDate,
+		coupon.PercentageOff, coupon.AmountOff, coupon.CurrencyCode, coupon.IsTextCoupon, coupon.TextCouponCode, coupon.TextCouponWebURL,
+		coupon.MaxRedemption, coupon.UnlimitedRedemption, coupon.StartDate,
+		coupon.UserLimit.Count, coupon.UserLimit.Period).Scan(&couponID)
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			fmt.Println(pgErr.Message) // => syntax error at end of input
-			fmt.Println(pgErr.Code)    // => 42601
+		s.logger.Error(err.Error())
+		return "", storemanagement.ErrUnableToCreateCoupon
+	}
+	if len(coupon.Categories) > 0 {
+		_, err = tx.Exec(ctx, `insert into coupon_categories(coupon_id,cat_id)
+		select $1, cat_id from categories where cat_name = any($2)`, couponID, coupon.Categories)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return "", storage.ErrServerError
 		}
+	}
+	err = setCouponWindows(ctx, tx, couponID, coupon.Windows)
+	if err != nil {
 		s.logger.Error(err.Error())
 		return "", storage.ErrServerError
 	}
-
-	return "", nil
-
-}
-
-//DeleteCoupon deletes the coupon from the store
-func (s *Database) DeleteCoupon(ctx context.Context, couponID string, storeID string) error {
-	conn, err := s.dbPool.Acquire(ctx)
-	defer conn.Release()
-	if err != nil {
-		return storage.ErrServerError
+	if len(coupon.Branches) > 0 {
+		err = setCouponBranches(ctx, tx, storeID, couponID, coupon.Branches)
+		if err != nil {
+			if errors.Is(err, storemanagement.ErrBranchNotFound) {
+				return "", err
+			}
+			s.logger.Error(err.Error())
+			return "", storage.ErrServerError
+		}
 	}
 
-	_, err = conn.Exec(ctx, `delete from coupons where coupon_id = $1 and store_id=$2`, couponID, storeID)
+	err = tx.Commit(ctx)
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			fmt.Println(pgErr.Message) // => syntax error at end of input
-			fmt.Println(pgErr.Code)    // => 42601
-		}
 		s.logger.Error(err.Error())
-		return storage.ErrServerError
+		return "", storage.ErrServerError
 	}
-
-	return nil
+	return couponID, nil
 }
 
 //Employees returns a list of employees associated with the store
 func (s *Database) Employees(ctx context.Context, storeID string) (*storemanagement.EmployeesResponse, error) {
 	conn, err := s.dbPool.Acquire(ctx)
-	defer conn.Release()
 	if err != nil {
+		s.logger.Debug(err.Error())
 		return nil, storage.ErrServerError
 	}
+	defer conn.Release()
 	rows, err := conn.Query(ctx, `
-	select fullname,emp_id,emp_state from employees inner join users on emp_id=user_id where store_id =$1 AND emp_state <>'removed`, storeID)
+	select users.email,stores_employees.emp_id,stores_employees.emp_state,
+	coalesce(array_agg(employee_branches.branch_id) filter (where employee_branches.branch_id is not null), '{}')
+	from stores_employees
+	inner join users on stores_employees.user_id = users.user_id
+	left join employee_branches on employee_branches.emp_id = stores_employees.emp_id
+	where stores_employees.store_id = $1 AND stores_employees.emp_state <> 'removed'
+	group by users.email,stores_employees.emp_id,stores_employees.emp_state`, storeID)
 	if err != nil {
 		s.logger.Error(err.Error())
 		return nil, storage.ErrServerError
 	}
-	var response *storemanagement.EmployeesResponse
+	defer rows.Close()
+	response := &storemanagement.EmployeesResponse{}
 	for rows.Next() {
 		var employee storemanagement.Employee
 		err = rows.Scan(
-			&employee.FullName,
+			&employee.Email,
 			&employee.ID,
 			&employee.State,
+			&employee.Branches,
 		)
 		if err != nil {
 			continue
@@ -1082,26 +1500,6 @@ func (s *Database) Employees(ctx context.Context, storeID string) (*storemanagem
 
 }
 
-//AddEmployee register an employee under the given store
-func (s *Database) AddEmployee(ctx context.Context, storeID string, emp storemanagement.Employee) error {
-	conn, err := s.dbPool.Acquire(ctx)
-	defer conn.Release()
-	if err != nil {
-		return storage.ErrServerError
-	}
-
-	token := storage.GenerateUUID()
-	exp := time.Now().UnixNano()
-	_, err = conn.Exec(ctx, `insert into create_employees(email,store_id,token,expired_at)values($1,$2,$3`, emp.Email, storeID, token, exp)
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-
-	return nil
-
-}
-
 //SuspendEmployee suspends the given  store employee
 func (s *Database) SuspendEmployee(ctx context.Context, storeID string, empID string) error {
 	conn, err := s.dbPool.Acquire(ctx)
@@ -1155,12 +1553,57 @@ func (s *Database) ResumeEmployee(ctx context.Context, storeID string, empID str
 	return nil
 }
 
-func (s *Database) EditStore(ctx context.Context, userid string, edit storemanagement.StoreEdit) error {
-	return nil
-}
+//EditStore changes the details of the store that are given in the edit
+func (s *Database) EditStore(ctx context.Context, storeID string, edit storemanagement.StoreEdit) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
 
-//VerifyCoupon verifies the coupon
-func (s *Database) VerifyCoupon(ctx context.Context, emp_id, couponid string) error {
+	c := s.psql.Update("stores").Where(sq.Eq{"store_id": storeID})
+	changed := false
+	if edit.Name != "" {
+		c = c.Set("store_name", edit.Name)
+		changed = true
+	}
+	if edit.Tagline != "" {
+		c = c.Set("tagline", edit.Tagline)
+		changed = true
+	}
+	if edit.Address != "" {
+		c = c.Set("address", edit.Address)
+		changed = true
+	}
+	if edit.Location != nil {
+		c = c.Set("latitude", edit.Location.Latitude).Set("longitude", edit.Location.Longitude)
+		changed = true
+	}
+	if edit.Timezone != "" {
+		c = c.Set("timezone", edit.Timezone)
+		changed = true
+	}
+	if edit.VoidWindow != nil {
+		c = c.Set("void_window_seconds", int(edit.VoidWindow.Seconds()))
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	cStr, args, err := c.ToSql()
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	tag, err := conn.Exec(ctx, cStr, args...)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return storemanagement.ErrStoreNotFound
+	}
 	return nil
 }
 
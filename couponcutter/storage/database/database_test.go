@@ -0,0 +1,33 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDatabase_TokensValidAfter(t *testing.T) {
+	s := testDatabase(t)
+	ctx := context.Background()
+
+	validAfter, err := s.TokensValidAfter(ctx, "shopper")
+	if err != nil {
+		t.Fatalf("TokensValidAfter() error = %v", err)
+	}
+	if validAfter.Unix() != 0 {
+		t.Errorf("TokensValidAfter() = %v without a reset, want the epoch", validAfter)
+	}
+
+	before := time.Now()
+	_, err = s.dbPool.Exec(ctx, `update users set tokens_valid_after = now() where user_id = 'shopper'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validAfter, err = s.TokensValidAfter(ctx, "shopper")
+	if err != nil {
+		t.Fatalf("TokensValidAfter() error = %v", err)
+	}
+	if d := validAfter.Sub(before); d < -time.Minute || d > time.Minute {
+		t.Errorf("TokensValidAfter() = %v, want about %v whatever the session time zone", validAfter, before)
+	}
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"couponcutter/storage"
+	"couponcutter/storemanagement"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//ClaimIdempotencyKey stores the record unless its key is held by an unexpired record of the store,
+//an expired record is taken over. The record holding the key is returned when it is not claimed
+func (s *Database) ClaimIdempotencyKey(ctx context.Context, record storemanagement.IdempotencyRecord) (*storemanagement.IdempotencyRecord, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `insert into idempotency_keys(store_id,idem_key,fingerprint,created_at,expired_at)
+	values($1,$2,$3,$4,$5)
+	on conflict (store_id,idem_key) do update
+	set fingerprint = excluded.fingerprint, outcome = null, created_at = excluded.created_at, expired_at = excluded.expired_at
+	where idempotency_keys.expired_at <= excluded.created_at`,
+		record.StoreID, record.Key, record.Fingerprint, record.CreatedAt, record.ExpiredAt)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	var held storemanagement.IdempotencyRecord
+	err = conn.QueryRow(ctx, `select store_id,idem_key,fingerprint,coalesce(outcome,''),created_at,expired_at
+	from idempotency_keys where store_id = $1 and idem_key = $2`, record.StoreID, record.Key).Scan(
+		&held.StoreID, &held.Key, &held.Fingerprint, &held.Outcome, &held.CreatedAt, &held.ExpiredAt,
+	)
+	if err != nil {
+		//the key was released meanwhile, the retry can try again
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storemanagement.ErrRedemptionInProgress
+		}
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	return &held, nil
+}
+
+//CompleteIdempotencyKey records the outcome of the redemption holding the key, kept until expiredAt
+func (s *Database) CompleteIdempotencyKey(ctx context.Context, storeID string, key string, outcome string, expiredAt time.Time) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `update idempotency_keys set outcome = $3, expired_at = $4 where store_id = $1 and idem_key = $2`,
+		storeID, key, outcome, expiredAt)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//ReleaseIdempotencyKey removes the key of a redemption whose outcome is not kept
+func (s *Database) ReleaseIdempotencyKey(ctx context.Context, storeID string, key string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `delete from idempotency_keys where store_id = $1 and idem_key = $2 and outcome is null`, storeID, key)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//DeleteExpiredIdempotencyKeys removes the idempotency keys whose retention has ended
+func (s *Database) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return 0, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `delete from idempotency_keys where expired_at <= now()`)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return 0, storage.ErrServerError
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,259 @@
+package database
+
+import (
+	"context"
+	"couponcutter/storage"
+	"couponcutter/storemanagement"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//CreateInvite stores the hash of an invite token for the email and returns the id of the invite
+func (s *Database) CreateInvite(ctx context.Context, storeID string, email string, tokenHash string, expiredAt time.Time) (string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var id int64
+	err = conn.QueryRow(ctx, `insert into create_employees(store_id,email,token,expired_at) values($1,$2,$3,$4) returning emp_id`,
+		storeID, email, tokenHash, expiredAt).Scan(&id)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+//Invites returns the invites of the store that are neither accepted, revoked nor expired
+func (s *Database) Invites(ctx context.Context, storeID string) ([]storemanagement.Invite, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `select emp_id,email,created_at,expired_at from create_employees
+	where store_id = $1 and revoked = false and accepted_at is null and expired_at > now()
+	order by created_at desc`, storeID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer rows.Close()
+
+	invites := []storemanagement.Invite{}
+	for rows.Next() {
+		var id int64
+		invite := storemanagement.Invite{StoreID: storeID}
+		err = rows.Scan(&id, &invite.Email, &invite.CreatedAt, &invite.ExpiredAt)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return nil, storage.ErrServerError
+		}
+		invite.ID = strconv.FormatInt(id, 10)
+		invites = append(invites, invite)
+	}
+	if rows.Err() != nil {
+		s.logger.Error(rows.Err().Error())
+		return nil, storage.ErrServerError
+	}
+	return invites, nil
+}
+
+//RevokeInvite stops a pending invite of the store from being accepted
+func (s *Database) RevokeInvite(ctx context.Context, storeID string, inviteID string) error {
+	id, err := strconv.ParseInt(inviteID, 10, 64)
+	if err != nil {
+		return storemanagement.ErrInvalidInvite
+	}
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `update create_employees set revoked = true
+	where emp_id = $1 and store_id = $2 and revoked = false and accepted_at is null`, id, storeID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return storemanagement.ErrInvalidInvite
+	}
+	return nil
+}
+
+//InviteWithToken returns the pending invite with the token hash
+func (s *Database) InviteWithToken(ctx context.Context, tokenHash string) (*storemanagement.Invite, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var id int64
+	invite := &storemanagement.Invite{}
+	err = conn.QueryRow(ctx, `select emp_id,store_id,email,created_at,expired_at from create_employees
+	where token = $1 and revoked = false and accepted_at is null and expired_at > now()`, tokenHash).Scan(
+		&id, &invite.StoreID, &invite.Email, &invite.CreatedAt, &invite.ExpiredAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storemanagement.ErrInvalidInvite
+		}
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	invite.ID = strconv.FormatInt(id, 10)
+	return invite, nil
+}
+
+//AcceptInvite consumes the invite and makes the user an active employee of its store,
+//an employee who was removed earlier is reinstated under the same employee id
+func (s *Database) AcceptInvite(ctx context.Context, tokenHash string, userID string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	inviteID, storeID, inviteEmail, err := s.lockInvite(ctx, tx, tokenHash)
+	if err != nil {
+		return err
+	}
+
+	var userEmail string
+	err = tx.QueryRow(ctx, `select email from users where user_id = $1`, userID).Scan(&userEmail)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storemanagement.ErrInvalidInvite
+		}
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if !strings.EqualFold(userEmail, inviteEmail) {
+		return storemanagement.ErrInviteEmailMismatch
+	}
+
+	err = s.employInvitee(ctx, tx, inviteID, storeID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//AcceptInviteAsNewUser creates a user with the email of the invite and makes them an active
+//employee of its store in the same transaction, returning the id of the user
+func (s *Database) AcceptInviteAsNewUser(ctx context.Context, tokenHash string, password string) (string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	inviteID, storeID, inviteEmail, err := s.lockInvite(ctx, tx, tokenHash)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := storage.HashPassword(password)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	userID := storage.GenerateUUID()
+	tag, err := tx.Exec(ctx, `insert into users(user_id,email,password_hash,created_at) values($1,$2,$3,now()) on conflict (email) do nothing`,
+		userID, inviteEmail, hash)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return "", storemanagement.ErrInviteeRegistered
+	}
+
+	err = s.employInvitee(ctx, tx, inviteID, storeID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return userID, nil
+}
+
+//lockInvite locks the pending invite with the token hash for the rest of the transaction
+func (s *Database) lockInvite(ctx context.Context, tx pgx.Tx, tokenHash string) (int64, string, string, error) {
+	var inviteID int64
+	var storeID, inviteEmail string
+	err := tx.QueryRow(ctx, `select emp_id,store_id,email from create_employees
+	where token = $1 and revoked = false and accepted_at is null and expired_at > now() for update`, tokenHash).Scan(
+		&inviteID, &storeID, &inviteEmail)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, "", "", storemanagement.ErrInvalidInvite
+		}
+		s.logger.Error(err.Error())
+		return 0, "", "", storage.ErrServerError
+	}
+	return inviteID, storeID, inviteEmail, nil
+}
+
+//employInvitee marks the invite accepted by the user and makes the user an active employee of the store
+func (s *Database) employInvitee(ctx context.Context, tx pgx.Tx, inviteID int64, storeID string, userID string) error {
+	_, err := tx.Exec(ctx, `update create_employees set accepted_at = now(), accepted_by = $1 where emp_id = $2`, userID, inviteID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+
+	tag, err := tx.Exec(ctx, `update stores_employees set emp_state = 'active' where store_id = $1 and user_id = $2`, storeID, userID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		_, err = tx.Exec(ctx, `insert into stores_employees(emp_id,store_id,created_at,user_id,emp_state) values($1,$2,now(),$3,'active')`,
+			storage.GenerateUUID(), storeID, userID)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return storage.ErrServerError
+		}
+	}
+	return nil
+}
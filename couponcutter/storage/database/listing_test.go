@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"couponcutter/listing"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+//listingData adds a live food coupon, a scheduled one that has started and food coupons that
+//are paused, not started yet, outside of their validity window and about to expire, every one
+//of them redeemed once
+const listingData = `
+insert into categories(cat_name) values('food');
+insert into coupons(store_id,"desc",expired_at) values('store','live',now() + interval '1 day');
+insert into coupons(store_id,"desc","state",expired_at) values('store','paused','paused',now() + interval '1 day');
+insert into coupons(store_id,"desc",start_at,expired_at) values('store','not started',now() + interval '1 hour',now() + interval '1 day');
+insert into coupons(store_id,"desc","state",start_at,expired_at)
+values('store','scheduled','scheduled',now() - interval '1 minute',now() + interval '1 day');
+insert into coupons(store_id,"desc","state",start_at,expired_at)
+values('store','scheduled later','scheduled',now() + interval '1 hour',now() + interval '1 day');
+insert into coupons(store_id,"desc",expired_at) values('store','out of window',now() + interval '1 day');
+insert into coupons(store_id,"desc",expired_at) values('store','expired since',now() + interval '1 day');
+insert into coupon_windows(coupon_id,weekday,start_minute,end_minute)
+select coupon_id,(extract(dow from now() at time zone 'UTC')::integer + 1) % 7,0,1440 from coupons where "desc" = 'out of window';
+insert into coupon_categories(coupon_id,cat_id) select coupons.coupon_id::text,categories.cat_id from coupons,categories;
+insert into redeemed_coupons(coupon_id,redeemed_when) select coupon_id::text,now() from coupons;
+create materialized view popular_coupons as
+select coupons.coupon_id,count(redeemed_coupons.id) as redeemed_count from coupons
+inner join redeemed_coupons on redeemed_coupons.coupon_id = coupons.coupon_id::text group by coupons.coupon_id;
+`
+
+func couponDescs(t *testing.T, response *listing.CouponListResponse, err error) []string {
+	if err != nil {
+		t.Fatalf("listing error = %v", err)
+	}
+	descs := []string{}
+	for _, coupon := range response.Coupons {
+		descs = append(descs, coupon.Desc)
+	}
+	sort.Strings(descs)
+	return descs
+}
+
+func TestDatabase_ListingsHideCouponsThatAreNotLive(t *testing.T) {
+	s := testDatabase(t)
+	ctx := context.Background()
+	_, err := s.dbPool.Exec(ctx, listingData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the coupon was live when the view was built
+	_, err = s.dbPool.Exec(ctx, `update coupons set expired_at = now() - interval '1 second' where "desc" = 'expired since'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"live", "scheduled"}
+
+	response, err := s.CategoryCoupons(ctx, "food")
+	if got := couponDescs(t, response, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("CategoryCoupons() = %v, want %v", got, want)
+	}
+	response, err = s.LatestCouponsWithFiltering(ctx, "food")
+	if got := couponDescs(t, response, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("LatestCouponsWithFiltering() = %v, want %v", got, want)
+	}
+	response, err = s.PopularCoupons(ctx)
+	if got := couponDescs(t, response, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("PopularCoupons() = %v, want %v", got, want)
+	}
+	response, err = s.PopularCouponsWithFiltering(ctx, "food")
+	if got := couponDescs(t, response, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("PopularCouponsWithFiltering() = %v, want %v", got, want)
+	}
+
+	var notStarted string
+	err = s.dbPool.QueryRow(ctx, `select coupon_id::text from coupons where "desc" = 'not started'`).Scan(&notStarted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.SingleCoupon(ctx, notStarted)
+	if !errors.Is(err, listing.ErrCouponNotFound) {
+		t.Errorf("SingleCoupon() of a coupon that has not started error = %v, want %v", err, listing.ErrCouponNotFound)
+	}
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"couponcutter/authetication"
+	"couponcutter/storage"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//CreateMagicLink stores the hash of a one-time login link of the user
+func (s *Database) CreateMagicLink(ctx context.Context, userID string, tokenHash string, expiredAt time.Time) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `insert into magic_links(user_id,token_hash,expired_at,created_at)values($1,$2,$3,now())`, userID, tokenHash, expiredAt)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//UseMagicLink consumes the unexpired magic link and returns its user, a link can only be used once
+func (s *Database) UseMagicLink(ctx context.Context, tokenHash string) (string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var userID string
+	err = conn.QueryRow(ctx, `update magic_links set used_at = now()
+	where token_hash = $1 and used_at is null and expired_at > now() returning user_id`, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", authetication.ErrInvalidMagicLink
+		}
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return userID, nil
+}
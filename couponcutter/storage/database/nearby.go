@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"couponcutter/listing"
+	"couponcutter/storage"
+)
+
+//NearbyCoupons narrows the stores down to the bounding box of the circle through the location
+//index, then keeps the coupons of those within the radius by their great circle distance
+func (s *Database) NearbyCoupons(ctx context.Context, lat, lng, radius float64, limit int) ([]listing.NearbyCoupon, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	minLat, maxLat, minLng, maxLng := listing.BoundingBox(lat, lng, radius)
+	rows, err := conn.Query(ctx, `select * from (
+		select coupons.coupon_id,stores.store_id,stores.store_name,stores.tagline,coalesce(stores.address,''),
+		coalesce(stores.theme_color,0),stores.latitude,stores.longitude,coupons."desc",
+		coalesce(coupons.amount_off,0)::float8,coalesce(coupons.percentage_off,0)::float8,coalesce(coupons.currency_code,''),
+		coalesce(coupons.qr_code_url,''),extract(epoch from coupons.expired_at)::integer,coupons.is_text_coupon,
+		coalesce(coupons.text_coupon_code,''),coalesce(coupons.text_coupon_weburl,''),coupons.discount_type,
+		2 * 6371000 * asin(least(1, sqrt(
+			power(sin(radians(stores.latitude - $1) / 2), 2)
+			+ cos(radians($1)) * cos(radians(stores.latitude)) * power(sin(radians(stores.longitude - $2) / 2), 2)
+		))) as distance
+		from coupons inner join stores using(store_id)
+		where `+liveCoupon+` and stores.store_state = 'active'
+		and stores.latitude between $3 and $4 and stores.longitude between $5 and $6
+	) as nearby
+	where distance <= $7
+	order by distance, coupon_id
+	limit $8`, lat, lng, minLat, maxLat, minLng, maxLng, radius, limit)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer rows.Close()
+
+	coupons := make([]listing.NearbyCoupon, 0, limit)
+	for rows.Next() {
+		var nearby listing.NearbyCoupon
+		coupon := &nearby.Coupon
+		err = rows.Scan(
+			&coupon.CouponID,
+			&coupon.Store.StoreID,
+			&coupon.Store.StoreName,
+			&coupon.Store.Tagline,
+			&coupon.Store.Address,
+			&coupon.Store.ThemeColor,
+			&coupon.Store.Latitude,
+			&coupon.Store.Longitude,
+			&coupon.Desc,
+			&coupon.AmountOff,
+			&coupon.PercentageOff,
+			&coupon.CurrencyCode,
+			&coupon.QrCodeURL,
+			&coupon.ExpiringDate,
+			&coupon.IsTextCoupon,
+			&coupon.TextCouponCode,
+			&coupon.TextCouponWebURL,
+			&coupon.DiscountType,
+			&nearby.Distance,
+		)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return nil, storage.ErrServerError
+		}
+		coupons = append(coupons, nearby)
+	}
+	if rows.Err() != nil {
+		s.logger.Error(rows.Err().Error())
+		return nil, storage.ErrServerError
+	}
+	return coupons, nil
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"couponcutter/authetication"
+	"couponcutter/storage"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//CreateOIDCState stores the state of a login started with an identity provider
+func (s *Database) CreateOIDCState(ctx context.Context, stateHash string, state authetication.OIDCState) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `insert into oidc_states(state_hash,provider,code_verifier,nonce,expired_at) values($1,$2,$3,$4,$5)`,
+		stateHash, state.Provider, state.CodeVerifier, state.Nonce, state.ExpiredAt)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//UseOIDCState marks the state as used and returns it, a state can only be used once
+func (s *Database) UseOIDCState(ctx context.Context, stateHash string) (*authetication.OIDCState, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	state := &authetication.OIDCState{}
+	err = conn.QueryRow(ctx, `update oidc_states set used_at = now() where state_hash = $1 and used_at is null
+	returning provider,code_verifier,nonce,expired_at`, stateHash).Scan(
+		&state.Provider, &state.CodeVerifier, &state.Nonce, &state.ExpiredAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, authetication.ErrInvalidOIDCState
+		}
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	return state, nil
+}
+
+//UserWithOIDCIdentity returns the user linked to the subject of the identity provider
+func (s *Database) UserWithOIDCIdentity(ctx context.Context, provider string, subject string) (string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var userID string
+	err = conn.QueryRow(ctx, `select user_id from user_identities where provider = $1 and subject = $2`, provider, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", authetication.ErrIdentityDoesNotExists
+		}
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return userID, nil
+}
+
+//LinkOIDCIdentity links the subject of the identity provider to the user
+func (s *Database) LinkOIDCIdentity(ctx context.Context, userID string, provider string, subject string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `insert into user_identities(provider,subject,user_id,created_at) values($1,$2,$3,now())
+	on conflict (provider,subject) do nothing`, provider, subject, userID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
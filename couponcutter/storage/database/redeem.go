@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"couponcutter/storage"
+	"couponcutter/storemanagement"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//VerifyCoupon redeems the coupon in a transaction holding the coupon row, so concurrent
+//redemptions of a limited coupon are checked one after the other. The redemption is recorded,
+//the count of the coupon incremented and the coupon marked used once its limit is reached.
+//Redemption times are stored in UTC, the employee is left out when the store owner redeems it and
+//the API key is kept when a terminal does
+func (s *Database) VerifyCoupon(ctx context.Context, redemption storemanagement.Redemption) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	var state string
+	var expired, started, unlimited bool
+	var max, count, userLimit uint
+	var since time.Time
+	err = tx.QueryRow(ctx, `select coupons."state",coupons.expired_at <= now(),coalesce(coupons.start_at <= now(),false),coupons.unlimited_redemption,
+	coalesce(coupons.max_redemptions,0),coupons.redemption_count,coupons.user_limit,
+	coalesce(date_trunc(coupons.user_limit_period, now() at time zone stores.timezone) at time zone stores.timezone,
+	'epoch'::timestamptz)
+	from coupons inner join stores using(store_id)
+	where coupons.store_id = $1 and coupons.coupon_id::text = $2
+	for update of coupons`, redemption.StoreID, redemption.CouponID).Scan(
+		&state, &expired, &started, &unlimited, &max, &count, &userLimit, &since,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storemanagement.ErrCouponNotValid
+		}
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	switch {
+	case state == storemanagement.CouponUsed:
+		return storemanagement.ErrCouponIsUsed
+	case state == storemanagement.CouponScheduled && !started:
+		return storemanagement.ErrCouponNotStarted
+	case (state != storemanagement.CouponActive && state != storemanagement.CouponScheduled) || expired:
+		return storemanagement.ErrCouponNotValid
+	case !unlimited && count >= max:
+		return storemanagement.ErrCouponLimitExceeded
+	}
+	if userLimit > 0 {
+		if redemption.ShopperID == "" {
+			return storemanagement.ErrShopperRequired
+		}
+		var redeemed uint
+		err = tx.QueryRow(ctx, `select count(*) from redeemed_coupons
+		where coupon_id = $1 and redeemed_for = $2 and redeemed_when >= $3`,
+			redemption.CouponID, redemption.ShopperID, since).Scan(&redeemed)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return storage.ErrServerError
+		}
+		if redeemed >= userLimit {
+			return storemanagement.ErrCouponUserLimitReached
+		}
+	}
+
+	_, err = tx.Exec(ctx, `insert into redeemed_coupons(coupon_id,redeemed_by,redeemed_by_key,branch_id,redeemed_for,redeemed_when)
+	values($1,(select emp_id from stores_employees where store_id = $2 and user_id = $3),nullif($4,''),nullif($5,''),nullif($6,''),$7)`,
+		redemption.CouponID, redemption.StoreID, redemption.RedeemedBy, redemption.APIKeyID, redemption.BranchID, redemption.ShopperID,
+		redemption.RedeemedAt)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	_, err = tx.Exec(ctx, `update coupons set redemption_count = redemption_count + 1,
+	"state" = case when not unlimited_redemption and redemption_count + 1 >= max_redemptions then 'used' else "state" end
+	where coupon_id::text = $1`, redemption.CouponID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package database
+
+import (
+	"context"
+	"couponcutter/storemanagement"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+//testSchema holds the columns of db.sql the tokens, redemptions, suggestions and listings use
+const testSchema = `
+create table users(user_id text primary key, tokens_valid_after timestamptz null);
+create table stores(
+	store_id text primary key,
+	store_name text not null default '',
+	store_state text not null default 'active',
+	tagline text not null default '',
+	"address" text null,
+	theme_color integer,
+	timezone text not null default 'UTC'
+);
+create table stores_employees(emp_id text primary key, store_id text references stores(store_id), user_id text references users(user_id));
+create table coupons(
+	coupon_id integer primary key generated always as identity,
+	store_id text references stores(store_id) not null,
+	"desc" text not null default '',
+	"state" text not null default 'active',
+	amount_off numeric,
+	percentage_off numeric,
+	currency_code char(3),
+	qr_code_url text null,
+	expired_at timestamptz not null,
+	created_at timestamptz not null default now(),
+	start_at timestamptz null,
+	is_text_coupon boolean not null default false,
+	text_coupon_code text unique,
+	text_coupon_weburl text,
+	unlimited_redemption boolean not null default true,
+	max_redemptions integer,
+	redemption_count integer not null default 0,
+	user_limit integer not null default 0,
+	user_limit_period text null,
+	check(unlimited_redemption or redemption_count <= max_redemptions)
+);
+create table redeemed_coupons(
+	id integer primary key generated always as identity,
+	coupon_id text not null,
+	redeemed_by text references stores_employees(emp_id),
+	redeemed_by_key text null,
+	branch_id text null,
+	redeemed_for text null references users(user_id),
+	redeemed_when timestamptz not null
+);
+create table coupon_windows(
+	coupon_id integer references coupons(coupon_id),
+	weekday smallint not null,
+	start_minute smallint not null,
+	end_minute smallint not null
+);
+create table categories(cat_id integer primary key generated always as identity, cat_name text not null unique);
+create table coupon_categories(coupon_id text not null, cat_id integer references categories(cat_id));
+insert into users(user_id) values('cashier1'),('cashier2'),('shopper');
+insert into stores(store_id) values('store');
+insert into stores_employees(emp_id,store_id,user_id) values('e1','store','cashier1'),('e2','store','cashier2');
+`
+
+//testDatabase connects to the disposable database of COUPONCUTTER_TEST_DATABASE in a schema
+//of its own, dropped once the test is over
+func testDatabase(t *testing.T) *Database {
+	connStr := os.Getenv("COUPONCUTTER_TEST_DATABASE")
+	if connStr == "" {
+		t.Skip("COUPONCUTTER_TEST_DATABASE is not set")
+	}
+	ctx := context.Background()
+	schema := fmt.Sprintf("verify_test_%d", time.Now().UnixNano())
+
+	config, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.MaxConns = 20
+	//public holds the extensions such as pg_trgm
+	config.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	//a session away from UTC catches any time stored without its zone
+	config.ConnConfig.RuntimeParams["timezone"] = "America/New_York"
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pool.Exec(ctx, "create schema "+schema)
+	if err != nil {
+		pool.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pool.Exec(ctx, "drop schema "+schema+" cascade")
+		pool.Close()
+	})
+	_, err = pool.Exec(ctx, testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Database{
+		dbPool: pool,
+		logger: hclog.NewNullLogger(),
+		psql:   sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+	}
+}
+
+//redeemConcurrently redeems the coupon from many goroutines at once and counts the outcomes
+func redeemConcurrently(t *testing.T, s *Database, couponID string, shopperID string, n int) map[error]int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	outcomes := make(map[error]int)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			err := s.VerifyCoupon(context.Background(), storemanagement.Redemption{
+				CouponID:   couponID,
+				StoreID:    "store",
+				RedeemedBy: fmt.Sprintf("cashier%d", i%2+1),
+				ShopperID:  shopperID,
+				RedeemedAt: time.Now(),
+			})
+			mu.Lock()
+			outcomes[err]++
+			mu.Unlock()
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return outcomes
+}
+
+func TestDatabase_VerifyCouponConcurrently(t *testing.T) {
+	s := testDatabase(t)
+	ctx := context.Background()
+
+	var couponID string
+	err := s.dbPool.QueryRow(ctx, `insert into coupons(store_id,expired_at,unlimited_redemption,max_redemptions)
+	values('store',now() + interval '1 day',false,10) returning coupon_id::text`).Scan(&couponID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outcomes := redeemConcurrently(t, s, couponID, "", 50)
+	if outcomes[nil] != 10 {
+		t.Errorf("VerifyCoupon() succeeded %d times, want 10: %v", outcomes[nil], outcomes)
+	}
+	if outcomes[nil]+outcomes[storemanagement.ErrCouponIsUsed]+outcomes[storemanagement.ErrCouponLimitExceeded] != 50 {
+		t.Errorf("VerifyCoupon() failed unexpectedly: %v", outcomes)
+	}
+
+	var state string
+	var count, redeemed int
+	err = s.dbPool.QueryRow(ctx, `select "state",redemption_count,
+	(select count(*) from redeemed_coupons where coupon_id = $1)
+	from coupons where coupon_id::text = $1`, couponID).Scan(&state, &count, &redeemed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != storemanagement.CouponUsed || count != 10 || redeemed != 10 {
+		t.Errorf("coupon is %s with %d redemptions counted and %d recorded, want used with 10", state, count, redeemed)
+	}
+	err = s.VerifyCoupon(ctx, storemanagement.Redemption{CouponID: couponID, StoreID: "store", RedeemedBy: "cashier1", RedeemedAt: time.Now()})
+	if !errors.Is(err, storemanagement.ErrCouponIsUsed) {
+		t.Errorf("VerifyCoupon() of an exhausted coupon error = %v, want %v", err, storemanagement.ErrCouponIsUsed)
+	}
+}
+
+func TestDatabase_VerifyCouponUserLimitConcurrently(t *testing.T) {
+	s := testDatabase(t)
+	ctx := context.Background()
+
+	var couponID string
+	err := s.dbPool.QueryRow(ctx, `insert into coupons(store_id,expired_at,user_limit,user_limit_period)
+	values('store',now() + interval '1 day',2,'day') returning coupon_id::text`).Scan(&couponID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outcomes := redeemConcurrently(t, s, couponID, "shopper", 20)
+	if outcomes[nil] != 2 || outcomes[storemanagement.ErrCouponUserLimitReached] != 18 {
+		t.Errorf("VerifyCoupon() outcomes %v, want 2 redemptions and 18 refusals", outcomes)
+	}
+}
+
+func TestDatabase_VerifyScheduledCoupon(t *testing.T) {
+	s := testDatabase(t)
+	ctx := context.Background()
+
+	var started, later string
+	err := s.dbPool.QueryRow(ctx, `insert into coupons(store_id,"state",start_at,expired_at)
+	values('store','scheduled',now() - interval '1 minute',now() + interval '1 day') returning coupon_id::text`).Scan(&started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.dbPool.QueryRow(ctx, `insert into coupons(store_id,"state",start_at,expired_at)
+	values('store','scheduled',now() + interval '1 hour',now() + interval '1 day') returning coupon_id::text`).Scan(&later)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		couponID string
+		wantErr  error
+	}{
+		{name: "started", couponID: started},
+		{name: "not started", couponID: later, wantErr: storemanagement.ErrCouponNotStarted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.VerifyCoupon(ctx, storemanagement.Redemption{
+				CouponID:   tt.couponID,
+				StoreID:    "store",
+				RedeemedBy: "cashier1",
+				RedeemedAt: time.Now(),
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyCoupon() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"couponcutter/storemanagement"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//liveCoupon keeps the coupons shoppers can see and redeem now: active or scheduled, started,
+//not expired and, for those with validity windows, within one of them in the timezone of the
+//store. A scheduled coupon goes live at its start date. The coupons must be joined with their stores
+const liveCoupon = `(coupons."state" = 'active' or (coupons."state" = 'scheduled' and coupons.start_at is not null))
+	and coupons.expired_at > now()
+	and (coupons.start_at is null or coupons.start_at <= now())
+	and (not exists (select 1 from coupon_windows where coupon_windows.coupon_id = coupons.coupon_id)
+	or exists (select 1 from coupon_windows where coupon_windows.coupon_id = coupons.coupon_id
+		and coupon_windows.weekday = extract(dow from now() at time zone stores.timezone)
+		and extract(hour from now() at time zone stores.timezone) * 60 + extract(minute from now() at time zone stores.timezone)
+		>= coupon_windows.start_minute
+		and extract(hour from now() at time zone stores.timezone) * 60 + extract(minute from now() at time zone stores.timezone)
+		< coupon_windows.end_minute))`
+
+//setCouponWindows replaces the validity windows of the coupon within the transaction,
+//a row is stored for every day of a window
+func setCouponWindows(ctx context.Context, tx pgx.Tx, couponID string, windows []storemanagement.ValidityWindow) error {
+	_, err := tx.Exec(ctx, `delete from coupon_windows where coupon_id::text = $1`, couponID)
+	if err != nil {
+		return err
+	}
+	var days, starts, ends []int16
+	for _, w := range windows {
+		start, end, err := w.Minutes()
+		if err != nil {
+			return err
+		}
+		for _, day := range w.Days {
+			days = append(days, int16(day))
+			starts = append(starts, int16(start))
+			ends = append(ends, int16(end))
+		}
+	}
+	if len(days) == 0 {
+		return nil
+	}
+	_, err = tx.Exec(ctx, `insert into coupon_windows(coupon_id,weekday,start_minute,end_minute)
+	select coupons.coupon_id, unnest($2::smallint[]), unnest($3::smallint[]), unnest($4::smallint[])
+	from coupons where coupons.coupon_id::text = $1
+	on conflict do nothing`, couponID, days, starts, ends)
+	return err
+}
+
+//couponWindows returns the validity windows of the coupon, the days sharing the same times are
+//put back together in a window
+func couponWindows(ctx context.Context, conn *pgx.Conn, couponID string) ([]storemanagement.ValidityWindow, error) {
+	rows, err := conn.Query(ctx, `select start_minute,end_minute,array_agg(weekday order by weekday)
+	from coupon_windows where coupon_id::text = $1
+	group by start_minute,end_minute order by start_minute,end_minute`, couponID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var windows []storemanagement.ValidityWindow
+	for rows.Next() {
+		var start, end int16
+		var days []int16
+		err = rows.Scan(&start, &end, &days)
+		if err != nil {
+			return nil, err
+		}
+		w := storemanagement.ValidityWindow{Start: clock(start), End: clock(end)}
+		for _, day := range days {
+			w.Days = append(w.Days, time.Weekday(day))
+		}
+		windows = append(windows, w)
+	}
+	return windows, rows.Err()
+}
+
+//clock formats minutes since midnight as HH:MM
+func clock(minutes int16) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
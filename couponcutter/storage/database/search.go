@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"couponcutter/listing"
+	"couponcutter/storage"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+//headlineOptions makes ts_headline mark the matched words the way the listing snippets expect
+const headlineOptions = "StartSel=" + listing.SnippetStart + ", StopSel=" + listing.SnippetStop + ", MinWords=8, MaxWords=25"
+
+//escapedDesc is the description of the coupon HTML escaped as html.EscapeString does, the
+//markup a store puts in it shows as text in the snippet
+const escapedDesc = `replace(replace(replace(replace(replace(coupons."desc",'&','&amp;'),'<','&lt;'),'>','&gt;'),'"','&#34;'),'''','&#39;')`
+
+//SearchCoupons ranks the active coupons whose description matches the term through the
+//full text index on coupons.tsv, or whose store name contains the words of the term.
+//A store name match weighs more than a description match
+func (s *Database) SearchCoupons(ctx context.Context, query listing.SearchQuery, after *listing.SearchCursor, limit int) ([]listing.SearchResult, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	matches := s.psql.Select(`coupons.coupon_id,stores.store_id,stores.store_name,stores.tagline,coalesce(stores.address,''),
+	coalesce(stores.theme_color,0),coupons."desc",coalesce(coupons.amount_off,0)::float8,coalesce(coupons.percentage_off,0)::float8,
+	coalesce(coupons.currency_code,''),coalesce(coupons.qr_code_url,''),extract(epoch from coupons.expired_at)::integer,
+	coupons.is_text_coupon,coalesce(coupons.text_coupon_code,''),coalesce(coupons.text_coupon_weburl,''),coupons.discount_type`).
+		Column(sq.Expr(`(ts_rank_cd(coupons.tsv, websearch_to_tsquery('english', ?))
+		+ 2 * ts_rank_cd(to_tsvector('simple', stores.store_name), plainto_tsquery('simple', ?)))::float8 as rank`, query.Term, query.Term)).
+		Column(sq.Expr(`ts_headline('english', `+escapedDesc+`, websearch_to_tsquery('english', ?), ?) as snippet`, query.Term, headlineOptions)).
+		From("coupons").
+		InnerJoin("stores using(store_id)").
+		Where(liveCoupon).
+		Where(sq.Expr(`(coupons.tsv @@ websearch_to_tsquery('english', ?)
+		or to_tsvector('simple', stores.store_name) @@ plainto_tsquery('simple', ?))`, query.Term, query.Term))
+	if len(query.Categories) > 0 {
+		matches = matches.Where(sq.Expr(`coupons.coupon_id::text in (select coupon_categories.coupon_id from coupon_categories
+		inner join categories using(cat_id) where categories.cat_name = any(?))`, query.Categories))
+	}
+	if query.DiscountType != "" {
+		matches = matches.Where(sq.Eq{"coupons.discount_type": query.DiscountType})
+	}
+
+	c := s.psql.Select("*").FromSelect(matches, "matches").OrderBy("rank desc", "coupon_id").Limit(uint64(limit))
+	if after != nil {
+		c = c.Where("(rank < ? or (rank = ? and coupon_id > ?))", after.Rank, after.Rank, after.CouponID)
+	}
+	cStr, args, err := c.ToSql()
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	rows, err := conn.Query(ctx, cStr, args...)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer rows.Close()
+
+	results := make([]listing.SearchResult, 0, limit)
+	for rows.Next() {
+		var result listing.SearchResult
+		coupon := &result.Coupon
+		err = rows.Scan(
+			&coupon.CouponID,
+			&coupon.Store.StoreID,
+			&coupon.Store.StoreName,
+			&coupon.Store.Tagline,
+			&coupon.Store.Address,
+			&coupon.Store.ThemeColor,
+			&coupon.Desc,
+			&coupon.AmountOff,
+			&coupon.PercentageOff,
+			&coupon.CurrencyCode,
+			&coupon.QrCodeURL,
+			&coupon.ExpiringDate,
+			&coupon.IsTextCoupon,
+			&coupon.TextCouponCode,
+			&coupon.TextCouponWebURL,
+			&coupon.DiscountType,
+			&result.Rank,
+			&result.Snippet,
+		)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return nil, storage.ErrServerError
+		}
+		results = append(results, result)
+	}
+	if rows.Err() != nil {
+		s.logger.Error(rows.Err().Error())
+		return nil, storage.ErrServerError
+	}
+	return results, nil
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"couponcutter/listing"
+	"couponcutter/storage"
+	"strings"
+)
+
+//likeEscaper escapes the wildcards of a like pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+//Suggestions matches the term against the start of the store names, categories and coupon
+//descriptions or any of their words, and through pg_trgm word similarity for misspelled terms.
+//Prefix matches come first, then the most redeemed
+func (s *Database) Suggestions(ctx context.Context, term string, limit int) ([]listing.Suggestion, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	escaped := likeEscaper.Replace(term)
+	prefix := escaped + "%"
+	wordPrefix := "% " + escaped + "%"
+	rows, err := conn.Query(ctx, `select kind,id,text,popularity from (
+		select 'store' as kind, stores.store_id as id, stores.store_name as text,
+		(select count(*) from redeemed_coupons inner join coupons on coupons.coupon_id::text = redeemed_coupons.coupon_id
+		where coupons.store_id = stores.store_id) as popularity,
+		stores.store_name ilike $2 or stores.store_name ilike $3 as prefixed,
+		word_similarity($1, stores.store_name) as closeness
+		from stores where stores.store_state = 'active'
+		and (stores.store_name ilike $2 or stores.store_name ilike $3 or $1 <% stores.store_name)
+	union all
+		select 'category', categories.cat_id::text, categories.cat_name,
+		(select count(*) from redeemed_coupons inner join coupon_categories using(coupon_id) where coupon_categories.cat_id = categories.cat_id),
+		categories.cat_name ilike $2 or categories.cat_name ilike $3,
+		word_similarity($1, categories.cat_name)
+		from categories where categories.cat_name ilike $2 or categories.cat_name ilike $3 or $1 <% categories.cat_name
+	union all
+		select 'coupon', coupons.coupon_id::text, coupons."desc",
+		(select count(*) from redeemed_coupons where redeemed_coupons.coupon_id = coupons.coupon_id::text),
+		coupons."desc" ilike $2 or coupons."desc" ilike $3,
+		word_similarity($1, coupons."desc")
+		from coupons inner join stores using(store_id) where `+liveCoupon+`
+		and (coupons."desc" ilike $2 or coupons."desc" ilike $3 or $1 <% coupons."desc")
+	) as suggestions
+	order by prefixed desc, popularity desc, closeness desc, text
+	limit $4`, term, prefix, wordPrefix, limit)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer rows.Close()
+
+	suggestions := make([]listing.Suggestion, 0, limit)
+	for rows.Next() {
+		var suggestion listing.Suggestion
+		err = rows.Scan(&suggestion.Kind, &suggestion.ID, &suggestion.Text, &suggestion.Popularity)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return nil, storage.ErrServerError
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	if rows.Err() != nil {
+		s.logger.Error(rows.Err().Error())
+		return nil, storage.ErrServerError
+	}
+	return suggestions, nil
+}
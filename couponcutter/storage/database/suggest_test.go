@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"couponcutter/listing"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+//suggestData adds a bookstore with a manga coupon and a burger place with a live and a paused
+//coupon, both redeemed
+const suggestData = `
+create extension if not exists pg_trgm with schema public;
+update stores set store_name = 'Book Haven' where store_id = 'store';
+insert into stores(store_id,store_name) values('burger','Burger Palace');
+insert into coupons(store_id,"desc",expired_at) values('store','20% off manga volumes',now() + interval '1 day');
+insert into coupons(store_id,"desc",expired_at) values('burger','Free fries with burger',now() + interval '1 day');
+insert into coupons(store_id,"desc","state",expired_at) values('burger','Burger combo','paused',now() + interval '1 day');
+insert into categories(cat_name) values('manga');
+insert into coupon_categories(coupon_id,cat_id)
+select coupons.coupon_id::text,categories.cat_id from coupons,categories where coupons."desc" like '%manga%';
+insert into redeemed_coupons(coupon_id,redeemed_when)
+select coupons.coupon_id::text,now() from coupons,generate_series(1,2) where coupons.store_id = 'burger';
+`
+
+func TestDatabase_Suggestions(t *testing.T) {
+	s := testDatabase(t)
+	_, err := s.dbPool.Exec(context.Background(), suggestData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		term string
+		want []string
+		//unordered is set when the ranking of the suggestions is left to pg_trgm
+		unordered bool
+	}{
+		{name: "prefix", term: "bur", want: []string{"Burger Palace", "Free fries with burger"}},
+		{name: "prefix of a later word", term: "vol", want: []string{"20% off manga volumes"}},
+		{name: "typo", term: "mangs", want: []string{"20% off manga volumes", "manga"}, unordered: true},
+		{name: "paused coupon", term: "combo", want: []string{}},
+		{name: "wildcards are literal", term: "20%", want: []string{"20% off manga volumes"}},
+		{name: "no match", term: "xyz", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suggestions, err := s.Suggestions(context.Background(), tt.term, 10)
+			if err != nil {
+				t.Fatalf("Suggestions() error = %v", err)
+			}
+			got := []string{}
+			for _, suggestion := range suggestions {
+				got = append(got, suggestion.Text)
+				if suggestion.Kind == listing.SuggestStore && suggestion.ID == "burger" && suggestion.Popularity != 4 {
+					t.Errorf("Suggestions() popularity of %s = %d, want 4", suggestion.Text, suggestion.Popularity)
+				}
+			}
+			if tt.unordered {
+				sort.Strings(got)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Suggestions(%q) = %v, want %v", tt.term, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package database
+
+import (
+	"context"
+	"couponcutter/authetication"
+	"couponcutter/storage"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//UserEmail returns the email address of the user
+func (s *Database) UserEmail(ctx context.Context, userID string) (string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var email string
+	row := conn.QueryRow(ctx, `select email from users where user_id = $1`, userID)
+	err = row.Scan(&email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", authetication.ErrIdentityDoesNotExists
+		}
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return email, nil
+}
+
+//CreateTOTP stores a pending secret for the user, an enabled secret is never replaced
+func (s *Database) CreateTOTP(ctx context.Context, userID string, secret string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `insert into user_totp(user_id,secret,created_at)values($1,$2,now())
+	on conflict (user_id) do update set secret = excluded.secret, created_at = now(), last_used_step = 0
+	where user_totp.enabled_at is null`, userID, secret)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return authetication.ErrTOTPAlreadyEnabled
+	}
+	return nil
+}
+
+//UserTOTP returns the one-time password configuration of the user
+func (s *Database) UserTOTP(ctx context.Context, userID string) (*authetication.TOTP, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var totp authetication.TOTP
+	row := conn.QueryRow(ctx, `select secret,enabled_at is not null,last_used_step from user_totp where user_id = $1`, userID)
+	err = row.Scan(&totp.Secret, &totp.Enabled, &totp.LastUsedStep)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, authetication.ErrTOTPNotEnabled
+		}
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	return &totp, nil
+}
+
+//EnableTOTP enables the secret of the user and replaces their recovery codes
+func (s *Database) EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `update user_totp set enabled_at = now(), last_used_step = $2 where user_id = $1 and enabled_at is null`, userID, step)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return authetication.ErrTOTPAlreadyEnabled
+	}
+	_, err = tx.Exec(ctx, `delete from totp_recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec(ctx, `insert into totp_recovery_codes(user_id,code_hash)values($1,$2)`, userID, hash)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return storage.ErrServerError
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//UseTOTPStep records the step of an accepted code, false is returned if it or a later step was already used
+func (s *Database) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return false, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `update user_totp set last_used_step = $2 where user_id = $1 and last_used_step < $2`, userID, step)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return false, storage.ErrServerError
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+//UseRecoveryCode marks the recovery code as used, false is returned if it is unknown or already used
+func (s *Database) UseRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return false, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `update totp_recovery_codes set used_at = now() where user_id = $1 and code_hash = $2 and used_at is null`, userID, codeHash)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return false, storage.ErrServerError
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+//DisableTOTP removes the secret and the recovery codes of the user
+func (s *Database) DisableTOTP(ctx context.Context, userID string) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `delete from totp_recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	_, err = tx.Exec(ctx, `delete from user_totp where user_id = $1`, userID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//CreateLoginChallenge stores the hash of a challenge issued after a password login
+func (s *Database) CreateLoginChallenge(ctx context.Context, userID string, tokenHash string, expiredAt time.Time) error {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return storage.ErrServerError
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `insert into login_challenges(token_hash,user_id,expired_at,created_at)values($1,$2,$3,now())`, tokenHash, userID, expiredAt)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return storage.ErrServerError
+	}
+	return nil
+}
+
+//AttemptLoginChallenge counts an attempt at the challenge and returns its user while it is usable
+func (s *Database) AttemptLoginChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var userid string
+	row := conn.QueryRow(ctx, `update login_challenges set attempts = attempts + 1
+	where token_hash = $1 and used_at is null and expired_at > now() and attempts < $2 returning user_id`, tokenHash, maxAttempts)
+	err = row.Scan(&userid)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", authetication.ErrInvalidLoginChallenge
+		}
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return userid, nil
+}
+
+//UseLoginChallenge marks the challenge as completed, false is returned if it already was
+func (s *Database) UseLoginChallenge(ctx context.Context, tokenHash string) (bool, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return false, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(ctx, `update login_challenges set used_at = now() where token_hash = $1 and used_at is null`, tokenHash)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return false, storage.ErrServerError
+	}
+	return tag.RowsAffected() == 1, nil
+}
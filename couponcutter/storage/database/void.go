@@ -0,0 +1,238 @@
+package database
+
+import (
+	"context"
+	"couponcutter/storage"
+	"couponcutter/storemanagement"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//redemptionColumns selects a redemption joined with its coupon and the employee who made it,
+//the employee is empty for the redemptions of the store owner and of API keys
+const redemptionColumns = `redeemed_coupons.id::text,redeemed_coupons.coupon_id,coupons.store_id,
+	coalesce(stores_employees.user_id,''),coalesce(redeemed_coupons.redeemed_by_key,''),coalesce(redeemed_coupons.branch_id,''),
+	coalesce(redeemed_coupons.redeemed_for,''),redeemed_coupons.redeemed_when
+	from redeemed_coupons inner join coupons on coupons.coupon_id::text = redeemed_coupons.coupon_id
+	left join stores_employees on stores_employees.emp_id = redeemed_coupons.redeemed_by`
+
+//Redemption returns the redemption of the store with the id
+func (s *Database) Redemption(ctx context.Context, storeID string, redemptionID string) (*storemanagement.Redemption, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var r storemanagement.Redemption
+	err = conn.QueryRow(ctx, `select `+redemptionColumns+`
+	where coupons.store_id = $1 and redeemed_coupons.id::text = $2`, storeID, redemptionID).Scan(
+		&r.ID, &r.CouponID, &r.StoreID, &r.RedeemedBy, &r.APIKeyID, &r.BranchID, &r.ShopperID, &r.RedeemedAt,
+	)
+	if err == nil {
+		return &r, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	var voided bool
+	err = conn.QueryRow(ctx, `select exists(select 1 from redemption_voids where store_id = $1 and redemption_id::text = $2)`,
+		storeID, redemptionID).Scan(&voided)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	if voided {
+		return nil, storemanagement.ErrRedemptionAlreadyVoided
+	}
+	return nil, storemanagement.ErrRedemptionNotFound
+}
+
+//CouponRedemptions returns the redemptions of the coupon of the store, latest first
+func (s *Database) CouponRedemptions(ctx context.Context, storeID string, couponID string) ([]storemanagement.Redemption, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `select `+redemptionColumns+`
+	where coupons.store_id = $1 and coupons.coupon_id::text = $2
+	order by redeemed_coupons.redeemed_when desc, redeemed_coupons.id desc`, storeID, couponID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer rows.Close()
+	redemptions := []storemanagement.Redemption{}
+	for rows.Next() {
+		var r storemanagement.Redemption
+		err = rows.Scan(&r.ID, &r.CouponID, &r.StoreID, &r.RedeemedBy, &r.APIKeyID, &r.BranchID, &r.ShopperID, &r.RedeemedAt)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return nil, storage.ErrServerError
+		}
+		redemptions = append(redemptions, r)
+	}
+	if rows.Err() != nil {
+		s.logger.Error(rows.Err().Error())
+		return nil, storage.ErrServerError
+	}
+	return redemptions, nil
+}
+
+//StoreVoidWindow returns how long after a redemption the employees of the store can void it
+func (s *Database) StoreVoidWindow(ctx context.Context, storeID string) (time.Duration, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return 0, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	var seconds int
+	err = conn.QueryRow(ctx, `select void_window_seconds from stores where store_id = $1`, storeID).Scan(&seconds)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, storemanagement.ErrStoreNotFound
+		}
+		s.logger.Error(err.Error())
+		return 0, storage.ErrServerError
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+//VoidRedemption removes the redemption and gives it back to its coupon in a transaction holding
+//the coupon row, the same lock redemptions take. An exhausted coupon becomes active again, the
+//change is recorded in the history of the coupon and the void in the audit trail of the store
+func (s *Database) VoidRedemption(ctx context.Context, storeID string, void storemanagement.RedemptionVoid) (string, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	defer tx.Rollback(ctx)
+
+	var couponID int
+	var state string
+	var count uint
+	err = tx.QueryRow(ctx, `select coupon_id,"state",redemption_count from coupons
+	where store_id = $1 and coupon_id::text = $2 for update`, storeID, void.Redemption.CouponID).Scan(&couponID, &state, &count)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", storemanagement.ErrRedemptionNotFound
+		}
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+
+	var redemptionID int
+	var redeemedBy, keyID, branchID, shopperID *string
+	var redeemedAt time.Time
+	err = tx.QueryRow(ctx, `delete from redeemed_coupons where id::text = $1 and coupon_id = $2
+	returning id,redeemed_by,redeemed_by_key,branch_id,redeemed_for,redeemed_when`, void.Redemption.ID, void.Redemption.CouponID).Scan(
+		&redemptionID, &redeemedBy, &keyID, &branchID, &shopperID, &redeemedAt,
+	)
+	if err != nil {
+		//voided meanwhile
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", storemanagement.ErrRedemptionAlreadyVoided
+		}
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+
+	changes := map[string]storemanagement.FieldChange{}
+	if count > 0 {
+		changes["redemption_count"] = storemanagement.FieldChange{From: count, To: count - 1}
+	}
+	if state == storemanagement.CouponExhausted {
+		changes["state"] = storemanagement.FieldChange{From: state, To: storemanagement.CouponActive}
+	}
+	_, err = tx.Exec(ctx, `update coupons set redemption_count = greatest(redemption_count - 1, 0),
+	"state" = case when "state" = 'used' then 'active' else "state" end
+	where coupon_id = $1`, couponID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	err = insertCouponEdit(ctx, tx, couponID, storemanagement.CouponEdit{
+		EditedBy: void.VoidedBy,
+		EditedAt: void.VoidedAt,
+		Changes:  changes,
+	})
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+
+	var voidID string
+	err = tx.QueryRow(ctx, `insert into redemption_voids(redemption_id,store_id,coupon_id,redeemed_by,redeemed_by_key,branch_id,
+	redeemed_for,redeemed_when,voided_by,voided_at,reason,override)
+	values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) returning void_id::text`,
+		redemptionID, storeID, void.Redemption.CouponID, redeemedBy, keyID, branchID, shopperID,
+		redeemedAt, void.VoidedBy, void.VoidedAt, void.Reason, void.Override).Scan(&voidID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return "", storage.ErrServerError
+	}
+	return voidID, nil
+}
+
+//RedemptionVoids returns the voided redemptions of the store, latest first
+func (s *Database) RedemptionVoids(ctx context.Context, storeID string) ([]storemanagement.RedemptionVoid, error) {
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		s.logger.Debug(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(ctx, `select redemption_voids.void_id::text,redemption_voids.redemption_id::text,
+	redemption_voids.coupon_id,redemption_voids.store_id,coalesce(stores_employees.user_id,''),
+	coalesce(redemption_voids.redeemed_by_key,''),coalesce(redemption_voids.branch_id,''),coalesce(redemption_voids.redeemed_for,''),redemption_voids.redeemed_when,
+	redemption_voids.voided_by,redemption_voids.voided_at,redemption_voids.reason,redemption_voids.override
+	from redemption_voids left join stores_employees on stores_employees.emp_id = redemption_voids.redeemed_by
+	where redemption_voids.store_id = $1
+	order by redemption_voids.voided_at desc, redemption_voids.void_id desc`, storeID)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, storage.ErrServerError
+	}
+	defer rows.Close()
+	voids := []storemanagement.RedemptionVoid{}
+	for rows.Next() {
+		var v storemanagement.RedemptionVoid
+		r := &v.Redemption
+		err = rows.Scan(&v.ID, &r.ID, &r.CouponID, &r.StoreID, &r.RedeemedBy, &r.APIKeyID, &r.BranchID, &r.ShopperID, &r.RedeemedAt,
+			&v.VoidedBy, &v.VoidedAt, &v.Reason, &v.Override)
+		if err != nil {
+			s.logger.Error(err.Error())
+			return nil, storage.ErrServerError
+		}
+		voids = append(voids, v)
+	}
+	if rows.Err() != nil {
+		s.logger.Error(rows.Err().Error())
+		return nil, storage.ErrServerError
+	}
+	return voids, nil
+}
@@ -0,0 +1,131 @@
+package memory
+
+import (
+	"context"
+	"couponcutter/storemanagement"
+
+	"github.com/google/uuid"
+)
+
+//store returns the store with the id
+func (s *Storage) store(storeID string) (*Store, error) {
+	for i := range s.Stores {
+		if s.Stores[i].StoreID == storeID {
+			return &s.Stores[i], nil
+		}
+	}
+	return nil, storemanagement.ErrStoreNotFound
+}
+
+//openBranch returns the open branch of the store with the id
+func (st *Store) openBranch(branchID string) (*storemanagement.Branch, error) {
+	for i := range st.Branches {
+		b := &st.Branches[i]
+		if b.ID == branchID && b.State == storemanagement.BranchOpen {
+			return b, nil
+		}
+	}
+	return nil, storemanagement.ErrBranchNotFound
+}
+
+//Branches returns the branches of the store, oldest first
+func (s *Storage) Branches(ctx context.Context, storeID string) ([]storemanagement.Branch, error) {
+	st, err := s.store(storeID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]storemanagement.Branch{}, st.Branches...), nil
+}
+
+//Branch returns the branch of the store with the id
+func (s *Storage) Branch(ctx context.Context, storeID string, branchID string) (*storemanagement.Branch, error) {
+	st, err := s.store(storeID)
+	if err != nil {
+		return nil, storemanagement.ErrBranchNotFound
+	}
+	for _, b := range st.Branches {
+		if b.ID == branchID {
+			return &b, nil
+		}
+	}
+	return nil, storemanagement.ErrBranchNotFound
+}
+
+//CreateBranch adds the branch to its store under a new id
+func (s *Storage) CreateBranch(ctx context.Context, branch storemanagement.Branch) (string, error) {
+	st, err := s.store(branch.StoreID)
+	if err != nil {
+		return "", err
+	}
+	branch.ID = uuid.New().String()
+	st.Branches = append(st.Branches, branch)
+	return branch.ID, nil
+}
+
+//EditBranch changes the details of an open branch that are given in the edit
+func (s *Storage) EditBranch(ctx context.Context, storeID string, branchID string, edit storemanagement.BranchEdit) error {
+	st, err := s.store(storeID)
+	if err != nil {
+		return storemanagement.ErrBranchNotFound
+	}
+	b, err := st.openBranch(branchID)
+	if err != nil {
+		return err
+	}
+	if edit.Name != "" {
+		b.Name = edit.Name
+	}
+	if edit.Address != "" {
+		b.Address = edit.Address
+	}
+	if edit.Location != nil {
+		location := *edit.Location
+		b.Location = &location
+	}
+	return nil
+}
+
+//CloseBranch closes an open branch of the store
+func (s *Storage) CloseBranch(ctx context.Context, storeID string, branchID string) error {
+	st, err := s.store(storeID)
+	if err != nil {
+		return storemanagement.ErrBranchNotFound
+	}
+	b, err := st.openBranch(branchID)
+	if err != nil {
+		return err
+	}
+	b.State = storemanagement.BranchClosed
+	return nil
+}
+
+//SetCouponBranches replaces the branches the coupon is limited to
+func (s *Storage) SetCouponBranches(ctx context.Context, storeID string, couponID string, branchIDs []string) error {
+	st, err := s.store(storeID)
+	if err != nil {
+		return storemanagement.ErrCouponNotValid
+	}
+	for _, id := range branchIDs {
+		if _, err := st.openBranch(id); err != nil {
+			return err
+		}
+	}
+	for i := range s.Coupons {
+		c := &s.Coupons[i]
+		if c.CouponID == couponID && c.Store.StoreID == storeID {
+			c.Branches = append([]string{}, branchIDs...)
+			return nil
+		}
+	}
+	return storemanagement.ErrCouponNotValid
+}
+
+//CouponBranches returns the branches the coupon of the store is limited to
+func (s *Storage) CouponBranches(ctx context.Context, storeID string, couponID string) ([]string, error) {
+	for _, c := range s.Coupons {
+		if c.CouponID == couponID && c.Store.StoreID == storeID {
+			return append([]string{}, c.Branches...), nil
+		}
+	}
+	return []string{}, nil
+}
@@ -0,0 +1,163 @@
+package memory
+
+import (
+	"context"
+	"couponcutter/storemanagement"
+	"strconv"
+	"time"
+)
+
+//state returns the state of the coupon
+func (c *Coupon) state() string {
+	if c.State == "" {
+		return storemanagement.CouponActive
+	}
+	return c.State
+}
+
+//listed reports whether the coupon is shown to shoppers now, it must be active or scheduled,
+//have started and be within one of its windows in the timezone of its store
+func (c *Coupon) listed() bool {
+	switch c.state() {
+	case storemanagement.CouponActive:
+	case storemanagement.CouponScheduled:
+		if c.StartDate == 0 {
+			return false
+		}
+	default:
+		return false
+	}
+	now := time.Now()
+	if c.StartDate != 0 && now.Unix() < int64(c.StartDate) {
+		return false
+	}
+	return storemanagement.WindowsContain(c.Windows, now.In(storemanagement.StoreLocation(c.Store.Timezone)))
+}
+
+//storeCoupon returns the coupon of the store with the id
+func (s *Storage) storeCoupon(storeID string, couponID string) (*Coupon, error) {
+	for i := range s.Coupons {
+		c := &s.Coupons[i]
+		if c.CouponID == couponID && c.Store.StoreID == storeID {
+			return c, nil
+		}
+	}
+	return nil, storemanagement.ErrCouponNotValid
+}
+
+//StoreCoupon returns the coupon of the store with the id, the sample coupons have no redemption limit
+func (s *Storage) StoreCoupon(ctx context.Context, storeID string, couponID string) (*storemanagement.Coupon, error) {
+	c, err := s.storeCoupon(storeID, couponID)
+	if err != nil {
+		return nil, err
+	}
+	limit := c.UserLimit
+	if c.SingleUserUse && limit.Count == 0 {
+		limit = storemanagement.UserLimit{Count: 1}
+	}
+	return &storemanagement.Coupon{
+		CouponID:            c.CouponID,
+		Store:               storemanagement.Store{StoreID: c.Store.StoreID, StoreName: c.Store.StoreName, Timezone: c.Store.Timezone},
+		AmountOff:           c.AmountOff,
+		PercentageOff:       c.PercentageOff,
+		Desc:                c.Desc,
+		CurrencyCode:        c.CurrencyCode,
+		CreationTime:        c.CreationDate,
+		State:               c.state(),
+		ExpiringDate:        int(c.ExpiringDate),
+		DiscountType:        c.DiscountType,
+		IsTextCoupon:        c.IsTextCoupon,
+		TextCouponCode:      c.TextCouponCode,
+		QrCodeURL:           c.QrCodeURL,
+		UnlimitedRedemption: true,
+		RedemptionCount:     c.Redemptions,
+		Categories:          append([]string{}, c.Categories...),
+		StartDate:           int(c.StartDate),
+		Windows:             append([]storemanagement.ValidityWindow{}, c.Windows...),
+		UserLimit:           limit,
+		SingleUserUse:       limit == storemanagement.UserLimit{Count: 1},
+	}, nil
+}
+
+//UpdateCoupon applies the update to the coupon while it is still in the state and records the edit
+func (s *Storage) UpdateCoupon(ctx context.Context, storeID string, couponID string, state string, update storemanagement.CouponUpdate, edit storemanagement.CouponEdit) error {
+	c, err := s.storeCoupon(storeID, couponID)
+	if err != nil {
+		return err
+	}
+	if c.state() != state || (update.ChangesTerms() && c.Redemptions > 0) {
+		return storemanagement.ErrCouponEditConflict
+	}
+	if update.Desc != nil {
+		c.Desc = *update.Desc
+	}
+	if update.Categories != nil {
+		c.Categories = append([]string{}, *update.Categories...)
+	}
+	if update.ExpiringDate != nil {
+		c.ExpiringDate = *update.ExpiringDate
+	}
+	if update.AmountOff != nil {
+		c.AmountOff = *update.AmountOff
+	}
+	if update.PercentageOff != nil {
+		c.PercentageOff = *update.PercentageOff
+	}
+	if update.CurrencyCode != nil {
+		c.CurrencyCode = *update.CurrencyCode
+	}
+	if update.DiscountType != nil {
+		c.DiscountType = *update.DiscountType
+	}
+	if update.IsTextCoupon != nil {
+		c.IsTextCoupon = *update.IsTextCoupon
+	}
+	if update.TextCouponCode != nil {
+		c.TextCouponCode = *update.TextCouponCode
+	}
+	if update.StartDate != nil {
+		c.StartDate = *update.StartDate
+	}
+	if update.Windows != nil {
+		c.Windows = append([]storemanagement.ValidityWindow{}, *update.Windows...)
+	}
+	if update.UserLimit != nil {
+		c.UserLimit = *update.UserLimit
+		c.SingleUserUse = false
+	}
+	//the listing and suggestions index the description and categories
+	s.invalidateSuggestions()
+	s.addCouponEdit(edit)
+	return nil
+}
+
+//SetCouponState moves the coupon from a state to another and records the edit
+func (s *Storage) SetCouponState(ctx context.Context, storeID string, couponID string, from string, to string, edit storemanagement.CouponEdit) error {
+	c, err := s.storeCoupon(storeID, couponID)
+	if err != nil {
+		return err
+	}
+	if c.state() != from {
+		return storemanagement.ErrCouponEditConflict
+	}
+	c.State = to
+	s.invalidateSuggestions()
+	s.addCouponEdit(edit)
+	return nil
+}
+
+func (s *Storage) addCouponEdit(edit storemanagement.CouponEdit) {
+	if s.couponEdits == nil {
+		s.couponEdits = make(map[string][]storemanagement.CouponEdit)
+	}
+	edit.ID = strconv.Itoa(len(s.couponEdits[edit.CouponID]) + 1)
+	s.couponEdits[edit.CouponID] = append(s.couponEdits[edit.CouponID], edit)
+}
+
+//CouponEdits returns the edit history of the coupon of the store, oldest first
+func (s *Storage) CouponEdits(ctx context.Context, storeID string, couponID string) ([]storemanagement.CouponEdit, error) {
+	if _, err := s.storeCoupon(storeID, couponID); err != nil {
+		return []storemanagement.CouponEdit{}, nil
+	}
+	return append([]storemanagement.CouponEdit{}, s.couponEdits[couponID]...), nil
+}
@@ -0,0 +1,32 @@
+package memory
+
+import (
+	"couponcutter/storemanagement"
+	"testing"
+	"time"
+)
+
+func TestCoupon_listed(t *testing.T) {
+	past := uint(time.Now().Add(-time.Minute).Unix())
+	future := uint(time.Now().Add(time.Hour).Unix())
+	tests := []struct {
+		name   string
+		coupon Coupon
+		want   bool
+	}{
+		{name: "sample coupon", coupon: Coupon{}, want: true},
+		{name: "active and started", coupon: Coupon{State: storemanagement.CouponActive, StartDate: past}, want: true},
+		{name: "active and not started", coupon: Coupon{State: storemanagement.CouponActive, StartDate: future}},
+		{name: "scheduled and started", coupon: Coupon{State: storemanagement.CouponScheduled, StartDate: past}, want: true},
+		{name: "scheduled and not started", coupon: Coupon{State: storemanagement.CouponScheduled, StartDate: future}},
+		{name: "scheduled without a start date", coupon: Coupon{State: storemanagement.CouponScheduled}},
+		{name: "paused", coupon: Coupon{State: storemanagement.CouponPaused}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.coupon.listed(); got != tt.want {
+				t.Errorf("listed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
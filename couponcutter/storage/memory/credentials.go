@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"context"
+	"couponcutter/authetication"
+	"couponcutter/storage"
+	"time"
+)
+
+//emailChange is a pending change of the email of a user
+type emailChange struct {
+	UserID    string
+	Email     string
+	ExpiredAt time.Time
+}
+
+//ChangePassword sets the password of the user, the memory storage keeps no sessions so there are none to revoke
+func (s *Storage) ChangePassword(ctx context.Context, userID string, password string, keepSessionID string) error {
+	u, ok := s.users[userID]
+	if !ok {
+		return authetication.ErrIdentityDoesNotExists
+	}
+	hash, err := storage.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = hash
+	return nil
+}
+
+//CreateEmailChange stores a pending change of the email, replacing earlier pending changes of the user
+func (s *Storage) CreateEmailChange(ctx context.Context, userID string, email string, tokenHash string, expiredAt time.Time) error {
+	for hash, change := range s.emailChanges {
+		if change.UserID == userID {
+			delete(s.emailChanges, hash)
+		}
+	}
+	s.emailChanges[tokenHash] = &emailChange{UserID: userID, Email: email, ExpiredAt: expiredAt}
+	return nil
+}
+
+//ConfirmEmailChange applies the pending change of the token and returns the userid
+func (s *Storage) ConfirmEmailChange(ctx context.Context, tokenHash string) (string, error) {
+	change, ok := s.emailChanges[tokenHash]
+	if !ok || time.Now().After(change.ExpiredAt) {
+		return "", authetication.ErrInvalidEmailChangeToken
+	}
+	delete(s.emailChanges, tokenHash)
+	if _, err := s.UserWithEmail(change.Email); err == nil {
+		return "", authetication.ErrIdentityAlreadyExists
+	}
+	u, ok := s.users[change.UserID]
+	if !ok {
+		return "", authetication.ErrIdentityDoesNotExists
+	}
+	u.Email = change.Email
+	return u.UserID, nil
+}
@@ -3,12 +3,14 @@ package memory
 //partially implemented
 import (
 	"couponcutter/listing"
+	"couponcutter/storage"
 	"couponcutter/storemanagement"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"image/png"
 	"os"
+	"sync"
 
 	"time"
 
@@ -40,6 +42,17 @@ type Coupon struct {
 	TextCouponCode string        `json:"text_coupon_code,omitempty"`
 	QrCodeURL      string        `json:"item_url,omitempty"`
 	DiscountType   string        `json:"discount_type,omitempty"`
+	Redemptions    uint          `json:"redemptions,omitempty"`
+	//Branches limits the coupon to some branches of its store, empty for every branch
+	Branches []string `json:"branches,omitempty"`
+	//State is empty for the active coupons of the sample data
+	State string `json:"state,omitempty"`
+	//StartDate is zero for the coupons valid from their creation
+	StartDate uint `json:"start_date,omitempty"`
+	//Windows limits the coupon to some times of the week, empty for all the time
+	Windows []storemanagement.ValidityWindow `json:"windows,omitempty"`
+	//UserLimit is how many times a shopper can redeem the coupon, SingleUserUse counts as once
+	UserLimit storemanagement.UserLimit `json:"user_limit,omitempty"`
 	// voucher percentage
 }
 
@@ -63,19 +76,44 @@ type Store struct {
 	SubStores   []Store    `json:"sub_stores,omitempty"`
 	Coupons     []Coupon   `json:"coupons,omitempty"`
 	ThemeColor  int        `json:"theme_color,omitempty"`
+	//Location is nil until the store is located
+	Location *storemanagement.Location `json:"location,omitempty"`
+	//Branches are the locations of the store, closed ones included
+	Branches []storemanagement.Branch `json:"branches,omitempty"`
+	//Timezone is empty for the stores of the sample data, which are in UTC
+	Timezone string `json:"timezone,omitempty"`
 }
 
 //Storage provides access to a storing interface
 type Storage struct {
 	Coupons []Coupon `json:"coupons"`
 	Stores  []Store  `json:"stores"`
+
+	users        map[string]*user
+	emailChanges map[string]*emailChange
+	//suggestMu guards suggest, built again on the first lookup after it is invalidated
+	suggestMu   sync.Mutex
+	suggest     *suggestIndex
+	couponEdits map[string][]storemanagement.CouponEdit
+}
+
+//user is an account with its hashed password
+type user struct {
+	UserID       string
+	Email        string
+	PasswordHash string
 }
 
 //NewStorage returns an acess to Storage facilites
 func NewStorage() Storage {
+	hash, _ := storage.HashPassword("password")
 	return Storage{
 		Coupons: make([]Coupon, 0, 20),
 		Stores:  make([]Store, 0, 20),
+		users: map[string]*user{
+			"123": {UserID: "123", Email: "davidadewoyin@hotmail.com", PasswordHash: hash},
+		},
+		emailChanges: make(map[string]*emailChange),
 	}
 }
 
@@ -112,6 +150,9 @@ func (s *Storage) PopularCoupons() (*listing.CouponListResponse, error) {
 
 	listc := make([]listing.Coupon, 0, 30)
 	for _, v := range s.Coupons {
+		if !v.listed() {
+			continue
+		}
 		c := listing.Coupon{
 			CouponID: v.CouponID,
 			Store: listing.Store{
@@ -141,6 +182,9 @@ func (s *Storage) PopularCouponsWithCat(cat string) (*listing.CouponListResponse
 
 	listc := make([]listing.Coupon, 0, 30)
 	for _, v := range s.Coupons {
+		if !v.listed() {
+			continue
+		}
 		c := listing.Coupon{
 			CouponID: v.CouponID,
 			Store: listing.Store{
@@ -172,6 +216,9 @@ func (s *Storage) LatestCoupons() (*listing.CouponListResponse, error) {
 	fmt.Println("latest coupons")
 	listc := make([]listing.Coupon, 0, 30)
 	for _, v := range s.Coupons {
+		if !v.listed() {
+			continue
+		}
 		c := listing.Coupon{
 			CouponID: v.CouponID,
 			Store: listing.Store{
@@ -213,6 +260,9 @@ func (s *Storage) LatestCouponWithFiltering(filters ...string) (*listing.CouponL
 	fmt.Println("latest coupons with filtering ...")
 	listc := make([]listing.Coupon, 0, 30)
 	for _, v := range s.Coupons {
+		if !v.listed() {
+			continue
+		}
 		c := listing.Coupon{
 			CouponID: v.CouponID,
 			Store: listing.Store{
@@ -242,6 +292,9 @@ func (s *Storage) CouponBeforeIDAndTimeFiltered(couponID string, lastTime string
 	fmt.Println("coupons before id and filtering .....")
 	listc := make([]listing.Coupon, 0, 30)
 	for _, v := range s.Coupons {
+		if !v.listed() {
+			continue
+		}
 		c := listing.Coupon{
 			CouponID: v.CouponID,
 			Store: listing.Store{
@@ -273,6 +326,9 @@ func (s *Storage) CouponsSavedByUser(userid string) (*listing.CouponListResponse
 
 	listc := make([]listing.Coupon, 0, 30)
 	for _, v := range s.Coupons {
+		if !v.listed() {
+			continue
+		}
 		c := listing.Coupon{
 			CouponID: v.CouponID,
 			Store: listing.Store{
@@ -473,23 +529,40 @@ func (s *Storage) RemoveEmployee(storeID string, employeeID string) error { retu
 //ResumeEmployee resumes the suspended employee
 func (s *Storage) ResumeEmployee(storeID string, employeeID string) error { return nil }
 
-//SubStores returns a list of sub stores associated with the store
-func (s *Storage) SubStores(storeID string) ([]storemanagement.Store, error) {
-	return []storemanagement.Store{
-		{StoreID: "123asf", StoreName: "Rive Nigeria"},
-		{StoreID: "123asf", StoreName: "Rive Global"},
-		{StoreID: "123asf", StoreName: "Rive Michigan"},
-	}, nil
-}
-
 //EditStore edit the details if a particular store
-func (s *Storage) EditStore(userID string, store storemanagement.StoreEdit) error { return nil }
-
-//AddSubStore adds a store as a substore for a given store
-func (s *Storage) AddSubStore(storeID string, otherStoreID string) error { return nil }
-
-//RemoveSubStore removes a store for the list of the substores associated with a store
-func (s *Storage) RemoveSubStore(storeid string, subStoreID string) error { return nil }
+func (s *Storage) EditStore(userID string, store storemanagement.StoreEdit) error {
+	for i := range s.Stores {
+		st := &s.Stores[i]
+		if st.StoreID != userID {
+			continue
+		}
+		if store.Name != "" {
+			st.StoreName = store.Name
+			s.invalidateSuggestions()
+		}
+		if store.Tagline != "" {
+			st.Tagline = store.Tagline
+		}
+		if store.Address != "" {
+			st.Address = store.Address
+		}
+		if store.Location != nil {
+			location := *store.Location
+			st.Location = &location
+		}
+		if store.Timezone != "" {
+			st.Timezone = store.Timezone
+			//the coupons hold a copy of their store
+			for j := range s.Coupons {
+				if s.Coupons[j].Store.StoreID == st.StoreID {
+					s.Coupons[j].Store.Timezone = store.Timezone
+				}
+			}
+		}
+		return nil
+	}
+	return storemanagement.ErrStoreNotFound
+}
 
 //CreateUser create a user with the given details and returns the userid
 func (s *Storage) CreateUser(email string, password string) (bool, error) {
@@ -498,19 +571,21 @@ func (s *Storage) CreateUser(email string, password string) (bool, error) {
 
 //UserWithEmail returns the userid asscoiated with the user or an error
 func (s *Storage) UserWithEmail(email string) (string, error) {
-	if email == "davidadewoyin@hotmail.com" {
-		return "123", nil
+	for _, u := range s.users {
+		if u.Email == email {
+			return u.UserID, nil
+		}
 	}
 	return "", errors.New("user already exists")
 }
 
-//TODO add password hashing
 //TODO hash fake password to stop delay for wrong email address
 //UserWithEmailAndPassword returns the userid associated with the email and password
 func (s *Storage) UserWithEmailAndPassword(email, password string) (string, error) {
-
-	if email == "davidadewoyin@hotmail.com" && password == "password" {
-		return "123", nil
+	for _, u := range s.users {
+		if u.Email == email && storage.CheckPasswordHash(u.PasswordHash, password) {
+			return u.UserID, nil
+		}
 	}
 	return "", errors.New("user not found")
 }
@@ -572,6 +647,13 @@ func (s *Storage) CouponState(couponid string) (string, error) {
 
 //VerifyCoupon verifies the coupon
 func (s *Storage) VerifyCoupon(userid, couponid string) error {
+	for i := range s.Coupons {
+		if s.Coupons[i].CouponID == couponid {
+			s.Coupons[i].Redemptions++
+			//popularity changed, the suggestions index is built again
+			s.invalidateSuggestions()
+		}
+	}
 	return nil
 }
 
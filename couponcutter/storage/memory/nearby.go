@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"context"
+	"couponcutter/listing"
+	"sort"
+)
+
+//NearbyCoupons measures the distance to every located store and keeps the coupons of those within the radius
+func (s *Storage) NearbyCoupons(ctx context.Context, lat, lng, radius float64, limit int) ([]listing.NearbyCoupon, error) {
+	stores := make(map[string]*Store, len(s.Stores))
+	for i := range s.Stores {
+		stores[s.Stores[i].StoreID] = &s.Stores[i]
+	}
+
+	coupons := make([]listing.NearbyCoupon, 0)
+	for _, v := range s.Coupons {
+		if !v.listed() {
+			continue
+		}
+		st, ok := stores[v.Store.StoreID]
+		if !ok || st.Location == nil {
+			continue
+		}
+		distance := listing.Distance(lat, lng, st.Location.Latitude, st.Location.Longitude)
+		if distance > radius {
+			continue
+		}
+		coupons = append(coupons, listing.NearbyCoupon{
+			Coupon: listing.Coupon{
+				CouponID: v.CouponID,
+				Store: listing.Store{
+					StoreName:  st.StoreName,
+					StoreID:    st.StoreID,
+					StoreImage: st.StoreImage,
+					ThemeColor: v.ThemeColor,
+					Tagline:    st.Tagline,
+					Address:    st.Address,
+					Latitude:   st.Location.Latitude,
+					Longitude:  st.Location.Longitude,
+				},
+				AmountOff:      v.AmountOff,
+				PercentageOff:  v.PercentageOff,
+				Desc:           v.Desc,
+				CurrencyCode:   v.CurrencyCode,
+				ExpiringDate:   int(v.ExpiringDate),
+				Categories:     v.Categories,
+				IsTextCoupon:   v.IsTextCoupon,
+				TextCouponCode: v.TextCouponCode,
+				QrCodeURL:      v.QrCodeURL,
+				DiscountType:   v.DiscountType,
+			},
+			Distance: distance,
+		})
+	}
+	sort.Slice(coupons, func(i, j int) bool {
+		if coupons[i].Distance != coupons[j].Distance {
+			return coupons[i].Distance < coupons[j].Distance
+		}
+		return coupons[i].Coupon.CouponID < coupons[j].Coupon.CouponID
+	})
+	if len(coupons) > limit {
+		coupons = coupons[:limit]
+	}
+	return coupons, nil
+}
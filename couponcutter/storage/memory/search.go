@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"context"
+	"couponcutter/listing"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//SearchCoupons is a naive stand in for the full text search of the database. A coupon matches
+//when a word of its description or store name starts with a word of the term, a store name
+//match weighs twice a description match
+func (s *Storage) SearchCoupons(ctx context.Context, query listing.SearchQuery, after *listing.SearchCursor, limit int) ([]listing.SearchResult, error) {
+	terms := searchWords(query.Term)
+	results := make([]listing.SearchResult, 0, len(s.Coupons))
+	for _, v := range s.Coupons {
+		if !v.listed() {
+			continue
+		}
+		if query.DiscountType != "" && v.DiscountType != query.DiscountType {
+			continue
+		}
+		if len(query.Categories) > 0 && !hasAnyCategory(v.Categories, query.Categories) {
+			continue
+		}
+		descHits := countMatches(searchWords(v.Desc), terms)
+		storeHits := countMatches(searchWords(v.Store.StoreName), terms)
+		if descHits+storeHits == 0 {
+			continue
+		}
+		results = append(results, listing.SearchResult{
+			Coupon: listing.Coupon{
+				CouponID: v.CouponID,
+				Store: listing.Store{
+					StoreName:  v.Store.StoreName,
+					StoreID:    v.Store.StoreID,
+					StoreImage: v.Store.StoreImage,
+					ThemeColor: v.ThemeColor,
+					Tagline:    v.Store.Tagline,
+					Address:    v.Store.Address,
+				},
+				AmountOff:      v.AmountOff,
+				PercentageOff:  v.PercentageOff,
+				Desc:           v.Desc,
+				CurrencyCode:   v.CurrencyCode,
+				ExpiringDate:   int(v.ExpiringDate),
+				Categories:     v.Categories,
+				IsTextCoupon:   v.IsTextCoupon,
+				TextCouponCode: v.TextCouponCode,
+				QrCodeURL:      v.QrCodeURL,
+				DiscountType:   v.DiscountType,
+			},
+			Rank:    float64(descHits+2*storeHits) / float64(len(terms)),
+			Snippet: highlight(v.Desc, terms),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Coupon.CouponID < results[j].Coupon.CouponID
+	})
+	if after != nil {
+		start := sort.Search(len(results), func(i int) bool {
+			r := results[i]
+			return r.Rank < after.Rank || (r.Rank == after.Rank && r.Coupon.CouponID > after.CouponID)
+		})
+		results = results[start:]
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+//searchWords splits the text into lower case words
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+//countMatches returns how many of the terms start a word
+func countMatches(words []string, terms []string) int {
+	n := 0
+	for _, term := range terms {
+		for _, word := range words {
+			if strings.HasPrefix(word, term) {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+func hasAnyCategory(categories []string, wanted []string) bool {
+	for _, c := range categories {
+		for _, w := range wanted {
+			if strings.EqualFold(c, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//highlight surrounds the words of the text that start with a term with the snippet markers,
+//the text is HTML escaped
+func highlight(text string, terms []string) string {
+	var b strings.Builder
+	start := -1
+	flush := func(end int) {
+		word := text[start:end]
+		if countMatches([]string{strings.ToLower(word)}, terms) > 0 {
+			b.WriteString(listing.SnippetStart + html.EscapeString(word) + listing.SnippetStop)
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		start = -1
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			flush(i)
+		}
+		b.WriteString(html.EscapeString(string(r)))
+	}
+	if start >= 0 {
+		flush(len(text))
+	}
+	return b.String()
+}
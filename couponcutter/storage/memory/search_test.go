@@ -0,0 +1,25 @@
+package memory
+
+import "testing"
+
+func Test_highlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		terms []string
+		want  string
+	}{
+		{name: "matched words", text: "Free fries with burgers", terms: []string{"fri", "burger"},
+			want: "Free <b>fries</b> with <b>burgers</b>"},
+		{name: "no match", text: "Free fries", terms: []string{"shoes"}, want: "Free fries"},
+		{name: "markup escaped", text: `<script>alert("hi")</script> & fries`, terms: []string{"fries"},
+			want: "&lt;script&gt;alert(&#34;hi&#34;)&lt;/script&gt; &amp; <b>fries</b>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlight(tt.text, tt.terms); got != tt.want {
+				t.Errorf("highlight() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
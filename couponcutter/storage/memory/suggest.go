@@ -0,0 +1,247 @@
+package memory
+
+import (
+	"context"
+	"couponcutter/listing"
+	"sort"
+	"strings"
+)
+
+//suggestIndex is an in-process index of the store names, categories and coupon descriptions,
+//every word prefix points to the entries starting a word with it and every trigram to the
+//entries holding it for the misspelled terms
+type suggestIndex struct {
+	entries  []suggestEntry
+	prefixes map[string][]int
+	trigrams map[string][]int
+	//size is the number of coupons and stores the index was built from
+	size int
+}
+
+type suggestEntry struct {
+	suggestion listing.Suggestion
+	words      []string
+}
+
+//invalidateSuggestions drops the index after a change to what it holds
+func (s *Storage) invalidateSuggestions() {
+	s.suggestMu.Lock()
+	s.suggest = nil
+	s.suggestMu.Unlock()
+}
+
+//suggestions returns the index of the storage, building it again when it was invalidated or
+//coupons or stores were added. A built index is never changed, lookups can share it
+func (s *Storage) suggestions() *suggestIndex {
+	s.suggestMu.Lock()
+	defer s.suggestMu.Unlock()
+	if s.suggest != nil && s.suggest.size == len(s.Coupons)+len(s.Stores) {
+		return s.suggest
+	}
+	index := &suggestIndex{
+		prefixes: make(map[string][]int),
+		trigrams: make(map[string][]int),
+		size:     len(s.Coupons) + len(s.Stores),
+	}
+	storePopularity := make(map[string]int)
+	categoryPopularity := make(map[string]int)
+	for _, c := range s.Coupons {
+		storePopularity[c.Store.StoreID] += int(c.Redemptions)
+		for _, category := range c.Categories {
+			categoryPopularity[strings.ToLower(category)] += int(c.Redemptions)
+		}
+	}
+
+	for _, st := range s.Stores {
+		index.add(listing.Suggestion{Text: st.StoreName, Kind: listing.SuggestStore, ID: st.StoreID, Popularity: storePopularity[st.StoreID]})
+	}
+	categories, _ := s.CategoriesList(0)
+	for _, c := range s.Coupons {
+		categories = append(categories, c.Categories...)
+	}
+	seen := make(map[string]bool)
+	for _, category := range categories {
+		name := strings.ToLower(category)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		index.add(listing.Suggestion{Text: category, Kind: listing.SuggestCategory, ID: name, Popularity: categoryPopularity[name]})
+	}
+	//the coupons shown change with their windows, they are filtered when looked up
+	for _, c := range s.Coupons {
+		index.add(listing.Suggestion{Text: c.Desc, Kind: listing.SuggestCoupon, ID: c.CouponID, Popularity: int(c.Redemptions)})
+	}
+	s.suggest = index
+	return index
+}
+
+func (x *suggestIndex) add(suggestion listing.Suggestion) {
+	id := len(x.entries)
+	words := searchWords(suggestion.Text)
+	x.entries = append(x.entries, suggestEntry{suggestion: suggestion, words: words})
+
+	prefixes := make(map[string]bool)
+	trigrams := make(map[string]bool)
+	for _, word := range words {
+		runes := []rune(word)
+		for i := 1; i <= len(runes); i++ {
+			prefixes[string(runes[:i])] = true
+		}
+		for _, t := range wordTrigrams(word) {
+			trigrams[t] = true
+		}
+	}
+	for p := range prefixes {
+		x.prefixes[p] = append(x.prefixes[p], id)
+	}
+	for t := range trigrams {
+		x.trigrams[t] = append(x.trigrams[t], id)
+	}
+}
+
+//Suggestions looks the term up in the in-process index. A term too long to be a typo of a
+//word prefix is also matched when it is within a small edit distance of a word
+func (s *Storage) Suggestions(ctx context.Context, term string, limit int) ([]listing.Suggestion, error) {
+	index := s.suggestions()
+	terms := searchWords(term)
+	if len(terms) == 0 {
+		return []listing.Suggestion{}, nil
+	}
+	//the last word is the one still being typed
+	last := terms[len(terms)-1]
+
+	type match struct {
+		entry    *suggestEntry
+		prefixed bool
+		distance int
+	}
+	matches := make(map[int]*match)
+	for _, id := range index.prefixes[last] {
+		matches[id] = &match{entry: &index.entries[id], prefixed: true}
+	}
+	maxDistance := allowedTypos(last)
+	if maxDistance > 0 {
+		for _, t := range wordTrigrams(last) {
+			for _, id := range index.trigrams[t] {
+				if _, ok := matches[id]; ok {
+					continue
+				}
+				entry := &index.entries[id]
+				if d := closestWord(entry.words, last); d <= maxDistance {
+					matches[id] = &match{entry: entry, distance: d}
+				}
+			}
+		}
+	}
+
+	live := make(map[string]bool)
+	for i := range s.Coupons {
+		if s.Coupons[i].listed() {
+			live[s.Coupons[i].CouponID] = true
+		}
+	}
+	ranked := make([]*match, 0, len(matches))
+	for _, m := range matches {
+		if m.entry.suggestion.Kind == listing.SuggestCoupon && !live[m.entry.suggestion.ID] {
+			continue
+		}
+		//the words typed before the last one must be in the suggestion as well
+		if countMatches(m.entry.words, terms[:len(terms)-1]) == len(terms)-1 {
+			ranked = append(ranked, m)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.prefixed != b.prefixed {
+			return a.prefixed
+		}
+		if a.entry.suggestion.Popularity != b.entry.suggestion.Popularity {
+			return a.entry.suggestion.Popularity > b.entry.suggestion.Popularity
+		}
+		if a.distance != b.distance {
+			return a.distance < b.distance
+		}
+		return a.entry.suggestion.Text < b.entry.suggestion.Text
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	suggestions := make([]listing.Suggestion, 0, len(ranked))
+	for _, m := range ranked {
+		suggestions = append(suggestions, m.entry.suggestion)
+	}
+	return suggestions, nil
+}
+
+//allowedTypos is the edit distance tolerated for a term, none for short terms
+func allowedTypos(term string) int {
+	n := len([]rune(term))
+	switch {
+	case n < 3:
+		return 0
+	case n < 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+//wordTrigrams returns the trigrams of the word padded the way pg_trgm does
+func wordTrigrams(word string) []string {
+	runes := []rune("  " + word + " ")
+	trigrams := make([]string, 0, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		trigrams = append(trigrams, string(runes[i:i+3]))
+	}
+	return trigrams
+}
+
+//closestWord returns the smallest edit distance between the term and a word or the start of a word
+func closestWord(words []string, term string) int {
+	best := len(term)
+	t := []rune(term)
+	for _, word := range words {
+		w := []rune(word)
+		if d := levenshtein(w, t); d < best {
+			best = d
+		}
+		if len(w) > len(t) {
+			if d := levenshtein(w[:len(t)], t); d < best {
+				best = d
+			}
+		}
+	}
+	return best
+}
+
+//levenshtein returns the number of single rune edits turning a into b
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
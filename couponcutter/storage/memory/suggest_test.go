@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"couponcutter/storemanagement"
+	"reflect"
+	"testing"
+)
+
+func suggestStorage() *Storage {
+	s := NewStorage()
+	bookHaven := Store{StoreName: "Book Haven", StoreID: "s1"}
+	burgerPalace := Store{StoreName: "Burger Palace", StoreID: "s2"}
+	s.Stores = []Store{bookHaven, burgerPalace}
+	s.Coupons = []Coupon{
+		{CouponID: "c1", Store: bookHaven, Desc: "20% off manga volumes", Categories: []string{"manga"}, Redemptions: 5},
+		{CouponID: "c2", Store: burgerPalace, Desc: "Free fries with burger", Redemptions: 9},
+		{CouponID: "c3", Store: burgerPalace, Desc: "Burger combo", State: storemanagement.CouponPaused, Redemptions: 20},
+	}
+	return &s
+}
+
+func suggestionTexts(t *testing.T, s *Storage, term string) []string {
+	suggestions, err := s.Suggestions(context.Background(), term, 10)
+	if err != nil {
+		t.Fatalf("Suggestions() error = %v", err)
+	}
+	texts := []string{}
+	for _, suggestion := range suggestions {
+		texts = append(texts, suggestion.Text)
+	}
+	return texts
+}
+
+func TestStorage_Suggestions(t *testing.T) {
+	tests := []struct {
+		name string
+		term string
+		want []string
+	}{
+		{name: "prefix", term: "bur", want: []string{"Burger Palace", "Free fries with burger"}},
+		{name: "prefix of a later word", term: "vol", want: []string{"20% off manga volumes"}},
+		{name: "typo", term: "mamga", want: []string{"20% off manga volumes", "manga"}},
+		{name: "typo in a word prefix", term: "bokk", want: []string{"Book Haven", "bookstore"}},
+		{name: "too short for a typo", term: "bx", want: []string{}},
+		{name: "too far for a typo", term: "mxmxa", want: []string{}},
+		{name: "multiple words", term: "free bur", want: []string{"Free fries with burger"}},
+		{name: "earlier word missing", term: "cheap bur", want: []string{}},
+		{name: "paused coupon", term: "combo", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := suggestionTexts(t, suggestStorage(), tt.term); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Suggestions(%q) = %v, want %v", tt.term, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_SuggestionsAfterStoreRename(t *testing.T) {
+	s := suggestStorage()
+	suggestionTexts(t, s, "bur")
+	err := s.EditStore("s2", storemanagement.StoreEdit{Name: "Fry Palace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Free fries with burger"}
+	if got := suggestionTexts(t, s, "bur"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Suggestions() after the rename = %v, want %v", got, want)
+	}
+	want = []string{"Fry Palace", "Free fries with burger"}
+	if got := suggestionTexts(t, s, "fr"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Suggestions() of the new name = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//COST for the legacy bcrypt hashes, new hashes use argon2id
+const COST = 14
+
+//argon2Params are the parameters new password hashes are created with,
+//hashes made with other parameters are upgraded on the next successful login
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	keyLen  uint32
+	saltLen uint32
+}
+
+var currentArgon2 = argon2Params{memory: 64 * 1024, time: 3, threads: 2, keyLen: 32, saltLen: 16}
+
+//HashPassword hash the supplied password with argon2id, the hash is stored in the PHC string format
+//$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash> so the algorithm and its parameters travel with it
+func HashPassword(password string) (string, error) {
+	p := currentArgon2
+	salt := make([]byte, p.saltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		log.Println(err)
+		return "", ErrServerError
+	}
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+//CheckPasswordHash checks if the passwrod and hash are the same, both argon2id and legacy bcrypt hashes are accepted
+func CheckPasswordHash(hashed, submittedPassword string) bool {
+	if strings.HasPrefix(hashed, "$argon2id$") {
+		p, salt, key, err := decodeArgon2(hashed)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		other := argon2.IDKey([]byte(submittedPassword), salt, p.time, p.memory, p.threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(submittedPassword))
+
+	return err == nil
+}
+
+//NeedsRehash reports whether the hash was made with an older algorithm or parameters,
+//it should be replaced with HashPassword once the password is known after a successful login
+func NeedsRehash(hashed string) bool {
+	if !strings.HasPrefix(hashed, "$argon2id$") {
+		return true
+	}
+	p, salt, key, err := decodeArgon2(hashed)
+	if err != nil {
+		return true
+	}
+	return p.memory != currentArgon2.memory || p.time != currentArgon2.time || p.threads != currentArgon2.threads ||
+		uint32(len(salt)) != currentArgon2.saltLen || uint32(len(key)) != currentArgon2.keyLen
+}
+
+//decode an argon2id hash in the PHC string format
+func decodeArgon2(hashed string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads)
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPasswordHash(hash, "correct horse") {
+		t.Errorf("password should match its hash")
+	}
+	if CheckPasswordHash(hash, "correct horsE") {
+		t.Errorf("another password should not match")
+	}
+	if NeedsRehash(hash) {
+		t.Errorf("a current hash should not need a rehash")
+	}
+	other, _ := HashPassword("correct horse")
+	if other == hash {
+		t.Errorf("hashes of the same password should be salted")
+	}
+}
+
+func TestCheckPasswordHash_Legacy(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPasswordHash(string(legacy), "password") {
+		t.Errorf("bcrypt hashes should still be accepted")
+	}
+	if !NeedsRehash(string(legacy)) {
+		t.Errorf("bcrypt hashes should be upgraded")
+	}
+
+	weaker := "$argon2id$v=19$m=16384,t=2,p=1$c29tZXNhbHRzb21lc2FsdA$8mYWTz6W4xpOVfcpP/x7xJ/0ecv+NvLU3lz0QJwYPbE"
+	if !NeedsRehash(weaker) {
+		t.Errorf("hashes with other parameters should be upgraded")
+	}
+	if CheckPasswordHash("$argon2id$broken", "password") {
+		t.Errorf("a malformed hash should not match")
+	}
+}
@@ -4,16 +4,54 @@ DROP TABLE IF EXISTS stores CASCADE;
 
 DROP TABLE IF EXISTS signup_users;
 
+DROP TABLE IF EXISTS password_resets;
+
+DROP TABLE IF EXISTS magic_links;
+
+DROP TABLE IF EXISTS refresh_tokens;
+
+DROP TABLE IF EXISTS user_sessions;
+
+DROP TABLE IF EXISTS user_totp;
+
+DROP TABLE IF EXISTS totp_recovery_codes;
+
+DROP TABLE IF EXISTS login_challenges;
+
+DROP TABLE IF EXISTS login_attempts;
+
+DROP TABLE IF EXISTS email_changes;
+
+DROP TABLE IF EXISTS oidc_states;
+
+DROP TABLE IF EXISTS user_identities;
+
 DROP TABLE IF EXISTS create_employees;
 
 DROP Table IF EXISTS stores_employees CASCADE;
 
+DROP TABLE IF EXISTS api_keys;
+
+DROP TABLE IF EXISTS store_branches CASCADE;
+
+DROP TABLE IF EXISTS employee_branches;
+
+DROP TABLE IF EXISTS coupon_branches;
+
+DROP TABLE IF EXISTS coupon_edits;
+
+DROP TABLE IF EXISTS coupon_windows;
+
 DROP TABLE IF EXISTS coupons CASCADE;
 
 DROP TABLE IF EXISTS archive_coupons;
 
 DROP TABLE IF EXISTS redeemed_coupons CASCADE;
 
+DROP TABLE IF EXISTS idempotency_keys;
+
+DROP TABLE IF EXISTS redemption_voids;
+
 DROP TABLE IF EXISTS coupon_categories CASCADE;
 
 DROP TABLE IF EXISTS categories CASCADE;
@@ -26,6 +64,8 @@ DROP TABLE IF EXISTS store_state CASCADE;
 
 DROP TABLE IF EXISTS discount_type CASCADE;
 
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
 CREATE TABLE employee_state(
     state_id PRIMARY KEY generated always AS IDENTITY,
     state_name text UNIQUE
@@ -59,7 +99,10 @@ VALUES
     ('active'),
     ("deleted"),
     ("expired"),
-    ("used");
+    ("used"),
+    ('draft'),
+    ('scheduled'),
+    ('paused');
 
 INSERT INTO
     store_state(state_name)
@@ -79,7 +122,11 @@ CREATE TABLE users (
     user_id text PRIMARY KEY,
     email text NOT NULL UNIQUE,
     password_hash text NOT NULL,
-    created_at timestamp NOT NULL
+    created_at timestamptz NOT NULL,
+    tokens_valid_after timestamptz NULL,
+    platform_admin boolean NOT NULL DEFAULT false,
+    deletion_scheduled_at timestamptz NULL,
+    deleted_at timestamptz NULL
 );
 
 CREATE TABLE stores(
@@ -88,9 +135,15 @@ CREATE TABLE stores(
     store_state REFERENCES store_state(store_name) DEFAULT 'inactive',
     theme_color integer,
     tagline text NOT NULL,
-    "address" text NULL
+    "address" text NULL,
+    latitude double precision NULL CHECK(latitude BETWEEN -90 AND 90),
+    longitude double precision NULL CHECK(longitude BETWEEN -180 AND 180),
+    timezone text NOT NULL DEFAULT 'UTC',
+    void_window_seconds integer NOT NULL DEFAULT 900 CHECK(void_window_seconds BETWEEN 0 AND 604800)
 );
 
+CREATE INDEX in_stores_location ON stores(latitude, longitude);
+
 CREATE Table stores_followed(
     user_id text REFERENCES users(user_id) NOT NULL,
     store_id text REFERENCES stores(store_id) NOT NULL,
@@ -102,7 +155,99 @@ Create TABLE signup_users(
     email text NOT NULL UNIQUE,
     password_hash text NOT NULL,
     token text NOT NULL UNIQUE,
-    expired_at timestamp NOT NULL
+    expired_at timestamptz NOT NULL
+);
+
+CREATE TABLE password_resets(
+    id integer PRIMARY KEY generated always AS IDENTITY,
+    user_id text REFERENCES users(user_id) ON DELETE CASCADE NOT NULL,
+    token_hash text NOT NULL UNIQUE,
+    expired_at timestamptz NOT NULL,
+    created_at timestamptz NOT NULL,
+    used_at timestamptz NULL
+);
+
+CREATE TABLE magic_links(
+    id integer PRIMARY KEY generated always AS IDENTITY,
+    user_id text REFERENCES users(user_id) ON DELETE CASCADE NOT NULL,
+    token_hash text NOT NULL UNIQUE,
+    expired_at timestamptz NOT NULL,
+    created_at timestamptz NOT NULL,
+    used_at timestamptz NULL
+);
+
+CREATE TABLE user_sessions(
+    session_id text PRIMARY KEY,
+    user_id text REFERENCES users(user_id) ON DELETE CASCADE NOT NULL,
+    created_at timestamptz NOT NULL,
+    revoked_at timestamptz NULL
+);
+
+CREATE TABLE refresh_tokens(
+    token_hash text PRIMARY KEY,
+    session_id text REFERENCES user_sessions(session_id) ON DELETE CASCADE NOT NULL,
+    user_id text REFERENCES users(user_id) ON DELETE CASCADE NOT NULL,
+    expired_at timestamptz NOT NULL,
+    created_at timestamptz NOT NULL,
+    used_at timestamptz NULL
+);
+
+CREATE TABLE user_totp(
+    user_id text PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    secret text NOT NULL,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    created_at timestamptz NOT NULL,
+    enabled_at timestamptz NULL
+);
+
+CREATE TABLE totp_recovery_codes(
+    user_id text REFERENCES users(user_id) ON DELETE CASCADE NOT NULL,
+    code_hash text NOT NULL,
+    used_at timestamptz NULL,
+    PRIMARY KEY(user_id, code_hash)
+);
+
+CREATE TABLE login_challenges(
+    token_hash text PRIMARY KEY,
+    user_id text REFERENCES users(user_id) ON DELETE CASCADE NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    expired_at timestamptz NOT NULL,
+    created_at timestamptz NOT NULL,
+    used_at timestamptz NULL
+);
+
+CREATE TABLE login_attempts(
+    attempt_key text PRIMARY KEY,
+    failures integer NOT NULL DEFAULT 0,
+    last_failure_at timestamptz NOT NULL,
+    blocked_until timestamptz NULL
+);
+
+CREATE TABLE email_changes(
+    id integer PRIMARY KEY generated always AS IDENTITY,
+    user_id text REFERENCES users(user_id) ON DELETE CASCADE NOT NULL,
+    email text NOT NULL,
+    token_hash text NOT NULL UNIQUE,
+    expired_at timestamptz NOT NULL,
+    created_at timestamptz NOT NULL,
+    used_at timestamptz NULL
+);
+
+CREATE TABLE oidc_states(
+    state_hash text PRIMARY KEY,
+    provider text NOT NULL,
+    code_verifier text NOT NULL,
+    nonce text NOT NULL,
+    expired_at timestamptz NOT NULL,
+    used_at timestamptz NULL
+);
+
+CREATE TABLE user_identities(
+    provider text NOT NULL,
+    subject text NOT NULL,
+    user_id text REFERENCES users(user_id) ON DELETE CASCADE NOT NULL,
+    created_at timestamptz NOT NULL,
+    PRIMARY KEY (provider, subject)
 );
 
 Create Table create_employees(
@@ -110,18 +255,52 @@ Create Table create_employees(
     store_id text REFERENCES stores(store_id),
     email text NOT NULL,
     token text NOT NULL UNIQUE,
-    expired_at timestamp NOT NULL,
-    revoked boolean NOT NULL DEFAULT false
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expired_at timestamptz NOT NULL,
+    revoked boolean NOT NULL DEFAULT false,
+    accepted_at timestamptz NULL,
+    accepted_by text NULL REFERENCES users(user_id)
 );
 
 CREATE TABLE stores_employees(
     emp_id text PRIMARY KEY,
     store_id text REFERENCES stores(store_id),
-    created_at timestamp NOT NULL,
+    created_at timestamptz NOT NULL,
     user_id text REFERENCES users(user_id),
     emp_state REFERENCES employee_state(state_name)
 );
 
+CREATE TABLE store_branches(
+    branch_id text PRIMARY KEY,
+    store_id text REFERENCES stores(store_id) NOT NULL,
+    name text NOT NULL,
+    "address" text NULL,
+    latitude double precision NULL CHECK(latitude BETWEEN -90 AND 90),
+    longitude double precision NULL CHECK(longitude BETWEEN -180 AND 180),
+    branch_state text NOT NULL DEFAULT 'open' CHECK(branch_state IN ('open', 'closed')),
+    created_at timestamptz NOT NULL
+);
+
+CREATE INDEX in_store_branches_store ON store_branches(store_id);
+
+CREATE TABLE employee_branches(
+    emp_id text REFERENCES stores_employees(emp_id) ON DELETE CASCADE NOT NULL,
+    branch_id text REFERENCES store_branches(branch_id) NOT NULL,
+    PRIMARY KEY(emp_id, branch_id)
+);
+
+CREATE TABLE api_keys(
+    key_id text PRIMARY KEY,
+    store_id text REFERENCES stores(store_id) NOT NULL,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    key_hash text NOT NULL UNIQUE,
+    scopes text [] NOT NULL,
+    created_at timestamptz NOT NULL,
+    last_used_at timestamptz NULL,
+    revoked_at timestamptz NULL
+);
+
 Create TABLE coupons(
     coupon_id integer PRIMARY KEY generated always AS IDENTITY,
     store_id text REFERENCES stores(store_id) NOT NULL,
@@ -132,14 +311,17 @@ Create TABLE coupons(
     percentage_off NUMERIC,
     currency_code char(3),
     qr_code_url text NULL,
-    expired_at timestamp NOT NULL,
-    created_at timestamp NOT NULL,
+    expired_at timestamptz NOT NULL,
+    created_at timestamptz NOT NULL,
+    start_at timestamptz NULL CHECK(start_at < expired_at),
     is_text_coupon boolean NOT NULL DEFAULT false,
     text_coupon_code text UNIQUE,
     text_coupon_weburl text,
     max_redemptions integer,
     unlimited_redemption boolean NOT NULL DEFAULT true,
     redemption_count integer NOT NULL DEFAULT 0,
+    user_limit integer NOT NULL DEFAULT 0 CHECK(user_limit >= 0),
+    user_limit_period text NULL CHECK(user_limit_period IN ('day', 'week', 'month')),
     tsv tsvector,
     CONSTRAINT either_text_or_is_qr CHECK(
         is_text_coupon = false
@@ -161,15 +343,44 @@ Create TABLE coupons(
 CREATE INDEX in_coupons_tsv ON coupons USING GIN(tsv);
 
 CREATE
-OR REPLACE FUNCTION set_full_text_search_on_coupons() RETURNS TRIGGER AS $$ BEGIN coupons.tsv = to_tsvector(coupons.desc ) RETURN NEW;
+OR REPLACE FUNCTION set_full_text_search_on_coupons() RETURNS TRIGGER AS $$ BEGIN NEW.tsv = to_tsvector('english', NEW."desc");
+
+RETURN NEW;
 
 END;
 
-$ $ language 'plpgsql';
+$$ language 'plpgsql';
 
 CREATE TRIGGER update_new_full_text_search BEFORE
+INSERT
+    OR
 UPDATE
-    ON coupons FOR EACH ROW EXECUTE PROCEDURE set_full_text_search_on_coupons();
+    OF "desc" ON coupons FOR EACH ROW EXECUTE PROCEDURE set_full_text_search_on_coupons();
+
+CREATE TABLE coupon_branches(
+    coupon_id integer REFERENCES coupons(coupon_id) ON DELETE CASCADE NOT NULL,
+    branch_id text REFERENCES store_branches(branch_id) NOT NULL,
+    PRIMARY KEY(coupon_id, branch_id)
+);
+
+CREATE TABLE coupon_windows(
+    coupon_id integer REFERENCES coupons(coupon_id) ON DELETE CASCADE NOT NULL,
+    weekday smallint NOT NULL CHECK(weekday BETWEEN 0 AND 6),
+    start_minute smallint NOT NULL CHECK(start_minute BETWEEN 0 AND 1439),
+    end_minute smallint NOT NULL CHECK(end_minute BETWEEN 1 AND 1440),
+    CHECK(start_minute < end_minute),
+    PRIMARY KEY(coupon_id, weekday, start_minute)
+);
+
+CREATE TABLE coupon_edits(
+    edit_id integer PRIMARY KEY generated always AS IDENTITY,
+    coupon_id integer REFERENCES coupons(coupon_id) ON DELETE CASCADE NOT NULL,
+    edited_by text NOT NULL,
+    edited_at timestamptz NOT NULL,
+    changes jsonb NOT NULL
+);
+
+CREATE INDEX in_coupon_edits_coupon ON coupon_edits(coupon_id, edited_at);
 
 Create TABLE saved_coupons(
     id integer PRIMARY KEY generated always AS IDENTITY,
@@ -187,8 +398,8 @@ Create Table archive_coupons(
     percentage_off NUMERIC,
     currency_code char(3),
     qr_code_url text NULL,
-    expired_at timestamp NOT NULL,
-    created_at timestamp NOT NULL,
+    expired_at timestamptz NOT NULL,
+    created_at timestamptz NOT NULL,
     is_text_coupon boolean NOT NULL DEFAULT false,
     text_coupon_code text UNIQUE,
     text_coupon_webUrl text,
@@ -201,10 +412,46 @@ Create Table archive_coupons(
 CREATE TABLE redeemed_coupons(
     id integer PRIMARY KEY generated always AS IDENTITY,
     coupon_id text REFERENCES coupons(coupon_id) NOT NULL,
-    redeemed_by text REFERENCES stores_employees(employee_id),
-    redeemed_when timestamp NOT NULL
+    redeemed_by text REFERENCES stores_employees(emp_id),
+    redeemed_by_key text NULL REFERENCES api_keys(key_id),
+    branch_id text NULL REFERENCES store_branches(branch_id),
+    redeemed_for text NULL REFERENCES users(user_id),
+    redeemed_when timestamptz NOT NULL,
+    CHECK(redeemed_by IS NULL OR redeemed_by_key IS NULL)
 );
 
+CREATE INDEX in_redeemed_coupons_shopper ON redeemed_coupons(coupon_id, redeemed_for, redeemed_when);
+
+CREATE TABLE idempotency_keys(
+    store_id text REFERENCES stores(store_id) NOT NULL,
+    idem_key text NOT NULL,
+    fingerprint text NOT NULL,
+    outcome text NULL,
+    created_at timestamptz NOT NULL,
+    expired_at timestamptz NOT NULL,
+    PRIMARY KEY(store_id, idem_key)
+);
+
+CREATE INDEX in_idempotency_keys_expired ON idempotency_keys(expired_at);
+
+CREATE TABLE redemption_voids(
+    void_id integer PRIMARY KEY generated always AS IDENTITY,
+    redemption_id integer NOT NULL UNIQUE,
+    store_id text REFERENCES stores(store_id) NOT NULL,
+    coupon_id text NOT NULL,
+    redeemed_by text NULL,
+    redeemed_by_key text NULL,
+    branch_id text NULL,
+    redeemed_for text NULL,
+    redeemed_when timestamptz NOT NULL,
+    voided_by text NOT NULL,
+    voided_at timestamptz NOT NULL,
+    reason text NOT NULL CHECK(length(reason) BETWEEN 1 AND 500),
+    override boolean NOT NULL DEFAULT false
+);
+
+CREATE INDEX in_redemption_voids_store ON redemption_voids(store_id, voided_at);
+
 CREATE TABLE categories (
     cat_id integer PRIMARY KEY generated always AS IDENTITY,
     cat_name text NOT NULL
@@ -212,10 +459,16 @@ CREATE TABLE categories (
 
 CREATE TABLE coupon_categories(
     coupon_id text REFERENCES coupons(coupon_id),
-    cat_name integer REFERENCES categories(cat_name),
+    cat_id integer REFERENCES categories(cat_id),
     PRIMARY KEY(coupon_id, cat_id)
 );
 
+CREATE INDEX in_stores_name_trgm ON stores USING GIN(store_name gin_trgm_ops);
+
+CREATE INDEX in_categories_name_trgm ON categories USING GIN(cat_name gin_trgm_ops);
+
+CREATE INDEX in_coupons_desc_trgm ON coupons USING GIN("desc" gin_trgm_ops);
+
 CREATE MATERIALIZED VIEW popular_coupons As
 SELECT
     coupon_id,
@@ -237,7 +490,7 @@ SELECT
 FROM
     (
         SELECT
-            coupon_id,
+            coupons.coupon_id,
             amount_off,
             percentage_off,
             currency_code,
@@ -248,14 +501,14 @@ FROM
             text_coupon_code,
             text_coupon_webUrl,
             store_id,
-            count(coupon_id) AS redeemed_count
+            count(redeemed_coupons.id) AS redeemed_count
         FROM
             coupons
-            INNER JOIN redeemed_coupons using(coupon_id)
+            INNER JOIN redeemed_coupons ON redeemed_coupons.coupon_id = coupons.coupon_id :: text
         WHERE
-            coupon_state = 'active'
+            coupons."state" = 'active'
         GROUP BY
-            coupon_id
+            coupons.coupon_id
         ORDER BY
             redeemed_count
     ) AS redeemed
@@ -5,22 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"image/png"
-	"log"
 	"os"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/skip2/go-qrcode"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrServerError = errors.New("the server developed an error while processing the request")
 )
 
-//COST for the hashing
-const COST = 14
-
 // Coupon represent a redeemable piece of text or qrcode
 type Coupon struct {
 	CouponID       string   `json:"coupon_id,omitempty"`
@@ -64,16 +59,6 @@ type Store struct {
 	ThemeColor  int        `json:"theme_color,omitempty"`
 }
 
-//HashPassword hash the supplied password
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), COST)
-	if err != nil {
-		log.Println(err)
-		return "", ErrServerError
-	}
-	return string(bytes), err
-}
-
 // returns a random uuid string
 func GenerateUUID() string {
 	uuid := uuid.NewString()
@@ -84,13 +69,6 @@ func CompareUUID(value1, value2 string) bool {
 
 }
 
-//CheckPasswordHash checks if the passwrod and hash are the same
-func CheckPasswordHash(hashed, submittedPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(submittedPassword))
-
-	return err == nil
-}
-
 func createUserWithPassword(email string, password string) error {
 	if len(password) > 64 {
 		// return password is too long to be accepted
@@ -0,0 +1,116 @@
+package storemanagement
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrInvalidAPIKey is returned if an API key is unknown or revoked
+	ErrInvalidAPIKey = errors.New("api key is invalid or revoked")
+	//ErrInvalidScope is returned if an API key is requested without scopes or with scopes keys cannot hold
+	ErrInvalidScope = errors.New("api key scope is invalid")
+	//ErrAPIKeyNotFound is returned if no active API key of the store has the id
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	//ErrAPIKeyOpFails is returned if an API key cannot be created
+	ErrAPIKeyOpFails = errors.New("unable to perform operation on api key")
+)
+
+//APIKeyPrefix starts every API key so they can be told apart from access tokens
+const APIKeyPrefix = "ccpos_"
+
+//apiKeyScopes are the permissions API keys can be granted
+var apiKeyScopes = []Permission{PermViewCoupons, PermVerifyCoupon}
+
+//APIKey is a credential a store terminal uses in place of an employee. Only the
+//hash of the key is stored, Prefix identifies it in listings and logs
+type APIKey struct {
+	ID         string       `json:"id"`
+	StoreID    string       `json:"store_id,omitempty"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix"`
+	Scopes     []Permission `json:"scopes"`
+	CreatedAt  time.Time    `json:"created_at"`
+	LastUsedAt *time.Time   `json:"last_used_at,omitempty"`
+}
+
+//CreateAPIKey creates an API key for the store, the key is only ever returned here
+func (s *service) CreateAPIKey(ctx context.Context, actor Actor, name string, scopes []Permission) (*APIKey, string, error) {
+	if !actor.Can(PermManageAPIKeys) {
+		return nil, "", ErrPermissionDenied
+	}
+	if len(scopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+	unique := []Permission{}
+	for _, scope := range scopes {
+		if !hasPermission(apiKeyScopes, scope) {
+			return nil, "", ErrInvalidScope
+		}
+		if !hasPermission(unique, scope) {
+			unique = append(unique, scope)
+		}
+	}
+
+	prefix, key, err := generateAPIKey()
+	if err != nil {
+		return nil, "", ErrAPIKeyOpFails
+	}
+	apiKey := &APIKey{
+		StoreID:   actor.StoreID,
+		Name:      strings.TrimSpace(name),
+		Prefix:    prefix,
+		Scopes:    unique,
+		CreatedAt: time.Now(),
+	}
+	apiKey.ID, err = s.repo.CreateAPIKey(ctx, *apiKey, hashToken(key))
+	if err != nil {
+		return nil, "", ErrAPIKeyOpFails
+	}
+	return apiKey, key, nil
+}
+
+//APIKeys lists the API keys of the store that are not revoked
+func (s *service) APIKeys(ctx context.Context, actor Actor) ([]APIKey, error) {
+	if !actor.Can(PermManageAPIKeys) {
+		return nil, ErrPermissionDenied
+	}
+	return s.repo.APIKeys(ctx, actor.StoreID)
+}
+
+//RevokeAPIKey stops the API key of the store from being accepted
+func (s *service) RevokeAPIKey(ctx context.Context, actor Actor, keyID string) error {
+	if !actor.Can(PermManageAPIKeys) {
+		return ErrPermissionDenied
+	}
+	return s.repo.RevokeAPIKey(ctx, actor.StoreID, keyID)
+}
+
+//AuthenticateAPIKey returns the active API key and records its use
+func (s *service) AuthenticateAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	return s.repo.UseAPIKey(ctx, hashToken(key))
+}
+
+//generate an API key of the form ccpos_<id>_<secret>, the prefix is the part up to the id
+func generateAPIKey() (string, string, error) {
+	id := make([]byte, 4)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", "", err
+	}
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+	prefix := APIKeyPrefix + hex.EncodeToString(id)
+	return prefix, prefix + "_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
@@ -0,0 +1,133 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type mockAPIKey struct {
+	APIKey
+	keyHash string
+	revoked bool
+}
+
+type mockAPIKeyRepo struct {
+	Repository
+	keys []*mockAPIKey
+}
+
+func (m *mockAPIKeyRepo) CreateAPIKey(ctx context.Context, key APIKey, keyHash string) (string, error) {
+	key.ID = strconv.Itoa(len(m.keys) + 1)
+	m.keys = append(m.keys, &mockAPIKey{APIKey: key, keyHash: keyHash})
+	return key.ID, nil
+}
+
+func (m *mockAPIKeyRepo) APIKeys(ctx context.Context, storeID string) ([]APIKey, error) {
+	var keys []APIKey
+	for _, key := range m.keys {
+		if key.StoreID == storeID && !key.revoked {
+			keys = append(keys, key.APIKey)
+		}
+	}
+	return keys, nil
+}
+
+func (m *mockAPIKeyRepo) RevokeAPIKey(ctx context.Context, storeID string, keyID string) error {
+	for _, key := range m.keys {
+		if key.ID == keyID && key.StoreID == storeID && !key.revoked {
+			key.revoked = true
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+func (m *mockAPIKeyRepo) UseAPIKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	for _, key := range m.keys {
+		if key.keyHash == keyHash && !key.revoked {
+			return &key.APIKey, nil
+		}
+	}
+	return nil, ErrInvalidAPIKey
+}
+
+func Test_service_APIKeys(t *testing.T) {
+	repo := &mockAPIKeyRepo{}
+	s := NewService(repo, &mockMailer{}, nil)
+	ctx := context.Background()
+	owner := Actor{UserID: "store", StoreID: "store", Roles: []string{RoleShopper, RoleOwner}}
+	other := Actor{UserID: "other", StoreID: "other", Roles: []string{RoleShopper, RoleOwner}}
+	employee := Actor{UserID: "emp", StoreID: "store", Roles: []string{RoleShopper, RoleEmployee}}
+
+	_, _, err := s.CreateAPIKey(ctx, employee, "till", []Permission{PermVerifyCoupon})
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("CreateAPIKey() by an employee error = %v", err)
+	}
+	for _, scopes := range [][]Permission{nil, {PermDeleteCoupon}, {PermVerifyCoupon, PermManageAPIKeys}} {
+		_, _, err = s.CreateAPIKey(ctx, owner, "till", scopes)
+		if !errors.Is(err, ErrInvalidScope) {
+			t.Errorf("CreateAPIKey(%v) error = %v, want %v", scopes, err, ErrInvalidScope)
+		}
+	}
+
+	apiKey, key, err := s.CreateAPIKey(ctx, owner, " till 1 ", []Permission{PermVerifyCoupon, PermVerifyCoupon})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, apiKey.Prefix+"_") || !strings.HasPrefix(apiKey.Prefix, APIKeyPrefix) {
+		t.Errorf("key %q does not start with its prefix %q", key, apiKey.Prefix)
+	}
+	if apiKey.Name != "till 1" || len(apiKey.Scopes) != 1 {
+		t.Errorf("CreateAPIKey() = %+v", apiKey)
+	}
+	if repo.keys[0].keyHash == key || strings.Contains(repo.keys[0].keyHash, apiKey.Prefix) {
+		t.Error("key is stored in plain text")
+	}
+
+	got, err := s.AuthenticateAPIKey(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actor := Actor{UserID: got.ID, StoreID: got.StoreID, Roles: []string{RoleAPIKey}, Scopes: got.Scopes}
+	if !actor.Can(PermVerifyCoupon) || actor.Can(PermViewCoupons) || actor.StoreID != "store" {
+		t.Errorf("actor of the key = %+v", actor)
+	}
+	_, err = s.AuthenticateAPIKey(ctx, key+"x")
+	if !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("AuthenticateAPIKey() of a wrong key error = %v", err)
+	}
+
+	keys, _ := s.APIKeys(ctx, owner)
+	if len(keys) != 1 {
+		t.Fatalf("APIKeys() = %v", keys)
+	}
+	err = s.RevokeAPIKey(ctx, other, apiKey.ID)
+	if !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("RevokeAPIKey() by another store error = %v", err)
+	}
+	err = s.RevokeAPIKey(ctx, owner, apiKey.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.AuthenticateAPIKey(ctx, key)
+	if !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("AuthenticateAPIKey() of a revoked key error = %v", err)
+	}
+}
+
+func Test_service_VerifyCouponWithAPIKey(t *testing.T) {
+	repo := &mockRepo{coupons: map[string]*Coupon{"c1": {CouponID: "c1", Store: Store{StoreID: "u1"}, State: CouponActive}}}
+	s := NewService(repo, &mockMailer{}, nil)
+	terminal := Actor{UserID: "k1", StoreID: "u1", Roles: []string{RoleAPIKey}, Scopes: []Permission{PermVerifyCoupon}}
+
+	err := s.VerifyCoupon(context.Background(), terminal, "c1", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.redemptions) != 1 || repo.redemptions[0].APIKeyID != "k1" || repo.redemptions[0].RedeemedBy != "" {
+		t.Errorf("VerifyCoupon() redeemed %+v, want it through the key k1", repo.redemptions)
+	}
+}
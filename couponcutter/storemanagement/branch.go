@@ -0,0 +1,216 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrBranchNotFound is returned if no open branch of the store is associated with the id
+	ErrBranchNotFound = errors.New("branch not found")
+	//ErrInvalidBranch is returned if a branch is created without a name
+	ErrInvalidBranch = errors.New("invalid branch")
+	//ErrBranchRequired is returned if a coupon limited to some branches is redeemed without saying where
+	ErrBranchRequired = errors.New("branch is required")
+	//ErrCouponNotAtBranch is returned if a coupon is redeemed at a branch it is not valid at
+	ErrCouponNotAtBranch = errors.New("coupon is not valid at this branch")
+)
+
+const (
+	//BranchOpen is the state of a branch taking part in the coupons of the store
+	BranchOpen = "open"
+	//BranchClosed is the state of a removed branch, its redemptions are kept
+	BranchClosed = "closed"
+)
+
+//Branch is a location of a store, a sub store of a chain
+type Branch struct {
+	ID        string    `json:"branch_id"`
+	StoreID   string    `json:"store_id"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address,omitempty"`
+	Location  *Location `json:"location,omitempty"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+//BranchEdit is used to create a branch or change its details, empty fields are left unchanged
+type BranchEdit struct {
+	Name    string
+	Address string
+	//Location is looked up from the address by the geocoder when it is not given
+	Location *Location
+}
+
+//BranchRedemptionCount is the number of coupons redeemed at a branch, the redemptions
+//made without a branch are counted with an empty branch id
+type BranchRedemptionCount struct {
+	BranchID   string `json:"branch_id,omitempty"`
+	BranchName string `json:"branch_name,omitempty"`
+	Count      uint   `json:"count"`
+}
+
+//Branches returns the branches of the store, closed ones included
+func (s *service) Branches(ctx context.Context, actor Actor) ([]Branch, error) {
+	if !actor.Can(PermViewStore) {
+		return nil, ErrPermissionDenied
+	}
+	return s.repo.Branches(ctx, actor.StoreID)
+}
+
+//AddBranch opens a new branch of the store
+func (s *service) AddBranch(ctx context.Context, actor Actor, edit BranchEdit) (*Branch, error) {
+	if !actor.Can(PermManageBranches) {
+		return nil, ErrPermissionDenied
+	}
+	edit.Name = strings.TrimSpace(edit.Name)
+	if edit.Name == "" {
+		return nil, ErrInvalidBranch
+	}
+	location, err := s.locate(ctx, edit.Address, edit.Location)
+	if err != nil {
+		return nil, err
+	}
+	branch := Branch{
+		StoreID:   actor.StoreID,
+		Name:      edit.Name,
+		Address:   strings.TrimSpace(edit.Address),
+		Location:  location,
+		State:     BranchOpen,
+		CreatedAt: time.Now(),
+	}
+	branch.ID, err = s.repo.CreateBranch(ctx, branch)
+	if err != nil {
+		return nil, err
+	}
+	return &branch, nil
+}
+
+//EditBranch changes the details of an open branch of the store
+func (s *service) EditBranch(ctx context.Context, actor Actor, branchID string, edit BranchEdit) error {
+	if !actor.Can(PermManageBranches) {
+		return ErrPermissionDenied
+	}
+	edit.Name = strings.TrimSpace(edit.Name)
+	location, err := s.locate(ctx, edit.Address, edit.Location)
+	if err != nil {
+		return err
+	}
+	edit.Location = location
+	return s.repo.EditBranch(ctx, actor.StoreID, branchID, edit)
+}
+
+//RemoveBranch closes a branch of the store. Its redemptions stay counted and the coupons
+//and employees limited to it are no longer valid or allowed there
+func (s *service) RemoveBranch(ctx context.Context, actor Actor, branchID string) error {
+	if !actor.Can(PermManageBranches) {
+		return ErrPermissionDenied
+	}
+	return s.repo.CloseBranch(ctx, actor.StoreID, branchID)
+}
+
+//AssignEmployeeBranches limits the employee to redeeming coupons at the branches, no branches
+//lets the employee redeem at every branch of the store
+func (s *service) AssignEmployeeBranches(ctx context.Context, actor Actor, employeeID string, branchIDs []string) error {
+	if !actor.Can(PermManageEmployees) {
+		return ErrPermissionDenied
+	}
+	return s.repo.SetEmployeeBranches(ctx, actor.StoreID, employeeID, uniqueIDs(branchIDs))
+}
+
+//SetCouponBranches limits the coupon to the branches, no branches makes it valid at every branch of the store
+func (s *service) SetCouponBranches(ctx context.Context, actor Actor, couponID string, branchIDs []string) error {
+	if !actor.Can(PermCreateCoupon) {
+		return ErrPermissionDenied
+	}
+	return s.repo.SetCouponBranches(ctx, actor.StoreID, couponID, uniqueIDs(branchIDs))
+}
+
+//BranchRedemptionCounts returns the number of coupons redeemed at each branch since the time,
+//the zero time counts every redemption
+func (s *service) BranchRedemptionCounts(ctx context.Context, actor Actor, since time.Time) ([]BranchRedemptionCount, error) {
+	if !actor.Can(PermViewStore) {
+		return nil, ErrPermissionDenied
+	}
+	return s.repo.BranchRedemptionCounts(ctx, actor.StoreID, since)
+}
+
+//redemptionBranch returns the branch the actor redeems the coupon at. It must be an open branch
+//of the store, one the coupon is valid at when the coupon is limited to some branches, and one
+//the employee is assigned to when the employee is limited to some branches. An employee assigned
+//to a single branch redeems there without saying so
+func (s *service) redemptionBranch(ctx context.Context, actor Actor, couponID string) (string, error) {
+	branchID := actor.BranchID
+	if isEmployeeOnly(actor) {
+		assigned, err := s.repo.EmployeeBranches(ctx, actor.StoreID, actor.UserID)
+		if err != nil {
+			return "", err
+		}
+		if branchID == "" && len(assigned) == 1 {
+			branchID = assigned[0]
+		}
+		if len(assigned) > 0 && !containsID(assigned, branchID) {
+			return "", ErrPermissionDenied
+		}
+	}
+	if branchID != "" {
+		branch, err := s.repo.Branch(ctx, actor.StoreID, branchID)
+		if err != nil {
+			return "", err
+		}
+		if branch.State != BranchOpen {
+			return "", ErrBranchNotFound
+		}
+	}
+
+	valid, err := s.repo.CouponBranches(ctx, actor.StoreID, couponID)
+	if err != nil {
+		return "", err
+	}
+	if len(valid) > 0 {
+		if branchID == "" {
+			return "", ErrBranchRequired
+		}
+		if !containsID(valid, branchID) {
+			return "", ErrCouponNotAtBranch
+		}
+	}
+	return branchID, nil
+}
+
+//isEmployeeOnly reports whether the actor acts on the store as an employee and nothing more
+func isEmployeeOnly(actor Actor) bool {
+	employee := false
+	for _, role := range actor.Roles {
+		switch role {
+		case RoleEmployee:
+			employee = true
+		case RoleOwner, RolePlatformAdmin:
+			return false
+		}
+	}
+	return employee
+}
+
+func containsID(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+//uniqueIDs drops the empty and repeated ids
+func uniqueIDs(ids []string) []string {
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id != "" && !containsID(unique, id) {
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
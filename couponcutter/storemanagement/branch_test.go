@@ -0,0 +1,159 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func (m *mockRepo) CreateBranch(ctx context.Context, branch Branch) (string, error) {
+	branch.ID = "b" + strconv.Itoa(len(m.branches)+1)
+	m.branches = append(m.branches, branch)
+	return branch.ID, nil
+}
+
+func (m *mockRepo) Branch(ctx context.Context, storeID string, branchID string) (*Branch, error) {
+	for _, b := range m.branches {
+		if b.ID == branchID && b.StoreID == storeID {
+			return &b, nil
+		}
+	}
+	return nil, ErrBranchNotFound
+}
+
+func (m *mockRepo) EmployeeBranches(ctx context.Context, storeID string, userID string) ([]string, error) {
+	return m.employeeBranches[userID], nil
+}
+
+func (m *mockRepo) CouponBranches(ctx context.Context, storeID string, couponID string) ([]string, error) {
+	return m.couponBranches[couponID], nil
+}
+
+//VerifyCoupon checks the limit per shopper the way the database does under the coupon lock
+func (m *mockRepo) VerifyCoupon(ctx context.Context, redemption Redemption) error {
+	if c, ok := m.coupons[redemption.CouponID]; ok && c.UserLimit.Count > 0 {
+		if redemption.ShopperID == "" {
+			return ErrShopperRequired
+		}
+		since := c.UserLimit.PeriodStart(redemption.RedeemedAt, StoreLocation(c.Store.Timezone))
+		var redeemed uint
+		for _, r := range m.redemptions {
+			if r.CouponID == redemption.CouponID && r.ShopperID == redemption.ShopperID && !r.RedeemedAt.Before(since) {
+				redeemed++
+			}
+		}
+		if redeemed >= c.UserLimit.Count {
+			return ErrCouponUserLimitReached
+		}
+	}
+	m.redemptions = append(m.redemptions, redemption)
+	return nil
+}
+
+func Test_service_AddBranch(t *testing.T) {
+	geocoder := NewStaticGeocoder(map[string]Location{
+		"3 Marina Road, Lagos": {Latitude: 6.4541, Longitude: 3.3947},
+	})
+	owner := Actor{UserID: "u1", StoreID: "u1", Roles: []string{RoleOwner}}
+
+	tests := []struct {
+		name    string
+		actor   Actor
+		edit    BranchEdit
+		want    *Location
+		wantErr error
+	}{
+		{name: "geocoded address", actor: owner, edit: BranchEdit{Name: " Marina ", Address: "3 marina road, lagos"},
+			want: &Location{Latitude: 6.4541, Longitude: 3.3947}},
+		{name: "no address", actor: owner, edit: BranchEdit{Name: "Kiosk"}},
+		{name: "no name", actor: owner, edit: BranchEdit{Name: "  ", Address: "3 Marina Road, Lagos"}, wantErr: ErrInvalidBranch},
+		{name: "unknown address", actor: owner, edit: BranchEdit{Name: "Kiosk", Address: "nowhere"}, wantErr: ErrAddressNotFound},
+		{name: "employee", actor: Actor{UserID: "u2", StoreID: "u1", Roles: []string{RoleEmployee}},
+			edit: BranchEdit{Name: "Kiosk"}, wantErr: ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockRepo{}
+			s := NewService(repo, &mockMailer{}, geocoder)
+			branch, err := s.AddBranch(context.Background(), tt.actor, tt.edit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AddBranch() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(repo.branches) != 0 {
+					t.Error("AddBranch() saved a rejected branch")
+				}
+				return
+			}
+			if branch.ID == "" || branch.StoreID != tt.actor.StoreID || branch.State != BranchOpen {
+				t.Errorf("AddBranch() = %+v", branch)
+			}
+			if branch.Name != "Marina" && branch.Name != "Kiosk" {
+				t.Errorf("AddBranch() name = %q, want it trimmed", branch.Name)
+			}
+			got := branch.Location
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("AddBranch() location %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_service_VerifyCouponAtBranch(t *testing.T) {
+	owner := Actor{UserID: "u1", StoreID: "u1", Roles: []string{RoleOwner}}
+	employee := Actor{UserID: "u2", StoreID: "u1", Roles: []string{RoleEmployee}}
+	at := func(actor Actor, branchID string) Actor {
+		actor.BranchID = branchID
+		return actor
+	}
+
+	tests := []struct {
+		name     string
+		actor    Actor
+		couponID string
+		want     string
+		wantErr  error
+	}{
+		{name: "coupon for every branch without a branch", actor: owner, couponID: "c1", want: ""},
+		{name: "coupon for every branch at a branch", actor: at(owner, "b2"), couponID: "c1", want: "b2"},
+		{name: "limited coupon at its branch", actor: at(owner, "b1"), couponID: "c2", want: "b1"},
+		{name: "limited coupon at another branch", actor: at(owner, "b2"), couponID: "c2", wantErr: ErrCouponNotAtBranch},
+		{name: "limited coupon without a branch", actor: owner, couponID: "c2", wantErr: ErrBranchRequired},
+		{name: "closed branch", actor: at(owner, "b3"), couponID: "c1", wantErr: ErrBranchNotFound},
+		{name: "unknown branch", actor: at(owner, "b9"), couponID: "c1", wantErr: ErrBranchNotFound},
+		{name: "employee of a single branch", actor: employee, couponID: "c2", want: "b1"},
+		{name: "employee at another branch", actor: at(employee, "b2"), couponID: "c1", wantErr: ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockRepo{
+				branches: []Branch{
+					{ID: "b1", StoreID: "u1", Name: "Marina", State: BranchOpen},
+					{ID: "b2", StoreID: "u1", Name: "Ikeja", State: BranchOpen},
+					{ID: "b3", StoreID: "u1", Name: "Yaba", State: BranchClosed},
+				},
+				employeeBranches: map[string][]string{"u2": {"b1"}},
+				couponBranches:   map[string][]string{"c2": {"b1"}},
+				coupons: map[string]*Coupon{
+					"c1": {CouponID: "c1", Store: Store{StoreID: "u1"}, State: CouponActive},
+					"c2": {CouponID: "c2", Store: Store{StoreID: "u1"}, State: CouponActive},
+				},
+			}
+			s := NewService(repo, &mockMailer{}, nil)
+			err := s.VerifyCoupon(context.Background(), tt.actor, tt.couponID, "", "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyCoupon() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(repo.redemptions) != 0 {
+					t.Error("VerifyCoupon() redeemed a rejected coupon")
+				}
+				return
+			}
+			if len(repo.redemptions) != 1 || repo.redemptions[0].BranchID != tt.want {
+				t.Errorf("VerifyCoupon() redeemed %v, want at %q", repo.redemptions, tt.want)
+			}
+		})
+	}
+}
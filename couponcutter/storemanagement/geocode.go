@@ -0,0 +1,80 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	//ErrAddressNotFound is returned if the address of a store can not be turned into coordinates
+	ErrAddressNotFound = errors.New("address not found")
+	//ErrInvalidLocation is returned if the coordinates of a store are out of range
+	ErrInvalidLocation = errors.New("invalid location")
+)
+
+//Location is the geocoordinates of a store in degrees
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+//valid reports whether the coordinates are on earth
+func (l Location) valid() bool {
+	return l.Latitude >= -90 && l.Latitude <= 90 && l.Longitude >= -180 && l.Longitude <= 180
+}
+
+//Geocoder turns the address of a store into its coordinates
+type Geocoder interface {
+	//Geocode returns ErrAddressNotFound if the address is unknown
+	Geocode(ctx context.Context, address string) (*Location, error)
+}
+
+//StaticGeocoder looks addresses up in a fixed table, it stands in for a geocoding service
+type StaticGeocoder struct {
+	table map[string]Location
+}
+
+//NewStaticGeocoder returns a geocoder knowing the addresses of the table, addresses are
+//matched regardless of case and spacing
+func NewStaticGeocoder(table map[string]Location) *StaticGeocoder {
+	g := &StaticGeocoder{table: make(map[string]Location, len(table))}
+	for address, location := range table {
+		g.table[normalizeAddress(address)] = location
+	}
+	return g
+}
+
+//Geocode returns the coordinates of the address in the table
+func (g *StaticGeocoder) Geocode(ctx context.Context, address string) (*Location, error) {
+	location, ok := g.table[normalizeAddress(address)]
+	if !ok {
+		return nil, ErrAddressNotFound
+	}
+	return &location, nil
+}
+
+func normalizeAddress(address string) string {
+	return strings.Join(strings.Fields(strings.ToLower(address)), " ")
+}
+
+//locate returns the given location, or the location of the address when none is given
+func (s *service) locate(ctx context.Context, address string, location *Location) (*Location, error) {
+	if location != nil {
+		if !location.valid() {
+			return nil, ErrInvalidLocation
+		}
+		return location, nil
+	}
+	if strings.TrimSpace(address) == "" || s.geocoder == nil {
+		return nil, nil
+	}
+	location, err := s.geocoder.Geocode(ctx, address)
+	if err != nil {
+		if errors.Is(err, ErrAddressNotFound) {
+			return nil, ErrAddressNotFound
+		}
+		return nil, err
+	}
+	return location, nil
+}
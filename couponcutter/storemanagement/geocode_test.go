@@ -0,0 +1,79 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func (m *mockRepo) EditStore(ctx context.Context, storeID string, edit StoreEdit) error {
+	m.edit = &edit
+	return nil
+}
+
+func Test_service_EditStore(t *testing.T) {
+	geocoder := NewStaticGeocoder(map[string]Location{
+		"12 Allen Avenue, Ikeja": {Latitude: 6.6018, Longitude: 3.3515},
+	})
+	owner := Actor{UserID: "u1", StoreID: "u1", Roles: []string{RoleOwner}}
+	window := func(d time.Duration) *time.Duration { return &d }
+
+	tests := []struct {
+		name    string
+		actor   Actor
+		edit    StoreEdit
+		want    *Location
+		wantErr error
+	}{
+		{name: "geocoded address", actor: owner, edit: StoreEdit{Address: "  12 allen avenue,   IKEJA "},
+			want: &Location{Latitude: 6.6018, Longitude: 3.3515}},
+		{name: "given coordinates win", actor: owner,
+			edit: StoreEdit{Address: "12 Allen Avenue, Ikeja", Location: &Location{Latitude: 1, Longitude: 2}},
+			want: &Location{Latitude: 1, Longitude: 2}},
+		{name: "no address", actor: owner, edit: StoreEdit{Name: "Corner shop"}},
+		{name: "unknown address", actor: owner, edit: StoreEdit{Address: "nowhere"}, wantErr: ErrAddressNotFound},
+		{name: "coordinates out of range", actor: owner, edit: StoreEdit{Location: &Location{Latitude: 95}}, wantErr: ErrInvalidLocation},
+		{name: "timezone", actor: owner, edit: StoreEdit{Timezone: "Africa/Lagos"}},
+		{name: "unknown timezone", actor: owner, edit: StoreEdit{Timezone: "Mars/Olympus"}, wantErr: ErrInvalidTimezone},
+		{name: "local timezone", actor: owner, edit: StoreEdit{Timezone: "Local"}, wantErr: ErrInvalidTimezone},
+		{name: "void window", actor: owner, edit: StoreEdit{VoidWindow: window(time.Hour)}},
+		{name: "voids disabled", actor: owner, edit: StoreEdit{VoidWindow: window(0)}},
+		{name: "void window too long", actor: owner, edit: StoreEdit{VoidWindow: window(MaxVoidWindow + time.Minute)}, wantErr: ErrInvalidVoidWindow},
+		{name: "employee", actor: Actor{UserID: "u2", StoreID: "u1", Roles: []string{RoleEmployee}},
+			edit: StoreEdit{Address: "12 Allen Avenue, Ikeja"}, wantErr: ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockRepo{}
+			s := NewService(repo, &mockMailer{}, geocoder)
+			err := s.EditStore(context.Background(), tt.actor, tt.edit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EditStore() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if repo.edit != nil {
+					t.Error("EditStore() saved a rejected edit")
+				}
+				return
+			}
+			got := repo.edit.Location
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("EditStore() saved location %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_service_EditStoreWithoutGeocoder(t *testing.T) {
+	owner := Actor{UserID: "u1", StoreID: "u1", Roles: []string{RoleOwner}}
+	repo := &mockRepo{}
+	s := NewService(repo, &mockMailer{}, nil)
+	err := s.EditStore(context.Background(), owner, StoreEdit{Address: "12 Allen Avenue, Ikeja"})
+	if err != nil {
+		t.Fatalf("EditStore() error = %v", err)
+	}
+	if repo.edit.Address != "12 Allen Avenue, Ikeja" || repo.edit.Location != nil {
+		t.Errorf("EditStore() saved %+v, want the address without location", repo.edit)
+	}
+}
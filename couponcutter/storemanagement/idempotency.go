@@ -0,0 +1,125 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrInvalidIdempotencyKey is returned if an idempotency key is too long
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+	//ErrIdempotencyKeyReused is returned if an idempotency key is sent again for another redemption
+	ErrIdempotencyKeyReused = errors.New("idempotency key already used for another request")
+	//ErrRedemptionInProgress is returned if a retry arrives while the request with the same key is still running
+	ErrRedemptionInProgress = errors.New("redemption with this idempotency key is in progress")
+)
+
+//IdempotencyRetention is how long the outcome of a redemption sent with an idempotency key is replayed
+const IdempotencyRetention = 24 * time.Hour
+
+//idempotencyLease is how long a redemption in progress holds its key, a key whose outcome could
+//not be stored is claimed again once it ends. It is far longer than a redemption takes
+const idempotencyLease = 30 * time.Second
+
+//maxIdempotencyKeyLength is the length of the longest idempotency key accepted
+const maxIdempotencyKeyLength = 255
+
+//outcomeRedeemed is the outcome of a successful redemption
+const outcomeRedeemed = "redeemed"
+
+//redemptionOutcomes are the outcomes of a redemption kept for its idempotency key. Rejections a
+//retry could overturn, such as a coupon outside its window or a missing branch, are not kept
+var redemptionOutcomes = map[string]error{
+	outcomeRedeemed:      nil,
+	"coupon_not_valid":   ErrCouponNotValid,
+	"coupon_used":        ErrCouponIsUsed,
+	"limit_exceeded":     ErrCouponLimitExceeded,
+	"user_limit_reached": ErrCouponUserLimitReached,
+}
+
+//IdempotencyRecord is a redemption sent with an idempotency key, Outcome is empty while the
+//redemption is in progress and ExpiredAt the end of its lease until the outcome is kept
+type IdempotencyRecord struct {
+	StoreID string
+	Key     string
+	//Fingerprint identifies the redemption the key was first sent for
+	Fingerprint string
+	Outcome     string
+	CreatedAt   time.Time
+	ExpiredAt   time.Time
+}
+
+//redemptionFingerprint identifies the redemption of the coupon by the actor for the shopper
+func redemptionFingerprint(actor Actor, couponID string, shopperID string) string {
+	return strings.Join([]string{actor.UserID, actor.BranchID, couponID, shopperID}, "\x00")
+}
+
+//redemptionOutcome returns the kept outcome of the error of a redemption, false if it is not kept
+func redemptionOutcome(err error) (string, bool) {
+	for outcome, e := range redemptionOutcomes {
+		if errors.Is(err, e) {
+			return outcome, true
+		}
+	}
+	return "", false
+}
+
+//VerifyCoupon redeems the coupon for the shopper. A redemption sent again with the same
+//idempotency key within IdempotencyRetention gets the outcome of the first one instead of
+//being redeemed twice
+func (s *service) VerifyCoupon(ctx context.Context, actor Actor, couponid string, shopperID string, idempotencyKey string) error {
+	if !actor.Can(PermVerifyCoupon) {
+		return ErrPermissionDenied
+	}
+	if idempotencyKey == "" {
+		return s.verifyCoupon(ctx, actor, couponid, shopperID)
+	}
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return ErrInvalidIdempotencyKey
+	}
+
+	now := time.Now()
+	record := IdempotencyRecord{
+		StoreID:     actor.StoreID,
+		Key:         idempotencyKey,
+		Fingerprint: redemptionFingerprint(actor, couponid, shopperID),
+		CreatedAt:   now,
+		ExpiredAt:   now.Add(idempotencyLease),
+	}
+	previous, err := s.repo.ClaimIdempotencyKey(ctx, record)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		if previous.Fingerprint != record.Fingerprint {
+			return ErrIdempotencyKeyReused
+		}
+		if previous.Outcome == "" {
+			return ErrRedemptionInProgress
+		}
+		return redemptionOutcomes[previous.Outcome]
+	}
+
+	err = s.verifyCoupon(ctx, actor, couponid, shopperID)
+	//the redemption stands whatever happens to its key, a key left in progress expires with its lease
+	if outcome, kept := redemptionOutcome(err); kept {
+		keyErr := s.repo.CompleteIdempotencyKey(ctx, actor.StoreID, idempotencyKey, outcome, time.Now().Add(IdempotencyRetention))
+		if keyErr != nil {
+			log.Println(keyErr)
+		}
+	} else {
+		keyErr := s.repo.ReleaseIdempotencyKey(ctx, actor.StoreID, idempotencyKey)
+		if keyErr != nil {
+			log.Println(keyErr)
+		}
+	}
+	return err
+}
+
+//PurgeIdempotencyKeys removes the idempotency keys whose retention has ended
+func (s *service) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	return s.repo.DeleteExpiredIdempotencyKeys(ctx)
+}
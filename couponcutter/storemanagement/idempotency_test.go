@@ -0,0 +1,111 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func (m *mockRepo) ClaimIdempotencyKey(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error) {
+	if m.idempotency == nil {
+		m.idempotency = make(map[string]*IdempotencyRecord)
+	}
+	held, ok := m.idempotency[record.StoreID+"/"+record.Key]
+	if ok && held.ExpiredAt.After(record.CreatedAt) {
+		previous := *held
+		return &previous, nil
+	}
+	m.idempotency[record.StoreID+"/"+record.Key] = &record
+	return nil, nil
+}
+
+func (m *mockRepo) CompleteIdempotencyKey(ctx context.Context, storeID string, key string, outcome string, expiredAt time.Time) error {
+	if m.keyErr != nil {
+		return m.keyErr
+	}
+	m.idempotency[storeID+"/"+key].Outcome = outcome
+	m.idempotency[storeID+"/"+key].ExpiredAt = expiredAt
+	return nil
+}
+
+func (m *mockRepo) ReleaseIdempotencyKey(ctx context.Context, storeID string, key string) error {
+	if m.keyErr != nil {
+		return m.keyErr
+	}
+	delete(m.idempotency, storeID+"/"+key)
+	return nil
+}
+
+func Test_service_VerifyCouponIdempotency(t *testing.T) {
+	owner := Actor{UserID: "u1", StoreID: "u1", Roles: []string{RoleOwner}}
+	type attempt struct {
+		couponID string
+		key      string
+		wantErr  error
+	}
+	closed := []ValidityWindow{{Days: []time.Weekday{(time.Now().UTC().Weekday() + 1) % 7}, Start: "00:00", End: "24:00"}}
+
+	tests := []struct {
+		name     string
+		held     *IdempotencyRecord
+		attempts []attempt
+		//keyErr fails the writes of the outcome of the key
+		keyErr error
+		//redeemed is the number of redemptions recorded
+		redeemed int
+	}{
+		{name: "retry replays the redemption", redeemed: 1, attempts: []attempt{
+			{couponID: "c1", key: "k1"}, {couponID: "c1", key: "k1"}}},
+		{name: "new key redeems again", redeemed: 2, attempts: []attempt{
+			{couponID: "c1", key: "k1"}, {couponID: "c1", key: "k2"}}},
+		{name: "no key redeems again", redeemed: 2, attempts: []attempt{
+			{couponID: "c1"}, {couponID: "c1"}}},
+		{name: "retry replays the rejection", redeemed: 0, attempts: []attempt{
+			{couponID: "c9", key: "k1", wantErr: ErrCouponNotValid}, {couponID: "c9", key: "k1", wantErr: ErrCouponNotValid}}},
+		{name: "key sent for another coupon", redeemed: 1, attempts: []attempt{
+			{couponID: "c1", key: "k1"}, {couponID: "c2", key: "k1", wantErr: ErrIdempotencyKeyReused}}},
+		{name: "rejection a retry can overturn is not kept", redeemed: 1, attempts: []attempt{
+			{couponID: "closed", key: "k1", wantErr: ErrCouponOutsideWindow}, {couponID: "c1", key: "k1"}}},
+		{name: "retry while in progress", redeemed: 0,
+			held: &IdempotencyRecord{StoreID: "u1", Key: "k1", Fingerprint: redemptionFingerprint(owner, "c1", ""),
+				CreatedAt: time.Now(), ExpiredAt: time.Now().Add(idempotencyLease)},
+			attempts: []attempt{{couponID: "c1", key: "k1", wantErr: ErrRedemptionInProgress}}},
+		{name: "retry after the lease of a key left in progress", redeemed: 1,
+			held: &IdempotencyRecord{StoreID: "u1", Key: "k1", Fingerprint: redemptionFingerprint(owner, "c1", ""),
+				CreatedAt: time.Now().Add(-idempotencyLease * 2), ExpiredAt: time.Now().Add(-idempotencyLease)},
+			attempts: []attempt{{couponID: "c1", key: "k1"}}},
+		{name: "outcome not stored", redeemed: 1, keyErr: errors.New("connection lost"), attempts: []attempt{
+			{couponID: "c1", key: "k1"}, {couponID: "c1", key: "k1", wantErr: ErrRedemptionInProgress}}},
+		{name: "rejection not released", redeemed: 0, keyErr: errors.New("connection lost"), attempts: []attempt{
+			{couponID: "closed", key: "k1", wantErr: ErrCouponOutsideWindow}, {couponID: "closed", key: "k1", wantErr: ErrRedemptionInProgress}}},
+		{name: "expired key is claimed again", redeemed: 1,
+			held: &IdempotencyRecord{StoreID: "u1", Key: "k1", Fingerprint: redemptionFingerprint(owner, "c2", ""), Outcome: outcomeRedeemed,
+				CreatedAt: time.Now().Add(-IdempotencyRetention * 2), ExpiredAt: time.Now().Add(-IdempotencyRetention)},
+			attempts: []attempt{{couponID: "c1", key: "k1"}}},
+		{name: "key too long", redeemed: 0, attempts: []attempt{
+			{couponID: "c1", key: string(make([]byte, maxIdempotencyKeyLength+1)), wantErr: ErrInvalidIdempotencyKey}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockRepo{coupons: map[string]*Coupon{
+				"c1":     {CouponID: "c1", Store: Store{StoreID: "u1"}, State: CouponActive},
+				"c2":     {CouponID: "c2", Store: Store{StoreID: "u1"}, State: CouponActive},
+				"closed": {CouponID: "closed", Store: Store{StoreID: "u1"}, State: CouponActive, Windows: closed},
+			}, keyErr: tt.keyErr}
+			if tt.held != nil {
+				repo.idempotency = map[string]*IdempotencyRecord{"u1/k1": tt.held}
+			}
+			s := NewService(repo, &mockMailer{}, nil)
+			for i, a := range tt.attempts {
+				err := s.VerifyCoupon(context.Background(), owner, a.couponID, "", a.key)
+				if !errors.Is(err, a.wantErr) {
+					t.Fatalf("VerifyCoupon() attempt %d error = %v, want %v", i+1, err, a.wantErr)
+				}
+			}
+			if len(repo.redemptions) != tt.redeemed {
+				t.Errorf("VerifyCoupon() redeemed %d times, want %d", len(repo.redemptions), tt.redeemed)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package storemanagement
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrInvalidEmail is returned if an invite is sent to an invalid email address
+	ErrInvalidEmail = errors.New("email is invalid")
+	//ErrInvalidInvite is returned if an invite is unknown, expired, revoked or already accepted
+	ErrInvalidInvite = errors.New("invite is invalid or expired")
+	//ErrInviteEmailMismatch is returned if an invite is accepted by a user with another email address
+	ErrInviteEmailMismatch = errors.New("invite was sent to another email address")
+	//ErrInviteeRegistered is returned if an invite is accepted with a new account for an email that already has one
+	ErrInviteeRegistered = errors.New("an account already exists for the invited email")
+)
+
+//inviteTTL is how long an invited employee has to accept the invite
+const inviteTTL = time.Hour * 24 * 7
+
+//Invite is a pending invitation of an employee to a store
+type Invite struct {
+	ID        string    `json:"id"`
+	StoreID   string    `json:"store_id,omitempty"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+//Mailer delivers messages such as employee invites
+type Mailer interface {
+	Send(ctx context.Context, to string, subject string, body string) error
+}
+
+//InviteEmployee sends an invite to the email, the employee is added to the store once they accept it
+func (s *service) InviteEmployee(ctx context.Context, actor Actor, email string) (*Invite, error) {
+	if !actor.Can(PermManageEmployees) {
+		return nil, ErrPermissionDenied
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return nil, ErrInvalidEmail
+	}
+
+	token, err := generateInviteToken()
+	if err != nil {
+		return nil, ErrEmployeeOpFails
+	}
+	expiredAt := time.Now().Add(inviteTTL)
+	id, err := s.repo.CreateInvite(ctx, actor.StoreID, email, hashToken(token), expiredAt)
+	if err != nil {
+		return nil, ErrEmployeeOpFails
+	}
+
+	body := fmt.Sprintf("You have been invited to join a store on couponcutter as an employee.\n\n"+
+		"Accept the invite with the following code, it expires in %v:\n\n%s\n\n"+
+		"If you do not have an account yet one is created for this email address when you accept.", inviteTTL, token)
+	err = s.mailer.Send(ctx, email, "You have been invited to a store", body)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrEmployeeOpFails
+	}
+	return &Invite{ID: id, StoreID: actor.StoreID, Email: email, CreatedAt: time.Now(), ExpiredAt: expiredAt}, nil
+}
+
+//Invites lists the pending invites of the store
+func (s *service) Invites(ctx context.Context, actor Actor) ([]Invite, error) {
+	if !actor.Can(PermManageEmployees) {
+		return nil, ErrPermissionDenied
+	}
+	return s.repo.Invites(ctx, actor.StoreID)
+}
+
+//RevokeInvite stops a pending invite of the store from being accepted
+func (s *service) RevokeInvite(ctx context.Context, actor Actor, inviteID string) error {
+	if !actor.Can(PermManageEmployees) {
+		return ErrPermissionDenied
+	}
+	return s.repo.RevokeInvite(ctx, actor.StoreID, inviteID)
+}
+
+//InviteWithToken returns the pending invite the token was sent for
+func (s *service) InviteWithToken(ctx context.Context, token string) (*Invite, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, ErrInvalidInvite
+	}
+	return s.repo.InviteWithToken(ctx, hashToken(token))
+}
+
+//AcceptInvite makes the user an active employee of the store that invited them,
+//the email of the user has to be the one the invite was sent to
+func (s *service) AcceptInvite(ctx context.Context, token string, userID string) error {
+	token = strings.TrimSpace(token)
+	if token == "" || userID == "" {
+		return ErrInvalidInvite
+	}
+	return s.repo.AcceptInvite(ctx, hashToken(token), userID)
+}
+
+//AcceptInviteAsNewUser creates a user with the email the invite was sent to and makes them an
+//active employee of the store in one step, so a failed acceptance leaves no account behind.
+//The password must have been checked against the password policy
+func (s *service) AcceptInviteAsNewUser(ctx context.Context, token string, password string) (string, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", ErrInvalidInvite
+	}
+	return s.repo.AcceptInviteAsNewUser(ctx, hashToken(token), password)
+}
+
+//generate a random url safe invite token
+func generateInviteToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//invite tokens and API keys are stored hashed so a leaked table cannot be used to act for a store
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,241 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockInvite struct {
+	Invite
+	tokenHash string
+	revoked   bool
+	accepted  bool
+}
+
+type mockRepo struct {
+	Repository
+	invites   []*mockInvite
+	users     map[string]string
+	employees map[string]string
+	edit      *StoreEdit
+
+	branches         []Branch
+	employeeBranches map[string][]string
+	couponBranches   map[string][]string
+	redemptions      []Redemption
+
+	coupons     map[string]*Coupon
+	couponEdits []CouponEdit
+	created     *CreateCoupon
+
+	idempotency map[string]*IdempotencyRecord
+	keyErr      error
+
+	voidWindow time.Duration
+	voids      []RedemptionVoid
+}
+
+func (m *mockRepo) CreateInvite(ctx context.Context, storeID string, email string, tokenHash string, expiredAt time.Time) (string, error) {
+	id := strconv.Itoa(len(m.invites) + 1)
+	m.invites = append(m.invites, &mockInvite{
+		Invite:    Invite{ID: id, StoreID: storeID, Email: email, CreatedAt: time.Now(), ExpiredAt: expiredAt},
+		tokenHash: tokenHash,
+	})
+	return id, nil
+}
+
+func (m *mockRepo) pending(invite *mockInvite) bool {
+	return !invite.revoked && !invite.accepted && invite.ExpiredAt.After(time.Now())
+}
+
+func (m *mockRepo) Invites(ctx context.Context, storeID string) ([]Invite, error) {
+	var invites []Invite
+	for _, invite := range m.invites {
+		if invite.StoreID == storeID && m.pending(invite) {
+			invites = append(invites, invite.Invite)
+		}
+	}
+	return invites, nil
+}
+
+func (m *mockRepo) RevokeInvite(ctx context.Context, storeID string, inviteID string) error {
+	for _, invite := range m.invites {
+		if invite.ID == inviteID && invite.StoreID == storeID && m.pending(invite) {
+			invite.revoked = true
+			return nil
+		}
+	}
+	return ErrInvalidInvite
+}
+
+func (m *mockRepo) InviteWithToken(ctx context.Context, tokenHash string) (*Invite, error) {
+	for _, invite := range m.invites {
+		if invite.tokenHash == tokenHash && m.pending(invite) {
+			return &invite.Invite, nil
+		}
+	}
+	return nil, ErrInvalidInvite
+}
+
+func (m *mockRepo) AcceptInvite(ctx context.Context, tokenHash string, userID string) error {
+	for _, invite := range m.invites {
+		if invite.tokenHash == tokenHash && m.pending(invite) {
+			if m.users[userID] != invite.Email {
+				return ErrInviteEmailMismatch
+			}
+			invite.accepted = true
+			m.employees[userID] = invite.StoreID
+			return nil
+		}
+	}
+	return ErrInvalidInvite
+}
+
+func (m *mockRepo) AcceptInviteAsNewUser(ctx context.Context, tokenHash string, password string) (string, error) {
+	for _, invite := range m.invites {
+		if invite.tokenHash == tokenHash && m.pending(invite) {
+			for _, email := range m.users {
+				if email == invite.Email {
+					return "", ErrInviteeRegistered
+				}
+			}
+			userID := "new" + invite.ID
+			m.users[userID] = invite.Email
+			invite.accepted = true
+			m.employees[userID] = invite.StoreID
+			return userID, nil
+		}
+	}
+	return "", ErrInvalidInvite
+}
+
+type mockMailer struct {
+	to   string
+	body string
+}
+
+func (m *mockMailer) Send(ctx context.Context, to string, subject string, body string) error {
+	m.to = to
+	m.body = body
+	return nil
+}
+
+//token returns the invite token of the last mail
+func (m *mockMailer) token() string {
+	lines := strings.Split(m.body, "\n")
+	for i, line := range lines {
+		if strings.HasSuffix(line, ":") && i+2 < len(lines) {
+			return lines[i+2]
+		}
+	}
+	return ""
+}
+
+func Test_service_Invites(t *testing.T) {
+	repo := &mockRepo{
+		users:     map[string]string{"emp": "emp@gmail.com", "other": "other@gmail.com"},
+		employees: map[string]string{},
+	}
+	mailer := &mockMailer{}
+	s := NewService(repo, mailer, nil)
+	ctx := context.Background()
+	owner := Actor{UserID: "store", StoreID: "store", Roles: []string{RoleShopper, RoleOwner}}
+	employee := Actor{UserID: "emp2", StoreID: "store", Roles: []string{RoleShopper, RoleEmployee}}
+
+	_, err := s.InviteEmployee(ctx, employee, "emp@gmail.com")
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("InviteEmployee() by an employee error = %v", err)
+	}
+	_, err = s.InviteEmployee(ctx, owner, "not an email")
+	if !errors.Is(err, ErrInvalidEmail) {
+		t.Fatalf("InviteEmployee() of an invalid email error = %v", err)
+	}
+
+	invite, err := s.InviteEmployee(ctx, owner, " Emp@gmail.com ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := mailer.token()
+	if mailer.to != "emp@gmail.com" || token == "" {
+		t.Fatalf("invite mailed to %q with token %q", mailer.to, token)
+	}
+	if repo.invites[0].tokenHash == token {
+		t.Error("invite token is stored in plain text")
+	}
+
+	invites, _ := s.Invites(ctx, owner)
+	if len(invites) != 1 || invites[0].ID != invite.ID {
+		t.Fatalf("Invites() = %v", invites)
+	}
+
+	err = s.AcceptInvite(ctx, token, "other")
+	if !errors.Is(err, ErrInviteEmailMismatch) {
+		t.Fatalf("AcceptInvite() by another user error = %v", err)
+	}
+	err = s.AcceptInvite(ctx, token, "emp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.employees["emp"] != "store" {
+		t.Errorf("employee was not added to the store")
+	}
+	err = s.AcceptInvite(ctx, token, "emp")
+	if !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("AcceptInvite() twice error = %v", err)
+	}
+
+	//a revoked invite can no longer be accepted
+	invite, _ = s.InviteEmployee(ctx, owner, "other@gmail.com")
+	token = mailer.token()
+	err = s.RevokeInvite(ctx, owner, invite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.InviteWithToken(ctx, token)
+	if !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("InviteWithToken() of a revoked invite error = %v", err)
+	}
+	invites, _ = s.Invites(ctx, owner)
+	if len(invites) != 0 {
+		t.Errorf("Invites() after accepting and revoking = %v", invites)
+	}
+}
+
+func Test_service_AcceptInviteAsNewUser(t *testing.T) {
+	repo := &mockRepo{
+		users:     map[string]string{"emp": "emp@gmail.com"},
+		employees: map[string]string{},
+	}
+	mailer := &mockMailer{}
+	s := NewService(repo, mailer, nil)
+	ctx := context.Background()
+	owner := Actor{UserID: "store", StoreID: "store", Roles: []string{RoleShopper, RoleOwner}}
+
+	s.InviteEmployee(ctx, owner, "emp@gmail.com")
+	_, err := s.AcceptInviteAsNewUser(ctx, mailer.token(), "password")
+	if !errors.Is(err, ErrInviteeRegistered) {
+		t.Fatalf("AcceptInviteAsNewUser() of a registered email error = %v, want %v", err, ErrInviteeRegistered)
+	}
+	users := len(repo.users)
+
+	s.InviteEmployee(ctx, owner, "new@gmail.com")
+	token := mailer.token()
+	userID, err := s.AcceptInviteAsNewUser(ctx, token, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.users[userID] != "new@gmail.com" || repo.employees[userID] != "store" || len(repo.users) != users+1 {
+		t.Errorf("AcceptInviteAsNewUser() did not create the employee %q", userID)
+	}
+	_, err = s.AcceptInviteAsNewUser(ctx, token, "password")
+	if !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("AcceptInviteAsNewUser() twice error = %v, want %v", err, ErrInvalidInvite)
+	}
+	if len(repo.users) != users+1 {
+		t.Errorf("AcceptInviteAsNewUser() of an accepted invite created a user")
+	}
+}
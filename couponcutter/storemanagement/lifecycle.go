@@ -0,0 +1,382 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrInvalidCouponState is returned if a coupon is created in or moved to an unknown state
+	ErrInvalidCouponState = errors.New("invalid coupon state")
+	//ErrInvalidTransition is returned if a coupon can not move from its state to the requested one
+	ErrInvalidTransition = errors.New("coupon can not move to this state")
+	//ErrCouponNotEditable is returned if an expired, exhausted or deleted coupon is edited
+	ErrCouponNotEditable = errors.New("coupon can no longer be edited")
+	//ErrCouponTermsLocked is returned if the discount of a coupon is changed after it has been redeemed
+	ErrCouponTermsLocked = errors.New("coupon terms can not change once it has been redeemed")
+	//ErrInvalidCouponEdit is returned if an edit leaves the coupon without a description,
+	//with an unknown discount type, an expiring date in the past or a limit below its redemptions
+	ErrInvalidCouponEdit = errors.New("invalid coupon edit")
+	//ErrCouponEditConflict is returned if the coupon changed state or got redeemed while being edited
+	ErrCouponEditConflict = errors.New("coupon changed while being edited")
+)
+
+const (
+	//CouponDraft represent a coupon being prepared, it is not listed
+	CouponDraft = "draft"
+	//CouponScheduled represent a coupon that goes live by itself at its start date
+	CouponScheduled = "scheduled"
+	//CouponPaused represent an active coupon taken off temporarily
+	CouponPaused = "paused"
+	//CouponExpired represent a coupon past its expiring date or ended early
+	CouponExpired = "expired"
+	//CouponExhausted represent a coupon that reached its redemption limit
+	CouponExhausted = CouponUsed
+	//CouponDeleted represent a coupon removed by the store, its redemptions are kept
+	CouponDeleted = "deleted"
+)
+
+//couponTransitions lists the states a coupon in each state can move to. A coupon only
+//becomes exhausted by being redeemed, the store can not move it there. Voiding one of its
+//redemptions makes it active again outside of these transitions. A scheduled coupon is live
+//from its start date without moving to active, so it can be paused or ended like an active one
+var couponTransitions = map[string][]string{
+	CouponDraft:     {CouponScheduled, CouponActive, CouponDeleted},
+	CouponScheduled: {CouponDraft, CouponActive, CouponPaused, CouponExpired, CouponDeleted},
+	CouponActive:    {CouponPaused, CouponExpired, CouponExhausted, CouponDeleted},
+	CouponPaused:    {CouponActive, CouponExpired, CouponDeleted},
+	CouponInActive:  {CouponActive, CouponDeleted},
+	CouponExpired:   {CouponDeleted},
+	CouponExhausted: {CouponDeleted},
+	CouponDeleted:   {},
+}
+
+//canTransition reports whether a coupon can move between the states
+func canTransition(from, to string) bool {
+	return containsID(couponTransitions[from], to)
+}
+
+//FieldChange is the value of a coupon field before and after an edit
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+//CouponEdit is an entry of the edit history of a coupon, state changes included
+type CouponEdit struct {
+	ID       string                 `json:"edit_id,omitempty"`
+	CouponID string                 `json:"coupon_id"`
+	EditedBy string                 `json:"edited_by"`
+	EditedAt time.Time              `json:"edited_at"`
+	Changes  map[string]FieldChange `json:"changes"`
+}
+
+//CouponUpdate is a partial edit of a coupon, nil fields are left unchanged
+type CouponUpdate struct {
+	Desc                *string   `json:"desc,omitempty"`
+	Categories          *[]string `json:"categories,omitempty"`
+	ExpiringDate        *uint     `json:"expiring_date,omitempty"`
+	UnlimitedRedemption *bool     `json:"unlimited_redemption,omitempty"`
+	MaxRedemption       *uint     `json:"max_redemption,omitempty"`
+	TextCouponWebURL    *string   `json:"text_coupon_web_url,omitempty"`
+	//StartDate is zero to make the coupon live at once, Windows empty to make it valid all the time
+	StartDate *uint             `json:"start_date,omitempty"`
+	Windows   *[]ValidityWindow `json:"windows,omitempty"`
+	//UserLimit with a count of zero removes the limit per shopper
+	UserLimit *UserLimit `json:"user_limit,omitempty"`
+
+	//the terms of the discount, they are locked once the coupon is redeemed
+	AmountOff      *float64 `json:"amount_off,omitempty"`
+	PercentageOff  *float64 `json:"percentage_off,omitempty"`
+	CurrencyCode   *string  `json:"currency_code,omitempty"`
+	DiscountType   *string  `json:"discount_type,omitempty"`
+	IsTextCoupon   *bool    `json:"is_text_coupon,omitempty"`
+	TextCouponCode *string  `json:"text_coupon_code,omitempty"`
+}
+
+//ChangesTerms reports whether the update changes the discount the coupon gives
+func (u CouponUpdate) ChangesTerms() bool {
+	return u.AmountOff != nil || u.PercentageOff != nil || u.CurrencyCode != nil ||
+		u.DiscountType != nil || u.IsTextCoupon != nil || u.TextCouponCode != nil
+}
+
+//UpdateCoupon applies the fields of the update that differ from the coupon and records them
+//in its history. Only draft, scheduled, active and paused coupons can be edited, and once
+//redeemed the discount is locked, the expiring date can only be pushed back and the
+//redemption limit can not drop below the redemptions made
+func (s *service) UpdateCoupon(ctx context.Context, actor Actor, couponID string, update CouponUpdate) (*Coupon, error) {
+	if !actor.Can(PermCreateCoupon) {
+		return nil, ErrPermissionDenied
+	}
+	coupon, err := s.repo.StoreCoupon(ctx, actor.StoreID, couponID)
+	if err != nil {
+		return nil, err
+	}
+	switch coupon.State {
+	case CouponExpired, CouponExhausted, CouponDeleted:
+		return nil, ErrCouponNotEditable
+	}
+
+	changed, changes := couponChanges(coupon, update)
+	if len(changes) == 0 {
+		return coupon, nil
+	}
+	err = validateCouponUpdate(coupon, changed)
+	if err != nil {
+		return nil, err
+	}
+	edit := CouponEdit{
+		CouponID: coupon.CouponID,
+		EditedBy: actor.UserID,
+		EditedAt: time.Now(),
+		Changes:  changes,
+	}
+	err = s.repo.UpdateCoupon(ctx, actor.StoreID, couponID, coupon.State, changed, edit)
+	if err != nil {
+		return nil, err
+	}
+	applyCouponUpdate(coupon, changed)
+	return coupon, nil
+}
+
+//TransitionCoupon moves the coupon to the state and records it in its history. Deleting
+//needs the permission to delete coupons, every other move the one to create them
+func (s *service) TransitionCoupon(ctx context.Context, actor Actor, couponID string, state string) error {
+	if _, ok := couponTransitions[state]; !ok {
+		return ErrInvalidCouponState
+	}
+	perm := PermCreateCoupon
+	if state == CouponDeleted {
+		perm = PermDeleteCoupon
+	}
+	if !actor.Can(perm) {
+		return ErrPermissionDenied
+	}
+	if state == CouponExhausted {
+		return ErrInvalidTransition
+	}
+	coupon, err := s.repo.StoreCoupon(ctx, actor.StoreID, couponID)
+	if err != nil {
+		return err
+	}
+	if !canTransition(coupon.State, state) {
+		return ErrInvalidTransition
+	}
+	if (state == CouponActive || state == CouponScheduled) && int64(coupon.ExpiringDate) <= time.Now().Unix() {
+		return ErrInvalidTransition
+	}
+	if state == CouponScheduled && coupon.StartDate == 0 {
+		return ErrInvalidSchedule
+	}
+	edit := CouponEdit{
+		CouponID: coupon.CouponID,
+		EditedBy: actor.UserID,
+		EditedAt: time.Now(),
+		Changes:  map[string]FieldChange{"state": {From: coupon.State, To: state}},
+	}
+	return s.repo.SetCouponState(ctx, actor.StoreID, couponID, coupon.State, state, edit)
+}
+
+//CouponHistory returns the edits of the coupon, oldest first
+func (s *service) CouponHistory(ctx context.Context, actor Actor, couponID string) ([]CouponEdit, error) {
+	if !actor.Can(PermViewCoupons) {
+		return nil, ErrPermissionDenied
+	}
+	return s.repo.CouponEdits(ctx, actor.StoreID, couponID)
+}
+
+//couponChanges returns the part of the update that differs from the coupon and the changes it makes
+func couponChanges(c *Coupon, u CouponUpdate) (CouponUpdate, map[string]FieldChange) {
+	var changed CouponUpdate
+	changes := map[string]FieldChange{}
+	if u.Desc != nil {
+		desc := strings.TrimSpace(*u.Desc)
+		if desc != c.Desc {
+			changed.Desc = &desc
+			changes["desc"] = FieldChange{From: c.Desc, To: desc}
+		}
+	}
+	if u.Categories != nil && !sameIDs(*u.Categories, c.Categories) {
+		categories := uniqueIDs(*u.Categories)
+		changed.Categories = &categories
+		changes["categories"] = FieldChange{From: c.Categories, To: categories}
+	}
+	if u.ExpiringDate != nil && int(*u.ExpiringDate) != c.ExpiringDate {
+		changed.ExpiringDate = u.ExpiringDate
+		changes["expiring_date"] = FieldChange{From: c.ExpiringDate, To: *u.ExpiringDate}
+	}
+	if u.UnlimitedRedemption != nil && *u.UnlimitedRedemption != c.UnlimitedRedemption {
+		changed.UnlimitedRedemption = u.UnlimitedRedemption
+		changes["unlimited_redemption"] = FieldChange{From: c.UnlimitedRedemption, To: *u.UnlimitedRedemption}
+	}
+	if u.MaxRedemption != nil && *u.MaxRedemption != c.MaxRedemption {
+		changed.MaxRedemption = u.MaxRedemption
+		changes["max_redemption"] = FieldChange{From: c.MaxRedemption, To: *u.MaxRedemption}
+	}
+	if u.TextCouponWebURL != nil && *u.TextCouponWebURL != c.TextCouponWebURL {
+		changed.TextCouponWebURL = u.TextCouponWebURL
+		changes["text_coupon_web_url"] = FieldChange{From: c.TextCouponWebURL, To: *u.TextCouponWebURL}
+	}
+	if u.StartDate != nil && int(*u.StartDate) != c.StartDate {
+		changed.StartDate = u.StartDate
+		changes["start_date"] = FieldChange{From: c.StartDate, To: *u.StartDate}
+	}
+	if u.Windows != nil && !reflect.DeepEqual(*u.Windows, c.Windows) && (len(*u.Windows) > 0 || len(c.Windows) > 0) {
+		changed.Windows = u.Windows
+		changes["windows"] = FieldChange{From: c.Windows, To: *u.Windows}
+	}
+	if u.UserLimit != nil && *u.UserLimit != c.UserLimit {
+		changed.UserLimit = u.UserLimit
+		changes["user_limit"] = FieldChange{From: c.UserLimit, To: *u.UserLimit}
+	}
+	if u.AmountOff != nil && *u.AmountOff != c.AmountOff {
+		changed.AmountOff = u.AmountOff
+		changes["amount_off"] = FieldChange{From: c.AmountOff, To: *u.AmountOff}
+	}
+	if u.PercentageOff != nil && *u.PercentageOff != c.PercentageOff {
+		changed.PercentageOff = u.PercentageOff
+		changes["percentage_off"] = FieldChange{From: c.PercentageOff, To: *u.PercentageOff}
+	}
+	if u.CurrencyCode != nil && *u.CurrencyCode != c.CurrencyCode {
+		changed.CurrencyCode = u.CurrencyCode
+		changes["currency_code"] = FieldChange{From: c.CurrencyCode, To: *u.CurrencyCode}
+	}
+	if u.DiscountType != nil && *u.DiscountType != c.DiscountType {
+		changed.DiscountType = u.DiscountType
+		changes["discount_type"] = FieldChange{From: c.DiscountType, To: *u.DiscountType}
+	}
+	if u.IsTextCoupon != nil && *u.IsTextCoupon != c.IsTextCoupon {
+		changed.IsTextCoupon = u.IsTextCoupon
+		changes["is_text_coupon"] = FieldChange{From: c.IsTextCoupon, To: *u.IsTextCoupon}
+	}
+	if u.TextCouponCode != nil && *u.TextCouponCode != c.TextCouponCode {
+		changed.TextCouponCode = u.TextCouponCode
+		changes["text_coupon_code"] = FieldChange{From: c.TextCouponCode, To: *u.TextCouponCode}
+	}
+	return changed, changes
+}
+
+//validateCouponUpdate checks the coupon the changes would leave
+func validateCouponUpdate(c *Coupon, u CouponUpdate) error {
+	redeemed := c.RedemptionCount > 0
+	if redeemed && u.ChangesTerms() {
+		return ErrCouponTermsLocked
+	}
+	if u.Desc != nil && *u.Desc == "" {
+		return ErrInvalidCouponEdit
+	}
+	if u.DiscountType != nil && *u.DiscountType != "amount_off" && *u.DiscountType != "percentage_off" {
+		return ErrInvalidCouponEdit
+	}
+	if u.PercentageOff != nil && (*u.PercentageOff < 0 || *u.PercentageOff > 100) {
+		return ErrInvalidCouponEdit
+	}
+	if u.AmountOff != nil && *u.AmountOff < 0 {
+		return ErrInvalidCouponEdit
+	}
+	if u.ExpiringDate != nil {
+		if int64(*u.ExpiringDate) <= time.Now().Unix() || (redeemed && int(*u.ExpiringDate) < c.ExpiringDate) {
+			return ErrInvalidCouponEdit
+		}
+	}
+	start, expiring, windows := uint(c.StartDate), uint(c.ExpiringDate), c.Windows
+	if u.StartDate != nil {
+		start = *u.StartDate
+	}
+	if u.ExpiringDate != nil {
+		expiring = *u.ExpiringDate
+	}
+	if u.Windows != nil {
+		windows = *u.Windows
+	}
+	if err := validateSchedule(start, expiring, windows); err != nil {
+		return err
+	}
+	//a scheduled coupon without a start date would never go live
+	if c.State == CouponScheduled && start == 0 {
+		return ErrInvalidSchedule
+	}
+	if u.UserLimit != nil {
+		if err := u.UserLimit.validate(); err != nil {
+			return err
+		}
+	}
+	unlimited, max := c.UnlimitedRedemption, c.MaxRedemption
+	if u.UnlimitedRedemption != nil {
+		unlimited = *u.UnlimitedRedemption
+	}
+	if u.MaxRedemption != nil {
+		max = *u.MaxRedemption
+	}
+	if !unlimited && (max == 0 || max < c.RedemptionCount) {
+		return ErrInvalidCouponEdit
+	}
+	return nil
+}
+
+//applyCouponUpdate sets the fields of the update on the coupon
+func applyCouponUpdate(c *Coupon, u CouponUpdate) {
+	if u.Desc != nil {
+		c.Desc = *u.Desc
+	}
+	if u.Categories != nil {
+		c.Categories = *u.Categories
+	}
+	if u.ExpiringDate != nil {
+		c.ExpiringDate = int(*u.ExpiringDate)
+	}
+	if u.UnlimitedRedemption != nil {
+		c.UnlimitedRedemption = *u.UnlimitedRedemption
+	}
+	if u.MaxRedemption != nil {
+		c.MaxRedemption = *u.MaxRedemption
+	}
+	if u.TextCouponWebURL != nil {
+		c.TextCouponWebURL = *u.TextCouponWebURL
+	}
+	if u.StartDate != nil {
+		c.StartDate = int(*u.StartDate)
+	}
+	if u.Windows != nil {
+		c.Windows = *u.Windows
+	}
+	if u.UserLimit != nil {
+		c.UserLimit = *u.UserLimit
+		c.SingleUserUse = c.UserLimit == UserLimit{Count: 1}
+	}
+	if u.AmountOff != nil {
+		c.AmountOff = *u.AmountOff
+	}
+	if u.PercentageOff != nil {
+		c.PercentageOff = *u.PercentageOff
+	}
+	if u.CurrencyCode != nil {
+		c.CurrencyCode = *u.CurrencyCode
+	}
+	if u.DiscountType != nil {
+		c.DiscountType = *u.DiscountType
+	}
+	if u.IsTextCoupon != nil {
+		c.IsTextCoupon = *u.IsTextCoupon
+	}
+	if u.TextCouponCode != nil {
+		c.TextCouponCode = *u.TextCouponCode
+	}
+}
+
+//sameIDs reports whether both lists hold the same ids regardless of order and repeats
+func sameIDs(a, b []string) bool {
+	a, b = uniqueIDs(a), uniqueIDs(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for _, id := range a {
+		if !containsID(b, id) {
+			return false
+		}
+	}
+	return true
+}
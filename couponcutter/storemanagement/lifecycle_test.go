@@ -0,0 +1,196 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func (m *mockRepo) StoreCoupon(ctx context.Context, storeID string, couponID string) (*Coupon, error) {
+	c, ok := m.coupons[couponID]
+	if !ok || c.Store.StoreID != storeID {
+		return nil, ErrCouponNotValid
+	}
+	coupon := *c
+	return &coupon, nil
+}
+
+func (m *mockRepo) UpdateCoupon(ctx context.Context, storeID string, couponID string, state string, update CouponUpdate, edit CouponEdit) error {
+	c := m.coupons[couponID]
+	if c.State != state {
+		return ErrCouponEditConflict
+	}
+	applyCouponUpdate(c, update)
+	m.couponEdits = append(m.couponEdits, edit)
+	return nil
+}
+
+func (m *mockRepo) SetCouponState(ctx context.Context, storeID string, couponID string, from string, to string, edit CouponEdit) error {
+	c := m.coupons[couponID]
+	if c.State != from {
+		return ErrCouponEditConflict
+	}
+	c.State = to
+	m.couponEdits = append(m.couponEdits, edit)
+	return nil
+}
+
+func lifecycleRepo() *mockRepo {
+	expiry := int(time.Now().Add(time.Hour * 24).Unix())
+	coupon := func(id, state string, redemptions uint) *Coupon {
+		return &Coupon{CouponID: id, Store: Store{StoreID: "u1"}, Desc: "10% off shoes", State: state,
+			DiscountType: "percentage_off", PercentageOff: 10, ExpiringDate: expiry,
+			MaxRedemption: 5, RedemptionCount: redemptions}
+	}
+	dated := coupon("dated", CouponDraft, 0)
+	dated.StartDate = int(time.Now().Add(time.Hour).Unix())
+	scheduled := coupon("scheduled", CouponScheduled, 0)
+	scheduled.StartDate = int(time.Now().Add(-time.Hour).Unix())
+	return &mockRepo{coupons: map[string]*Coupon{
+		"draft":     coupon("draft", CouponDraft, 0),
+		"dated":     dated,
+		"scheduled": scheduled,
+		"active":    coupon("active", CouponActive, 0),
+		"redeemed":  coupon("redeemed", CouponActive, 3),
+		"paused":    coupon("paused", CouponPaused, 1),
+		"exhausted": coupon("exhausted", CouponExhausted, 5),
+		"deleted":   coupon("deleted", CouponDeleted, 0),
+	}}
+}
+
+func Test_service_TransitionCoupon(t *testing.T) {
+	owner := Actor{UserID: "u1", StoreID: "u1", Roles: []string{RoleOwner}}
+	creator := Actor{UserID: "k1", StoreID: "u1", Roles: []string{RoleOwner}, Scopes: []Permission{PermCreateCoupon}}
+
+	tests := []struct {
+		name     string
+		actor    Actor
+		couponID string
+		state    string
+		wantErr  error
+	}{
+		{name: "draft scheduled", actor: owner, couponID: "dated", state: CouponScheduled},
+		{name: "draft scheduled without a start date", actor: owner, couponID: "draft", state: CouponScheduled, wantErr: ErrInvalidSchedule},
+		{name: "started scheduled paused", actor: owner, couponID: "scheduled", state: CouponPaused},
+		{name: "draft activated", actor: owner, couponID: "draft", state: CouponActive},
+		{name: "active paused", actor: owner, couponID: "active", state: CouponPaused},
+		{name: "paused resumed", actor: owner, couponID: "paused", state: CouponActive},
+		{name: "active ended early", actor: owner, couponID: "active", state: CouponExpired},
+		{name: "exhausted deleted", actor: owner, couponID: "exhausted", state: CouponDeleted},
+		{name: "draft paused", actor: owner, couponID: "draft", state: CouponPaused, wantErr: ErrInvalidTransition},
+		{name: "paused scheduled", actor: owner, couponID: "paused", state: CouponScheduled, wantErr: ErrInvalidTransition},
+		{name: "exhausted by the store", actor: owner, couponID: "active", state: CouponExhausted, wantErr: ErrInvalidTransition},
+		{name: "exhausted reactivated by the store", actor: owner, couponID: "exhausted", state: CouponActive, wantErr: ErrInvalidTransition},
+		{name: "deleted restored", actor: owner, couponID: "deleted", state: CouponActive, wantErr: ErrInvalidTransition},
+		{name: "unknown state", actor: owner, couponID: "active", state: "archived", wantErr: ErrInvalidCouponState},
+		{name: "unknown coupon", actor: owner, couponID: "c9", state: CouponPaused, wantErr: ErrCouponNotValid},
+		{name: "pause without delete permission", actor: creator, couponID: "active", state: CouponPaused},
+		{name: "delete without delete permission", actor: creator, couponID: "active", state: CouponDeleted, wantErr: ErrPermissionDenied},
+		{name: "employee", actor: Actor{UserID: "u2", StoreID: "u1", Roles: []string{RoleEmployee}},
+			couponID: "active", state: CouponPaused, wantErr: ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := lifecycleRepo()
+			s := NewService(repo, &mockMailer{}, nil)
+			from := ""
+			if c, ok := repo.coupons[tt.couponID]; ok {
+				from = c.State
+			}
+			err := s.TransitionCoupon(context.Background(), tt.actor, tt.couponID, tt.state)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TransitionCoupon() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(repo.couponEdits) != 0 {
+					t.Error("TransitionCoupon() recorded a rejected transition")
+				}
+				return
+			}
+			if got := repo.coupons[tt.couponID].State; got != tt.state {
+				t.Errorf("TransitionCoupon() state = %q, want %q", got, tt.state)
+			}
+			if len(repo.couponEdits) != 1 || repo.couponEdits[0].Changes["state"] != (FieldChange{From: from, To: tt.state}) {
+				t.Errorf("TransitionCoupon() history = %+v", repo.couponEdits)
+			}
+		})
+	}
+}
+
+func Test_service_UpdateCoupon(t *testing.T) {
+	owner := Actor{UserID: "u1", StoreID: "u1", Roles: []string{RoleOwner}}
+	str := func(v string) *string { return &v }
+	num := func(v float64) *float64 { return &v }
+	limit := func(v uint) *uint { return &v }
+	later := uint(time.Now().Add(time.Hour * 48).Unix())
+	earlier := uint(time.Now().Add(time.Hour).Unix())
+
+	tests := []struct {
+		name        string
+		couponID    string
+		update      CouponUpdate
+		wantChanges []string
+		wantErr     error
+	}{
+		{name: "description", couponID: "active", update: CouponUpdate{Desc: str(" 15% off shoes ")}, wantChanges: []string{"desc"}},
+		{name: "unchanged fields are not recorded", couponID: "active",
+			update: CouponUpdate{Desc: str("10% off shoes"), PercentageOff: num(15)}, wantChanges: []string{"percentage_off"}},
+		{name: "no change", couponID: "active", update: CouponUpdate{Desc: str("10% off shoes")}},
+		{name: "terms of a draft", couponID: "draft", update: CouponUpdate{DiscountType: str("amount_off"), AmountOff: num(5)},
+			wantChanges: []string{"discount_type", "amount_off"}},
+		{name: "terms once redeemed", couponID: "redeemed", update: CouponUpdate{PercentageOff: num(20)}, wantErr: ErrCouponTermsLocked},
+		{name: "description once redeemed", couponID: "redeemed", update: CouponUpdate{Desc: str("Shoes")}, wantChanges: []string{"desc"}},
+		{name: "expiry pushed back once redeemed", couponID: "redeemed", update: CouponUpdate{ExpiringDate: &later},
+			wantChanges: []string{"expiring_date"}},
+		{name: "expiry brought forward once redeemed", couponID: "redeemed", update: CouponUpdate{ExpiringDate: &earlier},
+			wantErr: ErrInvalidCouponEdit},
+		{name: "expiry brought forward before redemptions", couponID: "active", update: CouponUpdate{ExpiringDate: &earlier},
+			wantChanges: []string{"expiring_date"}},
+		{name: "limit below redemptions", couponID: "redeemed", update: CouponUpdate{MaxRedemption: limit(2)}, wantErr: ErrInvalidCouponEdit},
+		{name: "limit raised", couponID: "redeemed", update: CouponUpdate{MaxRedemption: limit(10)}, wantChanges: []string{"max_redemption"}},
+		{name: "empty description", couponID: "active", update: CouponUpdate{Desc: str("  ")}, wantErr: ErrInvalidCouponEdit},
+		{name: "unknown discount type", couponID: "active", update: CouponUpdate{DiscountType: str("free")}, wantErr: ErrInvalidCouponEdit},
+		{name: "paused", couponID: "paused", update: CouponUpdate{Desc: str("Shoes")}, wantChanges: []string{"desc"}},
+		{name: "exhausted", couponID: "exhausted", update: CouponUpdate{Desc: str("Shoes")}, wantErr: ErrCouponNotEditable},
+		{name: "deleted", couponID: "deleted", update: CouponUpdate{Desc: str("Shoes")}, wantErr: ErrCouponNotEditable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := lifecycleRepo()
+			s := NewService(repo, &mockMailer{}, nil)
+			coupon, err := s.UpdateCoupon(context.Background(), owner, tt.couponID, tt.update)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateCoupon() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(repo.couponEdits) != 0 {
+					t.Error("UpdateCoupon() recorded a rejected edit")
+				}
+				return
+			}
+			if len(tt.wantChanges) == 0 {
+				if len(repo.couponEdits) != 0 {
+					t.Errorf("UpdateCoupon() recorded %+v for no change", repo.couponEdits)
+				}
+				return
+			}
+			if len(repo.couponEdits) != 1 {
+				t.Fatalf("UpdateCoupon() recorded %d edits, want 1", len(repo.couponEdits))
+			}
+			changes := repo.couponEdits[0].Changes
+			if len(changes) != len(tt.wantChanges) {
+				t.Errorf("UpdateCoupon() changes = %v, want %v", changes, tt.wantChanges)
+			}
+			for _, field := range tt.wantChanges {
+				if _, ok := changes[field]; !ok {
+					t.Errorf("UpdateCoupon() changes = %v, want %v", changes, tt.wantChanges)
+				}
+			}
+			if !reflect.DeepEqual(repo.coupons[tt.couponID], coupon) {
+				t.Errorf("UpdateCoupon() = %+v, stored %+v", coupon, repo.coupons[tt.couponID])
+			}
+		})
+	}
+}
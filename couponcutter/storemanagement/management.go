@@ -3,6 +3,7 @@ package storemanagement
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var (
@@ -22,6 +23,8 @@ var (
 	ErrCouponIsUsed = errors.New("coupon is already used")
 	//ErrCouponLimitExceeded is returned if the limit of redemption amount is exceeded
 	ErrCouponLimitExceeded = errors.New("coupon limit already exceeded")
+	//ErrPermissionDenied is returned if the actor is not allowed to perform the operation on the store
+	ErrPermissionDenied = errors.New("permission denied")
 )
 
 const (
@@ -77,7 +80,15 @@ type Coupon struct {
 	QrCodeURL           string  `json:"item_url,omitempty"`
 	UnlimitedRedemption bool    `json:"unlimted_redemption,omitempty"`
 	MaxRedemption       uint    `json:"redemption_limit,omitempty"`
+	RedemptionCount     uint    `json:"redemption_count,omitempty"`
+	TextCouponWebURL    string  `json:"text_coupon_weburl,omitempty"`
 	// voucher percentage
+	Categories []string `json:"categories,omitempty"`
+	//StartDate is when the coupon goes live, zero when it is live from its creation
+	StartDate int              `json:"start_date,omitempty"`
+	Windows   []ValidityWindow `json:"windows,omitempty"`
+	//UserLimit is how many times a shopper can redeem the coupon, SingleUserUse is set for once
+	UserLimit UserLimit `json:"user_limit"`
 }
 
 //CreateCoupon is used to create coupons
@@ -91,13 +102,22 @@ type CreateCoupon struct {
 	ExpiringDate  uint     `json:"expiring_date"`
 	State         string   `json:"state,omitempty"`
 
-	//SingleUserUse       bool     `json:"single_user_use,omitempty"`
+	//SingleUserUse limits the coupon to once per shopper, the same as a user limit of one
+	SingleUserUse       bool   `json:"single_user_use,omitempty"`
 	IsTextCoupon        bool   `json:"is_text_coupon,omitempty"`
 	TextCouponCode      string `json:"text_coupon_code,omitempty"`
 	TextCouponWebURL    string `json:"text_coupon_weburl,omitempty"`
 	UnlimitedRedemption bool   `json:"unlimted_redemption"`
 	MaxRedemption       uint   `json:"redemption_limit,omitempty"`
 	DiscountType        string `json:"discount_type,omitempty"`
+	//Branches limits the coupon to some branches of the store, empty for every branch
+	Branches []string `json:"branches,omitempty"`
+	//StartDate is when the coupon goes live, zero for at once
+	StartDate uint `json:"start_date,omitempty"`
+	//Windows restricts the coupon to some times of the week, empty for all the time
+	Windows []ValidityWindow `json:"windows,omitempty"`
+	//UserLimit is how many times a shopper can redeem the coupon, unlimited when its count is zero
+	UserLimit UserLimit `json:"user_limit"`
 }
 
 // Store represent an indentity that owns coupons
@@ -107,6 +127,8 @@ type Store struct {
 	StoreImage  string     `json:"store_image,omitempty"`
 	Tagline     string     `json:"tagline,omitempty"`
 	Address     string     `json:"address,omitempty"`
+	Location    *Location  `json:"location,omitempty"`
+	Timezone    string     `json:"timezone,omitempty"`
 	CouponCount uint       `json:"coupon_count,omitempty"`
 	Employees   []Employee `json:"employees,omitempty"`
 	SubStores   []Store    `json:"sub_stores,omitempty"`
@@ -118,6 +140,12 @@ type StoreEdit struct {
 	Name    string
 	Tagline string
 	Address string
+	//Location is looked up from the address by the geocoder when it is not given
+	Location *Location
+	//Timezone is the tz database name the validity windows of the coupons are in
+	Timezone string
+	//VoidWindow is how long after a redemption employees can void it, nil to leave it unchanged
+	VoidWindow *time.Duration
 }
 
 //Employee is an identifiable entity that has limited store_management abilities
@@ -126,101 +154,289 @@ type Employee struct {
 	FullName string `json:"full_name,omitempty"`
 	Email    string `json:"email,omitempty"`
 	State    string `json:"state,omitempty"`
+	//Branches are the branches the employee is limited to, empty for every branch
+	Branches []string `json:"branches,omitempty"`
 }
 
 // Repository provides acess to store management storage facilities
 type Repository interface {
 	CreateCoupon(ctx context.Context, storeID string, coupon CreateCoupon) (string, error)
-	DeleteCoupon(ctx context.Context, storeID string, couponID string) error
+	//StoreCoupon returns ErrCouponNotValid if the store has no coupon with the id
+	StoreCoupon(ctx context.Context, storeID string, couponID string) (*Coupon, error)
+	//UpdateCoupon applies the update and records the edit, it returns ErrCouponEditConflict if the
+	//coupon is no longer in the state, or got redeemed meanwhile when the update changes its terms
+	UpdateCoupon(ctx context.Context, storeID string, couponID string, state string, update CouponUpdate, edit CouponEdit) error
+	//SetCouponState moves the coupon between the states and records the edit, it returns
+	//ErrCouponEditConflict if the coupon is no longer in the from state
+	SetCouponState(ctx context.Context, storeID string, couponID string, from string, to string, edit CouponEdit) error
+	//CouponEdits returns the edit history of the coupon, oldest first
+	CouponEdits(ctx context.Context, storeID string, couponID string) ([]CouponEdit, error)
 
 	UserStoreData(ctx context.Context, userid string) (*UserStoreResponse, error)
 	UserStoreCoupons(ctx context.Context, userid string) (*CouponListResponse, error)
 
 	Employees(ctx context.Context, storeID string) (*EmployeesResponse, error)
-	AddEmployee(ctx context.Context, storeID string, employee Employee) error
 	SuspendEmployee(ctx context.Context, storeID string, employeeID string) error
 	RemoveEmployee(ctx context.Context, storeID string, employeeID string) error
 	ResumeEmployee(ctx context.Context, storeID string, employeeID string) error
 
+	//CreateInvite stores the hash of an invite token for the email and returns the id of the invite
+	CreateInvite(ctx context.Context, storeID string, email string, tokenHash string, expiredAt time.Time) (string, error)
+	//Invites returns the invites of the store that are neither accepted, revoked nor expired
+	Invites(ctx context.Context, storeID string) ([]Invite, error)
+	RevokeInvite(ctx context.Context, storeID string, inviteID string) error
+	InviteWithToken(ctx context.Context, tokenHash string) (*Invite, error)
+	//AcceptInvite consumes the invite and makes the user an active employee of its store
+	AcceptInvite(ctx context.Context, tokenHash string, userID string) error
+	//AcceptInviteAsNewUser creates a user with the email of the invite and accepts the invite
+	//for them at once, returning the id of the user
+	AcceptInviteAsNewUser(ctx context.Context, tokenHash string, password string) (string, error)
+
+	//CreateAPIKey stores the API key with the hash of its key and returns the id of the key
+	CreateAPIKey(ctx context.Context, key APIKey, keyHash string) (string, error)
+	APIKeys(ctx context.Context, storeID string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, storeID string, keyID string) error
+	//UseAPIKey returns the active API key with the hash and records when it was used
+	UseAPIKey(ctx context.Context, keyHash string) (*APIKey, error)
+
 	GetUserStoreCouponsRedeemedCount(ctx context.Context, storeID, filter string) (uint, error)
 	CouponState(ctx context.Context, couponid string) (string, error)
-	VerifyCoupon(ctx context.Context, userID, couponid string) error
+	//VerifyCoupon records the redemption, its branch and shopper are empty when unknown. The
+	//limits of the coupon, the one per shopper included, are checked while it is held
+	VerifyCoupon(ctx context.Context, redemption Redemption) error
+	//ClaimIdempotencyKey stores the record unless its key is held by an unexpired record of the store,
+	//which is returned instead
+	ClaimIdempotencyKey(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error)
+	//CompleteIdempotencyKey keeps the outcome of the redemption holding the key until expiredAt
+	CompleteIdempotencyKey(ctx context.Context, storeID string, key string, outcome string, expiredAt time.Time) error
+	ReleaseIdempotencyKey(ctx context.Context, storeID string, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+	//Redemption returns ErrRedemptionNotFound if the store has no redemption with the id
+	//and ErrRedemptionAlreadyVoided if it was voided
+	Redemption(ctx context.Context, storeID string, redemptionID string) (*Redemption, error)
+	CouponRedemptions(ctx context.Context, storeID string, couponID string) ([]Redemption, error)
+	StoreVoidWindow(ctx context.Context, storeID string) (time.Duration, error)
+	//VoidRedemption removes the redemption, gives it back to its coupon, reactivates the coupon
+	//if it was exhausted and records the void. It returns the id of the void
+	VoidRedemption(ctx context.Context, storeID string, void RedemptionVoid) (string, error)
+	RedemptionVoids(ctx context.Context, storeID string) ([]RedemptionVoid, error)
 
 	EditStore(ctx context.Context, userid string, edit StoreEdit) error
+
+	Branches(ctx context.Context, storeID string) ([]Branch, error)
+	//Branch returns ErrBranchNotFound if the store has no branch with the id
+	Branch(ctx context.Context, storeID string, branchID string) (*Branch, error)
+	//CreateBranch stores the branch and returns its id
+	CreateBranch(ctx context.Context, branch Branch) (string, error)
+	EditBranch(ctx context.Context, storeID string, branchID string, edit BranchEdit) error
+	CloseBranch(ctx context.Context, storeID string, branchID string) error
+	//SetEmployeeBranches replaces the branches of the employee, every branch must be an open branch of the store
+	SetEmployeeBranches(ctx context.Context, storeID string, employeeID string, branchIDs []string) error
+	//EmployeeBranches returns the branches the user is limited to as an employee of the store
+	EmployeeBranches(ctx context.Context, storeID string, userID string) ([]string, error)
+	//SetCouponBranches replaces the branches of the coupon, every branch must be an open branch of the store
+	SetCouponBranches(ctx context.Context, storeID string, couponID string, branchIDs []string) error
+	//CouponBranches returns the branches the coupon of the store is limited to
+	CouponBranches(ctx context.Context, storeID string, couponID string) ([]string, error)
+	BranchRedemptionCounts(ctx context.Context, storeID string, since time.Time) ([]BranchRedemptionCount, error)
 }
 
-// Service provides store management facilities
+// Service provides store management facilities, every operation on a store is checked
+// against the permissions of the actor performing it
 type Service interface {
-	CreateCoupon(ctx context.Context, storeID string, coupon CreateCoupon) (string, error)
-	DeleteCoupon(ctx context.Context, storeID string, couponID string) error
-
-	UserStoreData(ctx context.Context, userid string) (*UserStoreResponse, error)
-	Employees(ctx context.Context, storeID string) (*EmployeesResponse, error)
-	UserStoreCoupons(ctx context.Context, userid string) (*CouponListResponse, error)
-
-	AddEmployee(ctx context.Context, storeID string, employee Employee) error
-	SuspendEmployee(ctx context.Context, storeID string, employeeID string) error
-	RemoveEmployee(ctx context.Context, storeID string, employeeID string) error
-	ResumeEmployee(ctx context.Context, storeID string, employeeID string) error
-
-	GetUserStoreCouponsRedeemedCount(ctx context.Context, storeID, filter string) (uint, error)
+	CreateCoupon(ctx context.Context, actor Actor, coupon CreateCoupon) (string, error)
+	DeleteCoupon(ctx context.Context, actor Actor, couponID string) error
+	UpdateCoupon(ctx context.Context, actor Actor, couponID string, update CouponUpdate) (*Coupon, error)
+	TransitionCoupon(ctx context.Context, actor Actor, couponID string, state string) error
+	CouponHistory(ctx context.Context, actor Actor, couponID string) ([]CouponEdit, error)
+
+	UserStoreData(ctx context.Context, actor Actor) (*UserStoreResponse, error)
+	Employees(ctx context.Context, actor Actor) (*EmployeesResponse, error)
+	UserStoreCoupons(ctx context.Context, actor Actor) (*CouponListResponse, error)
+
+	SuspendEmployee(ctx context.Context, actor Actor, employeeID string) error
+	RemoveEmployee(ctx context.Context, actor Actor, employeeID string) error
+	ResumeEmployee(ctx context.Context, actor Actor, employeeID string) error
+
+	InviteEmployee(ctx context.Context, actor Actor, email string) (*Invite, error)
+	Invites(ctx context.Context, actor Actor) ([]Invite, error)
+	RevokeInvite(ctx context.Context, actor Actor, inviteID string) error
+	InviteWithToken(ctx context.Context, token string) (*Invite, error)
+	AcceptInvite(ctx context.Context, token string, userID string) error
+	AcceptInviteAsNewUser(ctx context.Context, token string, password string) (string, error)
+
+	//CreateAPIKey returns the created API key together with the key itself
+	CreateAPIKey(ctx context.Context, actor Actor, name string, scopes []Permission) (*APIKey, string, error)
+	APIKeys(ctx context.Context, actor Actor) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, actor Actor, keyID string) error
+	AuthenticateAPIKey(ctx context.Context, key string) (*APIKey, error)
+
+	GetUserStoreCouponsRedeemedCount(ctx context.Context, actor Actor, filter string) (uint, error)
 
 	CouponState(ctx context.Context, couponid string) (string, error)
-	VerifyCoupon(ctx context.Context, userID, couponid string) error
-
-	EditStore(ctx context.Context, userid string, edit StoreEdit) error
+	//VerifyCoupon redeems the coupon for the shopper, who can be left empty for coupons without user limit.
+	//The idempotency key is optional, a retry with the same key replays the outcome of the first request
+	VerifyCoupon(ctx context.Context, actor Actor, couponid string, shopperID string, idempotencyKey string) error
+	PurgeIdempotencyKeys(ctx context.Context) (int64, error)
+	//VoidRedemption undoes the redemption for the reason, after the void window of the store only the owner can
+	VoidRedemption(ctx context.Context, actor Actor, redemptionID string, reason string) (*RedemptionVoid, error)
+	CouponRedemptions(ctx context.Context, actor Actor, couponID string) ([]Redemption, error)
+	RedemptionVoids(ctx context.Context, actor Actor) ([]RedemptionVoid, error)
+
+	EditStore(ctx context.Context, actor Actor, edit StoreEdit) error
+
+	Branches(ctx context.Context, actor Actor) ([]Branch, error)
+	AddBranch(ctx context.Context, actor Actor, edit BranchEdit) (*Branch, error)
+	EditBranch(ctx context.Context, actor Actor, branchID string, edit BranchEdit) error
+	RemoveBranch(ctx context.Context, actor Actor, branchID string) error
+	AssignEmployeeBranches(ctx context.Context, actor Actor, employeeID string, branchIDs []string) error
+	SetCouponBranches(ctx context.Context, actor Actor, couponID string, branchIDs []string) error
+	BranchRedemptionCounts(ctx context.Context, actor Actor, since time.Time) ([]BranchRedemptionCount, error)
 }
 
-// NewService returns a store management service provider
-func NewService(repo Repository) Service {
-	return &service{repo: repo}
+// NewService returns a store management service provider, the mailer delivers employee invites
+//and the geocoder locates the stores from their address, it may be nil
+func NewService(repo Repository, mailer Mailer, geocoder Geocoder) Service {
+	return &service{repo: repo, mailer: mailer, geocoder: geocoder}
 }
 
 type service struct {
-	repo Repository
+	repo     Repository
+	mailer   Mailer
+	geocoder Geocoder
 }
 
-func (s *service) CreateCoupon(ctx context.Context, storeID string, coupon CreateCoupon) (string, error) {
-	return s.repo.CreateCoupon(ctx, storeID, coupon)
+func (s *service) CreateCoupon(ctx context.Context, actor Actor, coupon CreateCoupon) (string, error) {
+	if !actor.Can(PermCreateCoupon) {
+		return "", ErrPermissionDenied
+	}
+	switch coupon.State {
+	case "":
+		coupon.State = CouponActive
+	case CouponDraft, CouponScheduled, CouponActive:
+	default:
+		return "", ErrInvalidCouponState
+	}
+	err := validateSchedule(coupon.StartDate, coupon.ExpiringDate, coupon.Windows)
+	if err != nil {
+		return "", err
+	}
+	if coupon.State == CouponScheduled && coupon.StartDate == 0 {
+		return "", ErrInvalidSchedule
+	}
+	if coupon.SingleUserUse && coupon.UserLimit.Count == 0 {
+		coupon.UserLimit = UserLimit{Count: 1}
+	}
+	err = coupon.UserLimit.validate()
+	if err != nil {
+		return "", err
+	}
+	coupon.StoreID = actor.StoreID
+	coupon.Branches = uniqueIDs(coupon.Branches)
+	return s.repo.CreateCoupon(ctx, actor.StoreID, coupon)
 }
-func (s *service) Employees(ctx context.Context, storeID string) (*EmployeesResponse, error) {
-	return s.repo.Employees(ctx, storeID)
+func (s *service) Employees(ctx context.Context, actor Actor) (*EmployeesResponse, error) {
+	if !actor.Can(PermViewEmployees) {
+		return nil, ErrPermissionDenied
+	}
+	return s.repo.Employees(ctx, actor.StoreID)
 }
 
-func (s *service) AddEmployee(ctx context.Context, storeID string, employee Employee) error {
-	return s.repo.AddEmployee(ctx, storeID, employee)
-}
-func (s *service) SuspendEmployee(ctx context.Context, storeID string, employeeID string) error {
-	return s.repo.SuspendEmployee(ctx, storeID, employeeID)
+func (s *service) SuspendEmployee(ctx context.Context, actor Actor, employeeID string) error {
+	if !actor.Can(PermManageEmployees) {
+		return ErrPermissionDenied
+	}
+	return s.repo.SuspendEmployee(ctx, actor.StoreID, employeeID)
 }
-func (s *service) RemoveEmployee(ctx context.Context, storeID string, employeeID string) error {
-	return s.repo.RemoveEmployee(ctx, storeID, employeeID)
+func (s *service) RemoveEmployee(ctx context.Context, actor Actor, employeeID string) error {
+	if !actor.Can(PermManageEmployees) {
+		return ErrPermissionDenied
+	}
+	return s.repo.RemoveEmployee(ctx, actor.StoreID, employeeID)
 }
-func (s *service) ResumeEmployee(ctx context.Context, storeID string, employeeID string) error {
-	return s.repo.RemoveEmployee(ctx, storeID, employeeID)
+func (s *service) ResumeEmployee(ctx context.Context, actor Actor, employeeID string) error {
+	if !actor.Can(PermManageEmployees) {
+		return ErrPermissionDenied
+	}
+	return s.repo.ResumeEmployee(ctx, actor.StoreID, employeeID)
 }
 
-func (s *service) DeleteCoupon(ctx context.Context, storeID string, couponID string) error {
-	return s.repo.DeleteCoupon(ctx, storeID, couponID)
+//DeleteCoupon moves the coupon to the deleted state, its redemptions are kept
+func (s *service) DeleteCoupon(ctx context.Context, actor Actor, couponID string) error {
+	return s.TransitionCoupon(ctx, actor, couponID, CouponDeleted)
 }
 
-func (s *service) UserStoreData(ctx context.Context, userid string) (*UserStoreResponse, error) {
-	return s.repo.UserStoreData(ctx, userid)
+func (s *service) UserStoreData(ctx context.Context, actor Actor) (*UserStoreResponse, error) {
+	if !actor.Can(PermViewStore) {
+		return nil, ErrPermissionDenied
+	}
+	return s.repo.UserStoreData(ctx, actor.StoreID)
 }
-func (s *service) UserStoreCoupons(ctx context.Context, userid string) (*CouponListResponse, error) {
-	return s.repo.UserStoreCoupons(ctx, userid)
+func (s *service) UserStoreCoupons(ctx context.Context, actor Actor) (*CouponListResponse, error) {
+	if !actor.Can(PermViewCoupons) {
+		return nil, ErrPermissionDenied
+	}
+	return s.repo.UserStoreCoupons(ctx, actor.StoreID)
 }
-func (s *service) GetUserStoreCouponsRedeemedCount(ctx context.Context, storeID, filter string) (uint, error) {
-	return s.repo.GetUserStoreCouponsRedeemedCount(ctx, storeID, filter)
+func (s *service) GetUserStoreCouponsRedeemedCount(ctx context.Context, actor Actor, filter string) (uint, error) {
+	if !actor.Can(PermViewStore) {
+		return 0, ErrPermissionDenied
+	}
+	return s.repo.GetUserStoreCouponsRedeemedCount(ctx, actor.StoreID, filter)
 }
 
 func (s *service) CouponState(ctx context.Context, couponid string) (string, error) {
 	return s.repo.CouponState(ctx, couponid)
 }
 
-func (s *service) VerifyCoupon(ctx context.Context, userID, couponid string) error {
-	return s.repo.VerifyCoupon(ctx, userID, couponid)
+//verifyCoupon redeems the coupon once the actor is allowed to
+func (s *service) verifyCoupon(ctx context.Context, actor Actor, couponid string, shopperID string) error {
+	coupon, err := s.repo.StoreCoupon(ctx, actor.StoreID, couponid)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = coupon.redeemableAt(now)
+	if err != nil {
+		return err
+	}
+	branchID, err := s.redemptionBranch(ctx, actor, couponid)
+	if err != nil {
+		return err
+	}
+	if coupon.UserLimit.Count > 0 && shopperID == "" {
+		return ErrShopperRequired
+	}
+	redemption := Redemption{
+		CouponID:   couponid,
+		StoreID:    actor.StoreID,
+		RedeemedBy: actor.UserID,
+		BranchID:   branchID,
+		ShopperID:  shopperID,
+		RedeemedAt: now,
+	}
+	if containsID(actor.Roles, RoleAPIKey) {
+		redemption.RedeemedBy = ""
+		redemption.APIKeyID = actor.UserID
+	}
+	return s.repo.VerifyCoupon(ctx, redemption)
 }
-func (s *service) EditStore(ctx context.Context, userid string, edit StoreEdit) error {
-	return s.repo.EditStore(ctx, userid, edit)
+func (s *service) EditStore(ctx context.Context, actor Actor, edit StoreEdit) error {
+	if !actor.Can(PermEditStore) {
+		return ErrPermissionDenied
+	}
+	if edit.Timezone != "" {
+		if _, err := time.LoadLocation(edit.Timezone); err != nil || edit.Timezone == "Local" {
+			return ErrInvalidTimezone
+		}
+	}
+	if edit.VoidWindow != nil && (*edit.VoidWindow < 0 || *edit.VoidWindow > MaxVoidWindow) {
+		return ErrInvalidVoidWindow
+	}
+	location, err := s.locate(ctx, edit.Address, edit.Location)
+	if err != nil {
+		return err
+	}
+	edit.Location = location
+	return s.repo.EditStore(ctx, actor.StoreID, edit)
 }
@@ -0,0 +1,92 @@
+package storemanagement
+
+//Permission is an action that can be performed on a store
+type Permission string
+
+const (
+	//PermViewStore allows reading the store details and reports
+	PermViewStore Permission = "store:view"
+	//PermEditStore allows editing the store details
+	PermEditStore Permission = "store:edit"
+	//PermViewCoupons allows listing the coupons of the store
+	PermViewCoupons Permission = "coupon:read"
+	//PermCreateCoupon allows creating coupons for the store
+	PermCreateCoupon Permission = "coupon:create"
+	//PermDeleteCoupon allows deleting coupons of the store
+	PermDeleteCoupon Permission = "coupon:delete"
+	//PermVerifyCoupon allows redeeming coupons at the store
+	PermVerifyCoupon Permission = "coupon:verify"
+	//PermViewEmployees allows listing the employees of the store
+	PermViewEmployees Permission = "employee:read"
+	//PermManageEmployees allows adding, suspending and removing employees
+	PermManageEmployees Permission = "employee:manage"
+	//PermManageAPIKeys allows creating, listing and revoking the API keys of the store
+	PermManageAPIKeys Permission = "apikey:manage"
+	//PermManageBranches allows opening, editing and closing the branches of the store
+	PermManageBranches Permission = "branch:manage"
+)
+
+const (
+	//RoleOwner is the role of the user who owns the store
+	RoleOwner = "owner"
+	//RoleEmployee is the role of an active employee of the store
+	RoleEmployee = "employee"
+	//RoleShopper is the role of every user, it grants nothing on a store
+	RoleShopper = "shopper"
+	//RolePlatformAdmin is the role of the platform operators, it grants everything
+	RolePlatformAdmin = "platform-admin"
+	//RoleAPIKey is the role of a store API key, its scopes narrow it further
+	RoleAPIKey = "api-key"
+)
+
+var allPermissions = []Permission{
+	PermViewStore, PermEditStore,
+	PermViewCoupons, PermCreateCoupon, PermDeleteCoupon, PermVerifyCoupon,
+	PermViewEmployees, PermManageEmployees,
+	PermManageAPIKeys,
+	PermManageBranches,
+}
+
+//rolePermissions lists what each role is allowed to do on the store the actor acts for
+var rolePermissions = map[string][]Permission{
+	RoleOwner:         allPermissions,
+	RolePlatformAdmin: allPermissions,
+	RoleEmployee:      {PermViewStore, PermViewCoupons, PermVerifyCoupon},
+	RoleAPIKey:        apiKeyScopes,
+}
+
+//Actor is the user or API key performing a store management operation
+type Actor struct {
+	UserID  string
+	StoreID string
+	Roles   []string
+	//Scopes restricts the permissions granted by the roles when it is not nil
+	Scopes []Permission
+	//BranchID is the branch of the store the actor is at, empty when unknown
+	BranchID string
+}
+
+//Can reports whether the actor is allowed the permission on its store
+func (a Actor) Can(p Permission) bool {
+	if a.StoreID == "" {
+		return false
+	}
+	if a.Scopes != nil && !hasPermission(a.Scopes, p) {
+		return false
+	}
+	for _, role := range a.Roles {
+		if hasPermission(rolePermissions[role], p) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPermission(permissions []Permission, p Permission) bool {
+	for _, permission := range permissions {
+		if permission == p {
+			return true
+		}
+	}
+	return false
+}
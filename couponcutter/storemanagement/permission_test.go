@@ -0,0 +1,41 @@
+package storemanagement
+
+import "testing"
+
+func TestActor_Can(t *testing.T) {
+	owner := Actor{UserID: "owner", StoreID: "store", Roles: []string{RoleShopper, RoleOwner}}
+	employee := Actor{UserID: "emp", StoreID: "store", Roles: []string{RoleShopper, RoleEmployee}}
+	shopper := Actor{UserID: "shopper", Roles: []string{RoleShopper}}
+	admin := Actor{UserID: "admin", StoreID: "store", Roles: []string{RoleShopper, RolePlatformAdmin}}
+	storeless := Actor{UserID: "admin", Roles: []string{RolePlatformAdmin}}
+	verifier := Actor{UserID: "key", StoreID: "store", Roles: []string{RoleAPIKey}, Scopes: []Permission{PermVerifyCoupon}}
+	scopedOwner := Actor{UserID: "owner", StoreID: "store", Roles: []string{RoleOwner}, Scopes: []Permission{PermViewCoupons}}
+
+	tests := []struct {
+		name  string
+		actor Actor
+		perm  Permission
+		want  bool
+	}{
+		{name: "owner deletes coupon", actor: owner, perm: PermDeleteCoupon, want: true},
+		{name: "owner edits store", actor: owner, perm: PermEditStore, want: true},
+		{name: "employee verifies coupon", actor: employee, perm: PermVerifyCoupon, want: true},
+		{name: "employee deletes coupon", actor: employee, perm: PermDeleteCoupon, want: false},
+		{name: "employee edits store", actor: employee, perm: PermEditStore, want: false},
+		{name: "employee manages employees", actor: employee, perm: PermManageEmployees, want: false},
+		{name: "shopper verifies coupon", actor: shopper, perm: PermVerifyCoupon, want: false},
+		{name: "admin manages employees", actor: admin, perm: PermManageEmployees, want: true},
+		{name: "admin without a store", actor: storeless, perm: PermViewStore, want: false},
+		{name: "api key within scope", actor: verifier, perm: PermVerifyCoupon, want: true},
+		{name: "api key outside scope", actor: verifier, perm: PermViewCoupons, want: false},
+		{name: "scopes narrow roles", actor: scopedOwner, perm: PermDeleteCoupon, want: false},
+		{name: "employee manages api keys", actor: employee, perm: PermManageAPIKeys, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.actor.Can(tt.perm); got != tt.want {
+				t.Errorf("Actor.Can(%v) = %v, want %v", tt.perm, got, tt.want)
+			}
+		})
+	}
+}
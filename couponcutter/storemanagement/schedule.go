@@ -0,0 +1,125 @@
+package storemanagement
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	//ErrInvalidSchedule is returned if a coupon starts after it expires or has a malformed validity window
+	ErrInvalidSchedule = errors.New("invalid coupon schedule")
+	//ErrInvalidTimezone is returned if a store is given a timezone that is not in the tz database
+	ErrInvalidTimezone = errors.New("invalid timezone")
+	//ErrCouponNotStarted is returned if a coupon is redeemed before its start date
+	ErrCouponNotStarted = errors.New("coupon has not started yet")
+	//ErrCouponOutsideWindow is returned if a coupon is redeemed outside of its validity windows
+	ErrCouponOutsideWindow = errors.New("coupon is not valid at this time")
+)
+
+//DefaultTimezone is the timezone of the stores that did not set one
+const DefaultTimezone = "UTC"
+
+//ValidityWindow is a time of the day a coupon is valid on some days of the week, such as from
+//four to six in the afternoon on weekdays. Times are HH:MM in the timezone of the store, the
+//end is excluded and an end of 24:00 closes the window at midnight
+type ValidityWindow struct {
+	Days  []time.Weekday `json:"days"`
+	Start string         `json:"start"`
+	End   string         `json:"end"`
+}
+
+//Minutes returns the start and end of the window in minutes since midnight
+func (w ValidityWindow) Minutes() (int, int, error) {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end <= start {
+		return 0, 0, ErrInvalidSchedule
+	}
+	return start, end, nil
+}
+
+//parseClock turns a HH:MM time into minutes since midnight
+func parseClock(clock string) (int, error) {
+	var hour, minute int
+	n, err := fmt.Sscanf(clock, "%d:%d", &hour, &minute)
+	if err != nil || n != 2 || len(clock) != 5 || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, ErrInvalidSchedule
+	}
+	return hour*60 + minute, nil
+}
+
+//WindowsContain reports whether the time falls within one of the windows, the time must be in
+//the timezone of the store. A coupon without windows is valid all day
+func WindowsContain(windows []ValidityWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range windows {
+		start, end, err := w.Minutes()
+		if err != nil || minute < start || minute >= end {
+			continue
+		}
+		for _, day := range w.Days {
+			if day == t.Weekday() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//validateSchedule checks the start date comes before the expiring date and every window is well formed
+func validateSchedule(startDate, expiringDate uint, windows []ValidityWindow) error {
+	if startDate != 0 && expiringDate != 0 && startDate >= expiringDate {
+		return ErrInvalidSchedule
+	}
+	for _, w := range windows {
+		if len(w.Days) == 0 {
+			return ErrInvalidSchedule
+		}
+		for _, day := range w.Days {
+			if day < time.Sunday || day > time.Saturday {
+				return ErrInvalidSchedule
+			}
+		}
+		if _, _, err := w.Minutes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//StoreLocation returns the location of the timezone of the store, UTC when it is not set
+func StoreLocation(timezone string) *time.Location {
+	if timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+//redeemableAt checks the coupon has started and the time falls within its windows,
+//a scheduled coupon starts at its start date
+func (c Coupon) redeemableAt(t time.Time) error {
+	if c.State == CouponScheduled && c.StartDate == 0 {
+		return ErrCouponNotStarted
+	}
+	if c.StartDate != 0 && t.Unix() < int64(c.StartDate) {
+		return ErrCouponNotStarted
+	}
+	if !WindowsContain(c.Windows, t.In(StoreLocation(c.Store.Timezone))) {
+		return ErrCouponOutsideWindow
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWindowsContain(t *testing.T) {
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	happyHour := []ValidityWindow{{Days: weekdays, Start: "16:00", End: "18:00"}}
+	lateNight := []ValidityWindow{{Days: []time.Weekday{time.Saturday}, Start: "22:00", End: "24:00"}}
+	//2021-06-07 is a monday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2021, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name    string
+		windows []ValidityWindow
+		t       time.Time
+		want    bool
+	}{
+		{name: "no windows", t: at(6, 3, 0), want: true},
+		{name: "start of the window", windows: happyHour, t: at(7, 16, 0), want: true},
+		{name: "within the window", windows: happyHour, t: at(11, 17, 59), want: true},
+		{name: "end of the window", windows: happyHour, t: at(7, 18, 0), want: false},
+		{name: "before the window", windows: happyHour, t: at(7, 15, 59), want: false},
+		{name: "another day", windows: happyHour, t: at(12, 17, 0), want: false},
+		{name: "until midnight", windows: lateNight, t: at(12, 23, 59), want: true},
+		{name: "after midnight", windows: lateNight, t: at(13, 0, 0), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WindowsContain(tt.windows, tt.t); got != tt.want {
+				t.Errorf("WindowsContain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateSchedule(t *testing.T) {
+	days := []time.Weekday{time.Monday}
+	tests := []struct {
+		name     string
+		start    uint
+		expiring uint
+		windows  []ValidityWindow
+		wantErr  error
+	}{
+		{name: "no schedule", expiring: 200},
+		{name: "start before expiry", start: 100, expiring: 200,
+			windows: []ValidityWindow{{Days: days, Start: "09:00", End: "24:00"}}},
+		{name: "start after expiry", start: 300, expiring: 200, wantErr: ErrInvalidSchedule},
+		{name: "window without days", windows: []ValidityWindow{{Start: "09:00", End: "10:00"}}, wantErr: ErrInvalidSchedule},
+		{name: "unknown day", windows: []ValidityWindow{{Days: []time.Weekday{7}, Start: "09:00", End: "10:00"}}, wantErr: ErrInvalidSchedule},
+		{name: "end before start", windows: []ValidityWindow{{Days: days, Start: "18:00", End: "16:00"}}, wantErr: ErrInvalidSchedule},
+		{name: "malformed time", windows: []ValidityWindow{{Days: days, Start: "9:00", End: "10:00"}}, wantErr: ErrInvalidSchedule},
+		{name: "past midnight", windows: []ValidityWindow{{Days: days, Start: "22:00", End: "24:30"}}, wantErr: ErrInvalidSchedule},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSchedule(tt.start, tt.expiring, tt.windows); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validateSchedule() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_service_VerifyCouponSchedule(t *testing.T) {
+	owner := Actor{UserID: "u1", StoreID: "u1", Roles: []string{RoleOwner}}
+	lagos, err := time.LoadLocation("Africa/Lagos")
+	if err != nil {
+		t.Skip("no tz database")
+	}
+	now := time.Now().In(lagos)
+	//the hour of now in the timezone of the store
+	thisHour := []ValidityWindow{{Days: []time.Weekday{now.Weekday()},
+		Start: fmt.Sprintf("%02d:00", now.Hour()), End: fmt.Sprintf("%02d:00", now.Hour()+1)}}
+	coupon := func(start int64, windows []ValidityWindow) *Coupon {
+		return &Coupon{CouponID: "c1", Store: Store{StoreID: "u1", Timezone: "Africa/Lagos"}, State: CouponActive,
+			StartDate: int(start), Windows: windows}
+	}
+	scheduled := func(start int64) *Coupon {
+		c := coupon(start, nil)
+		c.State = CouponScheduled
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		coupon  *Coupon
+		wantErr error
+	}{
+		{name: "started", coupon: coupon(time.Now().Add(-time.Hour).Unix(), nil)},
+		{name: "not started", coupon: coupon(time.Now().Add(time.Hour).Unix(), nil), wantErr: ErrCouponNotStarted},
+		{name: "within the window", coupon: coupon(0, thisHour)},
+		{name: "outside the window", coupon: coupon(0, []ValidityWindow{{Days: []time.Weekday{(now.Weekday() + 1) % 7},
+			Start: "00:00", End: "24:00"}}), wantErr: ErrCouponOutsideWindow},
+		{name: "scheduled and started", coupon: scheduled(time.Now().Add(-time.Minute).Unix())},
+		{name: "scheduled and not started", coupon: scheduled(time.Now().Add(time.Hour).Unix()), wantErr: ErrCouponNotStarted},
+		{name: "scheduled without a start date", coupon: scheduled(0), wantErr: ErrCouponNotStarted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockRepo{coupons: map[string]*Coupon{"c1": tt.coupon}}
+			s := NewService(repo, &mockMailer{}, nil)
+			err := s.VerifyCoupon(context.Background(), owner, "c1", "", "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyCoupon() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && len(repo.redemptions) != 0 {
+				t.Error("VerifyCoupon() redeemed a rejected coupon")
+			}
+		})
+	}
+}
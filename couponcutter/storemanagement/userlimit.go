@@ -0,0 +1,69 @@
+package storemanagement
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	//ErrCouponUserLimitReached is returned if the shopper already redeemed the coupon as many times as allowed
+	ErrCouponUserLimitReached = errors.New("coupon limit reached for this shopper")
+	//ErrShopperRequired is returned if a coupon limited per shopper is redeemed without saying for whom
+	ErrShopperRequired = errors.New("shopper is required")
+	//ErrInvalidUserLimit is returned if a per shopper limit has an unknown period
+	ErrInvalidUserLimit = errors.New("invalid user limit")
+)
+
+const (
+	//PeriodDay limits the redemptions of a shopper per calendar day
+	PeriodDay = "day"
+	//PeriodWeek limits the redemptions of a shopper per calendar week, weeks start on monday
+	PeriodWeek = "week"
+	//PeriodMonth limits the redemptions of a shopper per calendar month
+	PeriodMonth = "month"
+)
+
+//UserLimit is how many times a shopper can redeem a coupon, ever or in every period. A count of
+//zero leaves the coupon unlimited per shopper and a count of one without period makes it single use
+type UserLimit struct {
+	Count  uint   `json:"count"`
+	Period string `json:"period,omitempty"`
+}
+
+//Redemption is a coupon redeemed at a store for a shopper, by a user or through an API key
+type Redemption struct {
+	ID         string `json:"redemption_id,omitempty"`
+	CouponID   string `json:"coupon_id"`
+	StoreID    string `json:"store_id"`
+	RedeemedBy string `json:"redeemed_by,omitempty"`
+	//APIKeyID is the key of the terminal that redeemed the coupon, RedeemedBy is empty then
+	APIKeyID   string    `json:"api_key_id,omitempty"`
+	BranchID   string    `json:"branch_id,omitempty"`
+	ShopperID  string    `json:"shopper_id,omitempty"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+}
+
+//validate checks the period of the limit is known
+func (l UserLimit) validate() error {
+	switch l.Period {
+	case "", PeriodDay, PeriodWeek, PeriodMonth:
+		return nil
+	}
+	return ErrInvalidUserLimit
+}
+
+//PeriodStart returns when the period holding the time started in the location, the zero
+//time for a limit without period
+func (l UserLimit) PeriodStart(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	switch l.Period {
+	case PeriodDay:
+		return day
+	case PeriodWeek:
+		return day.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+	case PeriodMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	}
+	return time.Time{}
+}
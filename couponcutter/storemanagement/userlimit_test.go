@@ -0,0 +1,122 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func (m *mockRepo) CreateCoupon(ctx context.Context, storeID string, coupon CreateCoupon) (string, error) {
+	m.created = &coupon
+	return "c1", nil
+}
+
+func TestUserLimit_PeriodStart(t *testing.T) {
+	lagos := time.FixedZone("WAT", 60*60)
+	//2021-06-09 is a wednesday, 23:30 in Lagos is 22:30 in UTC
+	at := time.Date(2021, time.June, 9, 23, 30, 0, 0, lagos)
+
+	tests := []struct {
+		period string
+		want   time.Time
+	}{
+		{period: "", want: time.Time{}},
+		{period: PeriodDay, want: time.Date(2021, time.June, 9, 0, 0, 0, 0, lagos)},
+		{period: PeriodWeek, want: time.Date(2021, time.June, 7, 0, 0, 0, 0, lagos)},
+		{period: PeriodMonth, want: time.Date(2021, time.June, 1, 0, 0, 0, 0, lagos)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			got := UserLimit{Count: 1, Period: tt.period}.PeriodStart(at.UTC(), lagos)
+			if !got.Equal(tt.want) {
+				t.Errorf("PeriodStart() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_service_VerifyCouponUserLimit(t *testing.T) {
+	owner := Actor{UserID: "u1", StoreID: "u1", Roles: []string{RoleOwner}}
+	now := time.Now()
+	earlier := func(couponID string, shopperID string, d time.Duration) Redemption {
+		return Redemption{CouponID: couponID, StoreID: "u1", RedeemedBy: "u1", ShopperID: shopperID, RedeemedAt: now.Add(-d)}
+	}
+	coupon := func(limit UserLimit) *Coupon {
+		return &Coupon{CouponID: "c1", Store: Store{StoreID: "u1"}, State: CouponActive, UserLimit: limit}
+	}
+
+	tests := []struct {
+		name        string
+		limit       UserLimit
+		redemptions []Redemption
+		shopperID   string
+		wantErr     error
+	}{
+		{name: "no limit without shopper", limit: UserLimit{}},
+		{name: "once, first time", limit: UserLimit{Count: 1}, shopperID: "s1",
+			redemptions: []Redemption{earlier("c1", "s2", time.Hour)}},
+		{name: "once, second time", limit: UserLimit{Count: 1}, shopperID: "s1",
+			redemptions: []Redemption{earlier("c1", "s1", time.Hour*24*400)}, wantErr: ErrCouponUserLimitReached},
+		{name: "limited without shopper", limit: UserLimit{Count: 1}, wantErr: ErrShopperRequired},
+		{name: "three times, third time", limit: UserLimit{Count: 3}, shopperID: "s1",
+			redemptions: []Redemption{earlier("c1", "s1", time.Hour), earlier("c1", "s1", time.Hour*2), earlier("c2", "s1", time.Hour)}},
+		{name: "twice a day, again today", limit: UserLimit{Count: 2, Period: PeriodDay}, shopperID: "s1",
+			redemptions: []Redemption{earlier("c1", "s1", time.Second), earlier("c1", "s1", time.Second*2)}, wantErr: ErrCouponUserLimitReached},
+		{name: "twice a day, redeemed days ago", limit: UserLimit{Count: 2, Period: PeriodDay}, shopperID: "s1",
+			redemptions: []Redemption{earlier("c1", "s1", time.Hour*49), earlier("c1", "s1", time.Hour*50)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockRepo{
+				coupons:     map[string]*Coupon{"c1": coupon(tt.limit)},
+				redemptions: tt.redemptions,
+			}
+			s := NewService(repo, &mockMailer{}, nil)
+			err := s.VerifyCoupon(context.Background(), owner, "c1", tt.shopperID, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyCoupon() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(repo.redemptions) != len(tt.redemptions) {
+					t.Error("VerifyCoupon() redeemed a rejected coupon")
+				}
+				return
+			}
+			last := repo.redemptions[len(repo.redemptions)-1]
+			if len(repo.redemptions) != len(tt.redemptions)+1 || last.ShopperID != tt.shopperID {
+				t.Errorf("VerifyCoupon() redeemed %+v, want it for %q", last, tt.shopperID)
+			}
+		})
+	}
+}
+
+func Test_service_CreateCouponUserLimit(t *testing.T) {
+	owner := Actor{UserID: "u1", StoreID: "u1", Roles: []string{RoleOwner}}
+	expiry := uint(time.Now().Add(time.Hour * 24).Unix())
+
+	tests := []struct {
+		name    string
+		coupon  CreateCoupon
+		want    UserLimit
+		wantErr error
+	}{
+		{name: "single use", coupon: CreateCoupon{SingleUserUse: true}, want: UserLimit{Count: 1}},
+		{name: "per week", coupon: CreateCoupon{UserLimit: UserLimit{Count: 2, Period: PeriodWeek}}, want: UserLimit{Count: 2, Period: PeriodWeek}},
+		{name: "unknown period", coupon: CreateCoupon{UserLimit: UserLimit{Count: 2, Period: "fortnight"}}, wantErr: ErrInvalidUserLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockRepo{}
+			s := NewService(repo, &mockMailer{}, nil)
+			tt.coupon.ExpiringDate = expiry
+			_, err := s.CreateCoupon(context.Background(), owner, tt.coupon)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateCoupon() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && repo.created.UserLimit != tt.want {
+				t.Errorf("CreateCoupon() saved limit %+v, want %+v", repo.created.UserLimit, tt.want)
+			}
+		})
+	}
+}
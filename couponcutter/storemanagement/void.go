@@ -0,0 +1,124 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrVoidReasonRequired is returned if a redemption is voided without a reason or with one too long
+	ErrVoidReasonRequired = errors.New("a reason is required to void a redemption")
+	//ErrRedemptionNotFound is returned if no redemption of the store is associated with the id
+	ErrRedemptionNotFound = errors.New("redemption not found")
+	//ErrRedemptionAlreadyVoided is returned if the redemption was voided before
+	ErrRedemptionAlreadyVoided = errors.New("redemption already voided")
+	//ErrVoidWindowPassed is returned if a redemption older than the void window of the store is
+	//voided by someone other than the owner
+	ErrVoidWindowPassed = errors.New("redemption can no longer be voided")
+	//ErrInvalidVoidWindow is returned if a store is given a void window longer than MaxVoidWindow
+	ErrInvalidVoidWindow = errors.New("invalid void window")
+)
+
+const (
+	//DefaultVoidWindow is how long after a redemption it can be voided in the stores that did not set it
+	DefaultVoidWindow = 15 * time.Minute
+	//MaxVoidWindow is the longest void window a store can set
+	MaxVoidWindow = 7 * 24 * time.Hour
+	//maxVoidReasonLength is the length of the longest reason accepted
+	maxVoidReasonLength = 500
+)
+
+//RedemptionVoid is the audit record of a redemption undone by the store, VoidedBy is the user
+//or API key that voided it the way coupon edits record their author. Override is set when the
+//owner voided it after the void window of the store
+type RedemptionVoid struct {
+	ID         string     `json:"void_id,omitempty"`
+	Redemption Redemption `json:"redemption"`
+	VoidedBy   string     `json:"voided_by"`
+	VoidedAt   time.Time  `json:"voided_at"`
+	Reason     string     `json:"reason"`
+	Override   bool       `json:"override"`
+}
+
+//isOwner reports whether the actor acts on the store as its owner
+func isOwner(actor Actor) bool {
+	return containsID(actor.Roles, RoleOwner)
+}
+
+//voidableBy checks the actor can void the redemption, an employee assigned to branches only
+//voids the redemptions of those branches and an API key only its own redemptions
+func (s *service) voidableBy(ctx context.Context, actor Actor, redemption *Redemption) error {
+	if containsID(actor.Roles, RoleAPIKey) && redemption.APIKeyID != actor.UserID {
+		return ErrPermissionDenied
+	}
+	if isEmployeeOnly(actor) {
+		assigned, err := s.repo.EmployeeBranches(ctx, actor.StoreID, actor.UserID)
+		if err != nil {
+			return err
+		}
+		if len(assigned) > 0 && !containsID(assigned, redemption.BranchID) {
+			return ErrPermissionDenied
+		}
+	}
+	return nil
+}
+
+//VoidRedemption undoes a redemption made by mistake, the coupon gets the redemption back and
+//becomes active again if it was exhausted. Anyone allowed to verify coupons at the branch of
+//the redemption can void within the void window of the store, only the owner afterwards
+func (s *service) VoidRedemption(ctx context.Context, actor Actor, redemptionID string, reason string) (*RedemptionVoid, error) {
+	if !actor.Can(PermVerifyCoupon) {
+		return nil, ErrPermissionDenied
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" || len(reason) > maxVoidReasonLength {
+		return nil, ErrVoidReasonRequired
+	}
+	redemption, err := s.repo.Redemption(ctx, actor.StoreID, redemptionID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.voidableBy(ctx, actor, redemption)
+	if err != nil {
+		return nil, err
+	}
+	window, err := s.repo.StoreVoidWindow(ctx, actor.StoreID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	override := now.Sub(redemption.RedeemedAt) > window
+	if override && !isOwner(actor) {
+		return nil, ErrVoidWindowPassed
+	}
+	void := RedemptionVoid{
+		Redemption: *redemption,
+		VoidedBy:   actor.UserID,
+		VoidedAt:   now,
+		Reason:     reason,
+		Override:   override,
+	}
+	void.ID, err = s.repo.VoidRedemption(ctx, actor.StoreID, void)
+	if err != nil {
+		return nil, err
+	}
+	return &void, nil
+}
+
+//CouponRedemptions returns the redemptions of the coupon that were not voided, latest first
+func (s *service) CouponRedemptions(ctx context.Context, actor Actor, couponID string) ([]Redemption, error) {
+	if !actor.Can(PermViewCoupons) {
+		return nil, ErrPermissionDenied
+	}
+	return s.repo.CouponRedemptions(ctx, actor.StoreID, couponID)
+}
+
+//RedemptionVoids returns the voided redemptions of the store, latest first
+func (s *service) RedemptionVoids(ctx context.Context, actor Actor) ([]RedemptionVoid, error) {
+	if !actor.Can(PermViewStore) {
+		return nil, ErrPermissionDenied
+	}
+	return s.repo.RedemptionVoids(ctx, actor.StoreID)
+}
@@ -0,0 +1,135 @@
+package storemanagement
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (m *mockRepo) Redemption(ctx context.Context, storeID string, redemptionID string) (*Redemption, error) {
+	for _, r := range m.redemptions {
+		if r.ID == redemptionID && r.StoreID == storeID {
+			redemption := r
+			return &redemption, nil
+		}
+	}
+	for _, v := range m.voids {
+		if v.Redemption.ID == redemptionID && v.Redemption.StoreID == storeID {
+			return nil, ErrRedemptionAlreadyVoided
+		}
+	}
+	return nil, ErrRedemptionNotFound
+}
+
+func (m *mockRepo) StoreVoidWindow(ctx context.Context, storeID string) (time.Duration, error) {
+	return m.voidWindow, nil
+}
+
+func (m *mockRepo) VoidRedemption(ctx context.Context, storeID string, void RedemptionVoid) (string, error) {
+	for i, r := range m.redemptions {
+		if r.ID != void.Redemption.ID {
+			continue
+		}
+		m.redemptions = append(m.redemptions[:i], m.redemptions[i+1:]...)
+		c := m.coupons[r.CouponID]
+		c.RedemptionCount--
+		if c.State == CouponExhausted {
+			c.State = CouponActive
+		}
+		void.ID = strconv.Itoa(len(m.voids) + 1)
+		m.voids = append(m.voids, void)
+		return void.ID, nil
+	}
+	return "", ErrRedemptionAlreadyVoided
+}
+
+func voidRepo() *mockRepo {
+	repo := lifecycleRepo()
+	repo.voidWindow = 15 * time.Minute
+	repo.redemptions = []Redemption{
+		{ID: "recent", CouponID: "active", StoreID: "u1", RedeemedBy: "u2", RedeemedAt: time.Now().Add(-time.Minute)},
+		{ID: "old", CouponID: "redeemed", StoreID: "u1", RedeemedBy: "u2", RedeemedAt: time.Now().Add(-time.Hour)},
+		{ID: "last", CouponID: "exhausted", StoreID: "u1", RedeemedBy: "u2", RedeemedAt: time.Now().Add(-time.Minute)},
+		{ID: "elsewhere", CouponID: "c9", StoreID: "u9", RedeemedBy: "u8", RedeemedAt: time.Now()},
+		{ID: "marina", CouponID: "active", StoreID: "u1", RedeemedBy: "u3", BranchID: "b2", RedeemedAt: time.Now()},
+		{ID: "terminal", CouponID: "active", StoreID: "u1", APIKeyID: "k2", RedeemedAt: time.Now()},
+	}
+	repo.employeeBranches = map[string][]string{"u3": {"b1"}}
+	repo.coupons["active"].RedemptionCount = 3
+	repo.voids = []RedemptionVoid{{ID: "1", Redemption: Redemption{ID: "voided", CouponID: "active", StoreID: "u1"}}}
+	return repo
+}
+
+func Test_service_VoidRedemption(t *testing.T) {
+	owner := Actor{UserID: "u1", StoreID: "u1", Roles: []string{RoleOwner}}
+	employee := Actor{UserID: "u2", StoreID: "u1", Roles: []string{RoleEmployee}}
+	viewer := Actor{UserID: "k1", StoreID: "u1", Roles: []string{RoleAPIKey}, Scopes: []Permission{PermViewCoupons}}
+	assigned := Actor{UserID: "u3", StoreID: "u1", Roles: []string{RoleEmployee}}
+	terminal := Actor{UserID: "k2", StoreID: "u1", Roles: []string{RoleAPIKey}, Scopes: []Permission{PermVerifyCoupon}}
+	otherTerminal := Actor{UserID: "k3", StoreID: "u1", Roles: []string{RoleAPIKey}, Scopes: []Permission{PermVerifyCoupon}}
+
+	tests := []struct {
+		name         string
+		actor        Actor
+		redemptionID string
+		reason       string
+		wantOverride bool
+		wantState    string
+		wantErr      error
+	}{
+		{name: "within the window", actor: employee, redemptionID: "recent", reason: "wrong coupon scanned", wantState: CouponActive},
+		{name: "past the window", actor: employee, redemptionID: "old", reason: "wrong coupon scanned", wantErr: ErrVoidWindowPassed},
+		{name: "past the window by the owner", actor: owner, redemptionID: "old", reason: "refund", wantOverride: true,
+			wantState: CouponActive},
+		{name: "exhausted coupon reactivated", actor: employee, redemptionID: "last", reason: "double scan", wantState: CouponActive},
+		{name: "no reason", actor: employee, redemptionID: "recent", reason: "  ", wantErr: ErrVoidReasonRequired},
+		{name: "reason too long", actor: employee, redemptionID: "recent", reason: strings.Repeat("a", maxVoidReasonLength+1),
+			wantErr: ErrVoidReasonRequired},
+		{name: "unknown redemption", actor: employee, redemptionID: "r9", reason: "mistake", wantErr: ErrRedemptionNotFound},
+		{name: "redemption of another store", actor: owner, redemptionID: "elsewhere", reason: "mistake", wantErr: ErrRedemptionNotFound},
+		{name: "voided twice", actor: owner, redemptionID: "voided", reason: "mistake", wantErr: ErrRedemptionAlreadyVoided},
+		{name: "without verify permission", actor: viewer, redemptionID: "recent", reason: "mistake", wantErr: ErrPermissionDenied},
+		{name: "employee of another branch", actor: assigned, redemptionID: "marina", reason: "mistake", wantErr: ErrPermissionDenied},
+		{name: "employee without branches", actor: employee, redemptionID: "marina", reason: "mistake", wantState: CouponActive},
+		{name: "redemption of the key", actor: terminal, redemptionID: "terminal", reason: "mistake", wantState: CouponActive},
+		{name: "redemption of another key", actor: otherTerminal, redemptionID: "terminal", reason: "mistake", wantErr: ErrPermissionDenied},
+		{name: "redemption of a user by a key", actor: terminal, redemptionID: "recent", reason: "mistake", wantErr: ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := voidRepo()
+			s := NewService(repo, &mockMailer{}, nil)
+			count := map[string]uint{}
+			for id, c := range repo.coupons {
+				count[id] = c.RedemptionCount
+			}
+			void, err := s.VoidRedemption(context.Background(), tt.actor, tt.redemptionID, tt.reason)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VoidRedemption() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(repo.voids) != 1 || len(repo.redemptions) != 6 {
+					t.Error("VoidRedemption() voided a rejected redemption")
+				}
+				return
+			}
+			if void.ID == "" || void.Override != tt.wantOverride || void.VoidedBy != tt.actor.UserID || void.Reason != strings.TrimSpace(tt.reason) {
+				t.Errorf("VoidRedemption() = %+v", void)
+			}
+			if len(repo.voids) != 2 || repo.voids[1].Redemption.ID != tt.redemptionID {
+				t.Errorf("VoidRedemption() audit trail = %+v", repo.voids)
+			}
+			coupon := repo.coupons[void.Redemption.CouponID]
+			if coupon.State != tt.wantState || coupon.RedemptionCount != count[coupon.CouponID]-1 {
+				t.Errorf("VoidRedemption() left the coupon %s with %d redemptions", coupon.State, coupon.RedemptionCount)
+			}
+			_, err = s.VoidRedemption(context.Background(), owner, tt.redemptionID, tt.reason)
+			if !errors.Is(err, ErrRedemptionAlreadyVoided) {
+				t.Errorf("VoidRedemption() again error = %v, want %v", err, ErrRedemptionAlreadyVoided)
+			}
+		})
+	}
+}